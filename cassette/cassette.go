@@ -0,0 +1,555 @@
+// Package cassette records the interactions of a client with the Perplexity API
+// and replays them, so that tests run without network and with deterministic answers.
+//
+//	rec, err := cassette.New("testdata/capital.yaml", cassette.ModeRecordMissing)
+//	...
+//	client.SetHTTPClient(rec.HTTPClient())
+//	... // send the requests
+//	err = rec.Save()
+//
+// Cassettes are written in YAML if their name ends with .yaml or .yml, in JSON otherwise.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the version of the format of the cassettes.
+const Version = 1
+
+// Redacted replaces the value of the redacted headers.
+const Redacted = "REDACTED"
+
+// ErrInteractionNotFound is returned in replay mode when no interaction matches a request.
+var ErrInteractionNotFound = errors.New("no interaction matches the request")
+
+// ErrUnknownMode is returned by ParseMode.
+var ErrUnknownMode = errors.New("unknown cassette mode")
+
+// Mode is the behaviour of a Recorder.
+type Mode int
+
+const (
+	// ModeReplay answers with the recorded interactions and never uses the network.
+	ModeReplay Mode = iota
+	// ModeRecord sends the requests and records all the interactions, replacing the cassette.
+	ModeRecord
+	// ModeRecordMissing replays the recorded interactions and records the others.
+	ModeRecordMissing
+	// ModePassthrough sends the requests without recording nor replaying.
+	ModePassthrough
+)
+
+var modeNames = []string{"replay", "record", "record-missing", "passthrough"}
+
+// String returns the name of the mode.
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// ParseMode returns the mode named s: replay, record, record-missing or passthrough.
+func ParseMode(s string) (Mode, error) {
+	for i, name := range modeNames {
+		if strings.EqualFold(s, name) {
+			return Mode(i), nil
+		}
+	}
+	return ModeReplay, fmt.Errorf("%w: %q", ErrUnknownMode, s)
+}
+
+// Cassette is a list of recorded interactions.
+type Cassette struct {
+	Version      int            `json:"version" yaml:"version"`
+	Interactions []*Interaction `json:"interactions" yaml:"interactions"`
+}
+
+// Interaction is a request and its response.
+type Interaction struct {
+	Request  Request  `json:"request" yaml:"request"`
+	Response Response `json:"response" yaml:"response"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method" yaml:"method"`
+	URL    string      `json:"url" yaml:"url"`
+	Header http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body   string      `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Response is a recorded response.
+// The body of a stream of server-sent events is recorded in chunks, with their timing.
+type Response struct {
+	StatusCode int         `json:"status_code" yaml:"status_code"`
+	Header     http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body       string      `json:"body,omitempty" yaml:"body,omitempty"`
+	Chunks     []Chunk     `json:"chunks,omitempty" yaml:"chunks,omitempty"`
+}
+
+// Chunk is a piece of a streamed body.
+type Chunk struct {
+	// ElapsedMS is the time elapsed since the headers of the response were received, in milliseconds.
+	ElapsedMS int64  `json:"elapsed_ms" yaml:"elapsed_ms"`
+	Data      string `json:"data" yaml:"data"`
+}
+
+// Load reads a cassette in YAML or JSON, depending on the extension of path.
+func Load(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	c := &Cassette{}
+	if isYAML(path) {
+		err = yaml.Unmarshal(b, c)
+	} else {
+		err = json.Unmarshal(b, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+	if c.Version != Version {
+		return nil, fmt.Errorf("unsupported version %d of cassette %s", c.Version, path)
+	}
+	// the bodies may have been edited by hand
+	for _, i := range c.Interactions {
+		i.Request.Body = normalizeBody([]byte(i.Request.Body))
+	}
+	return c, nil
+}
+
+// Save writes the cassette in YAML or JSON, depending on the extension of path.
+func (c *Cassette) Save(path string) error {
+	var b []byte
+	var err error
+	if isYAML(path) {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(c)
+		b = buf.Bytes()
+	} else {
+		b, err = json.MarshalIndent(c, "", "  ")
+		b = append(b, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory of cassette: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Option is a functional option of a Recorder.
+type Option func(*Recorder)
+
+// WithTransport sets the transport used to send the requests (http.DefaultTransport by default).
+func WithTransport(transport http.RoundTripper) Option {
+	return func(r *Recorder) {
+		r.transport = transport
+	}
+}
+
+// WithRedactedHeaders adds headers whose values are replaced by Redacted in the cassette.
+// Authorization is always redacted.
+func WithRedactedHeaders(headers ...string) Option {
+	return func(r *Recorder) {
+		for _, h := range headers {
+			r.redacted[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+}
+
+// WithRealTiming replays the chunks of the streamed bodies with their recorded timing.
+// By default they are replayed without delay.
+func WithRealTiming() Option {
+	return func(r *Recorder) {
+		r.realTiming = true
+	}
+}
+
+// Recorder is an http.RoundTripper recording and replaying interactions. It is safe for concurrent use.
+type Recorder struct {
+	path       string
+	mode       Mode
+	transport  http.RoundTripper
+	redacted   map[string]bool
+	realTiming bool
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     map[*Interaction]bool
+}
+
+// New returns a recorder using the cassette at path. The cassette must exist in replay mode.
+func New(path string, mode Mode, opts ...Option) (*Recorder, error) {
+	r := &Recorder{
+		path:      path,
+		mode:      mode,
+		transport: http.DefaultTransport,
+		redacted:  map[string]bool{"Authorization": true},
+		cassette:  &Cassette{Version: Version},
+		used:      make(map[*Interaction]bool),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	switch mode {
+	case ModeReplay, ModeRecordMissing:
+		c, err := Load(path)
+		switch {
+		case err == nil:
+			r.cassette = c
+		case mode == ModeRecordMissing && errors.Is(err, os.ErrNotExist):
+		default:
+			return nil, err
+		}
+	case ModeRecord, ModePassthrough:
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownMode, mode)
+	}
+	return r, nil
+}
+
+// HTTPClient returns an HTTP client using the recorder, for perplexity.Client.SetHTTPClient.
+func (r *Recorder) HTTPClient() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Mode returns the mode of the recorder.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Cassette returns the interactions recorded or loaded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{
+		Version:      r.cassette.Version,
+		Interactions: append([]*Interaction(nil), r.cassette.Interactions...),
+	}
+}
+
+// Save writes the cassette if the mode records interactions.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord && r.mode != ModeRecordMissing {
+		return nil
+	}
+	return r.Cassette().Save(r.path)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == ModePassthrough {
+		return r.transport.RoundTrip(req)
+	}
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := r.recordRequest(req, body)
+	if r.mode != ModeRecord {
+		if i := r.match(recorded); i != nil {
+			return r.replay(req, i), nil
+		}
+		if r.mode == ModeReplay {
+			return nil, r.notFound(recorded)
+		}
+	}
+	upstream := req.Clone(req.Context())
+	upstream.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := r.transport.RoundTrip(upstream)
+	if err != nil {
+		return nil, err
+	}
+	i := &Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     r.redact(resp.Header),
+		},
+	}
+	resp.Body = &recordingBody{
+		body:   resp.Body,
+		stream: isStream(resp.Header),
+		start:  time.Now(),
+		done: func(rb *recordingBody) {
+			i.Response.Body = rb.buf.String()
+			i.Response.Chunks = rb.chunks
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.cassette.Interactions = append(r.cassette.Interactions, i)
+			r.used[i] = true
+		},
+	}
+	return resp, nil
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return b, nil
+}
+
+func (r *Recorder) recordRequest(req *http.Request, body []byte) Request {
+	return Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: r.redact(req.Header),
+		Body:   normalizeBody(body),
+	}
+}
+
+// redact returns a copy of h with the values of the redacted headers replaced.
+func (r *Recorder) redact(h http.Header) http.Header {
+	res := h.Clone()
+	for k := range res {
+		if r.redacted[http.CanonicalHeaderKey(k)] {
+			res[k] = []string{Redacted}
+		}
+	}
+	return res
+}
+
+// match returns the first unused interaction matching the request, nil if none matches.
+// A used interaction is returned again if it's the last one matching the request.
+func (r *Recorder) match(req Request) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last *Interaction
+	for _, i := range r.cassette.Interactions {
+		if !i.Request.matches(req) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return i
+		}
+		last = i
+	}
+	return last
+}
+
+func (req Request) matches(other Request) bool {
+	return req.Method == other.Method && req.URL == other.URL && req.Body == other.Body
+}
+
+// notFound returns an error with the difference between the request and the closest interaction.
+func (r *Recorder) notFound(req Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var closest *Interaction
+	for _, i := range r.cassette.Interactions {
+		if i.Request.Method == req.Method && i.Request.URL == req.URL {
+			closest = i
+			if !r.used[i] {
+				break
+			}
+		}
+	}
+	if closest == nil {
+		return fmt.Errorf("%w: %s %s (no interaction with this method and URL in %s)", ErrInteractionNotFound, req.Method, req.URL, r.path)
+	}
+	return fmt.Errorf("%w: %s %s, difference with the closest interaction of %s (- recorded, + request):\n%s", ErrInteractionNotFound, req.Method, req.URL, r.path,
+		diff(indentBody(closest.Request.Body), indentBody(req.Body)))
+}
+
+// replay returns the recorded response of the interaction.
+func (r *Recorder) replay(req *http.Request, i *Interaction) *http.Response {
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+		StatusCode: i.Response.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     i.Response.Header.Clone(),
+		Request:    req,
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if len(i.Response.Chunks) > 0 {
+		resp.ContentLength = -1
+		resp.Body = &replayBody{
+			req:        req,
+			chunks:     i.Response.Chunks,
+			realTiming: r.realTiming,
+			start:      time.Now(),
+		}
+		return resp
+	}
+	resp.ContentLength = int64(len(i.Response.Body))
+	resp.Body = io.NopCloser(strings.NewReader(i.Response.Body))
+	return resp
+}
+
+func isStream(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+}
+
+// recordingBody records a body while it is read.
+type recordingBody struct {
+	body   io.ReadCloser
+	stream bool
+	start  time.Time
+	buf    bytes.Buffer
+	chunks []Chunk
+	once   sync.Once
+	done   func(*recordingBody)
+}
+
+// Read implements io.Reader.
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if b.stream {
+			b.chunks = append(b.chunks, Chunk{
+				ElapsedMS: time.Since(b.start).Milliseconds(),
+				Data:      string(p[:n]),
+			})
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		b.once.Do(func() { b.done(b) })
+	}
+	return n, err
+}
+
+// Close implements io.Closer. A body closed before its end is recorded as read so far.
+func (b *recordingBody) Close() error {
+	b.once.Do(func() { b.done(b) })
+	return b.body.Close()
+}
+
+// replayBody replays the chunks of a streamed body.
+type replayBody struct {
+	req        *http.Request
+	chunks     []Chunk
+	realTiming bool
+	start      time.Time
+	current    []byte
+}
+
+// Read implements io.Reader.
+func (b *replayBody) Read(p []byte) (int, error) {
+	for len(b.current) == 0 {
+		if len(b.chunks) == 0 {
+			return 0, io.EOF
+		}
+		c := b.chunks[0]
+		b.chunks = b.chunks[1:]
+		if b.realTiming {
+			if wait := time.Until(b.start.Add(time.Duration(c.ElapsedMS) * time.Millisecond)); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-b.req.Context().Done():
+					t.Stop()
+					return 0, b.req.Context().Err()
+				case <-t.C:
+				}
+			}
+		}
+		b.current = []byte(c.Data)
+	}
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (b *replayBody) Close() error {
+	return nil
+}
+
+// normalizeBody returns the body in compact JSON with sorted keys, or as is if it's not JSON.
+func normalizeBody(body []byte) string {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return string(body)
+	}
+	return string(b)
+}
+
+// indentBody returns the normalized body indented, to compare it line by line.
+func indentBody(body string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(body), "", "  "); err != nil {
+		return body
+	}
+	return buf.String()
+}
+
+// diff returns the lines of want and got prefixed with "-" if only in want,
+// "+" if only in got, and " " if in both.
+func diff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package cassette_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/cassette"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+const apiKey = "apikey"
+
+func newRequest(content string, stream bool) *perplexity.CompletionRequest {
+	return perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+		{Role: "user", Content: content},
+	}), perplexity.WithStream(stream))
+}
+
+// newClient returns a client of srv using the recorder.
+func newClient(t *testing.T, srv *perplexitytest.Server, path string, mode cassette.Mode) (*perplexity.Client, *cassette.Recorder) {
+	t.Helper()
+	rec, err := cassette.New(path, mode, cassette.WithTransport(srv.Server.Client().Transport))
+	assert.Nil(t, err)
+	c := srv.Client()
+	c.SetHTTPClient(rec.HTTPClient())
+	return c, rec
+}
+
+func stream(t *testing.T, c *perplexity.Client, content string) []string {
+	t.Helper()
+	var wg sync.WaitGroup
+	ch := make(chan perplexity.CompletionResponse)
+	errCh := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		errCh <- c.SendSSEHTTPRequest(&wg, newRequest(content, true), ch)
+	}()
+	var contents []string
+	for r := range ch {
+		contents = append(contents, r.GetLastContent())
+	}
+	wg.Wait()
+	assert.Nil(t, <-errCh)
+	return contents
+}
+
+func TestRecorder(t *testing.T) {
+	for _, name := range []string{"cassette.yaml", "cassette.json"} {
+		t.Run("records and replays "+name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			srv := perplexitytest.NewServer()
+			srv.Enqueue(perplexitytest.Answer("Paris."), perplexitytest.Stream([]string{"Ber", "lin."}))
+
+			c, rec := newClient(t, srv, path, cassette.ModeRecord)
+			resp, err := c.SendCompletionRequest(newRequest("Capital of France?", false))
+			assert.Nil(t, err)
+			assert.Equal(t, "Paris.", resp.GetLastContent())
+			assert.Equal(t, []string{"Ber", "Berlin."}, stream(t, c, "Capital of Germany?"))
+			assert.Nil(t, rec.Save())
+			srv.Close()
+
+			b, err := os.ReadFile(path)
+			assert.Nil(t, err)
+			assert.NotContains(t, string(b), perplexitytest.DefaultAPIKey)
+			assert.Contains(t, string(b), cassette.Redacted)
+
+			// the server is closed: the answers come from the cassette
+			c, _ = newClient(t, srv, path, cassette.ModeReplay)
+			assert.Equal(t, []string{"Ber", "Berlin."}, stream(t, c, "Capital of Germany?"))
+			resp, err = c.SendCompletionRequest(newRequest("Capital of France?", false))
+			assert.Nil(t, err)
+			assert.Equal(t, "Paris.", resp.GetLastContent())
+		})
+	}
+
+	t.Run("records the chunks of a stream with their timing", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cassette.json")
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		c, rec := newClient(t, srv, path, cassette.ModeRecord)
+		stream(t, c, "Hello")
+		interactions := rec.Cassette().Interactions
+		assert.Len(t, interactions, 1)
+		assert.NotEmpty(t, interactions[0].Response.Chunks)
+		assert.Empty(t, interactions[0].Response.Body)
+		assert.True(t, strings.HasPrefix(interactions[0].Response.Chunks[0].Data, "data: {"))
+	})
+
+	t.Run("matches requests on the normalized body", func(t *testing.T) {
+		rec, err := cassette.New(filepath.Join("testdata", "capital.yaml"), cassette.ModeReplay)
+		assert.Nil(t, err)
+		c := perplexity.NewClient("another key")
+		c.SetHTTPClient(rec.HTTPClient())
+		resp, err := c.SendCompletionRequest(newRequest("What's the capital of France?", false))
+		assert.Nil(t, err)
+		assert.Equal(t, "Paris is the capital of France.", resp.GetLastContent())
+	})
+
+	t.Run("fails on unmatched requests with a diff", func(t *testing.T) {
+		rec, err := cassette.New(filepath.Join("testdata", "capital.yaml"), cassette.ModeReplay)
+		assert.Nil(t, err)
+		c := perplexity.NewClient(apiKey)
+		c.SetHTTPClient(rec.HTTPClient())
+		_, err = c.SendCompletionRequest(newRequest("What's the capital of Italy?", false))
+		assert.ErrorIs(t, err, cassette.ErrInteractionNotFound)
+		assert.ErrorContains(t, err, `-       "content": "What's the capital of France?",`)
+		assert.ErrorContains(t, err, `+       "content": "What's the capital of Italy?",`)
+	})
+
+	t.Run("records the missing interactions", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cassette.yaml")
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		b, err := os.ReadFile(filepath.Join("testdata", "capital.yaml"))
+		assert.Nil(t, err)
+		b = []byte(strings.ReplaceAll(string(b), perplexity.DefaultEndpoint, srv.URL()))
+		assert.Nil(t, os.WriteFile(path, b, 0o600))
+		srv.Enqueue(perplexitytest.Answer("Rome."))
+
+		c, rec := newClient(t, srv, path, cassette.ModeRecordMissing)
+		resp, err := c.SendCompletionRequest(newRequest("What's the capital of France?", false))
+		assert.Nil(t, err)
+		assert.Equal(t, "Paris is the capital of France.", resp.GetLastContent())
+		resp, err = c.SendCompletionRequest(newRequest("What's the capital of Italy?", false))
+		assert.Nil(t, err)
+		assert.Equal(t, "Rome.", resp.GetLastContent())
+		assert.Len(t, srv.Requests(), 1)
+		assert.Nil(t, rec.Save())
+
+		loaded, err := cassette.Load(path)
+		assert.Nil(t, err)
+		assert.Len(t, loaded.Interactions, 2)
+	})
+
+	t.Run("passes the requests through", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cassette.yaml")
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		c, rec := newClient(t, srv, path, cassette.ModePassthrough)
+		_, err := c.SendCompletionRequest(newRequest("Hello", false))
+		assert.Nil(t, err)
+		assert.Nil(t, rec.Save())
+		_, err = os.Stat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("requires the cassette in replay mode", func(t *testing.T) {
+		_, err := cassette.New(filepath.Join(t.TempDir(), "missing.yaml"), cassette.ModeReplay)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []cassette.Mode{cassette.ModeReplay, cassette.ModeRecord, cassette.ModeRecordMissing, cassette.ModePassthrough} {
+		parsed, err := cassette.ParseMode(mode.String())
+		assert.Nil(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+	_, err := cassette.ParseMode("rewind")
+	assert.ErrorIs(t, err, cassette.ErrUnknownMode)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// ask sends a prompt, read from the arguments or stdin, and writes the answer.
+func ask(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("ask", "[flags] [prompt...]",
+		"Sends the prompt (the arguments, or stdin if there is none or if it's -) and writes the answer.")
+	var (
+		client  clientFlags
+		request requestFlags
+		stream  bool
+		format  string
+	)
+	client.register(fs)
+	request.register(fs)
+	fs.BoolVar(&stream, "stream", false, "write the answer while it is generated")
+	fs.StringVar(&format, "format", formatText, "output format: text, markdown or json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(format, formatText, formatMarkdown, formatJSON); err != nil {
+		return err
+	}
+	prompt, err := readPrompt(env.stdin, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	if err := request.validate(prompt); err != nil {
+		return err
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return err
+	}
+	conv := perplexity.NewConversation(c, request.messages(), request.options()...)
+	if !stream {
+		resp, err := conv.Send(ctx, prompt)
+		if err != nil {
+			return err
+		}
+		return writeResponse(env.stdout, format, resp)
+	}
+
+	_, err = sendStream(ctx, conv, perplexity.Message{Role: "user", Content: prompt}, newStreamWriter(env.stdout, format))
+	return err
+}
+
+// sendStream sends the user message msg in the conversation and writes the streamed answer with out.
+func sendStream(ctx context.Context, conv *perplexity.Conversation, msg perplexity.Message, out *streamWriter) (*perplexity.CompletionResponse, error) {
+	events := make(chan perplexity.CompletionResponse)
+	errWrite := make(chan error, 1)
+	go func() {
+		var err error
+		for event := range events {
+			if err == nil {
+				err = out.write(event)
+			}
+		}
+		errWrite <- err
+	}()
+	resp, err := conv.SendMessageStream(ctx, msg, events)
+	if errW := <-errWrite; err == nil {
+		err = errW
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, out.close(resp)
+}
+
+// readPrompt returns the prompt: the arguments, or stdin if there is none or if it's -.
+func readPrompt(stdin io.Reader, args []string) (string, error) {
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "-") {
+		return strings.Join(args, " "), nil
+	}
+	b, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the prompt: %w", err)
+	}
+	prompt := strings.TrimSpace(string(b))
+	if prompt == "" {
+		return "", usageError{msg: "the prompt is empty"}
+	}
+	return prompt, nil
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Formats of the batch files.
+const (
+	batchFormatAuto   = "auto"
+	batchFormatNative = "native"
+	batchFormatOpenAI = "openai"
+)
+
+// batch sends the requests of a JSONL file and writes the results as JSONL.
+func batch(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("batch", "[flags] [file]",
+		`Sends the requests of a JSONL file (or stdin) and writes a result per line.
+Each line is a prompt (a JSON string, or an object with a "prompt" and an optional "id"),
+a CompletionRequest (with an optional "id"), or a request of the OpenAI Batch format.
+All the requests are validated before the first one is sent.`)
+	var (
+		client      clientFlags
+		request     requestFlags
+		output      string
+		resume      bool
+		concurrency int
+		rateLimit   int
+		format      string
+	)
+	client.register(fs)
+	request.register(fs)
+	fs.StringVar(&output, "o", "", "output file (default stdout)")
+	fs.BoolVar(&resume, "resume", false, "keep the successful results of the output file and send the other requests")
+	fs.IntVar(&concurrency, "concurrency", 4, "number of requests sent concurrently")
+	fs.IntVar(&rateLimit, "rate-limit", 0, "maximum number of requests per minute (0: no limit)")
+	fs.StringVar(&format, "format", batchFormatAuto,
+		"output format: native, openai, or auto (openai if the input is in the OpenAI Batch format)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(format, batchFormatAuto, batchFormatNative, batchFormatOpenAI); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() > 1:
+		return usageError{msg: "too many arguments"}
+	case concurrency < 1:
+		return usageError{msg: "the concurrency must be at least 1"}
+	case rateLimit < 0:
+		return usageError{msg: "the rate limit must be positive"}
+	case resume && output == "":
+		return usageError{msg: "-resume requires an output file"}
+	}
+
+	in := env.stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	items, err := parseBatch(in, &request)
+	if err != nil {
+		return err
+	}
+	if format == batchFormatAuto {
+		format = batchFormatNative
+		if items[0].openAI {
+			format = batchFormatOpenAI
+		}
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	out := env.stdout
+	if output != "" {
+		if resume {
+			if done, err = resumeBatch(output); err != nil {
+				return err
+			}
+		}
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND|truncateUnless(resume), 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	var todo []batchItem
+	for _, item := range items {
+		if !done[item.id] {
+			todo = append(todo, item)
+		}
+	}
+
+	r := &batchRunner{
+		client:      c,
+		concurrency: concurrency,
+		limiter:     newLimiter(rateLimit),
+		out:         &batchWriter{w: out, openAI: format == batchFormatOpenAI},
+	}
+	failed, err := r.run(ctx, todo)
+	fmt.Fprintf(env.stderr, "batch: %d succeeded, %d failed, %d skipped\n",
+		r.succeeded, failed, len(items)-len(todo))
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", failed, len(todo))
+	}
+	return nil
+}
+
+// truncateUnless returns os.O_TRUNC if keep is false.
+func truncateUnless(keep bool) int {
+	if keep {
+		return 0
+	}
+	return os.O_TRUNC
+}
+
+// batchItem is a request of a batch.
+type batchItem struct {
+	id     string
+	openAI bool // the request is in the OpenAI Batch format
+	req    *perplexity.CompletionRequest
+}
+
+// batchLine is a line of a batch file. Lines with neither a prompt nor a custom_id are CompletionRequests.
+type batchLine struct {
+	ID     string  `json:"id"`
+	Prompt *string `json:"prompt"`
+	// Fields of the OpenAI Batch format.
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// parseBatch returns the requests of the batch file r, or a validationError listing the invalid lines.
+func parseBatch(r io.Reader, request *requestFlags) ([]batchItem, error) {
+	var (
+		items []batchItem
+		errs  []error
+		ids   = map[string]int{}
+	)
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read the batch: %w", err)
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			item, errLine := parseBatchLine(data, request)
+			if errLine == nil {
+				errLine = item.req.Validate()
+			}
+			if item.id == "" {
+				item.id = strconv.Itoa(n)
+			}
+			if first, ok := ids[item.id]; ok && errLine == nil {
+				errLine = fmt.Errorf("duplicate id %q (first on line %d)", item.id, first)
+			}
+			if errLine != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", n, errLine))
+			} else {
+				ids[item.id] = n
+				items = append(items, item)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, validationError{err: errors.Join(errs...)}
+	}
+	if len(items) == 0 {
+		return nil, usageError{msg: "the batch is empty"}
+	}
+	return items, nil
+}
+
+// parseBatchLine returns the request of a line. The flags give the default values of the requests.
+func parseBatchLine(data []byte, request *requestFlags) (batchItem, error) {
+	if data[0] == '"' {
+		var prompt string
+		if err := json.Unmarshal(data, &prompt); err != nil {
+			return batchItem{}, fmt.Errorf("invalid JSON: %w", err)
+		}
+		req, err := request.request(prompt)
+		return batchItem{req: req}, err
+	}
+	var line batchLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return batchItem{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	switch {
+	case line.CustomID != "":
+		if line.Method != "" && line.Method != "POST" {
+			return batchItem{}, fmt.Errorf("unexpected method %q", line.Method)
+		}
+		if len(line.Body) == 0 {
+			return batchItem{}, errors.New("the body of the request is missing")
+		}
+		req, err := decodeRequest(line.Body, request)
+		return batchItem{id: line.CustomID, openAI: true, req: req}, err
+	case line.Prompt != nil:
+		req, err := request.request(*line.Prompt)
+		return batchItem{id: line.ID, req: req}, err
+	}
+	req, err := decodeRequest(data, request)
+	return batchItem{id: line.ID, req: req}, err
+}
+
+// decodeRequest returns the CompletionRequest encoded in data. The missing fields are set by the flags.
+func decodeRequest(data []byte, request *requestFlags) (*perplexity.CompletionRequest, error) {
+	req := perplexity.NewCompletionRequest(request.options()...)
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	req.Stream = false
+	return req, nil
+}
+
+// batchResult is a line of the output in the native format.
+type batchResult struct {
+	ID        string            `json:"id"`
+	Response  string            `json:"response,omitempty"`
+	Citations []string          `json:"citations,omitempty"`
+	Usage     *perplexity.Usage `json:"usage,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// openAIBatchResult is a line of the output in the OpenAI Batch format.
+type openAIBatchResult struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *openAIBatchResponse `json:"response"`
+	Error    *openAIBatchError    `json:"error"`
+}
+
+type openAIBatchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type openAIBatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// batchWriter writes the results of a batch, one per line.
+// batchWriter is safe for concurrent use.
+type batchWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	openAI bool
+}
+
+// write writes the result of the request item.
+func (b *batchWriter) write(item batchItem, resp *perplexity.CompletionResponse, err error) error {
+	var result any
+	if b.openAI {
+		result = openAIResult(item, resp, err)
+	} else {
+		r := batchResult{ID: item.id}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Response = resp.GetLastContent()
+			r.Citations = resp.GetCitations()
+			r.Usage = &resp.Usage
+		}
+		result = r
+	}
+	data, errJSON := json.Marshal(result)
+	if errJSON != nil {
+		return fmt.Errorf("failed to marshal the result of %q: %w", item.id, errJSON)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write the result of %q: %w", item.id, err)
+	}
+	return nil
+}
+
+// openAIResult returns the result of the request item in the OpenAI Batch format.
+// The errors of the API are responses, the other errors have no response.
+func openAIResult(item batchItem, resp *perplexity.CompletionResponse, err error) openAIBatchResult {
+	r := openAIBatchResult{ID: "batch_req_" + item.id, CustomID: item.id}
+	var apiErr *perplexity.APIError
+	switch {
+	case err == nil:
+		body, _ := json.Marshal(resp)
+		r.ID = "batch_req_" + resp.ID
+		r.Response = &openAIBatchResponse{StatusCode: 200, RequestID: resp.ID, Body: body}
+	case errors.As(err, &apiErr):
+		r.Response = &openAIBatchResponse{StatusCode: apiErr.StatusCode, Body: json.RawMessage("null")}
+		if json.Valid([]byte(apiErr.Body)) {
+			r.Response.Body = json.RawMessage(apiErr.Body)
+		}
+	default:
+		r.Error = &openAIBatchError{Code: "request_failed", Message: err.Error()}
+	}
+	return r
+}
+
+// resumeBatch keeps only the successful results of the output file and returns their IDs.
+func resumeBatch(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resume: %w", err)
+	}
+	done := map[string]bool{}
+	var kept bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var result struct {
+			ID       string          `json:"id"`
+			Error    json.RawMessage `json:"error"`
+			CustomID string          `json:"custom_id"`
+			Response json.RawMessage `json:"response"`
+		}
+		// an interrupted run may have left a truncated last line
+		if json.Unmarshal(line, &result) != nil {
+			continue
+		}
+		id, ok := result.ID, len(result.Error) == 0
+		if result.CustomID != "" {
+			var resp openAIBatchResponse
+			id, ok = result.CustomID, json.Unmarshal(result.Response, &resp) == nil && resp.StatusCode == 200
+		}
+		if ok {
+			done[id] = true
+			kept.Write(line)
+			kept.WriteByte('\n')
+		}
+	}
+	if err := os.WriteFile(path, kept.Bytes(), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to resume: %w", err)
+	}
+	return done, nil
+}
+
+// batchRunner sends the requests of a batch.
+type batchRunner struct {
+	client      perplexity.Completer
+	concurrency int
+	limiter     *limiter
+	out         *batchWriter
+
+	mu        sync.Mutex
+	succeeded int
+	failed    int
+	err       error // first error writing the results
+}
+
+// run sends the requests and returns the number of failed requests.
+// The requests interrupted by the cancellation of ctx have no result.
+func (r *batchRunner) run(ctx context.Context, items []batchItem) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan batchItem)
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				if err := r.send(ctx, item); err != nil {
+					r.mu.Lock()
+					if r.err == nil {
+						r.err = err
+					}
+					r.mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+loop:
+	for _, item := range items {
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.failed, r.err
+	}
+	return r.failed, ctx.Err()
+}
+
+// send sends the request of item and writes its result.
+func (r *batchRunner) send(ctx context.Context, item batchItem) error {
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil
+	}
+	resp, err := r.client.SendCompletionRequestWithContext(ctx, item.req)
+	if ctx.Err() != nil {
+		return nil
+	}
+	r.mu.Lock()
+	if err != nil {
+		r.failed++
+	} else {
+		r.succeeded++
+	}
+	r.mu.Unlock()
+	return r.out.write(item, resp, err)
+}
+
+// limiter spaces out the requests to stay under a rate limit.
+// limiter is safe for concurrent use.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newLimiter returns a limiter allowing perMinute requests per minute, nil if perMinute is 0.
+func newLimiter(perMinute int) *limiter {
+	if perMinute == 0 {
+		return nil
+	}
+	return &limiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait waits for the next slot. A nil limiter never waits.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile writes the lines in a file of a temporary directory and returns its path.
+func writeFile(t *testing.T, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+	return path
+}
+
+// readResults returns the lines of the output file decoded in maps.
+func readResults(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var results []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &r), line)
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestBatch(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+
+	t.Run("runs prompts and requests", func(t *testing.T) {
+		srv.Reset()
+		input := writeFile(t, "batch.jsonl",
+			`"What's the capital of France?"`,
+			``,
+			`{"id": "italy", "prompt": "What's the capital of Italy?"}`,
+			`{"id": "spain", "model": "sonar-pro", "messages": [{"role": "user", "content": "What's the capital of Spain?"}]}`,
+		)
+		output := filepath.Join(t.TempDir(), "results.jsonl")
+		code, _, stderr := runCommand(t, srv, "", "batch", "-system", "Be brief", "-o", output, input)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Equal(t, "batch: 3 succeeded, 0 failed, 0 skipped\n", stderr)
+
+		results := readResults(t, output)
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r["id"].(string))
+			assert.Equal(t, perplexitytest.DefaultAnswer, r["response"])
+			assert.NotNil(t, r["usage"])
+		}
+		sort.Strings(ids)
+		assert.Equal(t, []string{"1", "italy", "spain"}, ids)
+
+		models := map[string]int{}
+		for _, req := range srv.Requests() {
+			models[req.Request.Model]++
+			if req.Request.Model == "sonar-pro" {
+				assert.Len(t, req.Request.Messages, 1)
+			} else {
+				assert.Equal(t, "Be brief", req.Request.Messages[0].Content)
+			}
+		}
+		assert.Equal(t, map[string]int{"sonar": 2, "sonar-pro": 1}, models)
+	})
+
+	t.Run("validates all the requests first", func(t *testing.T) {
+		srv.Reset()
+		input := writeFile(t, "batch.jsonl",
+			`"What's the capital of France?"`,
+			`{"prompt": "What's the capital of Italy?", "id": "1"}`,
+			`{"messages": [{"role": "user", "content": "Hi"}], "temperature": 3}`,
+			`not json`,
+		)
+		code, _, stderr := runCommand(t, srv, "", "batch", input)
+		assert.Equal(t, exitValidation, code)
+		assert.Contains(t, stderr, `line 2: duplicate id "1" (first on line 1)`)
+		assert.Contains(t, stderr, "line 3: ")
+		assert.Contains(t, stderr, "line 4: invalid JSON")
+		assert.Empty(t, srv.Requests())
+	})
+
+	t.Run("resumes from the output file", func(t *testing.T) {
+		srv.Reset()
+		input := writeFile(t, "batch.jsonl", `"one"`, `"two"`, `"three"`)
+		output := writeFile(t, "results.jsonl",
+			`{"id":"1","response":"done"}`,
+			`{"id":"2","error":"unexpected status code: 500"}`,
+			`{"id":"3","respo`,
+		)
+		srv.Enqueue(perplexitytest.Error(http.StatusTooManyRequests, "slow down"))
+		code, _, stderr := runCommand(t, srv, "", "batch", "-resume", "-concurrency", "1", "-o", output, input)
+		assert.Equal(t, exitError, code)
+		assert.Contains(t, stderr, "batch: 1 succeeded, 1 failed, 1 skipped\n")
+		assert.Contains(t, stderr, "1 of 2 requests failed")
+
+		code, _, stderr = runCommand(t, srv, "", "batch", "-resume", "-o", output, input)
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, "batch: 1 succeeded, 0 failed, 2 skipped\n", stderr)
+		results := readResults(t, output)
+		assert.Len(t, results, 3)
+		for _, r := range results {
+			assert.Nil(t, r["error"])
+		}
+		assert.Len(t, srv.Requests(), 3)
+	})
+
+	t.Run("OpenAI Batch format", func(t *testing.T) {
+		srv.Reset()
+		input := writeFile(t, "batch.jsonl",
+			`{"custom_id": "france", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "sonar", "messages": [{"role": "user", "content": "What's the capital of France?"}]}}`,
+			`{"custom_id": "italy", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "sonar", "messages": [{"role": "user", "content": "What's the capital of Italy?"}]}}`,
+		)
+		output := filepath.Join(t.TempDir(), "results.jsonl")
+		srv.Enqueue(perplexitytest.Answer("Paris."), perplexitytest.Error(http.StatusBadRequest, "invalid model"))
+		code, _, _ := runCommand(t, srv, "", "batch", "-concurrency", "1", "-o", output, input)
+		assert.Equal(t, exitError, code)
+
+		results := readResults(t, output)
+		assert.Len(t, results, 2)
+		assert.Equal(t, "france", results[0]["custom_id"])
+		assert.Nil(t, results[0]["error"])
+		resp := results[0]["response"].(map[string]any)
+		assert.Equal(t, 200.0, resp["status_code"])
+		assert.Equal(t, "Paris.", resp["body"].(map[string]any)["choices"].([]any)[0].(map[string]any)["message"].(map[string]any)["content"])
+		assert.Equal(t, "italy", results[1]["custom_id"])
+		assert.Equal(t, 400.0, results[1]["response"].(map[string]any)["status_code"])
+
+		// only the failed request is sent again
+		code, _, _ = runCommand(t, srv, "", "batch", "-resume", "-o", output, input)
+		assert.Equal(t, exitOK, code)
+		assert.Len(t, srv.Requests(), 3)
+		assert.Len(t, readResults(t, output), 2)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		srv.Reset()
+		input := writeFile(t, "batch.jsonl", `"one"`, `"two"`, `"three"`)
+		start := time.Now()
+		code, _, _ := runCommand(t, srv, "", "batch", "-rate-limit", "600", "-o", filepath.Join(t.TempDir(), "out.jsonl"), input)
+		assert.Equal(t, exitOK, code)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// chatHelp is the help of the slash commands of the chat.
+const chatHelp = `Commands:
+  /system [message]  show or set the system message
+  /model [name]      show or set the model
+  /reset             clear the history
+  /save [name]       save the session (under its current name by default)
+  /load <name>       restore a saved session
+  /retry             ask the last question again
+  /undo              remove the last question and its answer
+  /help              show this help
+  /exit              quit (or Ctrl-D)
+Ctrl-C cancels the answer being written.
+`
+
+// chat runs an interactive chat session reading the questions on stdin.
+func chat(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("chat", "[flags]",
+		"Starts an interactive chat: the answers are streamed with their citations, /help lists the commands.")
+	var (
+		client  clientFlags
+		request requestFlags
+		dir     string
+		name    string
+	)
+	client.register(fs)
+	request.register(fs)
+	fs.StringVar(&dir, "sessions", defaultSessionsDir(), "directory of the saved sessions")
+	fs.StringVar(&name, "session", "", "name of the session restored at start and saved after each answer")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{msg: "unexpected arguments: " + strings.Join(fs.Args(), " ")}
+	}
+	if err := request.validate("hello"); err != nil {
+		return err
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return err
+	}
+
+	s := &chatSession{
+		env:      env,
+		client:   c,
+		request:  &request,
+		messages: request.messages(),
+		dir:      dir,
+		name:     name,
+	}
+	if name != "" {
+		if err := s.restore(ctx, name); err != nil && !errors.Is(err, perplexity.ErrConversationNotFound) {
+			return err
+		}
+	}
+	return s.run(ctx)
+}
+
+// defaultSessionsDir returns the default directory of the saved sessions, empty if unknown.
+func defaultSessionsDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "perplexity", "sessions")
+}
+
+// chatSession is the state of an interactive chat.
+type chatSession struct {
+	env      *environment
+	client   perplexity.ChatClient
+	request  *requestFlags // the model can be changed by /model
+	messages perplexity.Messages
+	dir      string // directory of the saved sessions
+	name     string // name of the session, saved after each change if set
+}
+
+// run reads and handles the lines of stdin until EOF or /exit.
+func (s *chatSession) run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	lines := readLines(s.env.stdin, done)
+	for {
+		fmt.Fprint(s.env.stdout, "> ")
+		var line string
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.env.interrupts:
+			fmt.Fprintln(s.env.stdout, "\n(type /exit or press Ctrl-D to quit)")
+			continue
+		case l, ok := <-lines:
+			if !ok {
+				fmt.Fprintln(s.env.stdout)
+				return nil
+			}
+			line = strings.TrimSpace(l)
+		}
+
+		var err error
+		switch {
+		case line == "":
+			continue
+		case line == "/exit" || line == "/quit":
+			return nil
+		case strings.HasPrefix(line, "/"):
+			err = s.command(ctx, line)
+		default:
+			err = s.send(ctx, s.messages, perplexity.Message{Role: "user", Content: line})
+		}
+		if err != nil {
+			fmt.Fprintf(s.env.stderr, "error: %v\n", err)
+		}
+	}
+}
+
+// readLines sends the lines of r on the returned channel, closed at the end of r.
+func readLines(r io.Reader, done <-chan struct{}) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// send asks the user message msg after the messages of base, streams the answer and replaces
+// the history by base and the turn. An interrupt cancels the answer, the history is then unchanged.
+func (s *chatSession) send(ctx context.Context, base perplexity.Messages, msg perplexity.Message) error {
+	ctx, cancel := s.env.notifyContext(ctx)
+	defer cancel()
+	conv := perplexity.NewConversation(s.client, base, s.request.options()...)
+	_, err := sendStream(ctx, conv, msg, newStreamWriter(s.env.stdout, formatText))
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(s.env.stdout, "\n(canceled)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.update(ctx, conv.Messages())
+}
+
+// command runs a slash command.
+func (s *chatSession) command(ctx context.Context, line string) error {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/help":
+		fmt.Fprint(s.env.stdout, chatHelp)
+	case "/system":
+		if arg == "" {
+			if system := s.messages.GetSystemMessage(); system != "" {
+				fmt.Fprintln(s.env.stdout, system)
+			} else {
+				fmt.Fprintln(s.env.stdout, "(no system message)")
+			}
+			return nil
+		}
+		m, err := s.messages.Truncate(s.messages.Len(), arg)
+		if err != nil {
+			return err
+		}
+		return s.update(ctx, m)
+	case "/model":
+		if arg != "" {
+			s.request.model = arg
+		}
+		fmt.Fprintln(s.env.stdout, s.request.model)
+	case "/reset":
+		return s.update(ctx, perplexity.NewMessages(perplexity.WithSystemMessage(s.messages.GetSystemMessage())))
+	case "/save":
+		if arg == "" {
+			arg = s.name
+		}
+		if arg == "" {
+			return errors.New("usage: /save <name>")
+		}
+		store, err := s.store()
+		if err != nil {
+			return err
+		}
+		if err := store.Save(ctx, arg, s.messages); err != nil {
+			return err
+		}
+		s.name = arg
+		fmt.Fprintf(s.env.stdout, "session saved as %q\n", arg)
+	case "/load":
+		if arg == "" {
+			return errors.New("usage: /load <name>")
+		}
+		if err := s.restore(ctx, arg); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.env.stdout, "session %q loaded: %d messages\n", arg, s.messages.Len())
+	case "/undo":
+		if s.messages.Len() < 2 {
+			return errors.New("nothing to undo")
+		}
+		m, err := s.messages.Truncate(s.messages.Len()-2, s.messages.GetSystemMessage())
+		if err != nil {
+			return err
+		}
+		return s.update(ctx, m)
+	case "/retry":
+		if s.messages.Len() < 2 {
+			return errors.New("nothing to retry")
+		}
+		msgs := s.messages.GetMessages()
+		question := msgs[len(msgs)-2]
+		m, err := s.messages.Truncate(s.messages.Len()-2, s.messages.GetSystemMessage())
+		if err != nil {
+			return err
+		}
+		return s.send(ctx, m, question)
+	default:
+		return fmt.Errorf("unknown command %s, type /help for the list", name)
+	}
+	return nil
+}
+
+// update replaces the history and saves the session if it has a name.
+func (s *chatSession) update(ctx context.Context, m perplexity.Messages) error {
+	s.messages = m
+	if s.name == "" {
+		return nil
+	}
+	store, err := s.store()
+	if err != nil {
+		return err
+	}
+	return store.Save(ctx, s.name, m)
+}
+
+// restore replaces the history with the saved session name.
+func (s *chatSession) restore(ctx context.Context, name string) error {
+	store, err := s.store()
+	if err != nil {
+		return err
+	}
+	m, err := store.Load(ctx, name)
+	if err != nil {
+		return err
+	}
+	s.messages = m
+	s.name = name
+	return nil
+}
+
+// store returns the store of the saved sessions.
+func (s *chatSession) store() (*perplexity.FileStore, error) {
+	if s.dir == "" {
+		return nil, errors.New("the directory of the sessions is not set, use -sessions")
+	}
+	return perplexity.NewFileStore(s.dir)
+}
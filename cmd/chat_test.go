@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// contents returns the contents of the messages.
+func contents(msgs []perplexity.Message) []string {
+	var c []string
+	for _, m := range msgs {
+		c = append(c, m.Content)
+	}
+	return c
+}
+
+func TestChat(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+
+	t.Run("slash commands", func(t *testing.T) {
+		dir := t.TempDir()
+		srv.Reset()
+		srv.Enqueue(
+			perplexitytest.Stream([]string{"Par", "is[1]."}, "https://en.wikipedia.org/wiki/Paris"),
+			perplexitytest.Stream([]string{"Rome."}),
+			perplexitytest.Stream([]string{"Paris, again."}),
+		)
+		input := strings.Join([]string{
+			"/system Be brief",
+			"What's the capital of France?",
+			"/model sonar-pro",
+			"And Italy?",
+			"/undo",
+			"/retry",
+			"/save trip",
+			"/unknown",
+		}, "\n")
+		code, stdout, stderr := runCommand(t, srv, input, "chat", "-sessions", dir)
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stdout, "Paris[1].\n\nReferences:\n[1] Source 1 - https://en.wikipedia.org/wiki/Paris\n")
+		assert.Contains(t, stdout, `session saved as "trip"`)
+		assert.Equal(t, "error: unknown command /unknown, type /help for the list\n", stderr)
+
+		reqs := srv.Requests()
+		assert.Len(t, reqs, 3)
+		assert.Equal(t, []string{"Be brief", "What's the capital of France?"}, contents(reqs[0].Request.Messages))
+		assert.Equal(t, []string{"Be brief", "What's the capital of France?", "Paris[1].", "And Italy?"}, contents(reqs[1].Request.Messages))
+		assert.Equal(t, "sonar-pro", reqs[1].Request.Model)
+		// the turn of Italy was undone, the question on France is asked again
+		assert.Equal(t, []string{"Be brief", "What's the capital of France?"}, contents(reqs[2].Request.Messages))
+
+		store, err := perplexity.NewFileStore(dir)
+		assert.Nil(t, err)
+		m, err := store.Load(context.Background(), "trip")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Be brief", "What's the capital of France?", "Paris, again."}, contents(m.GetMessages()))
+	})
+
+	t.Run("restores and saves the session", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := perplexity.NewFileStore(dir)
+		assert.Nil(t, err)
+		m := perplexity.NewMessages()
+		assert.Nil(t, m.AddUserMessage("What's the capital of France?"))
+		assert.Nil(t, m.AddAgentMessage("Paris."))
+		assert.Nil(t, store.Save(context.Background(), "trip", m))
+
+		srv.Reset()
+		srv.Enqueue(perplexitytest.Stream([]string{"Rome."}))
+		code, _, stderr := runCommand(t, srv, "And Italy?\n", "chat", "-sessions", dir, "-session", "trip")
+		assert.Equal(t, exitOK, code, stderr)
+		last, _ := srv.LastRequest()
+		assert.Equal(t, []string{"What's the capital of France?", "Paris.", "And Italy?"}, contents(last.Request.Messages))
+
+		m, err = store.Load(context.Background(), "trip")
+		assert.Nil(t, err)
+		assert.Equal(t, 4, m.Len())
+
+		code, _, stderr = runCommand(t, srv, "/reset\n/undo\n", "chat", "-sessions", dir, "-session", "trip")
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, "error: nothing to undo\n", stderr)
+		m, err = store.Load(context.Background(), "trip")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, m.Len())
+	})
+
+	t.Run("Ctrl-C cancels the answer only", func(t *testing.T) {
+		srv.Reset()
+		srv.Enqueue(perplexitytest.Response{
+			StatusCode: 200,
+			Chunks:     strings.Split(strings.Repeat("x", 10), ""),
+			Delay:      100 * time.Millisecond,
+		})
+		t.Setenv(apiKeyEnv, perplexitytest.DefaultAPIKey)
+		stdin, input := io.Pipe()
+		interrupts := make(chan os.Signal, 1)
+		var stdout, stderr bytes.Buffer
+		env := &environment{
+			stdin:      stdin,
+			stdout:     &stdout,
+			stderr:     &stderr,
+			httpClient: srv.Server.Client(),
+			interrupts: interrupts,
+		}
+		codes := make(chan int, 1)
+		go func() {
+			codes <- run(context.Background(), []string{"chat", "-endpoint", srv.URL()}, env)
+		}()
+		_, _ = io.WriteString(input, "Write ten x\n")
+		assert.Eventually(t, func() bool { return len(srv.Requests()) == 1 }, time.Second, 10*time.Millisecond)
+		interrupts <- os.Interrupt
+		_, _ = io.WriteString(input, "/undo\n")
+		_, _ = io.WriteString(input, "/exit\n")
+		assert.Equal(t, exitOK, <-codes)
+		assert.Contains(t, stdout.String(), "(canceled)")
+		// the canceled turn is not in the history
+		assert.Equal(t, "error: nothing to undo\n", stderr.String())
+	})
+	t.Run("retries a question with its images", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := perplexity.NewFileStore(dir)
+		assert.Nil(t, err)
+		m := perplexity.NewMessages()
+		image := perplexity.NewImageURLPart("https://example.com/cat.png")
+		assert.Nil(t, m.AddUserMessageWithImages("What's on this picture?", image))
+		assert.Nil(t, m.AddAgentMessage("A dog."))
+		assert.Nil(t, store.Save(context.Background(), "pet", m))
+
+		srv.Reset()
+		srv.Enqueue(perplexitytest.Stream([]string{"A cat."}))
+		code, _, stderr := runCommand(t, srv, "/retry\n", "chat", "-sessions", dir, "-session", "pet")
+		assert.Equal(t, exitOK, code, stderr)
+		last, _ := srv.LastRequest()
+		assert.Equal(t, m.GetMessages()[0], last.Request.Messages[0])
+	})
+
+	t.Run("Ctrl-C cancels a retry only", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := perplexity.NewFileStore(dir)
+		assert.Nil(t, err)
+		m := perplexity.NewMessages()
+		assert.Nil(t, m.AddUserMessage("What's the capital of France?"))
+		assert.Nil(t, m.AddAgentMessage("Paris."))
+		assert.Nil(t, store.Save(context.Background(), "trip", m))
+
+		srv.Reset()
+		srv.Enqueue(perplexitytest.Response{
+			StatusCode: 200,
+			Chunks:     strings.Split(strings.Repeat("x", 10), ""),
+			Delay:      100 * time.Millisecond,
+		})
+		t.Setenv(apiKeyEnv, perplexitytest.DefaultAPIKey)
+		stdin, input := io.Pipe()
+		interrupts := make(chan os.Signal, 1)
+		var stdout, stderr bytes.Buffer
+		env := &environment{
+			stdin:      stdin,
+			stdout:     &stdout,
+			stderr:     &stderr,
+			httpClient: srv.Server.Client(),
+			interrupts: interrupts,
+		}
+		codes := make(chan int, 1)
+		go func() {
+			codes <- run(context.Background(), []string{"chat", "-endpoint", srv.URL(), "-sessions", dir, "-session", "trip"}, env)
+		}()
+		_, _ = io.WriteString(input, "/retry\n")
+		assert.Eventually(t, func() bool { return len(srv.Requests()) == 1 }, time.Second, 10*time.Millisecond)
+		interrupts <- os.Interrupt
+		_, _ = io.WriteString(input, "/save copy\n")
+		_, _ = io.WriteString(input, "/exit\n")
+		assert.Equal(t, exitOK, <-codes)
+		assert.Contains(t, stdout.String(), "(canceled)")
+		// the last turn is still in the history
+		m, err = store.Load(context.Background(), "copy")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"What's the capital of France?", "Paris."}, contents(m.GetMessages()))
+	})
+}
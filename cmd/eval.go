@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sgaunet/perplexity-go/v2/eval"
+)
+
+// Formats of the evaluation reports.
+const (
+	reportFormatMarkdown = "markdown"
+	reportFormatJSON     = "json"
+)
+
+// evaluate runs the evaluation described by a spec file and writes the report.
+func evaluate(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("eval", "[flags] spec",
+		`Sends the cases of the dataset of a spec file with each configuration of the spec,
+grades the answers with the graders of the spec and writes a report comparing the
+configurations: scores, errors, latencies, tokens and costs.`)
+	var (
+		client      clientFlags
+		output      string
+		format      string
+		concurrency int
+	)
+	client.register(fs)
+	fs.StringVar(&output, "o", "", "output file (default stdout)")
+	fs.StringVar(&format, "format", reportFormatMarkdown, "format of the report: markdown or json")
+	fs.IntVar(&concurrency, "concurrency", 4, "number of requests sent concurrently")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(format, reportFormatMarkdown, reportFormatJSON); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() != 1:
+		return usageError{msg: "a spec file is required"}
+	case concurrency < 1:
+		return usageError{msg: "the concurrency must be at least 1"}
+	}
+	report, err := runEval(ctx, env, &client, fs.Arg(0), concurrency)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if format == reportFormatJSON {
+		if data, err = report.JSON(); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	} else {
+		data = []byte(report.Markdown())
+	}
+	if output == "" {
+		_, err = env.stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(output, data, 0o644)
+}
+
+// runEval runs the evaluation of the spec file path.
+func runEval(ctx context.Context, env *environment, client *clientFlags, path string, concurrency int) (*eval.Report, error) {
+	spec, err := eval.LoadSpec(path)
+	if err != nil {
+		return nil, validationError{err: err}
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return nil, err
+	}
+	graders, err := spec.NewGraders(c)
+	if err != nil {
+		return nil, validationError{err: err}
+	}
+	runner := eval.NewRunner(c,
+		eval.WithGraders(graders...),
+		eval.WithConcurrency(concurrency),
+		eval.WithCostFunc(spec.CostFunc()),
+	)
+	report, err := runner.Run(ctx, spec.Cases, spec.Configs...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, validationError{err: err}
+	}
+	for _, s := range report.Summaries {
+		fmt.Fprintf(env.stderr, "eval: %s: %d cases, %d errors\n", s.Config.Name, s.Cases, s.Errors)
+	}
+	return report, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEval(t *testing.T) {
+	srv := perplexitytest.NewServer(perplexitytest.WithHandler(func(req *perplexity.CompletionRequest) perplexitytest.Response {
+		r := perplexitytest.Answer("Paris", "https://en.wikipedia.org/wiki/Paris")
+		r.Completion.Model = req.Model
+		r.Completion.Usage = perplexity.Usage{PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000}
+		return r
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cases.jsonl"), []byte(
+		`{"id": "france", "input": "What's the capital of France?", "expected": "Paris"}`+"\n"+
+			`{"id": "italy", "input": "What's the capital of Italy?", "expected": "Rome"}`+"\n"), 0o644))
+	spec := filepath.Join(dir, "spec.yaml")
+	assert.Nil(t, os.WriteFile(spec, []byte(`
+dataset: cases.jsonl
+configs:
+  - {model: sonar}
+  - {model: sonar-pro}
+graders:
+  - {type: exact}
+  - {type: citations, min: 1}
+prices:
+  sonar-pro: {input: 3, output: 15}
+`), 0o644))
+
+	t.Run("writes a markdown report", func(t *testing.T) {
+		srv.Reset()
+		code, stdout, stderr := runCommand(t, srv, "", "eval", spec)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Equal(t, "eval: sonar: 2 cases, 0 errors\neval: sonar-pro: 2 cases, 0 errors\n", stderr)
+		assert.Len(t, srv.Requests(), 4)
+		assert.Contains(t, stdout, "| sonar | sonar | 2 | 0 | 0.50 (50% pass) | 1.00 (100% pass) |")
+		// sonar has the default prices: 4000 tokens at $1 per million and 2 requests at $5 per thousand.
+		assert.Contains(t, stdout, " | 4000 | $0.0140 |\n")
+		assert.Contains(t, stdout, " | 4000 | $0.0360 |\n")
+		assert.Contains(t, stdout, "| italy | exact ✗ citations ✓ | exact ✗ citations ✓ |")
+	})
+
+	t.Run("writes a JSON report", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "report.json")
+		code, stdout, stderr := runCommand(t, srv, "", "eval", "-format", "json", "-o", output, spec)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Empty(t, stdout)
+		data, err := os.ReadFile(output)
+		assert.Nil(t, err)
+		var report struct {
+			Summaries []struct {
+				Cost   float64            `json:"cost"`
+				Scores map[string]float64 `json:"scores"`
+			} `json:"summaries"`
+		}
+		assert.Nil(t, json.Unmarshal(data, &report))
+		assert.Len(t, report.Summaries, 2)
+		assert.InDelta(t, 0.036, report.Summaries[1].Cost, 1e-9)
+		assert.Equal(t, 0.5, report.Summaries[0].Scores["exact"])
+	})
+
+	t.Run("rejects invalid specs", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "spec.yaml")
+		assert.Nil(t, os.WriteFile(invalid, []byte("cases: [{input: Hello}]\nconfigs: [{model: sonar}]\ngraders: [{type: bleu}]\n"), 0o644))
+		code, _, stderr := runCommand(t, srv, "", "eval", invalid)
+		assert.Equal(t, exitValidation, code)
+		assert.Contains(t, stderr, `unknown type "bleu"`)
+
+		code, _, _ = runCommand(t, srv, "", "eval")
+		assert.Equal(t, exitUsage, code)
+	})
+}
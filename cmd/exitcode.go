@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Exit codes of the command.
+const (
+	exitOK         = 0
+	exitError      = 1 // unexpected error (network, I/O...)
+	exitUsage      = 2 // invalid flags or arguments
+	exitValidation = 3 // invalid request, rejected locally or by the API
+	exitAuth       = 4 // API key missing or rejected
+	exitRateLimit  = 5 // too many requests
+	exitServer     = 6 // error of the API
+	exitCanceled   = 130
+)
+
+// errMissingAPIKey is returned when the API key is not set.
+var errMissingAPIKey = errors.New("the API key must be set in the environment variable " + apiKeyEnv)
+
+// validationError is an error of the validation of a request.
+type validationError struct {
+	err error
+}
+
+func (e validationError) Error() string {
+	return "invalid request: " + e.err.Error()
+}
+
+func (e validationError) Unwrap() error {
+	return e.err
+}
+
+// usageError is an error in the flags or arguments of the command.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// exitCode returns the exit code of the command for err.
+func exitCode(err error) int {
+	var (
+		apiErr   *perplexity.APIError
+		usageErr usageError
+		valErr   validationError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &valErr):
+		return exitValidation
+	case errors.Is(err, errMissingAPIKey):
+		return exitAuth
+	case errors.Is(err, context.Canceled):
+		return exitCanceled
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return exitAuth
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return exitRateLimit
+		case apiErr.StatusCode >= http.StatusInternalServerError:
+			return exitServer
+		case apiErr.StatusCode >= http.StatusBadRequest:
+			return exitValidation
+		}
+	}
+	return exitError
+}
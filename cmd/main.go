@@ -1,75 +1,152 @@
+// Command perplexity is a command-line client of the Perplexity API.
+//
+//	perplexity [ask] [flags] [prompt...]
+//	perplexity chat [flags]
+//	perplexity batch [flags] [file]
+//	perplexity eval [flags] spec
+//	perplexity proxy [flags]
+//	perplexity snapshot [flags] [file]
+//	perplexity watch [flags] spec
+//	perplexity mcp [flags]
+//
+// The API key is read from the environment variable PPLX_API_KEY.
+// Run perplexity -h for the list of commands and flags.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"sync"
-
-	"github.com/sgaunet/perplexity-go/v2"
+	"os/signal"
+	"sort"
+	"strings"
 )
 
-// This example demonstrates how to create a completion request with a message
-// It then sends the request to the API and prints the last completion content.
-func main() {
-	client := perplexity.NewClient(os.Getenv("PPLX_API_KEY"))
-	msg := []perplexity.Message{
-		{
-			Role:    "user",
-			Content: "Wat's the capital of France?",
-		},
-	}
-	req := perplexity.NewCompletionRequest(perplexity.WithMessages(msg))
-	err := req.Validate()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
-	}
+// command is a subcommand of the tool.
+type command struct {
+	run   func(ctx context.Context, env *environment, args []string) error
+	short string
+	// interactive commands handle the interrupts, the others are canceled by the first one.
+	interactive bool
+}
 
-	res, err := client.SendCompletionRequest(req)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+// commands are the subcommands, by name. The default command is ask.
+var commands = map[string]command{
+	"ask":      {run: ask, short: "send a prompt and write the answer (default)"},
+	"batch":    {run: batch, short: "send the requests of a JSONL file"},
+	"chat":     {run: chat, short: "chat interactively", interactive: true},
+	"eval":     {run: evaluate, short: "compare models and prompts on a dataset"},
+	"mcp":      {run: mcp, short: "run a Model Context Protocol server over stdio"},
+	"proxy":    {run: proxy, short: "serve the chat completions API of OpenAI with Perplexity"},
+	"snapshot": {run: snapshotCmd, short: "check the answers against golden snapshots"},
+	"watch":    {run: watchCmd, short: "report the changes of the answers to scheduled queries"},
+}
+
+// environment holds the streams of the command.
+type environment struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// httpClient is the HTTP client of the API clients (the default one if nil).
+	httpClient *http.Client
+	// interrupts receives the interrupt signals (Ctrl-C).
+	interrupts <-chan os.Signal
+}
+
+// notifyContext returns a copy of ctx canceled by the next interrupt.
+func (env *environment) notifyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-env.interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// flagSet returns a flag set writing its errors and usage on stderr.
+func (env *environment) flagSet(name, synopsis, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(env.stderr, "Usage: perplexity %s %s\n\n%s\n\nFlags:\n", name, synopsis, description)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	fmt.Println(res.GetLastContent())
-	for i, c := range res.GetCitations() {
-		fmt.Printf("Citation %d: %s", i+1, c)
+func main() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	env := &environment{
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		interrupts: interrupts,
 	}
-	fmt.Println("*************")
+	os.Exit(run(context.Background(), os.Args[1:], env))
+}
 
-	// Support also server-sent events
-	req = perplexity.NewCompletionRequest(perplexity.WithMessages(msg), perplexity.WithStream(true))
-	err = req.Validate()
+// run runs the command line args and returns the exit code.
+func run(ctx context.Context, args []string, env *environment) int {
+	name := "ask"
+	if len(args) > 0 {
+		switch _, ok := commands[args[0]]; {
+		case ok:
+			name, args = args[0], args[1:]
+		case args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+			usage(env.stderr)
+			return exitOK
+		}
+	}
+	cmd := commands[name]
+	if !cmd.interactive {
+		var cancel context.CancelFunc
+		ctx, cancel = env.notifyContext(ctx)
+		defer cancel()
+	}
+	err := cmd.run(ctx, env, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(env.stderr, "perplexity: %v\n", err)
 	}
+	return exitCode(err)
+}
 
-	var wg sync.WaitGroup
-	chResponses := make(chan perplexity.CompletionResponse, 5)
-	fullResponse := perplexity.CompletionResponse{}
+// usage writes the list of commands.
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "  %-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(w, `Usage: perplexity [command] [flags] [arguments]
 
-	waitAfterGoroutine := make(chan struct{})
-	wg.Add(1)
-	go func() {
-		waitAfterGoroutine <- struct{}{}
-		err = client.SendSSEHTTPRequest(&wg, req, chResponses)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
-		}
-	}()
+Commands:
+%s
+Run perplexity <command> -h for the flags of a command.
+The API key is read from the environment variable %s.
 
-	<-waitAfterGoroutine
-	for msg := range chResponses {
-		fullResponse = msg
-	}
-	// perplexity.TreatSSEData(chResponses)
-	wg.Wait()
-	fmt.Println("----------------")
-	fmt.Println(fullResponse.GetLastContent())
-	// if err != nil {
-	// 	fmt.Printf("Error: %v\n", err)
-	// 	os.Exit(1)
-	// }
+Exit codes:
+  %3d  success
+  %3d  unexpected error
+  %3d  invalid flags or arguments
+  %3d  invalid request
+  %3d  API key missing or rejected
+  %3d  rate limited
+  %3d  server error
+  %3d  interrupted
+`, sb.String(), apiKeyEnv, exitOK, exitError, exitUsage, exitValidation, exitAuth, exitRateLimit, exitServer, exitCanceled)
 }
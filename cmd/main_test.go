@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// runCommand runs the command line args against srv and returns the exit code, stdout and stderr.
+func runCommand(t *testing.T, srv *perplexitytest.Server, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv(apiKeyEnv, perplexitytest.DefaultAPIKey)
+	var stdout, stderr bytes.Buffer
+	env := &environment{
+		stdin:      strings.NewReader(stdin),
+		stdout:     &stdout,
+		stderr:     &stderr,
+		httpClient: srv.Server.Client(),
+	}
+	args = append([]string{args[0], "-endpoint", srv.URL()}, args[1:]...)
+	code := run(context.Background(), args, env)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestAsk(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+
+	t.Run("maps the flags to the request", func(t *testing.T) {
+		code, _, stderr := runCommand(t, srv, "", "ask", "-model", "sonar-pro", "-system", "Be brief",
+			"-temperature", "0.5", "-domain", "wikipedia.org,-reddit.com", "-recency", "week",
+			"-return-related-questions", "What's", "the capital?")
+		assert.Equal(t, exitOK, code, stderr)
+		last, _ := srv.LastRequest()
+		req := last.Request
+		assert.Equal(t, "sonar-pro", req.Model)
+		assert.Equal(t, 0.5, req.Temperature)
+		assert.Equal(t, []string{"wikipedia.org", "-reddit.com"}, req.SearchDomainFilter)
+		assert.Equal(t, "week", req.SearchRecencyFilter)
+		assert.True(t, req.ReturnRelatedQuestions)
+		assert.Equal(t, []perplexity.Message{
+			{Role: "system", Content: "Be brief"},
+			{Role: "user", Content: "What's the capital?"},
+		}, req.Messages)
+	})
+
+	t.Run("reads the prompt from stdin", func(t *testing.T) {
+		code, _, _ := runCommand(t, srv, "  from stdin\n", "ask")
+		assert.Equal(t, exitOK, code)
+		last, _ := srv.LastRequest()
+		assert.Equal(t, "from stdin", last.Request.Messages[0].Content)
+
+		code, _, stderr := runCommand(t, srv, "", "ask")
+		assert.Equal(t, exitUsage, code)
+		assert.Contains(t, stderr, "the prompt is empty")
+	})
+
+	t.Run("writes the answer in the format", func(t *testing.T) {
+		answer := perplexitytest.Answer("Paris[1].", "https://en.wikipedia.org/wiki/Paris")
+		answer.Completion.RelatedQuestions = []string{"What's the population of Paris?"}
+		for _, tc := range []struct {
+			format, want string
+		}{
+			{format: "text", want: "Paris[1].\n\nReferences:\n[1] Source 1 - https://en.wikipedia.org/wiki/Paris\n\nRelated questions:\n- What's the population of Paris?\n"},
+			{format: "markdown", want: "Paris[^1].\n\n[^1]: [Source 1](https://en.wikipedia.org/wiki/Paris)\n\n**Related questions**\n\n- What's the population of Paris?\n"},
+		} {
+			srv.Enqueue(answer)
+			code, stdout, _ := runCommand(t, srv, "", "ask", "-format", tc.format, "capital")
+			assert.Equal(t, exitOK, code)
+			assert.Equal(t, tc.want, stdout, tc.format)
+		}
+
+		srv.Enqueue(answer)
+		code, stdout, _ := runCommand(t, srv, "", "ask", "-format", "json", "capital")
+		assert.Equal(t, exitOK, code)
+		var resp perplexity.CompletionResponse
+		assert.Nil(t, json.Unmarshal([]byte(stdout), &resp))
+		assert.Equal(t, "Paris[1].", resp.GetLastContent())
+
+		code, _, _ = runCommand(t, srv, "", "ask", "-format", "yaml", "capital")
+		assert.Equal(t, exitUsage, code)
+	})
+
+	t.Run("streams the answer", func(t *testing.T) {
+		srv.Enqueue(perplexitytest.Stream([]string{"Par", "is[1]", "."}, "https://en.wikipedia.org/wiki/Paris"))
+		code, stdout, _ := runCommand(t, srv, "", "ask", "-stream", "-format", "markdown", "capital")
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, "Paris[^1].\n\n[^1]: [Source 1](https://en.wikipedia.org/wiki/Paris)\n\n", stdout)
+
+		srv.Enqueue(perplexitytest.Stream([]string{"Par", "is."}))
+		code, stdout, _ = runCommand(t, srv, "", "ask", "-stream", "-format", "json", "capital")
+		assert.Equal(t, exitOK, code)
+		assert.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 2)
+	})
+
+	t.Run("exit codes distinguish the errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			resp perplexitytest.Response
+			code int
+		}{
+			{resp: perplexitytest.Error(http.StatusUnauthorized, "invalid key"), code: exitAuth},
+			{resp: perplexitytest.Error(http.StatusTooManyRequests, "slow down"), code: exitRateLimit},
+			{resp: perplexitytest.Error(http.StatusBadGateway, "oops"), code: exitServer},
+			{resp: perplexitytest.Error(http.StatusBadRequest, "invalid model"), code: exitValidation},
+		} {
+			srv.Enqueue(tc.resp)
+			code, _, _ := runCommand(t, srv, "", "ask", "capital")
+			assert.Equal(t, tc.code, code, tc.resp.StatusCode)
+		}
+		code, _, _ := runCommand(t, srv, "", "ask", "-temperature", "3", "capital")
+		assert.Equal(t, exitValidation, code)
+		code, _, _ = runCommand(t, srv, "", "ask", "-unknown", "capital")
+		assert.Equal(t, exitUsage, code)
+
+		t.Setenv(apiKeyEnv, "")
+		assert.Equal(t, exitAuth, run(context.Background(), []string{"capital"}, &environment{
+			stdin: strings.NewReader(""), stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{},
+		}))
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/mcpserver"
+)
+
+// mcp runs a Model Context Protocol server on stdin and stdout.
+func mcp(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("mcp", "[flags]",
+		`Runs a Model Context Protocol server over stdio, providing the tools ask, research and search.
+Add it to the MCP servers of your agent with the command "perplexity mcp".`)
+	var (
+		client         clientFlags
+		searchEndpoint string
+		model          string
+		researchModel  string
+	)
+	client.register(fs)
+	fs.StringVar(&searchEndpoint, "search-endpoint", perplexity.DefaultSearchEndpoint, "endpoint of the Search API")
+	fs.StringVar(&model, "model", perplexity.DefaultModel, "default model of the ask tool")
+	fs.StringVar(&researchModel, "research-model", mcpserver.DefaultResearchModel, "default model of the research tool")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{msg: "unexpected arguments"}
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return err
+	}
+	c.SetSearchEndpoint(searchEndpoint)
+	srv := mcpserver.NewServer(c, mcpserver.WithModel(model), mcpserver.WithResearchModel(researchModel))
+	return srv.Serve(ctx, env.stdin, env.stdout)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMCP(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(perplexitytest.Stream([]string{"Paris", "[1]."}, "https://en.wikipedia.org/wiki/Paris"))
+
+	input := strings.Join([]string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-06-18", "capabilities": {}, "clientInfo": {"name": "test", "version": "1"}}}`,
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "ask", "arguments": {"question": "What's the capital of France?", "search_recency_filter": "week"}, "_meta": {"progressToken": 7}}}`,
+	}, "\n")
+	code, stdout, stderr := runCommand(t, srv, input, "mcp", "-model", "sonar-pro")
+	assert.Equal(t, exitOK, code, stderr)
+
+	var msgs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		var m map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &m), line)
+		msgs = append(msgs, m)
+	}
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "2025-06-18", msgs[0]["result"].(map[string]any)["protocolVersion"])
+	for _, m := range msgs[1:3] {
+		assert.Equal(t, "notifications/progress", m["method"])
+		assert.Equal(t, 7.0, m["params"].(map[string]any)["progressToken"])
+	}
+	result := msgs[3]["result"].(map[string]any)
+	assert.Equal(t, []any{"https://en.wikipedia.org/wiki/Paris"}, result["structuredContent"].(map[string]any)["citations"])
+
+	last, _ := srv.LastRequest()
+	assert.Equal(t, "sonar-pro", last.Request.Model)
+	assert.Equal(t, "week", last.Request.SearchRecencyFilter)
+	assert.True(t, last.Request.Stream)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Output formats of the answers.
+const (
+	formatText     = "text"
+	formatMarkdown = "markdown"
+	formatJSON     = "json"
+)
+
+// renderer returns the renderer of the format, nil for JSON.
+func renderer(format string) perplexity.Renderer {
+	switch format {
+	case formatText:
+		return perplexity.TextRenderer{Endnotes: true}
+	case formatMarkdown:
+		return perplexity.MarkdownRenderer{}
+	}
+	return nil
+}
+
+// writeResponse writes the answer of resp in format.
+func writeResponse(w io.Writer, format string, resp *perplexity.CompletionResponse) error {
+	if format == formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+	answer := strings.TrimRight(renderer(format).Render(resp), "\n")
+	_, err := fmt.Fprintf(w, "%s\n%s", answer, extras(format, resp))
+	return err
+}
+
+// extras returns the related questions and images of resp, formatted for text or Markdown.
+func extras(format string, resp *perplexity.CompletionResponse) string {
+	var sb strings.Builder
+	title := func(s string) {
+		if format == formatMarkdown {
+			fmt.Fprintf(&sb, "\n**%s**\n\n", s)
+		} else {
+			fmt.Fprintf(&sb, "\n%s:\n", s)
+		}
+	}
+	if len(resp.RelatedQuestions) > 0 {
+		title("Related questions")
+		for _, q := range resp.RelatedQuestions {
+			fmt.Fprintf(&sb, "- %s\n", q)
+		}
+	}
+	if len(resp.Images) > 0 {
+		title("Images")
+		for _, img := range resp.Images {
+			if format == formatMarkdown {
+				fmt.Fprintf(&sb, "- ![](%s)\n", img.ImageURL)
+			} else {
+				fmt.Fprintf(&sb, "- %s\n", img.ImageURL)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// streamWriter writes the events of a stream in a format.
+type streamWriter struct {
+	w      io.Writer
+	format string
+	stream *perplexity.StreamRenderer
+	enc    *json.Encoder
+}
+
+func newStreamWriter(w io.Writer, format string) *streamWriter {
+	s := &streamWriter{w: w, format: format}
+	if format == formatJSON {
+		// one event per line
+		s.enc = json.NewEncoder(w)
+	} else {
+		s.stream = renderer(format).Stream(w)
+	}
+	return s
+}
+
+// write writes an event.
+func (s *streamWriter) write(event perplexity.CompletionResponse) error {
+	if s.enc != nil {
+		return s.enc.Encode(event)
+	}
+	return s.stream.WriteChunk(event)
+}
+
+// close ends the answer with the citations and the extras of the complete response.
+func (s *streamWriter) close(resp *perplexity.CompletionResponse) error {
+	if s.enc != nil {
+		return nil
+	}
+	if err := s.stream.Close(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(s.w, "\n%s", extras(s.format, resp))
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2/openaiproxy"
+)
+
+// shutdownTimeout is the time left to the requests in flight when the proxy stops.
+const shutdownTimeout = 10 * time.Second
+
+// proxy serves the chat completions API of OpenAI with Perplexity until interrupted.
+func proxy(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("proxy", "[flags]",
+		`Serves the chat completions API of OpenAI (POST /v1/chat/completions) with Perplexity.
+The callers use the API key of the environment, unless -tokens maps their bearer tokens to API keys.`)
+	var (
+		client clientFlags
+		addr   string
+		tokens string
+		model  string
+	)
+	client.register(fs)
+	fs.StringVar(&addr, "addr", "localhost:8080", "address to listen on")
+	fs.StringVar(&tokens, "tokens", "", `JSON file mapping the bearer tokens of the callers to API keys: {"token": "pplx-..."}`)
+	fs.StringVar(&model, "model", "", "model of the requests without one (the default model if empty)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{msg: "unexpected arguments"}
+	}
+
+	httpClient := env.httpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: client.timeout}
+	}
+	opts := []openaiproxy.Option{
+		openaiproxy.WithEndpoint(client.endpoint),
+		openaiproxy.WithHTTPClient(httpClient),
+	}
+	if model != "" {
+		opts = append(opts, openaiproxy.WithDefaultModel(model))
+	}
+	if tokens != "" {
+		m, err := readTokens(tokens)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, openaiproxy.WithTokens(m))
+	} else {
+		apiKey := os.Getenv(apiKeyEnv)
+		if apiKey == "" {
+			return errMissingAPIKey
+		}
+		opts = append(opts, openaiproxy.WithAPIKey(apiKey))
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           openaiproxy.NewServer(opts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errServe := make(chan error, 1)
+	go func() {
+		errServe <- srv.Serve(ln)
+	}()
+	fmt.Fprintf(env.stderr, "listening on http://%s/v1\n", ln.Addr())
+
+	select {
+	case err := <-errServe:
+		return err
+	case <-ctx.Done():
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to stop the proxy: %w", err)
+	}
+	if err := <-errServe; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// readTokens reads the JSON file mapping the bearer tokens to API keys.
+func readTokens(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, usageError{msg: fmt.Sprintf("invalid tokens file %s: %v", path, err)}
+	}
+	if len(tokens) == 0 {
+		return nil, usageError{msg: fmt.Sprintf("no token in %s", path)}
+	}
+	return tokens, nil
+}
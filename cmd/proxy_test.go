@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
+
+func TestProxy(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	tokens := writeFile(t, "tokens.json", `{"alice": "`+perplexitytest.DefaultAPIKey+`"}`)
+
+	var stderr syncBuffer
+	env := &environment{stdin: strings.NewReader(""), stdout: &syncBuffer{}, stderr: &stderr, httpClient: srv.Server.Client()}
+	ctx, cancel := context.WithCancel(context.Background())
+	codes := make(chan int, 1)
+	go func() {
+		codes <- run(ctx, []string{"proxy", "-addr", "127.0.0.1:0", "-endpoint", srv.URL(), "-tokens", tokens}, env)
+	}()
+
+	listening := regexp.MustCompile(`listening on (http://\S+)`)
+	assert.Eventually(t, func() bool { return listening.MatchString(stderr.String()) }, time.Second, 10*time.Millisecond)
+	url := listening.FindStringSubmatch(stderr.String())[1] + "/chat/completions"
+
+	for token, status := range map[string]int{"alice": http.StatusOK, "bob": http.StatusUnauthorized} {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"model": "sonar", "messages": [{"role": "user", "content": "Hi"}]}`))
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, token)
+	}
+	cancel()
+	assert.Equal(t, exitOK, <-codes)
+
+	code := run(context.Background(), []string{"proxy", "-tokens", filepath.Join(t.TempDir(), "missing.json")}, env)
+	assert.Equal(t, exitError, code)
+}
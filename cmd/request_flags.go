@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// apiKeyEnv is the environment variable holding the API key.
+const apiKeyEnv = "PPLX_API_KEY"
+
+// clientFlags are the flags configuring the client.
+type clientFlags struct {
+	endpoint string
+	timeout  time.Duration
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.endpoint, "endpoint", perplexity.DefaultEndpoint, "endpoint of the API")
+	fs.DurationVar(&f.timeout, "timeout", 2*time.Minute, "timeout of a request")
+}
+
+// newClient returns a client using the API key of the environment.
+func (f *clientFlags) newClient(env *environment) (*perplexity.Client, error) {
+	apiKey := os.Getenv(apiKeyEnv)
+	if apiKey == "" {
+		return nil, errMissingAPIKey
+	}
+	client := perplexity.NewClient(apiKey)
+	if env.httpClient != nil {
+		client.SetHTTPClient(env.httpClient)
+	}
+	client.SetEndpoint(f.endpoint)
+	client.SetHTTPTimeout(f.timeout)
+	return client, nil
+}
+
+// requestFlags are the flags mapping to the options of a CompletionRequest.
+type requestFlags struct {
+	model                  string
+	system                 string
+	maxTokens              int
+	temperature            float64
+	topP                   float64
+	topK                   int
+	presencePenalty        float64
+	frequencyPenalty       float64
+	domains                []string
+	recency                string
+	returnImages           bool
+	returnRelatedQuestions bool
+}
+
+func (f *requestFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.model, "model", perplexity.DefaultModel, "model answering the prompt")
+	fs.StringVar(&f.system, "system", "", "system message")
+	fs.IntVar(&f.maxTokens, "max-tokens", perplexity.DefaultMaxTokens, "maximum number of tokens of the answer (0: no limit)")
+	fs.Float64Var(&f.temperature, "temperature", perplexity.DefaultTemperature, "randomness of the answer, in ]0, 2[")
+	fs.Float64Var(&f.topP, "top-p", perplexity.DefaultTopP, "nucleus sampling threshold, in ]0, 1[")
+	fs.IntVar(&f.topK, "top-k", perplexity.DefaultTopK, "number of tokens kept for top-k filtering (0: disabled)")
+	fs.Float64Var(&f.presencePenalty, "presence-penalty", perplexity.DefaultPresencePenalty, "penalty of the tokens already present, in [-2, 2]")
+	fs.Float64Var(&f.frequencyPenalty, "frequency-penalty", perplexity.DefaultFrequencyPenalty, "penalty of the frequent tokens, greater than 0")
+	fs.Func("domain", "limit the search to a domain, or exclude it with a leading - (repeatable, comma-separated)", func(s string) error {
+		for _, d := range strings.Split(s, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				f.domains = append(f.domains, d)
+			}
+		}
+		return nil
+	})
+	fs.StringVar(&f.recency, "recency", "", "limit the search to results of the last hour, day, week or month")
+	fs.BoolVar(&f.returnImages, "return-images", false, "return images")
+	fs.BoolVar(&f.returnRelatedQuestions, "return-related-questions", false, "return related questions")
+}
+
+// options returns the options of the requests, without the messages.
+func (f *requestFlags) options() []perplexity.CompletionRequestOption {
+	return []perplexity.CompletionRequestOption{
+		perplexity.WithModel(f.model),
+		perplexity.WithMaxTokens(f.maxTokens),
+		perplexity.WithTemperature(f.temperature),
+		perplexity.WithTopP(f.topP),
+		perplexity.WithTopK(f.topK),
+		perplexity.WithPresencePenalty(f.presencePenalty),
+		perplexity.WithFrequencyPenalty(f.frequencyPenalty),
+		perplexity.WithSearchDomainFilter(f.domains),
+		perplexity.WithSearchRecencyFilter(f.recency),
+		perplexity.WithReturnImages(f.returnImages),
+		perplexity.WithReturnRelatedQuestions(f.returnRelatedQuestions),
+	}
+}
+
+// messages returns the messages starting a conversation: the system message if set.
+func (f *requestFlags) messages() perplexity.Messages {
+	if f.system == "" {
+		return perplexity.NewMessages()
+	}
+	return perplexity.NewMessages(perplexity.WithSystemMessage(f.system))
+}
+
+// request returns the request asking prompt.
+func (f *requestFlags) request(prompt string) (*perplexity.CompletionRequest, error) {
+	messages := f.messages()
+	if err := messages.AddUserMessage(prompt); err != nil {
+		return nil, err
+	}
+	return perplexity.NewCompletionRequest(append(f.options(), perplexity.WithMessages(messages.GetMessages()))...), nil
+}
+
+// validate returns a validationError if the request asking prompt is invalid.
+func (f *requestFlags) validate(prompt string) error {
+	req, err := f.request(prompt)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		return validationError{err: err}
+	}
+	return nil
+}
+
+// parseFlags parses args, returning a usageError if they are invalid.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// checkFormat returns a usageError if format is not one of formats.
+func checkFormat(format string, formats ...string) error {
+	for _, f := range formats {
+		if format == f {
+			return nil
+		}
+	}
+	return usageError{msg: fmt.Sprintf("invalid format %q: must be one of %s", format, strings.Join(formats, ", "))}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sgaunet/perplexity-go/v2/snapshot"
+)
+
+// snapshotCmd checks the answers to the requests of a JSONL file against their golden snapshots.
+func snapshotCmd(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("snapshot", "[flags] [file]",
+		`Sends the requests of a JSONL file (or stdin) and compares the answers with their golden
+snapshots: similarity of the texts, overlap of the citations and presence of the key facts.
+Each line has a "name", a "prompt" or a "request" (a CompletionRequest), and optional "facts".
+The differences of the answers that drifted are written. With -update, the missing snapshots
+are recorded and the ones that drifted are replaced.`)
+	var (
+		client     clientFlags
+		dir        string
+		update     bool
+		thresholds = snapshot.DefaultThresholds
+	)
+	client.register(fs)
+	fs.StringVar(&dir, "dir", "snapshots", "directory of the snapshots")
+	fs.BoolVar(&update, "update", false, "record the missing snapshots and replace the ones that drifted")
+	fs.Float64Var(&thresholds.Text, "min-text", thresholds.Text, "minimum similarity of the texts, between 0 and 1")
+	fs.Float64Var(&thresholds.Citations, "min-citations", thresholds.Citations,
+		"minimum overlap of the citations, between 0 and 1")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() > 1:
+		return usageError{msg: "too many arguments"}
+	case thresholds.Text < 0 || thresholds.Text > 1 || thresholds.Citations < 0 || thresholds.Citations > 1:
+		return usageError{msg: "the thresholds must be between 0 and 1"}
+	}
+
+	in := env.stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	cases, err := snapshot.ReadCases(in)
+	if err != nil {
+		return validationError{err: err}
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return err
+	}
+	results, err := snapshot.Run(ctx, c, snapshot.NewStore(dir), cases,
+		snapshot.WithUpdate(update), snapshot.WithThresholds(thresholds))
+	counts := map[snapshot.Status]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		switch r.Status {
+		case snapshot.StatusError:
+			fmt.Fprintf(env.stdout, "ERROR %s: %v\n", r.Name, r.Err)
+		case snapshot.StatusMissing:
+			fmt.Fprintf(env.stdout, "MISSING %s: run with -update to record it\n", r.Name)
+		case snapshot.StatusDrift:
+			fmt.Fprintf(env.stdout, "DRIFT %s\n%s\n", r.Name, r.Comparison.Diff())
+		case snapshot.StatusUpdated:
+			fmt.Fprintf(env.stdout, "UPDATED %s\n%s\n", r.Name, r.Comparison.Diff())
+		case snapshot.StatusNew:
+			fmt.Fprintf(env.stdout, "NEW %s\n", r.Name)
+		case snapshot.StatusPass:
+			fmt.Fprintf(env.stdout, "PASS %s\n", r.Name)
+		}
+	}
+	fmt.Fprintf(env.stderr, "snapshot: %d passed, %d drifted, %d missing, %d new, %d updated, %d failed\n",
+		counts[snapshot.StatusPass], counts[snapshot.StatusDrift], counts[snapshot.StatusMissing],
+		counts[snapshot.StatusNew], counts[snapshot.StatusUpdated], counts[snapshot.StatusError])
+	if err != nil {
+		return err
+	}
+	if failed := counts[snapshot.StatusDrift] + counts[snapshot.StatusMissing] + counts[snapshot.StatusError]; failed > 0 {
+		return fmt.Errorf("%d of %d snapshots did not pass", failed, len(cases))
+	}
+	return nil
+}
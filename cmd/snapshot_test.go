@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	input := writeFile(t, "cases.jsonl",
+		`{"name": "capital", "prompt": "What's the capital of France?", "facts": ["Paris"]}`,
+		`{"name": "river", "request": {"model": "sonar-pro", "messages": [{"role": "user", "content": "Which river?"}]}}`,
+	)
+
+	t.Run("reports the missing snapshots", func(t *testing.T) {
+		code, stdout, stderr := runCommand(t, srv, "", "snapshot", "-dir", dir, input)
+		assert.Equal(t, exitError, code)
+		assert.Equal(t, "MISSING capital: run with -update to record it\nMISSING river: run with -update to record it\n", stdout)
+		assert.Contains(t, stderr, "snapshot: 0 passed, 0 drifted, 2 missing, 0 new, 0 updated, 0 failed\n")
+	})
+
+	t.Run("records the snapshots", func(t *testing.T) {
+		srv.Enqueue(
+			perplexitytest.Answer("The capital of France is Paris.", "https://a.com"),
+			perplexitytest.Answer("The Seine.", "https://b.com"),
+		)
+		code, stdout, stderr := runCommand(t, srv, "", "snapshot", "-dir", dir, "-update", input)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Equal(t, "NEW capital\nNEW river\n", stdout)
+		_, err := os.Stat(filepath.Join(dir, "river.json"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("writes the diff of the drifts", func(t *testing.T) {
+		srv.Enqueue(
+			perplexitytest.Answer("The capital of France is Lyon.", "https://c.com"),
+			perplexitytest.Answer("The Seine.", "https://b.com"),
+		)
+		code, stdout, stderr := runCommand(t, srv, "", "snapshot", "-dir", dir, input)
+		assert.Equal(t, exitError, code)
+		assert.True(t, strings.HasPrefix(stdout, "DRIFT capital\n--- capital (recorded "), stdout)
+		assert.Contains(t, stdout, "citation overlap 0.00 (min 0.50) FAIL\n- citation https://a.com\n+ citation https://c.com\n")
+		assert.Contains(t, stdout, "! missing fact \"Paris\"\n@@ answer\n- The capital of France is Paris.\n+ The capital of France is Lyon.\n")
+		assert.True(t, strings.HasSuffix(stdout, "\nPASS river\n"), stdout)
+		assert.Contains(t, stderr, "snapshot: 1 passed, 1 drifted, 0 missing, 0 new, 0 updated, 0 failed\n")
+		assert.Contains(t, stderr, "1 of 2 snapshots did not pass")
+	})
+
+	t.Run("rejects invalid cases and thresholds", func(t *testing.T) {
+		code, _, _ := runCommand(t, srv, `{"name": "../x", "prompt": "Hello"}`, "snapshot", "-dir", dir)
+		assert.Equal(t, exitValidation, code)
+		code, _, _ = runCommand(t, srv, "", "snapshot", "-min-text", "2", input)
+		assert.Equal(t, exitUsage, code)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sgaunet/perplexity-go/v2/watch"
+)
+
+// watchCmd runs the queries of a spec file on their schedules and reports the changes of the answers.
+func watchCmd(ctx context.Context, env *environment, args []string) error {
+	fs := env.flagSet("watch", "[flags] spec",
+		`Sends the queries of a spec file on their schedules (cron expressions or @every durations)
+and reports the changes of their answers to the sinks of the spec: the new citations, the
+dropped sources and a diff of the content. The results are kept in the directory of the spec,
+the first one of each query being its baseline. With -once, all the queries are sent once and
+the command exits; otherwise it runs until interrupted.`)
+	var (
+		client clientFlags
+		once   bool
+	)
+	client.register(fs)
+	fs.BoolVar(&once, "once", false, "send all the queries once and exit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{msg: "a spec file is required"}
+	}
+	spec, err := watch.LoadSpec(fs.Arg(0))
+	if err != nil {
+		return validationError{err: err}
+	}
+	queries, err := spec.NewQueries()
+	if err != nil {
+		return validationError{err: err}
+	}
+	sinks, err := spec.NewSinks(env.stdout, env.httpClient)
+	if err != nil {
+		return validationError{err: err}
+	}
+	c, err := client.newClient(env)
+	if err != nil {
+		return err
+	}
+	opts := append(spec.Options(),
+		watch.WithSinks(sinks...),
+		watch.WithErrorHandler(func(query string, err error) {
+			fmt.Fprintf(env.stderr, "watch: query %s: %v\n", query, err)
+		}),
+	)
+	w := watch.New(c, watch.NewFileStore(spec.Dir), queries, opts...)
+
+	if once {
+		changes, err := w.RunOnce(ctx)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for _, change := range changes {
+			if change != nil && !change.Duplicate {
+				n++
+			}
+		}
+		fmt.Fprintf(env.stderr, "watch: %d queries, %d changes\n", len(queries), n)
+		return nil
+	}
+	fmt.Fprintf(env.stderr, "watching %d queries\n", len(queries))
+	if err := w.Run(ctx); ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	spec := writeFile(t, "watch.yaml",
+		"queries:",
+		"  - name: acme",
+		`    schedule: "0 8 * * *"`,
+		"    prompt: What's new about Acme Corp?",
+		"    search_recency_filter: day",
+		"sinks:",
+		"  - {type: stdout}",
+		"  - {type: file, path: changes.jsonl}",
+	)
+
+	t.Run("records the baseline", func(t *testing.T) {
+		srv.Enqueue(perplexitytest.Answer("Acme Corp released a new rocket.", "https://a.com"))
+		code, stdout, stderr := runCommand(t, srv, "", "watch", "-once", spec)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Equal(t, "", stdout)
+		assert.Contains(t, stderr, "watch: 1 queries, 0 changes\n")
+		last, _ := srv.LastRequest()
+		assert.Equal(t, "day", last.Request.SearchRecencyFilter)
+		_, err := os.Stat(filepath.Join(filepath.Dir(spec), "results", "acme.jsonl"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("reports the changes", func(t *testing.T) {
+		srv.Enqueue(perplexitytest.Answer("Acme Corp released a new rocket.", "https://b.com"))
+		code, stdout, stderr := runCommand(t, srv, "", "watch", "-once", spec)
+		assert.Equal(t, exitOK, code, stderr)
+		assert.Contains(t, stdout, "+ citation https://b.com\n- citation https://a.com\n")
+		assert.Contains(t, stderr, "watch: 1 queries, 1 changes\n")
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(spec), "changes.jsonl"))
+		assert.Nil(t, err)
+		assert.Contains(t, string(data), `"new_citations":["https://b.com"]`)
+	})
+
+	t.Run("rejects invalid specs", func(t *testing.T) {
+		code, _, _ := runCommand(t, srv, "", "watch", "-once")
+		assert.Equal(t, exitUsage, code)
+		invalid := writeFile(t, "invalid.yaml", "queries:", "  - {name: acme, schedule: never, prompt: Hi}")
+		code, _, stderr := runCommand(t, srv, "", "watch", "-once", invalid)
+		assert.Equal(t, exitValidation, code)
+		assert.Contains(t, stderr, "invalid schedule")
+	})
+}
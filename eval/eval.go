@@ -0,0 +1,272 @@
+// Package eval evaluates prompts and models: it runs a dataset of inputs through
+// several request configurations, grades the answers and compares the configurations.
+//
+//	runner := eval.NewRunner(client,
+//		eval.WithGraders(eval.Regex("(?i)paris"), eval.CitationCount(1, 0)),
+//	)
+//	report, err := runner.Run(ctx, dataset,
+//		eval.Config{Name: "sonar", Model: "sonar"},
+//		eval.Config{Name: "sonar-pro", Model: "sonar-pro"},
+//	)
+//	fmt.Print(report.Markdown())
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Case is an input of a dataset.
+type Case struct {
+	ID    string `json:"id" yaml:"id"`
+	Input string `json:"input" yaml:"input"`
+	// Expected is the reference answer, used by some graders.
+	Expected string `json:"expected,omitempty" yaml:"expected"`
+}
+
+// ReadDataset reads a dataset in JSONL: one Case per line.
+// The cases without ID are identified by their line number.
+func ReadDataset(r io.Reader) ([]Case, error) {
+	var cases []Case
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var c Case
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, fmt.Errorf("line %d: invalid case: %w", n, err)
+		}
+		if c.Input == "" {
+			return nil, fmt.Errorf("line %d: the input is empty", n)
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprint(n)
+		}
+		if ids[c.ID] {
+			return nil, fmt.Errorf("line %d: duplicate id %q", n, c.ID)
+		}
+		ids[c.ID] = true
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the dataset: %w", err)
+	}
+	return cases, nil
+}
+
+// LoadDataset reads the dataset of a JSONL file.
+func LoadDataset(path string) ([]Case, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the dataset: %w", err)
+	}
+	defer f.Close()
+	return ReadDataset(f)
+}
+
+// Config is a configuration of the requests evaluated.
+// The fields not set keep the default value of the requests.
+type Config struct {
+	Name                string   `json:"name" yaml:"name"`
+	Model               string   `json:"model,omitempty" yaml:"model"`
+	System              string   `json:"system,omitempty" yaml:"system"`
+	Temperature         *float64 `json:"temperature,omitempty" yaml:"temperature"`
+	MaxTokens           *int     `json:"max_tokens,omitempty" yaml:"max_tokens"`
+	SearchDomainFilter  []string `json:"search_domain_filter,omitempty" yaml:"search_domain_filter"`
+	SearchRecencyFilter string   `json:"search_recency_filter,omitempty" yaml:"search_recency_filter"`
+}
+
+// Request returns the request asking input with the configuration.
+func (c *Config) Request(input string) (*perplexity.CompletionRequest, error) {
+	var msgOpts []perplexity.MessagesOption
+	if c.System != "" {
+		msgOpts = append(msgOpts, perplexity.WithSystemMessage(c.System))
+	}
+	messages := perplexity.NewMessages(msgOpts...)
+	if err := messages.AddUserMessage(input); err != nil {
+		return nil, err
+	}
+	opts := []perplexity.CompletionRequestOption{
+		perplexity.WithMessages(messages.GetMessages()),
+		perplexity.WithSearchDomainFilter(c.SearchDomainFilter),
+		perplexity.WithSearchRecencyFilter(c.SearchRecencyFilter),
+	}
+	if c.Model != "" {
+		opts = append(opts, perplexity.WithModel(c.Model))
+	}
+	if c.Temperature != nil {
+		opts = append(opts, perplexity.WithTemperature(*c.Temperature))
+	}
+	if c.MaxTokens != nil {
+		opts = append(opts, perplexity.WithMaxTokens(*c.MaxTokens))
+	}
+	req := perplexity.NewCompletionRequest(opts...)
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", c.Name, err)
+	}
+	return req, nil
+}
+
+// CostFunc returns the cost of a response.
+type CostFunc func(resp *perplexity.CompletionResponse) float64
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// WithGraders sets the graders of the answers.
+func WithGraders(graders ...Grader) Option {
+	return func(r *Runner) {
+		r.graders = append(r.graders, graders...)
+	}
+}
+
+// WithConcurrency sets the number of requests sent concurrently (4 by default).
+func WithConcurrency(n int) Option {
+	return func(r *Runner) {
+		if n > 0 {
+			r.concurrency = n
+		}
+	}
+}
+
+// WithCostFunc sets the function computing the cost of the responses. Without it, the costs are 0.
+func WithCostFunc(cost CostFunc) Option {
+	return func(r *Runner) {
+		r.cost = cost
+	}
+}
+
+// Runner runs the evaluations.
+type Runner struct {
+	client      perplexity.Completer
+	graders     []Grader
+	concurrency int
+	cost        CostFunc
+}
+
+// NewRunner returns a runner sending the requests with client.
+func NewRunner(client perplexity.Completer, opts ...Option) *Runner {
+	r := &Runner{
+		client:      client,
+		concurrency: 4,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ErrNoConfig is returned by Run when there is no configuration to evaluate.
+var ErrNoConfig = errors.New("no configuration to evaluate")
+
+// Run sends each case of the dataset with each configuration and grades the answers.
+// The errors of the requests and of the graders are reported in the results;
+// Run only fails if a configuration is invalid or if ctx is done.
+func (r *Runner) Run(ctx context.Context, dataset []Case, configs ...Config) (*Report, error) {
+	if len(configs) == 0 {
+		return nil, ErrNoConfig
+	}
+	// the default names are set on a copy: the configurations of the caller are left unchanged
+	configs = slices.Clone(configs)
+	names := map[string]bool{}
+	for i := range configs {
+		if configs[i].Name == "" {
+			configs[i].Name = configs[i].Model
+		}
+		if configs[i].Name == "" || names[configs[i].Name] {
+			return nil, fmt.Errorf("configuration %d: the name must be set and unique", i+1)
+		}
+		names[configs[i].Name] = true
+		if _, err := configs[i].Request("validation"); err != nil {
+			return nil, err
+		}
+	}
+
+	type job struct {
+		config *Config
+		c      Case
+		result *Result
+	}
+	results := make([]Result, len(configs)*len(dataset))
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				*j.result = r.evaluate(ctx, j.config, j.c)
+			}
+		}()
+	}
+loop:
+	for i := range configs {
+		for k, c := range dataset {
+			select {
+			case jobs <- job{config: &configs[i], c: c, result: &results[i*len(dataset)+k]}:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return newReport(configs, r.graderNames(), results), nil
+}
+
+func (r *Runner) graderNames() []string {
+	names := make([]string, len(r.graders))
+	for i, g := range r.graders {
+		names[i] = g.Name()
+	}
+	return names
+}
+
+// evaluate sends a case with a configuration and grades the answer.
+func (r *Runner) evaluate(ctx context.Context, config *Config, c Case) Result {
+	result := Result{Config: config.Name, Case: c.ID}
+	req, err := config.Request(c.Input)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	start := time.Now()
+	resp, err := r.client.SendCompletionRequestWithContext(ctx, req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Answer = resp.GetLastContent()
+	result.Citations = resp.GetCitations()
+	result.Usage = resp.Usage
+	if r.cost != nil {
+		result.Cost = r.cost(resp)
+	}
+	result.Scores = make(map[string]Score, len(r.graders))
+	for _, g := range r.graders {
+		score, err := g.Grade(ctx, c, resp)
+		if err != nil {
+			score = Score{Reason: "error: " + err.Error()}
+		}
+		result.Scores[g.Name()] = score
+	}
+	return result
+}
@@ -0,0 +1,223 @@
+package eval_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/eval"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// capitals answers the questions about capitals, with the model of the request.
+func capitals(req *perplexity.CompletionRequest) perplexitytest.Response {
+	question := req.Messages[len(req.Messages)-1].Content
+	var r perplexitytest.Response
+	switch {
+	case strings.Contains(question, "France"):
+		r = perplexitytest.Answer("Paris", "https://en.wikipedia.org/wiki/Paris")
+	case strings.Contains(question, "Italy"):
+		r = perplexitytest.Answer("Rome", "https://www.britannica.com/place/Rome")
+	default:
+		return perplexitytest.Error(http.StatusBadRequest, "unknown country")
+	}
+	r.Completion.Model = req.Model
+	r.Completion.Usage = perplexity.Usage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500}
+	return r
+}
+
+func TestRun(t *testing.T) {
+	fake := perplexitytest.NewFake()
+	fake.Handler = capitals
+	dataset := []eval.Case{
+		{ID: "france", Input: "What's the capital of France?", Expected: "Paris"},
+		{ID: "italy", Input: "What's the capital of Italy?", Expected: "Rome"},
+		{ID: "atlantis", Input: "What's the capital of Atlantis?"},
+	}
+	temperature := 0.1
+	runner := eval.NewRunner(fake,
+		eval.WithGraders(eval.ExactMatch(), eval.CitationDomains("wikipedia.org")),
+		eval.WithConcurrency(2),
+		eval.WithCostFunc(func(resp *perplexity.CompletionResponse) float64 {
+			if resp.Model == "sonar-pro" {
+				return 0.02
+			}
+			return 0.01
+		}),
+	)
+	report, err := runner.Run(context.Background(), dataset,
+		eval.Config{Model: "sonar", System: "Be brief."},
+		eval.Config{Name: "pro", Model: "sonar-pro", Temperature: &temperature, SearchDomainFilter: []string{"wikipedia.org"}},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"exact", "domains"}, report.Graders)
+
+	calls := fake.Calls()
+	assert.Len(t, calls, 6)
+	for _, call := range calls {
+		if call.Request.Model == "sonar" {
+			assert.Equal(t, "Be brief.", call.Request.Messages[0].Content)
+		} else {
+			assert.Equal(t, 0.1, call.Request.Temperature)
+			assert.Equal(t, []string{"wikipedia.org"}, call.Request.SearchDomainFilter)
+		}
+	}
+
+	assert.Len(t, report.Results, 6)
+	assert.Equal(t, "sonar", report.Results[0].Config)
+	assert.Equal(t, "france", report.Results[0].Case)
+	assert.Equal(t, "Paris", report.Results[0].Answer)
+	assert.Equal(t, eval.Score{Value: 1, Pass: true}, report.Results[0].Scores["exact"])
+	assert.Equal(t, eval.Score{Value: 1, Pass: true}, report.Results[0].Scores["domains"])
+	assert.False(t, report.Results[1].Scores["domains"].Pass)
+	assert.Contains(t, report.Results[2].Error, "400")
+	assert.Nil(t, report.Results[2].Scores)
+
+	assert.Len(t, report.Summaries, 2)
+	s := report.Summaries[1]
+	assert.Equal(t, "pro", s.Config.Name)
+	assert.Equal(t, 3, s.Cases)
+	assert.Equal(t, 1, s.Errors)
+	assert.InDelta(t, 2.0/3, s.Scores["exact"], 1e-9)
+	assert.InDelta(t, 1.0/3, s.PassRates["domains"], 1e-9)
+	assert.Equal(t, 3000, s.TotalTokens)
+	assert.InDelta(t, 0.04, s.Cost, 1e-9)
+
+	md := report.Markdown()
+	assert.Contains(t, md, "| Config | Model | Cases | Errors | exact | domains | Mean latency | P95 latency | Tokens | Cost |")
+	assert.Contains(t, md, "| pro | sonar-pro | 3 | 1 | 0.67 (67% pass) | 0.33 (33% pass) |")
+	assert.Contains(t, md, "| france | exact ✓ domains ✓ | exact ✓ domains ✓ |")
+	assert.Contains(t, md, "| italy | exact ✓ domains ✗ | exact ✓ domains ✗ |")
+
+	data, err := report.JSON()
+	assert.Nil(t, err)
+	var decoded struct {
+		Summaries []map[string]any `json:"summaries"`
+		Results   []map[string]any `json:"results"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "pro", decoded.Summaries[1]["config"].(map[string]any)["name"])
+	assert.Contains(t, decoded.Summaries[1], "p95_latency_ms")
+	assert.Contains(t, decoded.Results[0], "latency_ms")
+	assert.Equal(t, 0.01, decoded.Results[0]["cost"])
+}
+
+func TestRunLeavesTheConfigsUnchanged(t *testing.T) {
+	fake := perplexitytest.NewFake()
+	fake.Handler = capitals
+	configs := []eval.Config{{Model: "sonar"}, {Name: "pro", Model: "sonar-pro"}}
+	report, err := eval.NewRunner(fake).Run(context.Background(),
+		[]eval.Case{{ID: "france", Input: "What's the capital of France?"}}, configs...)
+	assert.Nil(t, err)
+	assert.Equal(t, "sonar", report.Summaries[0].Config.Name)
+	assert.Equal(t, []eval.Config{{Model: "sonar"}, {Name: "pro", Model: "sonar-pro"}}, configs)
+}
+
+func TestRunErrors(t *testing.T) {
+	fake := perplexitytest.NewFake()
+	runner := eval.NewRunner(fake)
+	dataset := []eval.Case{{ID: "1", Input: "Hello"}}
+
+	_, err := runner.Run(context.Background(), dataset)
+	assert.True(t, errors.Is(err, eval.ErrNoConfig))
+
+	_, err = runner.Run(context.Background(), dataset, eval.Config{Name: "a"}, eval.Config{Name: "a"})
+	assert.ErrorContains(t, err, "unique")
+
+	_, err = runner.Run(context.Background(), dataset, eval.Config{Name: "a", SearchRecencyFilter: "year"})
+	assert.ErrorContains(t, err, "invalid configuration a")
+	assert.Empty(t, fake.Calls())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = runner.Run(ctx, dataset, eval.Config{Name: "a"})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestReadDataset(t *testing.T) {
+	cases, err := eval.ReadDataset(strings.NewReader(`{"id": "a", "input": "Hello", "expected": "Hi"}
+
+{"input": "Bye"}
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []eval.Case{{ID: "a", Input: "Hello", Expected: "Hi"}, {ID: "3", Input: "Bye"}}, cases)
+
+	_, err = eval.ReadDataset(strings.NewReader(`{"id": "a"}`))
+	assert.ErrorContains(t, err, "line 1: the input is empty")
+	_, err = eval.ReadDataset(strings.NewReader("{\"id\": \"a\", \"input\": \"x\"}\n{\"id\": \"a\", \"input\": \"y\"}"))
+	assert.ErrorContains(t, err, `line 2: duplicate id "a"`)
+	_, err = eval.ReadDataset(strings.NewReader(`not json`))
+	assert.ErrorContains(t, err, "line 1: invalid case")
+}
+
+func TestLoadSpec(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cases.jsonl"),
+		[]byte(`{"id": "france", "input": "What's the capital of France?", "expected": "Paris"}`+"\n"), 0o644))
+	path := filepath.Join(dir, "spec.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+dataset: cases.jsonl
+cases:
+  - input: What's the capital of Italy?
+configs:
+  - {name: sonar, model: sonar, temperature: 0.2}
+  - {model: sonar-pro, search_domain_filter: [wikipedia.org]}
+graders:
+  - {type: exact}
+  - {type: regex, pattern: "^[A-Z]"}
+  - {type: regex, name: short, pattern: "^.{1,10}$"}
+  - {type: json_schema, schema: {type: string}}
+  - {type: citations, min: 1}
+  - {type: domains, domains: [wikipedia.org]}
+  - {type: judge, criteria: The answer is correct., model: sonar-pro, threshold: 0.5}
+prices:
+  sonar: {input: 1, output: 2, request_fees: {low: 5}}
+`), 0o644))
+
+	spec, err := eval.LoadSpec(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []eval.Case{
+		{ID: "1", Input: "What's the capital of Italy?"},
+		{ID: "france", Input: "What's the capital of France?", Expected: "Paris"},
+	}, spec.Cases)
+	assert.Len(t, spec.Configs, 2)
+	assert.Equal(t, 0.2, *spec.Configs[0].Temperature)
+	assert.Equal(t, []string{"wikipedia.org"}, spec.Configs[1].SearchDomainFilter)
+
+	fake := perplexitytest.NewFake()
+	graders, err := spec.NewGraders(fake)
+	assert.Nil(t, err)
+	var names []string
+	for _, g := range graders {
+		names = append(names, g.Name())
+	}
+	assert.Equal(t, []string{"exact", "regex", "short", "json_schema", "citations", "domains", "judge"}, names)
+
+	cost := spec.CostFunc()
+	usage := perplexity.Usage{PromptTokens: 1000, CompletionTokens: 500}
+	assert.InDelta(t, 0.005+0.001+0.001, cost(&perplexity.CompletionResponse{Model: "sonar", Usage: usage}), 1e-9)
+	// The models without price in the spec have the default prices.
+	assert.InDelta(t, 0.003+0.0075+0.006, cost(&perplexity.CompletionResponse{Model: "sonar-pro", Usage: usage}), 1e-9)
+	assert.Equal(t, 0.0, cost(&perplexity.CompletionResponse{Model: "unknown", Usage: usage}))
+
+	spec.Graders = append(spec.Graders, eval.GraderSpec{Type: "exact"})
+	_, err = spec.NewGraders(fake)
+	assert.ErrorContains(t, err, `grader 8: duplicate name "exact"`)
+	spec.Graders = []eval.GraderSpec{{Type: "regex", Pattern: "("}}
+	_, err = spec.NewGraders(fake)
+	assert.ErrorContains(t, err, "grader 1: invalid pattern")
+	spec.Graders = []eval.GraderSpec{{Type: "bleu"}}
+	_, err = spec.NewGraders(fake)
+	assert.ErrorContains(t, err, `unknown type "bleu"`)
+
+	assert.Nil(t, os.WriteFile(path, []byte("configs: [{model: sonar}]\n"), 0o644))
+	_, err = eval.LoadSpec(path)
+	assert.ErrorContains(t, err, "no case to evaluate")
+}
@@ -0,0 +1,287 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Score is the grade of an answer.
+type Score struct {
+	// Value is between 0 and 1.
+	Value  float64 `json:"value"`
+	Pass   bool    `json:"pass"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// Grader grades the answers.
+// An error is reported as a failed score; the evaluation goes on.
+type Grader interface {
+	// Name identifies the grader in the reports. It must be unique among the graders of a Runner.
+	Name() string
+	Grade(ctx context.Context, c Case, resp *perplexity.CompletionResponse) (Score, error)
+}
+
+// GraderFunc is a Grader defined by a name and a function.
+type GraderFunc struct {
+	GraderName string
+	Func       func(ctx context.Context, c Case, resp *perplexity.CompletionResponse) (Score, error)
+}
+
+// Name implements Grader.
+func (g GraderFunc) Name() string {
+	return g.GraderName
+}
+
+// Grade implements Grader.
+func (g GraderFunc) Grade(ctx context.Context, c Case, resp *perplexity.CompletionResponse) (Score, error) {
+	return g.Func(ctx, c, resp)
+}
+
+// boolScore returns the score of a pass/fail check.
+func boolScore(pass bool, reason string) Score {
+	if pass {
+		return Score{Value: 1, Pass: true}
+	}
+	return Score{Reason: reason}
+}
+
+// ExactMatch passes if the answer is the expected answer of the case,
+// ignoring the surrounding spaces and the case.
+func ExactMatch() Grader {
+	return GraderFunc{
+		GraderName: "exact",
+		Func: func(_ context.Context, c Case, resp *perplexity.CompletionResponse) (Score, error) {
+			if c.Expected == "" {
+				return Score{}, errors.New("the case has no expected answer")
+			}
+			answer := strings.TrimSpace(resp.GetLastContent())
+			return boolScore(strings.EqualFold(answer, strings.TrimSpace(c.Expected)), "the answer is not the expected one"), nil
+		},
+	}
+}
+
+// Regex passes if the answer matches the regular expression pattern.
+// It panics if pattern is invalid; use CompileRegex for patterns from users.
+func Regex(pattern string) Grader {
+	g, err := CompileRegex(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// CompileRegex returns a grader passing if the answer matches the regular expression pattern.
+func CompileRegex(pattern string) (Grader, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return GraderFunc{
+		GraderName: "regex",
+		Func: func(_ context.Context, _ Case, resp *perplexity.CompletionResponse) (Score, error) {
+			return boolScore(re.MatchString(resp.GetLastContent()), fmt.Sprintf("the answer does not match %s", pattern)), nil
+		},
+	}, nil
+}
+
+// JSONSchema passes if the answer is a JSON document valid against schema.
+// The answer may be in a fenced code block. The schema supports the keywords type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum and maximum.
+func JSONSchema(schema json.RawMessage) (Grader, error) {
+	var s jsonSchema
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return GraderFunc{
+		GraderName: "json_schema",
+		Func: func(_ context.Context, _ Case, resp *perplexity.CompletionResponse) (Score, error) {
+			var doc any
+			if err := json.Unmarshal([]byte(unfence(resp.GetLastContent())), &doc); err != nil {
+				return Score{Reason: fmt.Sprintf("the answer is not JSON: %v", err)}, nil
+			}
+			if err := s.validate("$", doc); err != nil {
+				return Score{Reason: err.Error()}, nil
+			}
+			return Score{Value: 1, Pass: true}, nil
+		},
+	}, nil
+}
+
+// unfence returns the content of the first fenced code block of s, s itself without code block.
+func unfence(s string) string {
+	s = strings.TrimSpace(s)
+	start := strings.Index(s, "```")
+	if start < 0 {
+		return s
+	}
+	body := s[start+3:]
+	if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+		body = body[nl+1:] // skips the language
+	}
+	if end := strings.Index(body, "```"); end >= 0 {
+		body = body[:end]
+	}
+	return strings.TrimSpace(body)
+}
+
+// CitationCount passes if the answer cites at least minimum sources and at most maximum (no maximum if 0).
+func CitationCount(minimum, maximum int) Grader {
+	return GraderFunc{
+		GraderName: "citations",
+		Func: func(_ context.Context, _ Case, resp *perplexity.CompletionResponse) (Score, error) {
+			n := len(resp.GetCitations())
+			switch {
+			case n < minimum:
+				return Score{Reason: fmt.Sprintf("%d citations, less than %d", n, minimum)}, nil
+			case maximum > 0 && n > maximum:
+				return Score{Reason: fmt.Sprintf("%d citations, more than %d", n, maximum)}, nil
+			}
+			return Score{Value: 1, Pass: true}, nil
+		},
+	}
+}
+
+// CitationDomains scores the share of the citations from the domains (or their subdomains).
+// It passes if all the citations are from the domains, and fails without citation.
+func CitationDomains(domains ...string) Grader {
+	return GraderFunc{
+		GraderName: "domains",
+		Func: func(_ context.Context, _ Case, resp *perplexity.CompletionResponse) (Score, error) {
+			citations := resp.GetCitations()
+			if len(citations) == 0 {
+				return Score{Reason: "no citation"}, nil
+			}
+			var outside []string
+			for _, citation := range citations {
+				if !inDomains(citation, domains) {
+					outside = append(outside, citation)
+				}
+			}
+			score := Score{
+				Value: float64(len(citations)-len(outside)) / float64(len(citations)),
+				Pass:  len(outside) == 0,
+			}
+			if len(outside) > 0 {
+				score.Reason = "citations from other domains: " + strings.Join(outside, ", ")
+			}
+			return score, nil
+		},
+	}
+}
+
+// inDomains reports whether the host of rawURL is one of the domains or one of their subdomains.
+func inDomains(rawURL string, domains []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "www."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// judgePrompt is the system prompt of the judge.
+const judgePrompt = `You are an impartial judge grading the answer of an assistant to a question.
+Grade the answer against the criteria, and against the reference answer if there is one.
+Reply only with a JSON object: {"score": <integer from 0 to 10>, "reason": "<one sentence>"}.`
+
+// JudgeOption configures the Judge grader.
+type JudgeOption func(*judge)
+
+// WithJudgeModel sets the model of the judge (perplexity.DefaultModel by default).
+func WithJudgeModel(model string) JudgeOption {
+	return func(j *judge) {
+		j.model = model
+	}
+}
+
+// WithJudgeThreshold sets the minimum score, between 0 and 1, of the answers passing (0.7 by default).
+func WithJudgeThreshold(threshold float64) JudgeOption {
+	return func(j *judge) {
+		j.threshold = threshold
+	}
+}
+
+type judge struct {
+	client    perplexity.Completer
+	criteria  string
+	model     string
+	threshold float64
+}
+
+// Judge grades the answers with a model, sent with client, according to criteria
+// (e.g. "The answer is correct, concise and cites its sources.").
+// The judge gives a score from 0 to 10, divided by 10.
+func Judge(client perplexity.Completer, criteria string, opts ...JudgeOption) Grader {
+	j := &judge{
+		client:    client,
+		criteria:  criteria,
+		model:     perplexity.DefaultModel,
+		threshold: 0.7,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Name implements Grader.
+func (j *judge) Name() string {
+	return "judge"
+}
+
+// Grade implements Grader.
+func (j *judge) Grade(ctx context.Context, c Case, resp *perplexity.CompletionResponse) (Score, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Criteria:\n%s\n\nQuestion:\n%s\n\n", j.criteria, c.Input)
+	if c.Expected != "" {
+		fmt.Fprintf(&sb, "Reference answer:\n%s\n\n", c.Expected)
+	}
+	fmt.Fprintf(&sb, "Answer to grade:\n%s\n", resp.GetLastContent())
+
+	messages := perplexity.NewMessages(perplexity.WithSystemMessage(judgePrompt))
+	if err := messages.AddUserMessage(sb.String()); err != nil {
+		return Score{}, err
+	}
+	req := perplexity.NewCompletionRequest(
+		perplexity.WithMessages(messages.GetMessages()),
+		perplexity.WithModel(j.model),
+		perplexity.WithTemperature(0.1), // the API requires a positive temperature
+	)
+	verdict, err := j.client.SendCompletionRequestWithContext(ctx, req)
+	if err != nil {
+		return Score{}, fmt.Errorf("failed to ask the judge: %w", err)
+	}
+	content := verdict.GetLastContent()
+	start, end := strings.IndexByte(content, '{'), strings.LastIndexByte(content, '}')
+	var grade struct {
+		Score  *float64 `json:"score"`
+		Reason string   `json:"reason"`
+	}
+	if start < 0 || end < start || json.Unmarshal([]byte(content[start:end+1]), &grade) != nil || grade.Score == nil {
+		return Score{}, fmt.Errorf("invalid verdict of the judge: %q", content)
+	}
+	value := min(max(*grade.Score/10, 0), 1)
+	return Score{Value: value, Pass: value >= j.threshold, Reason: grade.Reason}, nil
+}
+
+// Named returns g with another name, to use several graders of the same kind.
+func Named(name string, g Grader) Grader {
+	return GraderFunc{GraderName: name, Func: g.Grade}
+}
@@ -0,0 +1,135 @@
+package eval_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/eval"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// grade grades an answer with the citations.
+func grade(t *testing.T, g eval.Grader, c eval.Case, answer string, citations ...string) eval.Score {
+	t.Helper()
+	score, err := g.Grade(context.Background(), c, perplexitytest.Answer(answer, citations...).Completion)
+	assert.Nil(t, err)
+	return score
+}
+
+func TestExactMatch(t *testing.T) {
+	g := eval.ExactMatch()
+	c := eval.Case{Input: "Capital of France?", Expected: "Paris"}
+	assert.True(t, grade(t, g, c, " paris\n").Pass)
+	assert.Equal(t, eval.Score{Reason: "the answer is not the expected one"}, grade(t, g, c, "Paris, France"))
+
+	_, err := g.Grade(context.Background(), eval.Case{Input: "Hello"}, perplexitytest.Answer("Hi").Completion)
+	assert.ErrorContains(t, err, "no expected answer")
+}
+
+func TestRegex(t *testing.T) {
+	g := eval.Regex(`(?i)\bparis\b`)
+	assert.Equal(t, "regex", g.Name())
+	assert.True(t, grade(t, g, eval.Case{}, "The capital is Paris.").Pass)
+	assert.False(t, grade(t, g, eval.Case{}, "The capital is Lyon.").Pass)
+
+	_, err := eval.CompileRegex("(")
+	assert.ErrorContains(t, err, "invalid pattern")
+	assert.Panics(t, func() { eval.Regex("(") })
+
+	named := eval.Named("capital", g)
+	assert.Equal(t, "capital", named.Name())
+	assert.True(t, grade(t, named, eval.Case{}, "Paris").Pass)
+}
+
+func TestJSONSchema(t *testing.T) {
+	g, err := eval.JSONSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"city": {"type": "string", "minLength": 2, "pattern": "^[A-Z]"},
+			"population": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"enum": ["capital", "port"]}, "maxItems": 2}
+		},
+		"required": ["city"],
+		"additionalProperties": false
+	}`))
+	assert.Nil(t, err)
+
+	assert.True(t, grade(t, g, eval.Case{}, `{"city": "Paris", "population": 2100000, "tags": ["capital"]}`).Pass)
+	assert.True(t, grade(t, g, eval.Case{}, "Here it is:\n```json\n{\"city\": \"Paris\"}\n```").Pass)
+
+	tests := map[string]string{
+		`not json`:                             "the answer is not JSON",
+		`[]`:                                   "$: array instead of object",
+		`{}`:                                   `$: missing property "city"`,
+		`{"city": "paris"}`:                    "$.city: does not match ^[A-Z]",
+		`{"city": "P"}`:                        "$.city: shorter than 2 characters",
+		`{"city": "Paris", "mayor": "x"}`:      `$: unexpected property "mayor"`,
+		`{"city": "Paris", "population": 1.5}`: "$.population: number instead of integer",
+		`{"city": "Paris", "population": -1}`:  "$.population: less than 0",
+		`{"city": "Paris", "tags": ["capital", "x"]}`:         "$.tags[1]: x is not one of [capital port]",
+		`{"city": "Paris", "tags": ["port", "port", "port"]}`: "$.tags: more than 2 items",
+	}
+	for answer, reason := range tests {
+		score := grade(t, g, eval.Case{}, answer)
+		assert.False(t, score.Pass, answer)
+		assert.Contains(t, score.Reason, reason, answer)
+	}
+
+	_, err = eval.JSONSchema(json.RawMessage(`{"type": "text"}`))
+	assert.ErrorContains(t, err, `unknown type "text"`)
+	_, err = eval.JSONSchema(json.RawMessage(`{"type": "string", "pattern": "("}`))
+	assert.ErrorContains(t, err, "invalid pattern")
+	_, err = eval.JSONSchema(json.RawMessage(`{"properties": {"a": null}}`))
+	assert.ErrorContains(t, err, `the schema of property "a" must be an object`)
+}
+
+func TestCitationGraders(t *testing.T) {
+	count := eval.CitationCount(1, 2)
+	assert.False(t, grade(t, count, eval.Case{}, "No source").Pass)
+	assert.True(t, grade(t, count, eval.Case{}, "One source", "https://a.com").Pass)
+	assert.Equal(t, "3 citations, more than 2",
+		grade(t, count, eval.Case{}, "Three sources", "https://a.com", "https://b.com", "https://c.com").Reason)
+
+	domains := eval.CitationDomains("wikipedia.org", "www.gouv.fr")
+	score := grade(t, domains, eval.Case{}, "Answer",
+		"https://en.wikipedia.org/wiki/Paris", "https://www.gouv.fr/", "https://example.com/wikipedia.org", "https://notwikipedia.org")
+	assert.Equal(t, 0.5, score.Value)
+	assert.False(t, score.Pass)
+	assert.Equal(t, "citations from other domains: https://example.com/wikipedia.org, https://notwikipedia.org", score.Reason)
+	assert.True(t, grade(t, domains, eval.Case{}, "Answer", "https://fr.wikipedia.org/wiki/Paris").Pass)
+	assert.Equal(t, eval.Score{Reason: "no citation"}, grade(t, domains, eval.Case{}, "Answer"))
+}
+
+func TestJudge(t *testing.T) {
+	fake := perplexitytest.NewFake(
+		perplexitytest.Answer(`Verdict: {"score": 8, "reason": "Correct and concise."}`),
+		perplexitytest.Answer(`{"score": 6, "reason": "Too long."}`),
+		perplexitytest.Answer(`I can't grade this.`),
+		perplexitytest.Error(500, "boom"),
+	)
+	g := eval.Judge(fake, "The answer is correct and concise.", eval.WithJudgeModel("sonar-pro"), eval.WithJudgeThreshold(0.8))
+	c := eval.Case{Input: "Capital of France?", Expected: "Paris"}
+
+	assert.Equal(t, eval.Score{Value: 0.8, Pass: true, Reason: "Correct and concise."}, grade(t, g, c, "Paris"))
+	req := fake.Calls()[0].Request
+	assert.Equal(t, "sonar-pro", req.Model)
+	assert.Equal(t, 0.1, req.Temperature)
+	assert.Equal(t, "system", req.Messages[0].Role)
+	prompt := req.Messages[1].Content
+	assert.Contains(t, prompt, "Criteria:\nThe answer is correct and concise.")
+	assert.Contains(t, prompt, "Question:\nCapital of France?")
+	assert.Contains(t, prompt, "Reference answer:\nParis")
+	assert.Contains(t, prompt, "Answer to grade:\nParis")
+
+	assert.Equal(t, eval.Score{Value: 0.6, Reason: "Too long."}, grade(t, g, c, "Paris is the capital..."))
+
+	_, err := g.Grade(context.Background(), c, perplexitytest.Answer("Paris").Completion)
+	assert.ErrorContains(t, err, "invalid verdict of the judge")
+	_, err = g.Grade(context.Background(), c, perplexitytest.Answer("Paris").Completion)
+	var apiErr *perplexity.APIError
+	assert.True(t, errors.As(err, &apiErr))
+}
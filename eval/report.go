@@ -0,0 +1,205 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Result is the evaluation of a case with a configuration.
+type Result struct {
+	Config    string           `json:"config"`
+	Case      string           `json:"case"`
+	Answer    string           `json:"answer,omitempty"`
+	Citations []string         `json:"citations,omitempty"`
+	Usage     perplexity.Usage `json:"usage"`
+	Latency   time.Duration    `json:"-"`
+	Cost      float64          `json:"cost"`
+	// Error is the error of the request; the case is then not graded.
+	Error  string           `json:"error,omitempty"`
+	Scores map[string]Score `json:"scores,omitempty"`
+}
+
+// MarshalJSON encodes the latency in milliseconds.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		result
+		LatencyMS int64 `json:"latency_ms"`
+	}{result(r), r.Latency.Milliseconds()})
+}
+
+// Summary sums up the results of a configuration.
+type Summary struct {
+	Config Config `json:"config"`
+	Cases  int    `json:"cases"`
+	Errors int    `json:"errors"`
+	// Scores is the mean score of each grader; the cases in error score 0.
+	Scores map[string]float64 `json:"scores"`
+	// PassRates is the share of the cases passing each grader.
+	PassRates   map[string]float64 `json:"pass_rates"`
+	MeanLatency time.Duration      `json:"-"`
+	P95Latency  time.Duration      `json:"-"`
+	TotalTokens int                `json:"total_tokens"`
+	Cost        float64            `json:"cost"`
+}
+
+// MarshalJSON encodes the latencies in milliseconds.
+func (s Summary) MarshalJSON() ([]byte, error) {
+	type summary Summary
+	return json.Marshal(struct {
+		summary
+		MeanLatencyMS int64 `json:"mean_latency_ms"`
+		P95LatencyMS  int64 `json:"p95_latency_ms"`
+	}{summary(s), s.MeanLatency.Milliseconds(), s.P95Latency.Milliseconds()})
+}
+
+// Report is the comparison of the configurations.
+type Report struct {
+	Graders   []string  `json:"graders"`
+	Summaries []Summary `json:"summaries"`
+	// Results are ordered by configuration, then by case.
+	Results []Result `json:"results"`
+}
+
+func newReport(configs []Config, graders []string, results []Result) *Report {
+	r := &Report{Graders: graders, Results: results}
+	for _, config := range configs {
+		s := Summary{
+			Config:    config,
+			Scores:    make(map[string]float64, len(graders)),
+			PassRates: make(map[string]float64, len(graders)),
+		}
+		var latencies []time.Duration
+		for _, result := range results {
+			if result.Config != config.Name {
+				continue
+			}
+			s.Cases++
+			if result.Error != "" {
+				s.Errors++
+			} else {
+				latencies = append(latencies, result.Latency)
+			}
+			s.TotalTokens += result.Usage.TotalTokens
+			s.Cost += result.Cost
+			for _, g := range graders {
+				score := result.Scores[g]
+				s.Scores[g] += score.Value
+				if score.Pass {
+					s.PassRates[g]++
+				}
+			}
+		}
+		if s.Cases > 0 {
+			for _, g := range graders {
+				s.Scores[g] /= float64(s.Cases)
+				s.PassRates[g] /= float64(s.Cases)
+			}
+		}
+		s.MeanLatency, s.P95Latency = latencyStats(latencies)
+		r.Summaries = append(r.Summaries, s)
+	}
+	return r
+}
+
+// latencyStats returns the mean and the 95th percentile of the latencies.
+func latencyStats(latencies []time.Duration) (time.Duration, time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	p95 := latencies[int(math.Ceil(0.95*float64(len(latencies))))-1]
+	return total / time.Duration(len(latencies)), p95
+}
+
+// JSON returns the report in indented JSON.
+func (r *Report) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the report: %w", err)
+	}
+	return data, nil
+}
+
+// Markdown returns the report in Markdown: a table comparing the configurations,
+// then a table of the scores of each case.
+func (r *Report) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("# Evaluation\n\n| Config | Model | Cases | Errors |")
+	for _, g := range r.Graders {
+		fmt.Fprintf(&sb, " %s |", g)
+	}
+	sb.WriteString(" Mean latency | P95 latency | Tokens | Cost |\n|---|---|--:|--:|")
+	sb.WriteString(strings.Repeat("--:|", len(r.Graders)+4))
+	sb.WriteString("\n")
+	for _, s := range r.Summaries {
+		model := s.Config.Model
+		if model == "" {
+			model = perplexity.DefaultModel
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %d | %d |", escape(s.Config.Name), model, s.Cases, s.Errors)
+		for _, g := range r.Graders {
+			fmt.Fprintf(&sb, " %.2f (%.0f%% pass) |", s.Scores[g], 100*s.PassRates[g])
+		}
+		fmt.Fprintf(&sb, " %s | %s | %d | $%.4f |\n",
+			s.MeanLatency.Round(time.Millisecond), s.P95Latency.Round(time.Millisecond), s.TotalTokens, s.Cost)
+	}
+
+	sb.WriteString("\n## Cases\n\n| Case |")
+	for _, s := range r.Summaries {
+		fmt.Fprintf(&sb, " %s |", escape(s.Config.Name))
+	}
+	sb.WriteString("\n|---|" + strings.Repeat("---|", len(r.Summaries)) + "\n")
+	byCase := map[string]map[string]Result{}
+	var cases []string
+	for _, result := range r.Results {
+		if byCase[result.Case] == nil {
+			byCase[result.Case] = map[string]Result{}
+			cases = append(cases, result.Case)
+		}
+		byCase[result.Case][result.Config] = result
+	}
+	for _, c := range cases {
+		fmt.Fprintf(&sb, "| %s |", escape(c))
+		for _, s := range r.Summaries {
+			fmt.Fprintf(&sb, " %s |", r.cell(byCase[c][s.Config.Name]))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// cell returns the scores of a result in a cell of the cases table: ✓ or ✗ for each grader.
+func (r *Report) cell(result Result) string {
+	if result.Error != "" {
+		return "error: " + escape(result.Error)
+	}
+	marks := make([]string, 0, len(r.Graders))
+	for _, g := range r.Graders {
+		mark := "✗"
+		if result.Scores[g].Pass {
+			mark = "✓"
+		}
+		marks = append(marks, g+" "+mark)
+	}
+	if len(marks) == 0 {
+		return "ok"
+	}
+	return strings.Join(marks, " ")
+}
+
+// escape escapes the text of a table cell.
+func escape(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
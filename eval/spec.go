@@ -0,0 +1,160 @@
+package eval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec describes an evaluation in YAML (or JSON):
+//
+//	dataset: cases.jsonl   # relative to the spec file, or inline cases
+//	configs:
+//	  - {name: sonar, model: sonar, temperature: 0.2}
+//	  - {name: sonar-pro, model: sonar-pro, search_domain_filter: [wikipedia.org]}
+//	graders:
+//	  - {type: regex, pattern: "(?i)paris"}
+//	  - {type: citations, min: 1}
+//	  - {type: judge, criteria: The answer is correct and concise., model: sonar-pro}
+//	prices:                # overrides perplexity.DefaultPricing
+//	  sonar: {input: 1, output: 1, request_fees: {low: 5, medium: 8, high: 12}}
+type Spec struct {
+	Dataset string             `yaml:"dataset"`
+	Cases   []Case             `yaml:"cases"`
+	Configs []Config           `yaml:"configs"`
+	Graders []GraderSpec       `yaml:"graders"`
+	Prices  perplexity.Pricing `yaml:"prices"`
+}
+
+// GraderSpec describes a grader of a Spec. Type is exact, regex, json_schema, citations,
+// domains or judge; the other fields are the parameters of the grader.
+type GraderSpec struct {
+	Type string `yaml:"type"`
+	// Name overrides the name of the grader in the reports.
+	Name      string   `yaml:"name"`
+	Pattern   string   `yaml:"pattern"`
+	Schema    any      `yaml:"schema"`
+	Min       int      `yaml:"min"`
+	Max       int      `yaml:"max"`
+	Domains   []string `yaml:"domains"`
+	Criteria  string   `yaml:"criteria"`
+	Model     string   `yaml:"model"`
+	Threshold float64  `yaml:"threshold"`
+}
+
+// LoadSpec reads a spec file and the cases of its dataset.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the spec: %w", err)
+	}
+	var s Spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	if s.Dataset != "" {
+		dataset := s.Dataset
+		if !filepath.IsAbs(dataset) {
+			dataset = filepath.Join(filepath.Dir(path), dataset)
+		}
+		cases, err := LoadDataset(dataset)
+		if err != nil {
+			return nil, err
+		}
+		s.Cases = append(s.Cases, cases...)
+	}
+	ids := map[string]bool{}
+	for i := range s.Cases {
+		if s.Cases[i].ID == "" {
+			s.Cases[i].ID = fmt.Sprint(i + 1)
+		}
+		if ids[s.Cases[i].ID] {
+			return nil, fmt.Errorf("invalid spec %s: duplicate case %q", path, s.Cases[i].ID)
+		}
+		ids[s.Cases[i].ID] = true
+	}
+	if len(s.Cases) == 0 {
+		return nil, fmt.Errorf("invalid spec %s: no case to evaluate", path)
+	}
+	if len(s.Configs) == 0 {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, ErrNoConfig)
+	}
+	return &s, nil
+}
+
+// NewGraders returns the graders of the spec. The judges send their requests with client.
+func (s *Spec) NewGraders(client perplexity.Completer) ([]Grader, error) {
+	graders := make([]Grader, 0, len(s.Graders))
+	names := map[string]bool{}
+	for i, gs := range s.Graders {
+		g, err := gs.grader(client)
+		if err != nil {
+			return nil, fmt.Errorf("grader %d: %w", i+1, err)
+		}
+		if gs.Name != "" {
+			g = Named(gs.Name, g)
+		}
+		if names[g.Name()] {
+			return nil, fmt.Errorf("grader %d: duplicate name %q, set another name", i+1, g.Name())
+		}
+		names[g.Name()] = true
+		graders = append(graders, g)
+	}
+	return graders, nil
+}
+
+func (gs *GraderSpec) grader(client perplexity.Completer) (Grader, error) {
+	switch gs.Type {
+	case "exact":
+		return ExactMatch(), nil
+	case "regex":
+		return CompileRegex(gs.Pattern)
+	case "json_schema":
+		schema, err := json.Marshal(gs.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+		return JSONSchema(schema)
+	case "citations":
+		return CitationCount(gs.Min, gs.Max), nil
+	case "domains":
+		if len(gs.Domains) == 0 {
+			return nil, errors.New("no domains")
+		}
+		return CitationDomains(gs.Domains...), nil
+	case "judge":
+		if gs.Criteria == "" {
+			return nil, errors.New("no criteria")
+		}
+		var opts []JudgeOption
+		if gs.Model != "" {
+			opts = append(opts, WithJudgeModel(gs.Model))
+		}
+		if gs.Threshold > 0 {
+			opts = append(opts, WithJudgeThreshold(gs.Threshold))
+		}
+		return Judge(client, gs.Criteria, opts...), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", gs.Type)
+	}
+}
+
+// CostFunc returns the function computing the cost of the responses with perplexity.DefaultPricing,
+// overridden by the prices of the spec. The models without price cost 0.
+func (s *Spec) CostFunc() CostFunc {
+	pricing := perplexity.DefaultPricing()
+	maps.Copy(pricing, s.Prices)
+	return func(resp *perplexity.CompletionResponse) float64 {
+		cost, err := pricing.Cost(resp)
+		if err != nil {
+			return 0
+		}
+		return cost.Total()
+	}
+}
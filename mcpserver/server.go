@@ -0,0 +1,344 @@
+// Package mcpserver exposes Perplexity as tools of the Model Context Protocol (MCP),
+// so that agents can ask questions and search the web with Perplexity.
+//
+//	srv := mcpserver.NewServer(perplexity.NewClient(os.Getenv("PPLX_API_KEY")))
+//	err := srv.Serve(ctx, os.Stdin, os.Stdout)
+//
+// The server speaks JSON-RPC 2.0 over the stdio transport of MCP: one message per line.
+// It provides the tools ask, research and search. When a call has a progress token,
+// the answer is streamed and each event is reported with a progress notification.
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// DefaultResearchModel is the model of the research tool.
+const DefaultResearchModel = "sonar-deep-research"
+
+// protocolVersions are the versions of MCP supported by the server, the latest first.
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// Error codes of JSON-RPC.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Client is the client of the Perplexity API used by the server, usually a *perplexity.Client.
+type Client interface {
+	perplexity.ChatClient
+	perplexity.Searcher
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithModel sets the model of the ask tool (perplexity.DefaultModel by default).
+func WithModel(model string) Option {
+	return func(s *Server) {
+		s.model = model
+	}
+}
+
+// WithResearchModel sets the model of the research tool (DefaultResearchModel by default).
+func WithResearchModel(model string) Option {
+	return func(s *Server) {
+		s.researchModel = model
+	}
+}
+
+// Server is an MCP server providing the tools of Perplexity.
+type Server struct {
+	client        Client
+	model         string
+	researchModel string
+	tools         []tool
+}
+
+// NewServer returns a server sending the requests of the tools with client.
+func NewServer(client Client, opts ...Option) *Server {
+	s := &Server{
+		client:        client,
+		model:         perplexity.DefaultModel,
+		researchModel: DefaultResearchModel,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.tools = s.newTools()
+	return s
+}
+
+// request is a request or a notification (without ID) received by the server.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is the response to a request.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// notification is a notification sent by the server.
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// session is the state of a connection.
+type session struct {
+	mu  sync.Mutex // serializes the writes
+	w   io.Writer
+	err error // first write error
+
+	inflightMu sync.Mutex
+	inflight   map[string]context.CancelFunc // by request ID
+}
+
+// send writes a message on its own line.
+func (c *session) send(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		_, c.err = c.w.Write(append(data, '\n'))
+	}
+}
+
+func (c *session) reply(id json.RawMessage, result any) {
+	c.send(response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (c *session) replyError(id json.RawMessage, code int, msg string) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	c.send(response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: msg}})
+}
+
+func (c *session) notify(method string, params any) {
+	c.send(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Serve reads the messages of r and writes the responses and notifications on w,
+// until the end of r or the cancellation of ctx. At the end of r, Serve waits for the
+// calls in flight; they are canceled if ctx is done.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := &session{w: w, inflight: make(map[string]context.CancelFunc)}
+	lines := make(chan []byte)
+	errRead := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					errRead <- fmt.Errorf("failed to read the messages: %w", err)
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-lines:
+			if !ok {
+				wg.Wait()
+				select {
+				case err := <-errRead:
+					return err
+				default:
+				}
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.err
+			}
+			s.handle(ctx, c, line, &wg)
+		}
+	}
+}
+
+// handle handles a message. The tool calls run in their own goroutine.
+func (s *Server) handle(ctx context.Context, c *session, line []byte, wg *sync.WaitGroup) {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		c.replyError(nil, codeParseError, fmt.Sprintf("invalid message: %v", err))
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		c.replyError(req.ID, codeInvalidRequest, "invalid JSON-RPC 2.0 request")
+		return
+	}
+	if req.ID == nil {
+		s.handleNotification(c, req)
+		return
+	}
+
+	switch req.Method {
+	case "initialize":
+		s.initialize(c, req)
+	case "ping":
+		c.reply(req.ID, struct{}{})
+	case "tools/list":
+		c.reply(req.ID, map[string]any{"tools": s.tools})
+	case "tools/call":
+		var params callParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			c.replyError(req.ID, codeInvalidParams, fmt.Sprintf("invalid params: %v", err))
+			return
+		}
+		t, ok := s.tool(params.Name)
+		if !ok {
+			c.replyError(req.ID, codeInvalidParams, fmt.Sprintf("unknown tool %q", params.Name))
+			return
+		}
+		callCtx, cancel := context.WithCancel(ctx)
+		c.inflightMu.Lock()
+		c.inflight[string(req.ID)] = cancel
+		c.inflightMu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				c.inflightMu.Lock()
+				delete(c.inflight, string(req.ID))
+				c.inflightMu.Unlock()
+				cancel()
+			}()
+			result := t.call(callCtx, params.Arguments, progressFunc(c, params.Meta.ProgressToken))
+			if callCtx.Err() != nil && ctx.Err() == nil {
+				// canceled by the client: no response
+				return
+			}
+			c.reply(req.ID, result)
+		}()
+	default:
+		c.replyError(req.ID, codeMethodNotFound, fmt.Sprintf("method %q not found", req.Method))
+	}
+}
+
+// handleNotification handles a notification of the client. The unknown notifications are ignored.
+func (s *Server) handleNotification(c *session, req request) {
+	if req.Method != "notifications/cancelled" {
+		return
+	}
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if json.Unmarshal(req.Params, &params) != nil {
+		return
+	}
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+	if cancel, ok := c.inflight[string(params.RequestID)]; ok {
+		cancel()
+	}
+}
+
+// initialize negotiates the version of the protocol and returns the capabilities of the server.
+func (s *Server) initialize(c *session, req request) {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		c.replyError(req.ID, codeInvalidParams, fmt.Sprintf("invalid params: %v", err))
+		return
+	}
+	version := protocolVersions[0]
+	for _, v := range protocolVersions {
+		if v == params.ProtocolVersion {
+			version = v
+		}
+	}
+	c.reply(req.ID, map[string]any{
+		"protocolVersion": version,
+		"capabilities": map[string]any{
+			"tools": map[string]any{},
+		},
+		"serverInfo": map[string]any{
+			"name":    "perplexity",
+			"version": moduleVersion(),
+		},
+	})
+}
+
+// moduleVersion returns the version of the perplexity module, "devel" if unknown.
+func moduleVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == "github.com/sgaunet/perplexity-go/v2" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			return info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/sgaunet/perplexity-go/v2" {
+				return dep.Version
+			}
+		}
+	}
+	return "devel"
+}
+
+// callParams are the params of tools/call.
+type callParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	Meta      struct {
+		ProgressToken json.RawMessage `json:"progressToken"`
+	} `json:"_meta"`
+}
+
+// progressFunc returns the function reporting the progress of a call, nil without progress token.
+func progressFunc(c *session, token json.RawMessage) func(progress float64, message string) {
+	if token == nil {
+		return nil
+	}
+	return func(progress float64, message string) {
+		c.notify("notifications/progress", map[string]any{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       message,
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package mcpserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/mcpserver"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve sends the messages to a server using client and returns the messages written by the server.
+func serve(t *testing.T, client mcpserver.Client, messages ...string) []map[string]any {
+	t.Helper()
+	return serveWith(t, mcpserver.NewServer(client), messages...)
+}
+
+// serveWith sends the messages to srv and returns the messages written by the server.
+func serveWith(t *testing.T, srv *mcpserver.Server, messages ...string) []map[string]any {
+	t.Helper()
+	var in, out bytes.Buffer
+	for _, m := range messages {
+		// one message per line
+		if json.Compact(&in, []byte(m)) != nil {
+			in.WriteString(m)
+		}
+		in.WriteByte('\n')
+	}
+	err := srv.Serve(context.Background(), &in, &out)
+	assert.Nil(t, err)
+	return decodeMessages(t, out.String())
+}
+
+func decodeMessages(t *testing.T, out string) []map[string]any {
+	t.Helper()
+	var msgs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var m map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &m), line)
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// result returns the result of the response to the request id.
+func result(t *testing.T, msgs []map[string]any, id float64) map[string]any {
+	t.Helper()
+	for _, m := range msgs {
+		if m["id"] == id {
+			assert.Nil(t, m["error"])
+			r, _ := m["result"].(map[string]any)
+			return r
+		}
+	}
+	t.Fatalf("no response to the request %v", id)
+	return nil
+}
+
+// text returns the text of the content of a tool result.
+func text(r map[string]any) string {
+	return r["content"].([]any)[0].(map[string]any)["text"].(string)
+}
+
+func TestLifecycle(t *testing.T) {
+	msgs := serve(t, perplexitytest.NewFake(),
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-03-26", "capabilities": {}, "clientInfo": {"name": "test", "version": "1"}}}`,
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "ping"}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "tools/list"}`,
+	)
+	assert.Len(t, msgs, 3)
+	init := result(t, msgs, 1)
+	assert.Equal(t, "2025-03-26", init["protocolVersion"])
+	assert.Equal(t, "perplexity", init["serverInfo"].(map[string]any)["name"])
+	assert.NotNil(t, init["capabilities"].(map[string]any)["tools"])
+	assert.Equal(t, map[string]any{}, result(t, msgs, 2))
+
+	var names []string
+	for _, tool := range result(t, msgs, 3)["tools"].([]any) {
+		tool := tool.(map[string]any)
+		names = append(names, tool["name"].(string))
+		assert.NotEmpty(t, tool["description"])
+		assert.Equal(t, "object", tool["inputSchema"].(map[string]any)["type"])
+	}
+	assert.Equal(t, []string{"ask", "research", "search"}, names)
+}
+
+func TestAsk(t *testing.T) {
+	fake := perplexitytest.NewFake(perplexitytest.Answer("Paris[1].", "https://en.wikipedia.org/wiki/Paris"))
+	msgs := serve(t, fake,
+		`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "ask", "arguments": {
+			"question": "What's the capital of France?", "model": "sonar-pro",
+			"search_domain_filter": ["wikipedia.org"], "search_recency_filter": "month"}}}`,
+	)
+	r := result(t, msgs, 1)
+	assert.Nil(t, r["isError"])
+	assert.Equal(t, "Paris[1].\n\nReferences:\n[1] Source 1 - https://en.wikipedia.org/wiki/Paris\n", text(r))
+	assert.Equal(t, map[string]any{
+		"answer":    "Paris[1].",
+		"citations": []any{"https://en.wikipedia.org/wiki/Paris"},
+	}, r["structuredContent"])
+
+	req := fake.Calls()[0].Request
+	assert.Equal(t, "sonar-pro", req.Model)
+	assert.Equal(t, "What's the capital of France?", req.Messages[0].Content)
+	assert.Equal(t, []string{"wikipedia.org"}, req.SearchDomainFilter)
+	assert.Equal(t, "month", req.SearchRecencyFilter)
+	assert.False(t, req.Stream)
+}
+
+func TestAskModelOverride(t *testing.T) {
+	fake := perplexitytest.NewFake()
+	srv := mcpserver.NewServer(fake)
+	// The calls of a session run concurrently: send them in two sessions to order them.
+	result(t, serveWith(t, srv, `{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "ask",
+		"arguments": {"question": "first", "model": "sonar-pro"}}}`), 1)
+	result(t, serveWith(t, srv, `{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "ask",
+		"arguments": {"question": "second"}}}`), 2)
+
+	// The override of a call does not stick for the next ones.
+	calls := fake.Calls()
+	assert.Equal(t, "sonar-pro", calls[0].Request.Model)
+	assert.Equal(t, perplexity.DefaultModel, calls[1].Request.Model)
+}
+
+func TestResearchProgress(t *testing.T) {
+	fake := perplexitytest.NewFake(perplexitytest.Stream([]string{"Paris ", "is ", "the capital."}))
+	msgs := serve(t, fake,
+		`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "research",
+			"arguments": {"question": "What's the capital of France?"}, "_meta": {"progressToken": "p1"}}}`,
+	)
+	assert.Len(t, msgs, 4)
+	var progress []float64
+	for _, m := range msgs[:3] {
+		assert.Equal(t, "notifications/progress", m["method"])
+		params := m["params"].(map[string]any)
+		assert.Equal(t, "p1", params["progressToken"])
+		progress = append(progress, params["progress"].(float64))
+	}
+	assert.Equal(t, []float64{6, 9, 21}, progress)
+	assert.Equal(t, "Paris is the capital.", text(result(t, msgs, 1)))
+
+	req := fake.Calls()[0].Request
+	assert.Equal(t, mcpserver.DefaultResearchModel, req.Model)
+	assert.True(t, req.Stream)
+}
+
+// messageStreams streams the responses of a Fake with their cumulative messages only,
+// as the streams of some models.
+type messageStreams struct {
+	*perplexitytest.Fake
+}
+
+func (m messageStreams) SendSSEHTTPRequestWithContext(ctx context.Context, wg *sync.WaitGroup, req *perplexity.CompletionRequest, responseChannel chan<- perplexity.CompletionResponse) error {
+	defer close(responseChannel)
+	defer wg.Done()
+	events := make(chan perplexity.CompletionResponse)
+	errs := make(chan error, 1)
+	var inner sync.WaitGroup
+	inner.Add(1)
+	go func() { errs <- m.Fake.SendSSEHTTPRequestWithContext(ctx, &inner, req, events) }()
+	for event := range events {
+		event.Choices[0].Delta = perplexity.Message{}
+		responseChannel <- event
+	}
+	return <-errs
+}
+
+func TestResearchProgressOfMessages(t *testing.T) {
+	fake := perplexitytest.NewFake(perplexitytest.Stream([]string{"Paris ", "is ", "the capital."}))
+	msgs := serve(t, messageStreams{fake},
+		`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "research",
+			"arguments": {"question": "What's the capital of France?"}, "_meta": {"progressToken": "p1"}}}`,
+	)
+	assert.Len(t, msgs, 4)
+	var progress []float64
+	for _, m := range msgs[:3] {
+		assert.Equal(t, "notifications/progress", m["method"])
+		progress = append(progress, m["params"].(map[string]any)["progress"].(float64))
+	}
+	assert.Equal(t, []float64{6, 9, 21}, progress)
+	assert.Equal(t, "Paris is the capital.", text(result(t, msgs, 1)))
+}
+
+func TestSearch(t *testing.T) {
+	fake := perplexitytest.NewFake(perplexitytest.Answer("", "https://go.dev/doc"))
+	msgs := serve(t, fake,
+		`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "search",
+			"arguments": {"query": ["golang generics", "rust traits"], "max_results": 3}}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "search", "arguments": {"query": 42}}}`,
+	)
+	r := result(t, msgs, 1)
+	assert.Equal(t, "1. Source 1\n   https://go.dev/doc\n", text(r))
+	req := fake.Calls()[0].SearchRequest
+	assert.Equal(t, []string{"golang generics", "rust traits"}, req.Query)
+	assert.Equal(t, 3, req.MaxResults)
+
+	r = result(t, msgs, 2)
+	assert.Equal(t, true, r["isError"])
+	assert.Len(t, fake.Calls(), 1)
+}
+
+func TestErrors(t *testing.T) {
+	fake := perplexitytest.NewFake(
+		perplexitytest.Error(http.StatusTooManyRequests, "slow down"),
+		perplexitytest.Response{Err: errors.New("network is down")},
+	)
+	msgs := serve(t, fake,
+		`not json`,
+		`{"jsonrpc": "2.0", "id": 1, "method": "resources/list"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "translate", "arguments": {}}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "tools/call", "params": {"name": "ask", "arguments": {}}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "tools/call", "params": {"name": "ask", "arguments": {"question": "Hi"}}}`,
+	)
+	codes := map[any]float64{}
+	for _, m := range msgs {
+		if e, ok := m["error"].(map[string]any); ok {
+			codes[m["id"]] = e["code"].(float64)
+		}
+	}
+	assert.Equal(t, map[any]float64{nil: -32700, 1.0: -32601, 2.0: -32602}, codes)
+
+	r := result(t, msgs, 3)
+	assert.Equal(t, true, r["isError"])
+	assert.Equal(t, "the question is required", text(r))
+	r = result(t, msgs, 4)
+	assert.Equal(t, true, r["isError"])
+	assert.Equal(t, "unexpected status code: 429", text(r))
+}
+
+func TestCancel(t *testing.T) {
+	fake := perplexitytest.NewFake(perplexitytest.Response{
+		Completion: perplexitytest.Answer("Paris.").Completion,
+		Delay:      10 * time.Second,
+	})
+	in, input := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- mcpserver.NewServer(fake).Serve(context.Background(), in, &out)
+	}()
+	_, _ = io.WriteString(input, `{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "ask", "arguments": {"question": "Hi"}}}`+"\n")
+	assert.Eventually(t, func() bool { return len(fake.Calls()) == 1 }, time.Second, 10*time.Millisecond)
+	_, _ = io.WriteString(input, `{"jsonrpc": "2.0", "method": "notifications/cancelled", "params": {"requestId": 1}}`+"\n")
+	_, _ = io.WriteString(input, `{"jsonrpc": "2.0", "id": 2, "method": "ping"}`+"\n")
+	input.Close()
+	assert.Nil(t, <-done)
+
+	// no response to the canceled request
+	msgs := decodeMessages(t, out.String())
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, 2.0, msgs[0]["id"])
+}
@@ -0,0 +1,248 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// tool is a tool of the server.
+type tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+	// call returns the result of the tool. progress is nil if the client did not ask for progress notifications.
+	call func(ctx context.Context, args json.RawMessage, progress func(float64, string)) *toolResult
+}
+
+// toolResult is the result of a call of a tool.
+type toolResult struct {
+	Content           []textContent `json:"content"`
+	StructuredContent any           `json:"structuredContent,omitempty"`
+	IsError           bool          `json:"isError,omitempty"`
+}
+
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// errorResult returns the result of a failed call, reported to the model so that it can correct the call.
+func errorResult(err error) *toolResult {
+	return &toolResult{
+		Content: []textContent{{Type: "text", Text: err.Error()}},
+		IsError: true,
+	}
+}
+
+// filtersSchema are the properties of the search filters, shared by the tools.
+const filtersSchema = `
+		"search_domain_filter": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "Limit the search to these domains, or exclude a domain with a leading - (e.g. -reddit.com)."
+		},
+		"search_recency_filter": {
+			"type": "string",
+			"enum": ["hour", "day", "week", "month"],
+			"description": "Limit the search to the results of the last hour, day, week or month."
+		}`
+
+// askSchema is the input schema of the ask and research tools.
+const askSchema = `{
+	"type": "object",
+	"properties": {
+		"question": {"type": "string", "description": "The question to answer."},
+		"model": {"type": "string", "description": "The Perplexity model answering the question."},` + filtersSchema + `
+	},
+	"required": ["question"]
+}`
+
+// searchSchema is the input schema of the search tool.
+const searchSchema = `{
+	"type": "object",
+	"properties": {
+		"query": {
+			"oneOf": [
+				{"type": "string"},
+				{"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 5}
+			],
+			"description": "The query, or up to 5 queries."
+		},
+		"max_results": {"type": "integer", "minimum": 1, "maximum": 20, "description": "The maximum number of results per query."},` + filtersSchema + `
+	},
+	"required": ["query"]
+}`
+
+func (s *Server) newTools() []tool {
+	return []tool{
+		{
+			Name: "ask",
+			Description: "Answers a question with up-to-date information from the web, " +
+				"with the URLs of the sources cited in the answer.",
+			InputSchema: json.RawMessage(askSchema),
+			call:        s.askTool(s.model),
+		},
+		{
+			Name: "research",
+			Description: "Researches a complex question in depth, reading many sources, and writes a detailed report " +
+				"with the URLs of the sources. Slower than ask.",
+			InputSchema: json.RawMessage(askSchema),
+			call:        s.askTool(s.researchModel),
+		},
+		{
+			Name:        "search",
+			Description: "Searches the web and returns the ranked results (title, URL, date and snippet), without answer.",
+			InputSchema: json.RawMessage(searchSchema),
+			call:        s.search,
+		},
+	}
+}
+
+// tool returns the tool with the given name.
+func (s *Server) tool(name string) (tool, bool) {
+	for _, t := range s.tools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return tool{}, false
+}
+
+// askArgs are the arguments of the ask and research tools.
+type askArgs struct {
+	Question            string   `json:"question"`
+	Model               string   `json:"model"`
+	SearchDomainFilter  []string `json:"search_domain_filter"`
+	SearchRecencyFilter string   `json:"search_recency_filter"`
+}
+
+// askTool returns a tool answering a question with model, unless the call sets another one.
+func (s *Server) askTool(model string) func(context.Context, json.RawMessage, func(float64, string)) *toolResult {
+	return func(ctx context.Context, data json.RawMessage, progress func(float64, string)) *toolResult {
+		var args askArgs
+		if err := json.Unmarshal(data, &args); err != nil {
+			return errorResult(fmt.Errorf("invalid arguments: %w", err))
+		}
+		if strings.TrimSpace(args.Question) == "" {
+			return errorResult(errors.New("the question is required"))
+		}
+		m := model
+		if args.Model != "" {
+			m = args.Model
+		}
+		conv := perplexity.NewConversation(s.client, perplexity.NewMessages(),
+			perplexity.WithModel(m),
+			perplexity.WithSearchDomainFilter(args.SearchDomainFilter),
+			perplexity.WithSearchRecencyFilter(args.SearchRecencyFilter),
+		)
+		var (
+			resp *perplexity.CompletionResponse
+			err  error
+		)
+		if progress == nil {
+			resp, err = conv.Send(ctx, args.Question)
+		} else {
+			resp, err = streamAnswer(ctx, conv, args.Question, progress)
+		}
+		if err != nil {
+			return errorResult(err)
+		}
+		return &toolResult{
+			Content: []textContent{{Type: "text", Text: perplexity.TextRenderer{Endnotes: true}.Render(resp)}},
+			StructuredContent: map[string]any{
+				"answer":    resp.GetLastContent(),
+				"citations": resp.GetCitations(),
+			},
+		}
+	}
+}
+
+// streamAnswer streams the answer, reporting the number of characters received with each event.
+func streamAnswer(ctx context.Context, conv *perplexity.Conversation, question string, progress func(float64, string)) (*perplexity.CompletionResponse, error) {
+	events := make(chan perplexity.CompletionResponse)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var content perplexity.StreamContent
+		for event := range events {
+			delta := content.Delta(event)
+			if delta == "" {
+				continue
+			}
+			progress(float64(len(content.String())), delta)
+		}
+	}()
+	resp, err := conv.SendStream(ctx, question, events)
+	<-done
+	return resp, err
+}
+
+// searchArgs are the arguments of the search tool.
+type searchArgs struct {
+	Query               json.RawMessage `json:"query"`
+	MaxResults          int             `json:"max_results"`
+	SearchDomainFilter  []string        `json:"search_domain_filter"`
+	SearchRecencyFilter string          `json:"search_recency_filter"`
+}
+
+// queries returns the queries of the arguments: a string or an array of strings.
+func (a *searchArgs) queries() ([]string, error) {
+	var query string
+	if err := json.Unmarshal(a.Query, &query); err == nil {
+		return []string{query}, nil
+	}
+	var queries []string
+	if err := json.Unmarshal(a.Query, &queries); err != nil {
+		return nil, errors.New("the query must be a string or an array of strings")
+	}
+	return queries, nil
+}
+
+func (s *Server) search(ctx context.Context, data json.RawMessage, _ func(float64, string)) *toolResult {
+	var args searchArgs
+	if err := json.Unmarshal(data, &args); err != nil {
+		return errorResult(fmt.Errorf("invalid arguments: %w", err))
+	}
+	queries, err := args.queries()
+	if err != nil {
+		return errorResult(err)
+	}
+	req := perplexity.NewSearchRequest(queries...)
+	req.MaxResults = args.MaxResults
+	req.SearchDomainFilter = args.SearchDomainFilter
+	req.SearchRecencyFilter = args.SearchRecencyFilter
+	if err := req.Validate(); err != nil {
+		return errorResult(err)
+	}
+	resp, err := s.client.Search(ctx, req)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	var sb strings.Builder
+	for i, r := range resp.Results {
+		fmt.Fprintf(&sb, "%d. %s\n   %s\n", i+1, r.Title, r.URL)
+		if r.Date != "" {
+			fmt.Fprintf(&sb, "   %s\n", r.Date)
+		}
+		if r.Snippet != "" {
+			fmt.Fprintf(&sb, "   %s\n", r.Snippet)
+		}
+	}
+	if len(resp.Results) == 0 {
+		sb.WriteString("No results.")
+	}
+	results := resp.Results
+	if results == nil {
+		results = []perplexity.SearchResult{}
+	}
+	return &toolResult{
+		Content:           []textContent{{Type: "text", Text: sb.String()}},
+		StructuredContent: map[string]any{"results": results},
+	}
+}
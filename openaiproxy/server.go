@@ -0,0 +1,319 @@
+// Package openaiproxy serves the chat completions API of OpenAI with Perplexity,
+// so that the tools speaking the OpenAI protocol can use the Perplexity models.
+//
+//	srv := openaiproxy.NewServer(openaiproxy.WithAPIKey(os.Getenv("PPLX_API_KEY")))
+//	log.Fatal(http.ListenAndServe(":8080", srv))
+//
+// The requests of POST /v1/chat/completions are translated into CompletionRequests
+// and sent with a perplexity.Client. The answers are sent back as OpenAI chat completions,
+// or as chunks of server-sent events if the request asks for a stream. The citations,
+// search results, images and related questions are in the "perplexity" extension field
+// of the completions (of the last chunk of a stream).
+package openaiproxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// maxRequestSize is the maximum size of the body of a request (images included).
+const maxRequestSize = 32 << 20
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithAPIKey sets the API key used for all the callers, who are then not authenticated.
+// It is ignored if tokens are set with WithTokens.
+func WithAPIKey(apiKey string) Option {
+	return func(s *Server) {
+		s.apiKey = apiKey
+	}
+}
+
+// WithTokens sets the bearer tokens of the callers, mapped to the API keys used for their requests.
+// The requests without a known token are rejected.
+func WithTokens(tokens map[string]string) Option {
+	return func(s *Server) {
+		s.tokens = make(map[string]string, len(tokens))
+		for token, apiKey := range tokens {
+			s.tokens[token] = apiKey
+		}
+	}
+}
+
+// WithEndpoint sets the endpoint of the Perplexity API.
+func WithEndpoint(endpoint string) Option {
+	return func(s *Server) {
+		s.endpoint = endpoint
+	}
+}
+
+// WithHTTPClient sets the HTTP client sending the requests to the Perplexity API.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(s *Server) {
+		s.httpClient = httpClient
+	}
+}
+
+// WithDefaultModel sets the model of the requests without one (perplexity.DefaultModel by default).
+func WithDefaultModel(model string) Option {
+	return func(s *Server) {
+		s.defaultModel = model
+	}
+}
+
+// Server is an http.Handler serving the chat completions API of OpenAI.
+// Server is safe for concurrent use.
+type Server struct {
+	apiKey       string
+	tokens       map[string]string
+	endpoint     string
+	httpClient   *http.Client
+	defaultModel string
+	mux          *http.ServeMux
+
+	mu      sync.Mutex
+	clients map[string]*perplexity.Client // by API key
+}
+
+// NewServer returns a Server configured by opts.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		defaultModel: perplexity.DefaultModel,
+		clients:      make(map[string]*perplexity.Client),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/v1/chat/completions", s.serveChatCompletions)
+	s.mux.HandleFunc("/chat/completions", s.serveChatCompletions)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("unknown path %s", r.URL.Path))
+	})
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// client returns the client of the caller of r, nil if the caller is not authorized.
+func (s *Server) client(r *http.Request) *perplexity.Client {
+	apiKey := s.apiKey
+	if s.tokens != nil {
+		var ok bool
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if apiKey, ok = s.lookup(token); !ok {
+			return nil
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[apiKey]
+	if !ok {
+		c = perplexity.NewClient(apiKey)
+		if s.httpClient != nil {
+			c.SetHTTPClient(s.httpClient)
+		}
+		if s.endpoint != "" {
+			c.SetEndpoint(s.endpoint)
+		}
+		s.clients[apiKey] = c
+	}
+	return c
+}
+
+// lookup returns the API key of token. The tokens are compared in constant time,
+// and all of them are compared, not to leak them through the timing of the responses.
+func (s *Server) lookup(token string) (string, bool) {
+	var (
+		apiKey string
+		found  bool
+	)
+	for t, key := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			apiKey, found = key, true
+		}
+	}
+	return apiKey, found
+}
+
+func (s *Server) serveChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method must be POST")
+		return
+	}
+	client := s.client(r)
+	if client == nil {
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid API key")
+		return
+	}
+	var body ChatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	req := body.CompletionRequest(s.defaultModel)
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if body.Stream {
+		s.stream(w, r, client, req, body.StreamOptions != nil && body.StreamOptions.IncludeUsage)
+		return
+	}
+	resp, err := client.SendCompletionRequestWithContext(r.Context(), req)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewChatCompletion(resp))
+}
+
+// stream sends the request and forwards the events as chunks.
+// The errors happening after the first chunk are sent as an event.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, client perplexity.Streamer, req *perplexity.CompletionRequest, includeUsage bool) {
+	var (
+		wg     sync.WaitGroup
+		events = make(chan perplexity.CompletionResponse)
+		errCh  = make(chan error, 1)
+	)
+	wg.Add(1)
+	go func() {
+		errCh <- client.SendSSEHTTPRequestWithContext(r.Context(), &wg, req, events)
+	}()
+
+	var (
+		cw      *chunkWriter
+		last    perplexity.CompletionResponse
+		content perplexity.StreamContent
+	)
+	for event := range events {
+		if cw == nil {
+			cw = newChunkWriter(w)
+		}
+		last = event
+		cw.write(newChunk(event, content.Delta(event), cw.first))
+	}
+	err := <-errCh
+	switch {
+	case err != nil && cw == nil:
+		writeUpstreamError(w, err)
+		return
+	case err != nil:
+		_, errType, msg := upstreamError(err)
+		cw.writeEvent(errorBody{Error: errorDetail{Message: msg, Type: errType}})
+		return
+	case cw == nil:
+		cw = newChunkWriter(w)
+	}
+	end := newChunk(last, "", cw.first)
+	end.Choices[0].FinishReason = finishReason(last)
+	end.Perplexity = newExtension(&last)
+	cw.write(end)
+	if includeUsage {
+		usage := newChunk(last, "", false)
+		usage.Choices = []ChunkChoice{}
+		usage.Usage = newUsage(last.Usage)
+		cw.write(usage)
+	}
+	cw.writeDone()
+}
+
+// chunkWriter writes server-sent events.
+type chunkWriter struct {
+	w     http.ResponseWriter
+	rc    *http.ResponseController
+	first bool // no chunk has been written yet
+}
+
+func newChunkWriter(w http.ResponseWriter) *chunkWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return &chunkWriter{w: w, rc: http.NewResponseController(w), first: true}
+}
+
+func (c *chunkWriter) write(chunk *ChatCompletionChunk) {
+	c.first = false
+	c.writeEvent(chunk)
+}
+
+// writeEvent writes v as the data of an event. The errors are ignored: the caller is gone.
+func (c *chunkWriter) writeEvent(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.w, "data: %s\n\n", data)
+	_ = c.rc.Flush()
+}
+
+func (c *chunkWriter) writeDone() {
+	fmt.Fprint(c.w, "data: [DONE]\n\n")
+	_ = c.rc.Flush()
+}
+
+// errorBody is the body of the errors of the OpenAI API.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, msg string) {
+	writeJSON(w, status, errorBody{Error: errorDetail{Message: msg, Type: errType}})
+}
+
+// writeUpstreamError writes the error returned by the Perplexity API.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	status, errType, msg := upstreamError(err)
+	writeError(w, status, errType, msg)
+}
+
+// upstreamError returns the status code, the type and the message of an error of the Perplexity API.
+// The errors of the API keep their status code, the other ones are 502 Bad Gateway.
+func upstreamError(err error) (int, string, string) {
+	var apiErr *perplexity.APIError
+	if !errors.As(err, &apiErr) {
+		return http.StatusBadGateway, "api_error", err.Error()
+	}
+	msg := apiErr.Error()
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(apiErr.Body), &body) == nil && body.Error.Message != "" {
+		msg = body.Error.Message
+	}
+	errType := "invalid_request_error"
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		errType = "authentication_error"
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case apiErr.StatusCode >= http.StatusInternalServerError:
+		errType = "api_error"
+	}
+	return apiErr.StatusCode, errType, msg
+}
@@ -0,0 +1,265 @@
+package openaiproxy_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/openaiproxy"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newProxy returns a proxy of the fake Perplexity server upstream.
+func newProxy(upstream *perplexitytest.Server, opts ...openaiproxy.Option) *httptest.Server {
+	opts = append([]openaiproxy.Option{
+		openaiproxy.WithAPIKey(perplexitytest.DefaultAPIKey),
+		openaiproxy.WithEndpoint(upstream.URL()),
+		openaiproxy.WithHTTPClient(upstream.Server.Client()),
+	}, opts...)
+	return httptest.NewServer(openaiproxy.NewServer(opts...))
+}
+
+// post sends body to the chat completions endpoint of the proxy.
+func post(t *testing.T, proxy *httptest.Server, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeError returns the type and the message of an error response.
+func decodeError(t *testing.T, resp *http.Response) (string, string) {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body.Error.Type, body.Error.Message
+}
+
+// readChunks reads the chunks of a stream, their content and whether the stream ended with [DONE].
+func readChunks(t *testing.T, resp *http.Response) ([]openaiproxy.ChatCompletionChunk, string, bool) {
+	t.Helper()
+	var (
+		chunks  []openaiproxy.ChatCompletionChunk
+		content string
+		done    bool
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk openaiproxy.ChatCompletionChunk
+		assert.Nil(t, json.Unmarshal([]byte(data), &chunk))
+		assert.Equal(t, "chat.completion.chunk", chunk.Object)
+		if len(chunk.Choices) > 0 {
+			content += chunk.Choices[0].Delta.Content
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, content, done
+}
+
+func TestChatCompletion(t *testing.T) {
+	upstream := perplexitytest.NewServer()
+	defer upstream.Close()
+	proxy := newProxy(upstream)
+	defer proxy.Close()
+
+	upstream.Enqueue(perplexitytest.Answer("Paris[1].", "https://en.wikipedia.org/wiki/Paris"))
+	resp := post(t, proxy, "", `{
+		"model": "sonar-pro",
+		"messages": [
+			{"role": "developer", "content": "Be brief"},
+			{"role": "user", "content": [{"type": "text", "text": "What's the capital of France?"}]}
+		],
+		"max_completion_tokens": 100,
+		"temperature": 0.5,
+		"frequency_penalty": 0,
+		"search_recency_filter": "week"
+	}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var completion openaiproxy.ChatCompletion
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&completion))
+	assert.Equal(t, "chat.completion", completion.Object)
+	assert.NotEmpty(t, completion.ID)
+	assert.Equal(t, "Paris[1].", completion.Choices[0].Message.Content)
+	assert.Equal(t, "assistant", completion.Choices[0].Message.Role)
+	assert.Equal(t, "stop", completion.Choices[0].FinishReason)
+	assert.NotZero(t, completion.Usage.TotalTokens)
+	assert.Equal(t, []string{"https://en.wikipedia.org/wiki/Paris"}, completion.Perplexity.Citations)
+
+	last, _ := upstream.LastRequest()
+	req := last.Request
+	assert.Equal(t, "sonar-pro", req.Model)
+	assert.Equal(t, "system", req.Messages[0].Role)
+	assert.Equal(t, "What's the capital of France?", req.Messages[1].Content)
+	assert.Equal(t, 100, req.MaxTokens)
+	assert.Equal(t, 0.5, req.Temperature)
+	assert.Equal(t, 1.0, req.FrequencyPenalty)
+	assert.Equal(t, "week", req.SearchRecencyFilter)
+	assert.False(t, req.Stream)
+}
+
+func TestChatCompletionSamplingBounds(t *testing.T) {
+	upstream := perplexitytest.NewServer()
+	defer upstream.Close()
+	proxy := newProxy(upstream)
+	defer proxy.Close()
+
+	// the defaults of the OpenAI SDKs are out of the ranges of Perplexity
+	upstream.Enqueue(perplexitytest.Answer("Paris."))
+	resp := post(t, proxy, "", `{"model": "sonar", "temperature": 0, "top_p": 1,
+		"messages": [{"role": "user", "content": "What's the capital of France?"}]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	last, _ := upstream.LastRequest()
+	assert.Equal(t, 0.01, last.Request.Temperature)
+	assert.Equal(t, 0.99, last.Request.TopP)
+
+	upstream.Enqueue(perplexitytest.Answer("Paris."))
+	resp = post(t, proxy, "", `{"model": "sonar", "temperature": 2,
+		"messages": [{"role": "user", "content": "What's the capital of France?"}]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	last, _ = upstream.LastRequest()
+	assert.Equal(t, 1.99, last.Request.Temperature)
+}
+
+func TestChatCompletionStream(t *testing.T) {
+	upstream := perplexitytest.NewServer()
+	defer upstream.Close()
+	proxy := newProxy(upstream)
+	defer proxy.Close()
+
+	upstream.Enqueue(perplexitytest.Stream([]string{"Par", "is", "[1]."}, "https://en.wikipedia.org/wiki/Paris"))
+	resp := post(t, proxy, "", `{"model": "sonar", "stream": true, "stream_options": {"include_usage": true},
+		"messages": [{"role": "user", "content": "What's the capital of France?"}]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	chunks, content, done := readChunks(t, resp)
+	assert.True(t, done)
+	assert.Equal(t, "Paris[1].", content)
+	assert.Len(t, chunks, 5)
+	assert.Equal(t, "assistant", chunks[0].Choices[0].Delta.Role)
+	assert.Nil(t, chunks[0].Choices[0].FinishReason)
+
+	end := chunks[3]
+	assert.Equal(t, "stop", *end.Choices[0].FinishReason)
+	assert.Equal(t, []string{"https://en.wikipedia.org/wiki/Paris"}, end.Perplexity.Citations)
+	assert.Empty(t, chunks[4].Choices)
+	assert.NotZero(t, chunks[4].Usage.TotalTokens)
+
+	last, _ := upstream.LastRequest()
+	assert.True(t, last.Request.Stream)
+}
+
+func TestChatCompletionStreamOfMessages(t *testing.T) {
+	// the events of some models only carry the cumulative content in their message
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"Par", "Paris", "Paris[1]."} {
+			event, _ := json.Marshal(perplexity.CompletionResponse{
+				ID:      "1",
+				Model:   "sonar",
+				Choices: []perplexity.Choice{{Message: perplexity.Message{Role: "assistant", Content: content}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	defer upstream.Close()
+	proxy := httptest.NewServer(openaiproxy.NewServer(
+		openaiproxy.WithAPIKey(perplexitytest.DefaultAPIKey),
+		openaiproxy.WithEndpoint(upstream.URL),
+	))
+	defer proxy.Close()
+
+	resp := post(t, proxy, "", `{"model": "sonar", "stream": true,
+		"messages": [{"role": "user", "content": "What's the capital of France?"}]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	chunks, content, done := readChunks(t, resp)
+	assert.True(t, done)
+	assert.Equal(t, "Paris[1].", content)
+	assert.Len(t, chunks, 4)
+	assert.Equal(t, "is", chunks[1].Choices[0].Delta.Content)
+}
+
+func TestTokens(t *testing.T) {
+	upstream := perplexitytest.NewServer()
+	defer upstream.Close()
+	proxy := newProxy(upstream, openaiproxy.WithTokens(map[string]string{
+		"alice": perplexitytest.DefaultAPIKey,
+		"bob":   "revoked-key",
+	}))
+	defer proxy.Close()
+	body := `{"model": "sonar", "messages": [{"role": "user", "content": "Hi"}]}`
+
+	resp := post(t, proxy, "alice", body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = post(t, proxy, "mallory", body)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Len(t, upstream.Requests(), 1)
+
+	resp = post(t, proxy, "bob", body)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	errType, _ := decodeError(t, resp)
+	assert.Equal(t, "authentication_error", errType)
+	last, _ := upstream.LastRequest()
+	assert.Equal(t, "Bearer revoked-key", last.Header.Get("Authorization"))
+}
+
+func TestErrors(t *testing.T) {
+	upstream := perplexitytest.NewServer()
+	defer upstream.Close()
+	proxy := newProxy(upstream)
+	defer proxy.Close()
+
+	t.Run("invalid requests are not sent", func(t *testing.T) {
+		resp := post(t, proxy, "", `{"model": "sonar", "temperature": 5, "messages": [{"role": "user", "content": "Hi"}]}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		errType, _ := decodeError(t, resp)
+		assert.Equal(t, "invalid_request_error", errType)
+
+		resp = post(t, proxy, "", `{"model":`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Empty(t, upstream.Requests())
+
+		get, err := http.Get(proxy.URL + "/v1/chat/completions")
+		assert.Nil(t, err)
+		get.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, get.StatusCode)
+	})
+
+	t.Run("errors of the API keep their status", func(t *testing.T) {
+		for _, stream := range []string{"false", "true"} {
+			upstream.Enqueue(perplexitytest.Error(http.StatusTooManyRequests, "slow down"))
+			resp := post(t, proxy, "", `{"model": "sonar", "stream": `+stream+`, "messages": [{"role": "user", "content": "Hi"}]}`)
+			assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+			errType, msg := decodeError(t, resp)
+			assert.Equal(t, "rate_limit_error", errType)
+			assert.Equal(t, "slow down", msg)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package openaiproxy
+
+import (
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// ChatCompletionRequest is the body of a request of the chat completions API of OpenAI.
+// The parameters specific to Perplexity are accepted as extensions.
+type ChatCompletionRequest struct {
+	Model               string               `json:"model"`
+	Messages            []perplexity.Message `json:"messages"`
+	Stream              bool                 `json:"stream"`
+	StreamOptions       *StreamOptions       `json:"stream_options,omitempty"`
+	MaxTokens           *int                 `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                 `json:"max_completion_tokens,omitempty"`
+	// Temperature and TopP are in [0, 2] and [0, 1] for OpenAI, but Perplexity excludes the bounds:
+	// the bounds, like the usual temperature 0 and top_p 1, are replaced by the closest values accepted.
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	PresencePenalty *float64 `json:"presence_penalty,omitempty"`
+	// FrequencyPenalty is multiplicative for Perplexity: only the values greater than 0 are kept.
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+
+	// Extensions
+	TopK                   *int     `json:"top_k,omitempty"`
+	SearchDomainFilter     []string `json:"search_domain_filter,omitempty"`
+	SearchRecencyFilter    string   `json:"search_recency_filter,omitempty"`
+	ReturnImages           bool     `json:"return_images,omitempty"`
+	ReturnRelatedQuestions bool     `json:"return_related_questions,omitempty"`
+}
+
+// boundMargin is the distance to the bounds of the values replacing them in insideBounds.
+const boundMargin = 0.01
+
+// insideBounds returns v, or the closest value inside the range if v is one of its bounds lo and hi.
+// The values out of the range are kept, to be rejected by the validation of the request.
+func insideBounds(v, lo, hi float64) float64 {
+	switch v {
+	case lo:
+		return lo + boundMargin
+	case hi:
+		return hi - boundMargin
+	}
+	return v
+}
+
+// StreamOptions are the options of a streamed request.
+type StreamOptions struct {
+	// IncludeUsage adds a last chunk with the usage and no choice.
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// CompletionRequest returns the CompletionRequest of r, with defaultModel if r has no model.
+// The developer messages are sent as system messages.
+func (r *ChatCompletionRequest) CompletionRequest(defaultModel string) *perplexity.CompletionRequest {
+	messages := make([]perplexity.Message, len(r.Messages))
+	for i, m := range r.Messages {
+		if m.Role == "developer" {
+			m.Role = "system"
+		}
+		messages[i] = m
+	}
+	model := r.Model
+	if model == "" {
+		model = defaultModel
+	}
+	opts := []perplexity.CompletionRequestOption{
+		perplexity.WithMessages(messages),
+		perplexity.WithModel(model),
+		perplexity.WithSearchDomainFilter(r.SearchDomainFilter),
+		perplexity.WithSearchRecencyFilter(r.SearchRecencyFilter),
+		perplexity.WithReturnImages(r.ReturnImages),
+		perplexity.WithReturnRelatedQuestions(r.ReturnRelatedQuestions),
+		perplexity.WithStream(r.Stream),
+	}
+	switch {
+	case r.MaxCompletionTokens != nil:
+		opts = append(opts, perplexity.WithMaxTokens(*r.MaxCompletionTokens))
+	case r.MaxTokens != nil:
+		opts = append(opts, perplexity.WithMaxTokens(*r.MaxTokens))
+	}
+	if r.Temperature != nil {
+		opts = append(opts, perplexity.WithTemperature(insideBounds(*r.Temperature, 0, 2)))
+	}
+	if r.TopP != nil {
+		opts = append(opts, perplexity.WithTopP(insideBounds(*r.TopP, 0, 1)))
+	}
+	if r.TopK != nil {
+		opts = append(opts, perplexity.WithTopK(*r.TopK))
+	}
+	if r.PresencePenalty != nil {
+		opts = append(opts, perplexity.WithPresencePenalty(*r.PresencePenalty))
+	}
+	if r.FrequencyPenalty != nil && *r.FrequencyPenalty > 0 {
+		opts = append(opts, perplexity.WithFrequencyPenalty(*r.FrequencyPenalty))
+	}
+	return perplexity.NewCompletionRequest(opts...)
+}
+
+// ChatCompletion is the response of a request of the chat completions API.
+type ChatCompletion struct {
+	ID         string     `json:"id"`
+	Object     string     `json:"object"`
+	Created    int64      `json:"created"`
+	Model      string     `json:"model"`
+	Choices    []Choice   `json:"choices"`
+	Usage      *Usage     `json:"usage"`
+	Perplexity *Extension `json:"perplexity,omitempty"`
+}
+
+// Choice is a choice of a ChatCompletion.
+type Choice struct {
+	Index        int             `json:"index"`
+	Message      ResponseMessage `json:"message"`
+	FinishReason string          `json:"finish_reason"`
+}
+
+// ResponseMessage is the message of a Choice, or the delta of a ChunkChoice.
+type ResponseMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Usage is the number of tokens used by a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Extension holds the data of the answer specific to Perplexity.
+type Extension struct {
+	Citations        []string                  `json:"citations,omitempty"`
+	SearchResults    []perplexity.SearchResult `json:"search_results,omitempty"`
+	Images           []perplexity.Image        `json:"images,omitempty"`
+	RelatedQuestions []string                  `json:"related_questions,omitempty"`
+}
+
+// ChatCompletionChunk is an event of a streamed response.
+type ChatCompletionChunk struct {
+	ID         string        `json:"id"`
+	Object     string        `json:"object"`
+	Created    int64         `json:"created"`
+	Model      string        `json:"model"`
+	Choices    []ChunkChoice `json:"choices"`
+	Usage      *Usage        `json:"usage,omitempty"`
+	Perplexity *Extension    `json:"perplexity,omitempty"`
+}
+
+// ChunkChoice is a choice of a ChatCompletionChunk.
+type ChunkChoice struct {
+	Index        int             `json:"index"`
+	Delta        ResponseMessage `json:"delta"`
+	FinishReason *string         `json:"finish_reason"`
+}
+
+// NewChatCompletion returns the ChatCompletion of a response of the Perplexity API.
+func NewChatCompletion(resp *perplexity.CompletionResponse) *ChatCompletion {
+	return &ChatCompletion{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Created: int64(resp.Created),
+		Model:   resp.Model,
+		Choices: []Choice{{
+			Message:      ResponseMessage{Role: "assistant", Content: resp.GetLastContent()},
+			FinishReason: *finishReason(*resp),
+		}},
+		Usage:      newUsage(resp.Usage),
+		Perplexity: newExtension(resp),
+	}
+}
+
+// newChunk returns a chunk of the stream of event with content. The first chunk has the role.
+func newChunk(event perplexity.CompletionResponse, content string, first bool) *ChatCompletionChunk {
+	c := &ChatCompletionChunk{
+		ID:      event.ID,
+		Object:  "chat.completion.chunk",
+		Created: int64(event.Created),
+		Model:   event.Model,
+		Choices: []ChunkChoice{{Delta: ResponseMessage{Content: content}}},
+	}
+	if first {
+		c.Choices[0].Delta.Role = "assistant"
+	}
+	return c
+}
+
+// finishReason returns the reason why the answer of resp stopped, "stop" if unknown.
+func finishReason(resp perplexity.CompletionResponse) *string {
+	reason := "stop"
+	if len(resp.Choices) > 0 && resp.Choices[len(resp.Choices)-1].FinishReason != "" {
+		reason = resp.Choices[len(resp.Choices)-1].FinishReason
+	}
+	return &reason
+}
+
+func newUsage(u perplexity.Usage) *Usage {
+	return &Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+// newExtension returns the extension of resp, nil if empty.
+func newExtension(resp *perplexity.CompletionResponse) *Extension {
+	e := &Extension{
+		Citations:        resp.GetCitations(),
+		SearchResults:    resp.SearchResults,
+		Images:           resp.Images,
+		RelatedQuestions: resp.RelatedQuestions,
+	}
+	if len(e.Citations) == 0 && len(e.SearchResults) == 0 && len(e.Images) == 0 && len(e.RelatedQuestions) == 0 {
+		return nil
+	}
+	return e
+}
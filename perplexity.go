@@ -1,7 +1,9 @@
 package perplexity
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +16,9 @@ import (
 // DefaultEndpoint is the default endpoint for the Perplexity API.
 const DefaultEndpoint = "https://api.perplexity.ai/chat/completions"
 
+// DefaultSearchEndpoint is the default endpoint for the Perplexity Search API.
+const DefaultSearchEndpoint = "https://api.perplexity.ai/search"
+
 // DefautTimeout is the default timeout for the HTTP client.
 const DefautTimeout = 10 * time.Second
 
@@ -22,11 +27,45 @@ const DefaultModel = "sonar"
 
 const defaultSizeSSEResponse = 64000
 
+// maxSizeErrorBody is the maximum number of bytes of the body kept in an APIError.
+const maxSizeErrorBody = 4096
+
+// ErrUnauthorized is returned (wrapped in an APIError) when the API key is rejected.
+var ErrUnauthorized = errors.New("unauthorized: check your API key")
+
+// APIError is returned when the API responds with an unexpected status code.
+type APIError struct {
+	StatusCode int
+	// Body is the beginning of the body of the response.
+	Body string
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized.Error()
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// Unwrap returns ErrUnauthorized if the status code is 401.
+func (e *APIError) Unwrap() error {
+	if e.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
 // Client is a client for the Perplexity API.
 type Client struct {
-	endpoint   string
-	apiKey     string
-	httpClient *http.Client
+	endpoint       string
+	searchEndpoint string
+	apiKey         string
+	httpClient     *http.Client
+	budget         *BudgetGuard
+	ledger         *UsageLedger
+	maxRetries     int
+	retryBackoff   time.Duration
 }
 
 // NewClient creates a new Perplexity API client.
@@ -34,8 +73,9 @@ type Client struct {
 // The default model is llama-3-sonar-small-32k-online.
 func NewClient(apiKey string) *Client {
 	s := &Client{
-		apiKey:   apiKey,
-		endpoint: DefaultEndpoint,
+		apiKey:         apiKey,
+		endpoint:       DefaultEndpoint,
+		searchEndpoint: DefaultSearchEndpoint,
 		httpClient: &http.Client{
 			Timeout: DefautTimeout,
 		},
@@ -48,6 +88,11 @@ func (s *Client) SetEndpoint(endpoint string) {
 	s.endpoint = endpoint
 }
 
+// SetSearchEndpoint sets the Search API endpoint.
+func (s *Client) SetSearchEndpoint(endpoint string) {
+	s.searchEndpoint = endpoint
+}
+
 // SetHTTPClient sets the HTTP client.
 func (s *Client) SetHTTPClient(httpClient *http.Client) {
 	s.httpClient = httpClient
@@ -63,34 +108,55 @@ func (s *Client) GetHTTPTimeout() time.Duration {
 	return s.httpClient.Timeout
 }
 
+// SetBudget sets the guard enforcing spending caps on the completions (nil to remove it).
+// The requests that would exceed a cap are downgraded or refused with a BudgetError.
+func (s *Client) SetBudget(budget *BudgetGuard) {
+	s.budget = budget
+}
+
+// SetUsageLedger sets the ledger recording the usage of the completions (nil to remove it).
+// The usage labels of the contexts of the requests are recorded with them.
+func (s *Client) SetUsageLedger(ledger *UsageLedger) {
+	s.ledger = ledger
+}
+
 // SendCompletionRequest sends a completion request to the Perplexity API.
 func (s *Client) SendCompletionRequest(req *CompletionRequest) (*CompletionResponse, error) {
-	r := &CompletionResponse{}
+	return s.SendCompletionRequestWithContext(context.Background(), req)
+}
+
+// SendCompletionRequestWithContext sends a completion request to the Perplexity API.
+// The request is canceled when ctx is done.
+func (s *Client) SendCompletionRequestWithContext(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 	if req == nil {
 		return nil, fmt.Errorf("request must not be nil")
 	}
-	requestBody, err := json.Marshal(req)
+	req, reservation, err := s.reserve(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		return nil, err
 	}
-	httpReq, err := http.NewRequest("POST", s.endpoint, bytes.NewBuffer(requestBody))
+	start := time.Now()
+	r, err := s.sendCompletionRequest(ctx, req)
+	s.completed(ctx, req, reservation, r, time.Since(start), false)
+	return r, err
+}
+
+// sendCompletionRequest sends a blocking completion request.
+func (s *Client) sendCompletionRequest(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	r := &CompletionResponse{}
+	httpReq, err := s.newJSONRequest(ctx, s.endpoint, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
-	httpReq.Header.Set("Content-Type", "application/json")
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// Check return status code
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusUnauthorized {
-			return nil, fmt.Errorf("unauthorized: check your API key")
-		}
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if err := checkResponseStatus(resp); err != nil {
+		return nil, err
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -100,13 +166,18 @@ func (s *Client) SendCompletionRequest(req *CompletionRequest) (*CompletionRespo
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response body: %w - body response=%s", err, string(body))
 	}
-	return r, err
+	return r, nil
 }
 
 // SendSSEHTTPRequest sends a completion request to the Perplexity API using Server-Sent Events.
 // It writes each response (event) on the channel responseChannel
 // The channel will be closed when the request is done.
 func (s *Client) SendSSEHTTPRequest(wg *sync.WaitGroup, req *CompletionRequest, responseChannel chan<- CompletionResponse) error {
+	return s.SendSSEHTTPRequestWithContext(context.Background(), wg, req, responseChannel)
+}
+
+// SendSSEHTTPRequestWithContext is like SendSSEHTTPRequest but the request is canceled when ctx is done.
+func (s *Client) SendSSEHTTPRequestWithContext(ctx context.Context, wg *sync.WaitGroup, req *CompletionRequest, responseChannel chan<- CompletionResponse) error {
 	if responseChannel == nil {
 		return fmt.Errorf("responseChannel must not be nil")
 	}
@@ -120,78 +191,143 @@ func (s *Client) SendSSEHTTPRequest(wg *sync.WaitGroup, req *CompletionRequest,
 	defer close(responseChannel)
 	defer wg.Done()
 
-	requestBody, err := json.Marshal(req)
+	req, reservation, err := s.reserve(req)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %w", err)
+		return err
 	}
-	httpReq, err := http.NewRequest("POST", s.endpoint, bytes.NewBuffer(requestBody))
+	var (
+		acc      streamAccumulator
+		received bool
+	)
+	observed := reservation != nil || s.ledger != nil
+	start := time.Now()
+	err = s.sendSSEHTTPRequest(ctx, req, func(r CompletionResponse) {
+		if observed {
+			acc.add(r)
+		}
+		received = true
+		responseChannel <- r
+	})
+	var resp *CompletionResponse
+	if received {
+		// the tokens generated before a failure are billed too
+		resp = acc.response()
+	}
+	s.completed(ctx, req, reservation, resp, time.Since(start), true)
+	return err
+}
+
+// sendSSEHTTPRequest sends a streamed completion request and calls emit with each event.
+func (s *Client) sendSSEHTTPRequest(ctx context.Context, req *CompletionRequest, emit func(CompletionResponse)) error {
+	httpReq, err := s.newJSONRequest(ctx, s.endpoint, req)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
-	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
 	httpReq.Header.Set("Cache-Control", "no-cache")
 	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("Connection", "keep-alive")
 
-	resp, err := s.httpClient.Do(httpReq)
+	resp, err := s.do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	// lastMessage is used to store the last message in case of truncation
-	// received events may be truncated
-	var lastMessage []byte
+	// Check return status code
+	if err := checkResponseStatus(resp); err != nil {
+		return err
+	}
+
+	return readSSEEvents(resp.Body, emit)
+}
+
+// reserve reserves the estimated cost of req if the client has a budget guard. It returns the
+// request to send, downgraded if needed.
+func (s *Client) reserve(req *CompletionRequest) (*CompletionRequest, *budgetReservation, error) {
+	if s.budget == nil {
+		return req, nil, nil
+	}
+	return s.budget.reserve(s.apiKey, req)
+}
+
+// completed records the response of a completion in the budget and the usage ledger. resp is nil if
+// the completion failed, or partial if a stream failed after its first event.
+func (s *Client) completed(ctx context.Context, req *CompletionRequest, reservation *budgetReservation,
+	resp *CompletionResponse, latency time.Duration, stream bool) {
+	if reservation != nil {
+		reservation.settle(resp)
+	}
+	if s.ledger != nil && resp != nil {
+		s.ledger.record(ctx, req.Model, resp, latency, stream)
+	}
+}
+
+// readSSEEvents decodes the server-sent events of body and calls emit with each of them.
+// Events may be split across reads or be larger than the read buffer.
+// As before, the events that are not valid JSON are ignored.
+func readSSEEvents(body io.Reader, emit func(CompletionResponse)) error {
+	reader := bufio.NewReaderSize(body, defaultSizeSSEResponse)
+	var data []byte
+	dispatch := func() {
+		defer func() { data = data[:0] }()
+		payload := bytes.TrimSpace(data)
+		if len(payload) == 0 || bytes.Equal(payload, []byte("[DONE]")) {
+			return
+		}
+		var r CompletionResponse
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return
+		}
+		emit(r)
+	}
 	for {
-		var tmpData []byte
-		data := make([]byte, defaultSizeSSEResponse)
-		_, errBody := resp.Body.Read(data)
-		if errBody != io.EOF && err != nil {
-			return fmt.Errorf("failed to read response body: %w", err)
+		line, errRead := reader.ReadBytes('\n')
+		if errRead != nil && !errors.Is(errRead, io.EOF) {
+			return fmt.Errorf("failed to read response body: %w", errRead)
 		}
-
-		// split the response by '\r\n\r\n'
-		// because each SSE event is separated by '\r\n\r\n'
-		splittedData := bytes.Split(data, []byte("\r\n\r\n"))
-	loop:
-		for _, d := range splittedData {
-			// Check if the last message has been truncated
-			// if not, we can directly use the data
-			if len(lastMessage) == 0 {
-				tmpData = d[6:]
-			}
-			// if the last message has been truncated, we need to concatenate the last message with the next one
-			if len(lastMessage) > 0 {
-				tmpData = append(lastMessage, d[6:]...)
-				lastMessage = nil
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			// an empty line ends the event
+			dispatch()
+		case bytes.HasPrefix(line, []byte("data:")):
+			if len(data) > 0 {
+				data = append(data, '\n')
 			}
-			// trim nil bytes
-			tmpData = bytes.Trim(tmpData, "\x00")
-			if len(tmpData) == 0 {
-				break loop
-			}
-			var r CompletionResponse
-			err = json.Unmarshal(tmpData, &r)
-			if err != nil {
-				// we ignore the error because the last message has been truncated
-				// we need to concatenate the last message with the next one
-				lastMessage = tmpData
-				break loop
-			}
-			// Write the response on the channel
-			responseChannel <- r
+			data = append(data, bytes.TrimPrefix(line[len("data:"):], []byte(" "))...)
 		}
-		// Check if it's the end of the stream
-		if errors.Is(errBody, io.EOF) {
-			break
+		// other fields (event, id, retry) and comments are ignored
+		if errors.Is(errRead, io.EOF) {
+			// the last event may not be followed by an empty line
+			dispatch()
+			return nil
 		}
 	}
-	// Check return status code
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusUnauthorized {
-			return fmt.Errorf("unauthorized: check your API key")
-		}
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
+
+// newJSONRequest creates an authenticated POST request with body marshalled in JSON.
+func (s *Client) newJSONRequest(ctx context.Context, endpoint string, body any) (*http.Request, error) {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq, nil
+}
+
+// checkResponseStatus returns an APIError if the status code of the response is not 200.
+func checkResponseStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxSizeErrorBody))
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
 	}
-	return nil
 }
@@ -0,0 +1,283 @@
+package perplexity
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BibEntry is a source of a bibliography.
+type BibEntry struct {
+	// Key is the citation key, derived from the domain and the normalized URL of the source.
+	// It does not depend on the order in which the sources are added.
+	Key string
+	URL string
+	// Title and Date are those of the first search result of the source that has them.
+	Title  string
+	Date   string
+	Domain string
+}
+
+// Bibliography collects the sources cited in one or several responses.
+// A source cited several times (even with slightly different URLs) is added once.
+type Bibliography struct {
+	entries []BibEntry
+	byURL   map[string]int
+}
+
+// NewBibliography returns an empty bibliography.
+func NewBibliography() *Bibliography {
+	return &Bibliography{
+		byURL: make(map[string]int),
+	}
+}
+
+// AddResponse adds the citations of the response, with the title and date of their search results.
+// If the response has no citations, its search results are added.
+func (b *Bibliography) AddResponse(resp *CompletionResponse) {
+	b.add(resp.GetCitations(), resp.GetSearchResults())
+}
+
+// AddMessages adds the citations of all the messages of a conversation.
+func (b *Bibliography) AddMessages(m Messages) {
+	for i := range m.messages {
+		md := m.GetMetadata(i)
+		b.add(md.Citations, md.SearchResults)
+	}
+}
+
+func (b *Bibliography) add(citations []string, searchResults []SearchResult) {
+	results := make(map[string]SearchResult)
+	for _, r := range searchResults {
+		results[normalizeURL(r.URL)] = r
+	}
+	urls := citations
+	if len(urls) == 0 {
+		// recent responses may only contain search results
+		for _, r := range searchResults {
+			urls = append(urls, r.URL)
+		}
+	}
+	for _, u := range urls {
+		b.addEntry(u, results[normalizeURL(u)])
+	}
+}
+
+func (b *Bibliography) addEntry(rawURL string, result SearchResult) {
+	if rawURL == "" {
+		return
+	}
+	key := normalizeURL(rawURL)
+	if i, ok := b.byURL[key]; ok {
+		// merge the information missing in the entry
+		if b.entries[i].Title == "" {
+			b.entries[i].Title = result.Title
+		}
+		if b.entries[i].Date == "" {
+			b.entries[i].Date = result.Date
+		}
+		return
+	}
+	entry := BibEntry{
+		URL:    rawURL,
+		Title:  result.Title,
+		Date:   result.Date,
+		Domain: domainOf(rawURL),
+	}
+	entry.Key = citationKey(key, entry.Domain)
+	b.byURL[key] = len(b.entries)
+	b.entries = append(b.entries, entry)
+}
+
+// Entries returns the entries in order of first citation.
+func (b *Bibliography) Entries() []BibEntry {
+	return append([]BibEntry(nil), b.entries...)
+}
+
+// BibTeX returns the bibliography in BibTeX format.
+func (b *Bibliography) BibTeX() string {
+	var sb strings.Builder
+	for i, e := range b.entries {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "@misc{%s,\n", e.Key)
+		fmt.Fprintf(&sb, "  title = {%s},\n", bibtexEscape(e.title()))
+		fmt.Fprintf(&sb, "  howpublished = {\\url{%s}},\n", e.URL)
+		fmt.Fprintf(&sb, "  url = {%s},\n", e.URL)
+		if e.Domain != "" {
+			fmt.Fprintf(&sb, "  organization = {%s},\n", bibtexEscape(e.Domain))
+		}
+		if y, m, _ := parseEntryDate(e.Date); y > 0 {
+			fmt.Fprintf(&sb, "  year = {%d},\n", y)
+			if m > 0 {
+				fmt.Fprintf(&sb, "  month = {%d},\n", m)
+			}
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+// RIS returns the bibliography in RIS format.
+func (b *Bibliography) RIS() string {
+	var sb strings.Builder
+	for _, e := range b.entries {
+		sb.WriteString("TY  - ELEC\n")
+		fmt.Fprintf(&sb, "ID  - %s\n", e.Key)
+		fmt.Fprintf(&sb, "TI  - %s\n", e.title())
+		fmt.Fprintf(&sb, "UR  - %s\n", e.URL)
+		if e.Domain != "" {
+			fmt.Fprintf(&sb, "PB  - %s\n", e.Domain)
+		}
+		if y, m, d := parseEntryDate(e.Date); y > 0 {
+			fmt.Fprintf(&sb, "PY  - %d\n", y)
+			switch {
+			case d > 0:
+				fmt.Fprintf(&sb, "DA  - %04d/%02d/%02d/\n", y, m, d)
+			case m > 0:
+				fmt.Fprintf(&sb, "DA  - %04d/%02d//\n", y, m)
+			}
+		}
+		sb.WriteString("ER  - \n")
+	}
+	return sb.String()
+}
+
+// cslItem is an item of a CSL-JSON bibliography.
+type cslItem struct {
+	ID             string   `json:"id"`
+	Type           string   `json:"type"`
+	Title          string   `json:"title"`
+	URL            string   `json:"URL"`
+	ContainerTitle string   `json:"container-title,omitempty"`
+	Issued         *cslDate `json:"issued,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// CSLJSON returns the bibliography in CSL-JSON format.
+func (b *Bibliography) CSLJSON() ([]byte, error) {
+	items := make([]cslItem, 0, len(b.entries))
+	for _, e := range b.entries {
+		item := cslItem{
+			ID:             e.Key,
+			Type:           "webpage",
+			Title:          e.title(),
+			URL:            e.URL,
+			ContainerTitle: e.Domain,
+		}
+		if y, m, d := parseEntryDate(e.Date); y > 0 {
+			parts := []int{y}
+			if m > 0 {
+				parts = append(parts, m)
+				if d > 0 {
+					parts = append(parts, d)
+				}
+			}
+			item.Issued = &cslDate{DateParts: [][]int{parts}}
+		}
+		items = append(items, item)
+	}
+	return json.MarshalIndent(items, "", "  ")
+}
+
+func (e BibEntry) title() string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return e.URL
+}
+
+// normalizeURL returns the URL used to detect duplicated sources:
+// lowercase scheme and host, without www, fragment, tracking parameters and trailing slash.
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(rawURL)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	u.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	u.Fragment = ""
+	q := u.Query()
+	for k := range q {
+		if strings.HasPrefix(k, "utm_") {
+			q.Del(k)
+		}
+	}
+	u.RawQuery = q.Encode()
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+// domainOf returns the host of the URL without www.
+func domainOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// citationKey returns a key such as wikipedia-1a2b3c from the normalized URL and the domain
+// of the source. The date is left out: responses may give different dates for the same source.
+func citationKey(normalizedURL, domain string) string {
+	labels := strings.Split(domain, ".")
+	name := labels[0]
+	if len(labels) >= 2 {
+		name = labels[len(labels)-2]
+	}
+	name = nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "")
+	if name == "" {
+		name = "source"
+	}
+	sum := sha1.Sum([]byte(normalizedURL))
+	return name + "-" + hex.EncodeToString(sum[:])[:6]
+}
+
+// parseEntryDate returns the year, month and day of a date (0 if unknown).
+func parseEntryDate(date string) (year, month, day int) {
+	date = strings.TrimSpace(date)
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "2006/01/02", "January 2, 2006", "Jan 2, 2006", "2 January 2006"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Year(), int(t.Month()), t.Day()
+		}
+	}
+	for _, layout := range []string{"2006-01", "January 2006", "2006"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			if layout == "2006" {
+				return t.Year(), 0, 0
+			}
+			return t.Year(), int(t.Month()), 0
+		}
+	}
+	return 0, 0, 0
+}
+
+// bibtexEscape escapes the special characters of BibTeX.
+func bibtexEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\textbackslash{}`,
+		`{`, `\{`,
+		`}`, `\}`,
+		`&`, `\&`,
+		`%`, `\%`,
+		`$`, `\$`,
+		`#`, `\#`,
+		`_`, `\_`,
+		`~`, `\textasciitilde{}`,
+		`^`, `\textasciicircum{}`,
+	).Replace(s)
+}
@@ -0,0 +1,116 @@
+package perplexity_test
+
+import (
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBibliographyResponses() (*perplexity.CompletionResponse, *perplexity.CompletionResponse) {
+	first := &perplexity.CompletionResponse{
+		Citations: &[]string{"https://www.example.com/article/", "https://go.dev/doc"},
+		SearchResults: []perplexity.SearchResult{
+			{Title: "Go & {docs}", URL: "https://go.dev/doc", Date: "2024-05-01"},
+		},
+	}
+	second := &perplexity.CompletionResponse{
+		Citations: &[]string{"https://example.com/article?utm_source=pplx#top"},
+		SearchResults: []perplexity.SearchResult{
+			{Title: "An article", URL: "https://example.com/article?utm_source=pplx#top", Date: "2023"},
+		},
+	}
+	return first, second
+}
+
+func TestBibliography(t *testing.T) {
+	t.Run("merges duplicated sources across responses", func(t *testing.T) {
+		first, second := newBibliographyResponses()
+		b := perplexity.NewBibliography()
+		b.AddResponse(first)
+		b.AddResponse(second)
+		entries := b.Entries()
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "https://www.example.com/article/", entries[0].URL)
+		assert.Equal(t, "An article", entries[0].Title)
+		assert.Equal(t, "example.com", entries[0].Domain)
+		assert.Regexp(t, `^example-[0-9a-f]{6}$`, entries[0].Key)
+		assert.Regexp(t, `^go-[0-9a-f]{6}$`, entries[1].Key)
+	})
+
+	t.Run("keys do not depend on the order of the responses", func(t *testing.T) {
+		first, second := newBibliographyResponses()
+		b1 := perplexity.NewBibliography()
+		b1.AddResponse(first)
+		b1.AddResponse(second)
+		b2 := perplexity.NewBibliography()
+		b2.AddResponse(second)
+		b2.AddResponse(first)
+		keys := func(b *perplexity.Bibliography) map[string]bool {
+			res := map[string]bool{}
+			for _, e := range b.Entries() {
+				res[e.Key] = true
+			}
+			return res
+		}
+		assert.Equal(t, keys(b1), keys(b2))
+	})
+
+	t.Run("keys do not depend on the dates of the responses", func(t *testing.T) {
+		dated := func(date string) *perplexity.CompletionResponse {
+			return &perplexity.CompletionResponse{
+				Citations:     &[]string{"https://go.dev/doc"},
+				SearchResults: []perplexity.SearchResult{{URL: "https://go.dev/doc", Date: date}},
+			}
+		}
+		b1 := perplexity.NewBibliography()
+		b1.AddResponse(dated("2023"))
+		b1.AddResponse(dated("2024-05-01"))
+		b2 := perplexity.NewBibliography()
+		b2.AddResponse(dated("2024-05-01"))
+		b2.AddResponse(dated("2023"))
+		assert.Equal(t, b1.Entries()[0].Key, b2.Entries()[0].Key)
+		assert.Equal(t, "2023", b1.Entries()[0].Date)
+		assert.Equal(t, "2024-05-01", b2.Entries()[0].Date)
+	})
+
+	t.Run("exports a conversation", func(t *testing.T) {
+		first, _ := newBibliographyResponses()
+		m := perplexity.NewMessages()
+		assert.Nil(t, m.AddUserMessage("hello"))
+		assert.Nil(t, m.AddAgentMessage("hi"))
+		assert.Nil(t, m.SetMetadata(1, perplexity.MessageMetadata{Citations: first.GetCitations(), SearchResults: first.SearchResults}))
+		b := perplexity.NewBibliography()
+		b.AddMessages(m)
+		assert.Len(t, b.Entries(), 2)
+	})
+}
+
+func TestBibliographyFormats(t *testing.T) {
+	b := perplexity.NewBibliography()
+	b.AddResponse(&perplexity.CompletionResponse{
+		SearchResults: []perplexity.SearchResult{
+			{Title: "Go & {docs}", URL: "https://go.dev/doc", Date: "2024-05-01"},
+		},
+	})
+	key := b.Entries()[0].Key
+
+	t.Run("BibTeX", func(t *testing.T) {
+		assert.Equal(t, "@misc{"+key+",\n"+
+			"  title = {Go \\& \\{docs\\}},\n"+
+			"  howpublished = {\\url{https://go.dev/doc}},\n"+
+			"  url = {https://go.dev/doc},\n"+
+			"  organization = {go.dev},\n"+
+			"  year = {2024},\n"+
+			"  month = {5},\n"+
+			"}\n", b.BibTeX())
+	})
+	t.Run("RIS", func(t *testing.T) {
+		assert.Equal(t, "TY  - ELEC\nID  - "+key+"\nTI  - Go & {docs}\nUR  - https://go.dev/doc\nPB  - go.dev\nPY  - 2024\nDA  - 2024/05/01/\nER  - \n", b.RIS())
+	})
+	t.Run("CSL-JSON", func(t *testing.T) {
+		data, err := b.CSLJSON()
+		assert.Nil(t, err)
+		assert.JSONEq(t, `[{"id":"`+key+`","type":"webpage","title":"Go & {docs}","URL":"https://go.dev/doc","container-title":"go.dev","issued":{"date-parts":[[2024,5,1]]}}]`, string(data))
+	})
+}
@@ -0,0 +1,273 @@
+package perplexity
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned (wrapped in a BudgetError) when a request would exceed a spending cap.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Periods of the spending caps of a BudgetGuard.
+const (
+	BudgetDaily   = "daily"
+	BudgetMonthly = "monthly"
+	BudgetKey     = "key"
+)
+
+// BudgetError is returned when a BudgetGuard refuses a request.
+type BudgetError struct {
+	// Cap is the cap that would be exceeded: BudgetDaily, BudgetMonthly or BudgetKey.
+	Cap string
+	// Limit is the cap in US dollars, Spent the amount already spent (or reserved) in its period
+	// and Cost the estimated cost of the request.
+	Limit float64
+	Spent float64
+	Cost  float64
+}
+
+// Error implements the error interface.
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s: the %s cap of $%.2f would be exceeded ($%.4f spent, $%.4f estimated)",
+		ErrBudgetExceeded, e.Cap, e.Limit, e.Spent, e.Cost)
+}
+
+// Unwrap returns ErrBudgetExceeded.
+func (e *BudgetError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// BudgetOption is a functional option for the BudgetGuard.
+type BudgetOption func(*BudgetGuard)
+
+// WithDailyCap sets the cap of the spending of a day, in US dollars.
+func WithDailyCap(usd float64) BudgetOption {
+	return func(g *BudgetGuard) {
+		g.daily = usd
+	}
+}
+
+// WithMonthlyCap sets the cap of the spending of a month, in US dollars.
+func WithMonthlyCap(usd float64) BudgetOption {
+	return func(g *BudgetGuard) {
+		g.monthly = usd
+	}
+}
+
+// WithKeyCap sets the cap of the spending of a month of each API key, in US dollars.
+func WithKeyCap(usd float64) BudgetOption {
+	return func(g *BudgetGuard) {
+		g.perKey = usd
+	}
+}
+
+// WithBudgetPricing sets the prices of the models (DefaultPricing by default).
+func WithBudgetPricing(pricing Pricing) BudgetOption {
+	return func(g *BudgetGuard) {
+		g.pricing = pricing
+	}
+}
+
+// WithModelDowngrade makes the guard send the requests for model from with the cheaper model to
+// when a cap would be exceeded, instead of refusing them. The downgrades can be chained.
+func WithModelDowngrade(from, to string) BudgetOption {
+	return func(g *BudgetGuard) {
+		g.downgrades[from] = to
+	}
+}
+
+// WithSearchContextDowngrade makes the guard send the requests with a low search context size
+// when a cap would be exceeded, before downgrading their model.
+func WithSearchContextDowngrade() BudgetOption {
+	return func(g *BudgetGuard) {
+		g.lowerContext = true
+	}
+}
+
+// WithBudgetClock sets the function returning the current time (time.Now by default).
+// The days and the months are the ones of its location.
+func WithBudgetClock(now func() time.Time) BudgetOption {
+	return func(g *BudgetGuard) {
+		g.now = now
+	}
+}
+
+// BudgetGuard enforces spending caps on the completions of the clients it is set on with
+// Client.SetBudget. Before a request is sent, its estimated cost (see Pricing.EstimateCost) is
+// reserved; the request is refused with a BudgetError, or downgraded, if the reservation would exceed
+// a cap. When the completion is done, the reservation is replaced by the actual cost of the response.
+// Since the completion tokens of a request are unknown until it is done, set MaxTokens to bound them:
+// without MaxTokens, DefaultEstimatedCompletionTokens are reserved, which a long answer can exceed.
+// A stream failing after its first event is billed with the usage it reported.
+//
+// The requests for a model without price in the pricing are refused with an error wrapping ErrNoPrice,
+// unless no cap is set: they are then sent, and their cost is not counted.
+//
+// A BudgetGuard can be shared by several clients and is safe for concurrent use. The caps of 0 are not enforced.
+type BudgetGuard struct {
+	daily, monthly, perKey float64
+	pricing                Pricing
+	downgrades             map[string]string
+	lowerContext           bool
+	now                    func() time.Time
+
+	mu         sync.Mutex
+	day, month string // the current periods
+	daySpent   float64
+	monthSpent float64
+	keySpent   map[string]float64 // spending of the month, by API key
+}
+
+// Spending is the spending of the current periods, in US dollars.
+type Spending struct {
+	Day   float64
+	Month float64
+	// Key is the spending of the month of an API key.
+	Key float64
+}
+
+// budgetReservation is the estimated cost reserved for a request.
+type budgetReservation struct {
+	guard      *BudgetGuard
+	apiKey     string
+	model      string
+	day, month string
+	cost       float64
+}
+
+// NewBudgetGuard creates a budget guard.
+func NewBudgetGuard(opts ...BudgetOption) *BudgetGuard {
+	g := &BudgetGuard{
+		pricing:    DefaultPricing(),
+		downgrades: make(map[string]string),
+		now:        time.Now,
+		keySpent:   make(map[string]float64),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Spent returns the spending of the current day and month, and the spending of the month of apiKey.
+func (g *BudgetGuard) Spent(apiKey string) Spending {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.roll()
+	return Spending{Day: g.daySpent, Month: g.monthSpent, Key: g.keySpent[apiKey]}
+}
+
+// Add adds an amount spent with apiKey in the current periods, e.g. to restore the spending
+// after a restart.
+func (g *BudgetGuard) Add(apiKey string, usd float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.roll()
+	g.add(apiKey, usd)
+}
+
+// roll resets the spending when a new day or month starts.
+func (g *BudgetGuard) roll() {
+	now := g.now()
+	if day := now.Format(time.DateOnly); day != g.day {
+		g.day, g.daySpent = day, 0
+	}
+	if month := now.Format("2006-01"); month != g.month {
+		g.month, g.monthSpent = month, 0
+		clear(g.keySpent)
+	}
+}
+
+func (g *BudgetGuard) add(apiKey string, usd float64) {
+	g.daySpent += usd
+	g.monthSpent += usd
+	g.keySpent[apiKey] += usd
+}
+
+// check returns a BudgetError if cost would exceed a cap.
+func (g *BudgetGuard) check(apiKey string, cost float64) error {
+	caps := []struct {
+		name         string
+		limit, spent float64
+	}{
+		{BudgetDaily, g.daily, g.daySpent},
+		{BudgetMonthly, g.monthly, g.monthSpent},
+		{BudgetKey, g.perKey, g.keySpent[apiKey]},
+	}
+	for _, c := range caps {
+		if c.limit > 0 && c.spent+cost > c.limit {
+			return &BudgetError{Cap: c.name, Limit: c.limit, Spent: c.spent, Cost: cost}
+		}
+	}
+	return nil
+}
+
+// reserve reserves the estimated cost of req, downgraded if needed. It returns the request to send,
+// which is a copy of req if it is downgraded.
+func (g *BudgetGuard) reserve(apiKey string, req *CompletionRequest) (*CompletionRequest, *budgetReservation, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.roll()
+	visited := map[string]bool{}
+	for {
+		estimate, err := g.pricing.EstimateCost(req)
+		if err != nil && g.daily == 0 && g.monthly == 0 && g.perKey == 0 {
+			// no cap to enforce: the request is sent but its cost is unknown
+			return req, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		cost := estimate.Total()
+		errBudget := g.check(apiKey, cost)
+		if errBudget == nil {
+			g.add(apiKey, cost)
+			return req, &budgetReservation{
+				guard: g, apiKey: apiKey, model: req.Model, day: g.day, month: g.month, cost: cost,
+			}, nil
+		}
+		visited[req.Model] = true
+		downgraded := *req
+		switch to, ok := g.downgrades[req.Model]; {
+		case g.lowerContext && searchContextSize(req) != SearchContextLow:
+			downgraded.WebSearchOptions = &WebSearchOptions{SearchContextSize: SearchContextLow}
+		case ok && !visited[to]:
+			downgraded.Model = to
+		default:
+			return nil, nil, errBudget
+		}
+		req = &downgraded
+	}
+}
+
+// settle replaces the reservation by the cost of resp, or releases it if resp is nil.
+// The reservation is kept if the usage of resp is unknown, e.g. when a stream failed
+// before reporting it.
+func (r *budgetReservation) settle(resp *CompletionResponse) {
+	if resp != nil && resp.Usage == (Usage{}) {
+		return
+	}
+	g := r.guard
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.roll()
+	cost := 0.0
+	if resp != nil {
+		price, ok := g.pricing[resp.Model]
+		if !ok {
+			// the response may name the model differently
+			price = g.pricing[r.model]
+		}
+		cost = price.cost(resp.Usage).Total()
+	}
+	if r.day == g.day {
+		g.daySpent -= r.cost
+	}
+	if r.month == g.month {
+		g.monthSpent -= r.cost
+		g.keySpent[r.apiKey] -= r.cost
+	}
+	g.add(r.apiKey, cost)
+}
@@ -0,0 +1,251 @@
+package perplexity_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// answerWithUsage returns an answer of model costing $0.007 with sonar: 1000 prompt and
+// completion tokens and the fee of a low search context.
+func answerWithUsage(model string) perplexitytest.Response {
+	resp := perplexitytest.Answer("Paris.")
+	resp.Completion.Model = model
+	resp.Completion.Usage = perplexity.Usage{PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000}
+	return resp
+}
+
+func newBudgetRequest(opts ...perplexity.CompletionRequestOption) *perplexity.CompletionRequest {
+	opts = append([]perplexity.CompletionRequestOption{
+		perplexity.WithMessages([]perplexity.Message{{Role: "user", Content: "What's the capital of France?"}}),
+	}, opts...)
+	return perplexity.NewCompletionRequest(opts...)
+}
+
+func TestBudgetGuard(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	now := time.Date(2025, time.January, 30, 12, 0, 0, 0, time.UTC)
+	guard := perplexity.NewBudgetGuard(
+		perplexity.WithDailyCap(0.015),
+		perplexity.WithMonthlyCap(0.018),
+		perplexity.WithBudgetClock(func() time.Time { return now }),
+	)
+	client := srv.Client()
+	client.SetBudget(guard)
+
+	for range 2 {
+		srv.Enqueue(answerWithUsage("sonar"))
+		_, err := client.SendCompletionRequest(newBudgetRequest())
+		assert.Nil(t, err)
+	}
+	assert.InDelta(t, 0.014, guard.Spent(perplexitytest.DefaultAPIKey).Day, 1e-9)
+
+	// The third request would exceed the daily cap: it is not sent.
+	_, err := client.SendCompletionRequest(newBudgetRequest())
+	assert.True(t, errors.Is(err, perplexity.ErrBudgetExceeded))
+	var budgetErr *perplexity.BudgetError
+	if assert.True(t, errors.As(err, &budgetErr)) {
+		assert.Equal(t, perplexity.BudgetDaily, budgetErr.Cap)
+		assert.Equal(t, 0.015, budgetErr.Limit)
+		assert.InDelta(t, 0.014, budgetErr.Spent, 1e-9)
+	}
+	assert.Len(t, srv.Requests(), 2)
+
+	// The next day, the monthly cap applies.
+	now = now.Add(24 * time.Hour)
+	assert.Equal(t, 0.0, guard.Spent(perplexitytest.DefaultAPIKey).Day)
+	_, err = client.SendCompletionRequest(newBudgetRequest())
+	if assert.True(t, errors.As(err, &budgetErr)) {
+		assert.Equal(t, perplexity.BudgetMonthly, budgetErr.Cap)
+	}
+
+	// A new month starts.
+	now = now.Add(24 * time.Hour)
+	spent := guard.Spent(perplexitytest.DefaultAPIKey)
+	assert.Equal(t, perplexity.Spending{}, spent)
+	srv.Enqueue(answerWithUsage("sonar"))
+	_, err = client.SendCompletionRequest(newBudgetRequest())
+	assert.Nil(t, err)
+
+	// The failed requests release their reservation.
+	guard = perplexity.NewBudgetGuard()
+	client.SetBudget(guard)
+	srv.Enqueue(perplexitytest.Error(500, "boom"))
+	_, err = client.SendCompletionRequest(newBudgetRequest())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0.0, guard.Spent(perplexitytest.DefaultAPIKey).Day)
+}
+
+func TestBudgetGuardKeyCap(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	guard := perplexity.NewBudgetGuard(perplexity.WithKeyCap(0.01))
+	guard.Add("other-key", 0.009)
+	client := srv.Client()
+	client.SetBudget(guard)
+
+	srv.Enqueue(answerWithUsage("sonar"))
+	_, err := client.SendCompletionRequest(newBudgetRequest())
+	assert.Nil(t, err)
+	_, err = client.SendCompletionRequest(newBudgetRequest())
+	var budgetErr *perplexity.BudgetError
+	if assert.True(t, errors.As(err, &budgetErr)) {
+		assert.Equal(t, perplexity.BudgetKey, budgetErr.Cap)
+	}
+	assert.InDelta(t, 0.016, guard.Spent("other-key").Month, 1e-9)
+	assert.InDelta(t, 0.009, guard.Spent("other-key").Key, 1e-9)
+}
+
+func TestBudgetGuardDowngrade(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	guard := perplexity.NewBudgetGuard(
+		perplexity.WithDailyCap(0.01),
+		perplexity.WithSearchContextDowngrade(),
+		perplexity.WithModelDowngrade("sonar-reasoning-pro", "sonar-pro"),
+		perplexity.WithModelDowngrade("sonar-pro", "sonar"),
+	)
+	client := srv.Client()
+	client.SetBudget(guard)
+
+	// $0.014 for the fee of a high search context of sonar-pro, $0.006 for a low one,
+	// and $0.0015 for 100 completion tokens.
+	srv.Enqueue(answerWithUsage("sonar-pro"))
+	req := newBudgetRequest(perplexity.WithModel("sonar-pro"), perplexity.WithMaxTokens(100),
+		perplexity.WithSearchContextSize(perplexity.SearchContextHigh))
+	_, err := client.SendCompletionRequest(req)
+	assert.Nil(t, err)
+	last, _ := srv.LastRequest()
+	assert.Equal(t, "sonar-pro", last.Request.Model)
+	assert.Equal(t, perplexity.SearchContextLow, last.Request.WebSearchOptions.SearchContextSize)
+	assert.Equal(t, perplexity.SearchContextHigh, req.WebSearchOptions.SearchContextSize, "the request is not modified")
+	// 1000 tokens at $3 and $15 per million, and the fee.
+	assert.InDelta(t, 0.024, guard.Spent("").Day, 1e-9)
+
+	guard = perplexity.NewBudgetGuard(
+		perplexity.WithDailyCap(0.0055),
+		perplexity.WithModelDowngrade("sonar-reasoning-pro", "sonar-pro"),
+		perplexity.WithModelDowngrade("sonar-pro", "sonar"),
+	)
+	client.SetBudget(guard)
+	srv.Enqueue(answerWithUsage("sonar"))
+	_, err = client.SendCompletionRequest(newBudgetRequest(perplexity.WithModel("sonar-reasoning-pro"), perplexity.WithMaxTokens(100)))
+	assert.Nil(t, err)
+	last, _ = srv.LastRequest()
+	assert.Equal(t, "sonar", last.Request.Model)
+
+	// Nothing is cheap enough.
+	guard.Add("", 1)
+	_, err = client.SendCompletionRequest(newBudgetRequest(perplexity.WithModel("sonar-pro")))
+	assert.True(t, errors.Is(err, perplexity.ErrBudgetExceeded))
+}
+
+func TestBudgetGuardWithoutMaxTokens(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	// sonar-pro: $0.006 for the fee, $0.03072 for the default completion tokens.
+	guard := perplexity.NewBudgetGuard(perplexity.WithDailyCap(0.03))
+	client := srv.Client()
+	client.SetBudget(guard)
+
+	_, err := client.SendCompletionRequest(newBudgetRequest(perplexity.WithModel("sonar-pro")))
+	var budgetErr *perplexity.BudgetError
+	assert.True(t, errors.As(err, &budgetErr))
+	assert.InDelta(t, 0.0367, budgetErr.Cost, 1e-4)
+	assert.Empty(t, srv.Requests())
+
+	srv.Enqueue(answerWithUsage("sonar-pro"))
+	_, err = client.SendCompletionRequest(newBudgetRequest(perplexity.WithModel("sonar-pro"), perplexity.WithMaxTokens(1000)))
+	assert.Nil(t, err)
+}
+
+func TestBudgetGuardStream(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	guard := perplexity.NewBudgetGuard(perplexity.WithDailyCap(0.01))
+	client := srv.Client()
+	client.SetBudget(guard)
+
+	srv.Enqueue(answerWithUsage("sonar"))
+	var wg sync.WaitGroup
+	events := make(chan perplexity.CompletionResponse)
+	wg.Add(1)
+	go func() {
+		assert.Nil(t, client.SendSSEHTTPRequest(&wg, newBudgetRequest(perplexity.WithStream(true)), events))
+	}()
+	n := 0
+	for range events {
+		n++
+	}
+	wg.Wait()
+	assert.Greater(t, n, 0)
+	assert.InDelta(t, 0.007, guard.Spent(perplexitytest.DefaultAPIKey).Day, 1e-9)
+
+	// The refused streams close the channel.
+	events = make(chan perplexity.CompletionResponse)
+	wg.Add(1)
+	err := client.SendSSEHTTPRequest(&wg, newBudgetRequest(perplexity.WithStream(true)), events)
+	assert.True(t, errors.Is(err, perplexity.ErrBudgetExceeded))
+	_, ok := <-events
+	assert.False(t, ok)
+	wg.Wait()
+}
+
+func TestBudgetGuardCanceledStream(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	guard := perplexity.NewBudgetGuard(perplexity.WithDailyCap(0.01))
+	client := srv.Client()
+	client.SetBudget(guard)
+
+	resp := answerWithUsage("sonar")
+	resp.Chunks = []string{"Par", "is."}
+	resp.Delay = 100 * time.Millisecond
+	srv.Enqueue(resp)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	events := make(chan perplexity.CompletionResponse)
+	wg.Add(1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.SendSSEHTTPRequestWithContext(ctx, &wg, newBudgetRequest(perplexity.WithStream(true)), events)
+	}()
+	<-events
+	cancel()
+	for range events {
+	}
+	wg.Wait()
+	assert.NotNil(t, <-errCh)
+	// the tokens generated before the cancellation are billed
+	assert.InDelta(t, 0.007, guard.Spent(perplexitytest.DefaultAPIKey).Day, 1e-9)
+}
+
+func TestBudgetGuardUnpricedModel(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	pricing := perplexity.Pricing{"sonar": perplexity.DefaultPricing()["sonar"]}
+
+	// without cap, the requests for a model without price are sent
+	guard := perplexity.NewBudgetGuard(perplexity.WithBudgetPricing(pricing))
+	client.SetBudget(guard)
+	srv.Enqueue(answerWithUsage("sonar-pro"))
+	_, err := client.SendCompletionRequest(newBudgetRequest(perplexity.WithModel("sonar-pro")))
+	assert.Nil(t, err)
+	assert.Zero(t, guard.Spent(perplexitytest.DefaultAPIKey).Day)
+
+	// with a cap, they are refused since their cost cannot be checked
+	client.SetBudget(perplexity.NewBudgetGuard(perplexity.WithBudgetPricing(pricing), perplexity.WithDailyCap(1)))
+	_, err = client.SendCompletionRequest(newBudgetRequest(perplexity.WithModel("sonar-pro")))
+	assert.True(t, errors.Is(err, perplexity.ErrNoPrice))
+	assert.False(t, errors.Is(err, perplexity.ErrBudgetExceeded))
+	assert.Len(t, srv.Requests(), 1)
+}
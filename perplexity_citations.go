@@ -0,0 +1,196 @@
+package perplexity
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxCitationDigits is the maximum number of digits of a citation marker.
+const maxCitationDigits = 4
+
+// AnswerSegment is a run of text of an answer and the citations supporting it.
+type AnswerSegment struct {
+	Text string
+	// Citations are the numbers of the markers following the text ([1][2] gives 1 and 2).
+	Citations []int
+	// Sources are the citations resolved to their URL and search result.
+	Sources []CitationSource
+	// Unresolved are the numbers of the markers that do not match a citation of the response.
+	Unresolved []int
+}
+
+// CitationSource is a citation resolved to its URL and, if available, its search result.
+type CitationSource struct {
+	Index        int
+	URL          string
+	SearchResult *SearchResult
+}
+
+// ParseCitations splits the answer of the response in segments, each tied to the
+// citations whose markers follow it. Brackets in code blocks and inline code are not markers.
+func ParseCitations(resp *CompletionResponse) []AnswerSegment {
+	p := NewCitationParser(resp.GetCitations(), resp.GetSearchResults())
+	segments := p.Write(resp.GetLastContent())
+	return append(segments, p.Flush()...)
+}
+
+// CitationParser splits an answer in segments incrementally, as it is streamed.
+// Markers and code fences split across chunks are handled.
+// CitationParser is not safe for concurrent use.
+type CitationParser struct {
+	citations     []string
+	searchResults []SearchResult
+
+	pending  string // end of the last chunk that may be the beginning of a marker or a fence
+	text     strings.Builder
+	markers  []int
+	inFence  bool
+	inInline bool
+	content  string // content received through WriteChunk
+}
+
+// NewCitationParser returns a parser resolving the markers with citations and searchResults.
+func NewCitationParser(citations []string, searchResults []SearchResult) *CitationParser {
+	return &CitationParser{
+		citations:     citations,
+		searchResults: searchResults,
+	}
+}
+
+// SetSources replaces the citations and search results used to resolve the markers
+// of the next segments.
+func (p *CitationParser) SetSources(citations []string, searchResults []SearchResult) {
+	p.citations = citations
+	p.searchResults = searchResults
+}
+
+// WriteChunk parses the new content of an event of a stream and returns the completed segments.
+// The citations and search results of the event, if any, are used to resolve the markers.
+func (p *CitationParser) WriteChunk(r CompletionResponse) []AnswerSegment {
+	if r.Citations != nil || r.SearchResults != nil {
+		p.SetSources(r.GetCitations(), r.GetSearchResults())
+	}
+	var text string
+	for _, c := range r.Choices {
+		switch {
+		case c.Delta.Content != "":
+			text += c.Delta.Content
+			p.content += c.Delta.Content
+		case strings.HasPrefix(c.Message.Content, p.content):
+			// the event contains the whole content received so far
+			text += c.Message.Content[len(p.content):]
+			p.content = c.Message.Content
+		}
+	}
+	return p.Write(text)
+}
+
+// Write parses a chunk of the answer and returns the completed segments.
+// A segment is completed when the text following its markers starts.
+func (p *CitationParser) Write(chunk string) []AnswerSegment {
+	return p.parse(p.pending+chunk, false)
+}
+
+// Flush returns the last segment.
+func (p *CitationParser) Flush() []AnswerSegment {
+	segments := p.parse(p.pending, true)
+	if p.text.Len() > 0 || len(p.markers) > 0 {
+		segments = append(segments, p.segment())
+	}
+	return segments
+}
+
+func (p *CitationParser) parse(s string, final bool) []AnswerSegment {
+	var segments []AnswerSegment
+	p.pending = ""
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c == '`' {
+			j := i
+			for j < len(s) && s[j] == '`' {
+				j++
+			}
+			if j == len(s) && !final {
+				// the run of backticks may continue in the next chunk
+				p.pending = s[i:]
+				break
+			}
+			segments = p.appendText(segments, s[i:j])
+			switch {
+			case j-i >= 3 && !p.inInline:
+				p.inFence = !p.inFence
+			case !p.inFence:
+				p.inInline = !p.inInline
+			}
+			i = j
+			continue
+		}
+		if c == '[' && !p.inFence && !p.inInline {
+			j := i + 1
+			for j < len(s) && j-i-1 < maxCitationDigits && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			if j == len(s) && !final && j-i-1 < maxCitationDigits {
+				// the marker may continue in the next chunk
+				p.pending = s[i:]
+				break
+			}
+			if j > i+1 && j < len(s) && s[j] == ']' {
+				n, _ := strconv.Atoi(s[i+1 : j])
+				p.markers = append(p.markers, n)
+				i = j + 1
+				continue
+			}
+		}
+		segments = p.appendText(segments, s[i:i+1])
+		i++
+	}
+	return segments
+}
+
+// appendText appends text to the current segment, completing it if it has markers.
+func (p *CitationParser) appendText(segments []AnswerSegment, text string) []AnswerSegment {
+	if len(p.markers) > 0 {
+		segments = append(segments, p.segment())
+	}
+	p.text.WriteString(text)
+	return segments
+}
+
+// segment returns the current segment and starts a new one.
+func (p *CitationParser) segment() AnswerSegment {
+	seg := AnswerSegment{
+		Text:      p.text.String(),
+		Citations: p.markers,
+	}
+	for _, n := range p.markers {
+		if n < 1 || n > len(p.citations) {
+			seg.Unresolved = append(seg.Unresolved, n)
+			continue
+		}
+		seg.Sources = append(seg.Sources, p.resolve(n))
+	}
+	p.text.Reset()
+	p.markers = nil
+	return seg
+}
+
+// resolve returns the source of the citation n (1-based).
+func (p *CitationParser) resolve(n int) CitationSource {
+	src := CitationSource{
+		Index: n,
+		URL:   p.citations[n-1],
+	}
+	if n <= len(p.searchResults) && p.searchResults[n-1].URL == src.URL {
+		r := p.searchResults[n-1]
+		src.SearchResult = &r
+		return src
+	}
+	for _, r := range p.searchResults {
+		if r.URL == src.URL {
+			src.SearchResult = &r
+			break
+		}
+	}
+	return src
+}
@@ -0,0 +1,97 @@
+package perplexity_test
+
+import (
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCitedResponse(content string) *perplexity.CompletionResponse {
+	return &perplexity.CompletionResponse{
+		Choices: []perplexity.Choice{
+			{Message: perplexity.Message{Role: "assistant", Content: content}},
+		},
+		Citations: &[]string{"https://a.example", "https://b.example", "https://c.example"},
+		SearchResults: []perplexity.SearchResult{
+			{Title: "C", URL: "https://c.example"},
+			{Title: "A", URL: "https://a.example"},
+		},
+	}
+}
+
+func texts(segments []perplexity.AnswerSegment) []string {
+	var res []string
+	for _, s := range segments {
+		res = append(res, s.Text)
+	}
+	return res
+}
+
+func TestParseCitations(t *testing.T) {
+	t.Run("splits the answer on markers and resolves the sources", func(t *testing.T) {
+		segments := perplexity.ParseCitations(newCitedResponse("Paris is the capital[1][2]. It is big[3]."))
+		assert.Equal(t, []string{"Paris is the capital", ". It is big", "."}, texts(segments))
+		assert.Equal(t, []int{1, 2}, segments[0].Citations)
+		assert.Equal(t, "https://a.example", segments[0].Sources[0].URL)
+		assert.Equal(t, "A", segments[0].Sources[0].SearchResult.Title)
+		assert.Nil(t, segments[0].Sources[1].SearchResult)
+		assert.Equal(t, "C", segments[1].Sources[0].SearchResult.Title)
+		assert.Nil(t, segments[2].Citations)
+	})
+
+	t.Run("reports out-of-range markers", func(t *testing.T) {
+		segments := perplexity.ParseCitations(newCitedResponse("Hello[4][0][1]"))
+		assert.Len(t, segments, 1)
+		assert.Equal(t, []int{4, 0, 1}, segments[0].Citations)
+		assert.Equal(t, []int{4, 0}, segments[0].Unresolved)
+		assert.Len(t, segments[0].Sources, 1)
+	})
+
+	t.Run("ignores brackets in code", func(t *testing.T) {
+		content := "Use `a[1]` or:\n```go\nx := b[2]\n```\nDone[1]"
+		segments := perplexity.ParseCitations(newCitedResponse(content))
+		assert.Len(t, segments, 1)
+		assert.Equal(t, "Use `a[1]` or:\n```go\nx := b[2]\n```\nDone", segments[0].Text)
+		assert.Equal(t, []int{1}, segments[0].Citations)
+	})
+
+	t.Run("keeps brackets that are not markers", func(t *testing.T) {
+		segments := perplexity.ParseCitations(newCitedResponse("a [b] [] [12345] [2"))
+		assert.Equal(t, []string{"a [b] [] [12345] [2"}, texts(segments))
+	})
+
+	t.Run("empty response", func(t *testing.T) {
+		assert.Len(t, perplexity.ParseCitations(&perplexity.CompletionResponse{}), 0)
+	})
+}
+
+func TestCitationParser(t *testing.T) {
+	t.Run("handles markers and fences split across chunks", func(t *testing.T) {
+		content := "Paris[1][2] is ```code[3]``` nice[3]."
+		for i := 1; i < len(content); i++ {
+			p := perplexity.NewCitationParser([]string{"https://a.example", "https://b.example", "https://c.example"}, nil)
+			segments := p.Write(content[:i])
+			segments = append(segments, p.Write(content[i:])...)
+			segments = append(segments, p.Flush()...)
+			assert.Equal(t, []string{"Paris", " is ```code[3]``` nice", "."}, texts(segments), "split at %d", i)
+		}
+	})
+
+	t.Run("parses the events of a stream", func(t *testing.T) {
+		p := perplexity.NewCitationParser(nil, nil)
+		var segments []perplexity.AnswerSegment
+		for _, content := range []string{"Paris[", "Paris[1] is", "Paris[1] is big[2]"} {
+			segments = append(segments, p.WriteChunk(perplexity.CompletionResponse{
+				Choices:   []perplexity.Choice{{Message: perplexity.Message{Content: content}}},
+				Citations: &[]string{"https://a.example", "https://b.example"},
+			})...)
+		}
+		segments = append(segments, p.WriteChunk(perplexity.CompletionResponse{
+			Choices: []perplexity.Choice{{Delta: perplexity.Message{Content: "!"}}},
+		})...)
+		segments = append(segments, p.Flush()...)
+		assert.Equal(t, []string{"Paris", " is big", "!"}, texts(segments))
+		assert.Equal(t, "https://b.example", segments[1].Sources[0].URL)
+	})
+}
@@ -0,0 +1,205 @@
+package perplexity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Turn is a question of the user and the answer of the assistant.
+type Turn struct {
+	User      string
+	Assistant string
+	Citations []string
+}
+
+// Conversation is a chat session bound to a client (usually a *Client).
+// It keeps the history of the conversation and sends it with each new question.
+// A turn (question and answer) is added to the history only if the call succeeds.
+// Conversation is safe for concurrent use: calls to the Send methods are
+// serialized, readers are never blocked by an in-flight request.
+type Conversation struct {
+	client ChatClient
+	opts   []CompletionRequestOption
+
+	sendMu     sync.Mutex // serializes the Send methods
+	mu         sync.RWMutex
+	messages   Messages
+	truncation *TruncationPolicy
+}
+
+// NewConversation creates a new conversation starting from messages.
+// opts are applied to every request sent during the conversation.
+func NewConversation(client ChatClient, messages Messages, opts ...CompletionRequestOption) *Conversation {
+	return &Conversation{
+		client:   client,
+		opts:     opts,
+		messages: messages.clone(),
+	}
+}
+
+// SetTruncationPolicy sets the policy used to truncate the history sent with each request.
+// The history kept by the conversation is not truncated. A nil policy disables the truncation.
+func (c *Conversation) SetTruncationPolicy(policy *TruncationPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.truncation = policy
+}
+
+// Send sends text as a new user message and returns the response.
+// The question and the answer are appended to the conversation if the call succeeds.
+func (c *Conversation) Send(ctx context.Context, text string) (*CompletionResponse, error) {
+	return c.SendMessage(ctx, Message{Role: "user", Content: text})
+}
+
+// SendMessage is like Send with a complete user message, e.g. with images.
+func (c *Conversation) SendMessage(ctx context.Context, msg Message) (*CompletionResponse, error) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	next, req, err := c.prepare(ctx, msg, false)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.SendCompletionRequestWithContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.commit(next, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SendStream sends text as a new user message and streams the response.
+// Each event is written on responseChannel (if not nil), which is closed when the request is done.
+// The complete response is returned and the turn is appended to the conversation if the call succeeds.
+func (c *Conversation) SendStream(ctx context.Context, text string, responseChannel chan<- CompletionResponse) (*CompletionResponse, error) {
+	return c.SendMessageStream(ctx, Message{Role: "user", Content: text}, responseChannel)
+}
+
+// SendMessageStream is like SendStream with a complete user message, e.g. with images.
+func (c *Conversation) SendMessageStream(ctx context.Context, msg Message, responseChannel chan<- CompletionResponse) (*CompletionResponse, error) {
+	if responseChannel != nil {
+		defer close(responseChannel)
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	next, req, err := c.prepare(ctx, msg, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		acc    streamAccumulator
+		events = make(chan CompletionResponse)
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for r := range events {
+			acc.add(r)
+			if responseChannel != nil {
+				responseChannel <- r
+			}
+		}
+	}()
+	wg.Add(1)
+	err = c.client.SendSSEHTTPRequestWithContext(ctx, &wg, req, events)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := acc.response()
+	if err := c.commit(next, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// prepare returns the messages with the new user message and the request to send.
+func (c *Conversation) prepare(ctx context.Context, msg Message, stream bool) (Messages, *CompletionRequest, error) {
+	c.mu.RLock()
+	next := c.messages.clone()
+	truncation := c.truncation
+	c.mu.RUnlock()
+	if msg.Role != "user" {
+		return Messages{}, nil, fmt.Errorf("the message sent must be a user message, not %q", msg.Role)
+	}
+	if err := next.add(msg); err != nil {
+		return Messages{}, nil, err
+	}
+	opts := append([]CompletionRequestOption{}, c.opts...)
+	opts = append(opts, WithMessages(next.GetMessages()), WithStream(stream))
+	req := NewCompletionRequest(opts...)
+	if truncation != nil {
+		truncated, err := truncation.Apply(ctx, next, req)
+		if err != nil {
+			return Messages{}, nil, err
+		}
+		req.Messages = truncated.GetMessages()
+	}
+	if err := req.Validate(); err != nil {
+		return Messages{}, nil, err
+	}
+	return next, req, nil
+}
+
+// commit appends the answer to next and replaces the history of the conversation.
+func (c *Conversation) commit(next Messages, res *CompletionResponse) error {
+	if err := next.AddAgentMessage(res.GetLastContent()); err != nil {
+		return fmt.Errorf("failed to add the answer to the conversation: %w", err)
+	}
+	metadata := res.metadata()
+	if err := next.SetMetadata(next.Len()-1, metadata); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = next
+	return nil
+}
+
+// Messages returns a copy of the history of the conversation.
+func (c *Conversation) Messages() Messages {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.messages.clone()
+}
+
+// GetMessages returns the messages of the conversation, system message included.
+func (c *Conversation) GetMessages() []Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.messages.GetMessages()
+}
+
+// Turns returns the completed turns of the conversation.
+func (c *Conversation) Turns() []Turn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var turns []Turn
+	for i := 0; i+1 < len(c.messages.messages); i += 2 {
+		turns = append(turns, Turn{
+			User:      c.messages.messages[i].Content,
+			Assistant: c.messages.messages[i+1].Content,
+			Citations: append([]string(nil), c.messages.GetMetadata(i+1).Citations...),
+		})
+	}
+	return turns
+}
+
+// Citations returns the citations of the answer of the given turn (starting at 0).
+func (c *Conversation) Citations(turn int) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if turn < 0 {
+		return []string{}
+	}
+	return append([]string{}, c.messages.GetMetadata(2*turn+1).Citations...)
+}
@@ -0,0 +1,197 @@
+package perplexity_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newConversationServer returns a server answering "answer N" to the Nth request.
+// The answer is streamed if requested. It fails when the question is "fail".
+func newConversationServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	n := 0
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req perplexity.CompletionRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Messages[len(req.Messages)-1].Content == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		n++
+		answer := fmt.Sprintf("answer %d (%d messages)", n, len(req.Messages))
+		mu.Unlock()
+		if req.Stream {
+			w.Header().Add("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"answer \"}}]}\r\n\r\n")
+			fmt.Fprintf(w, "data: {\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":%q}}],\"citations\":[\"https://stream.example\"]}\r\n\r\n", answer)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%q}}],"citations":["https://example.com/%d"]}`, answer, n)
+	}))
+}
+
+func newConversationClient(ts *httptest.Server) *perplexity.Client {
+	client := perplexity.NewClient(apiKey)
+	client.SetHTTPClient(ts.Client())
+	client.SetEndpoint(ts.URL)
+	return client
+}
+
+func TestConversationSend(t *testing.T) {
+	t.Run("appends both turns and keeps citations per turn", func(t *testing.T) {
+		ts := newConversationServer(t)
+		defer ts.Close()
+		conv := perplexity.NewConversation(newConversationClient(ts), perplexity.NewMessages(perplexity.WithSystemMessage("Be precise")))
+
+		res, err := conv.Send(context.Background(), "first")
+		assert.Nil(t, err)
+		assert.Equal(t, "answer 1 (2 messages)", res.GetLastContent())
+		res, err = conv.Send(context.Background(), "second")
+		assert.Nil(t, err)
+		assert.Equal(t, "answer 2 (4 messages)", res.GetLastContent())
+
+		assert.Equal(t, []perplexity.Turn{
+			{User: "first", Assistant: "answer 1 (2 messages)", Citations: []string{"https://example.com/1"}},
+			{User: "second", Assistant: "answer 2 (4 messages)", Citations: []string{"https://example.com/2"}},
+		}, conv.Turns())
+		assert.Len(t, conv.GetMessages(), 5)
+		assert.Equal(t, []string{"https://example.com/2"}, conv.Citations(1))
+		assert.Equal(t, []string{}, conv.Citations(2))
+	})
+
+	t.Run("rolls back the user turn if the call fails", func(t *testing.T) {
+		ts := newConversationServer(t)
+		defer ts.Close()
+		conv := perplexity.NewConversation(newConversationClient(ts), perplexity.NewMessages())
+
+		_, err := conv.Send(context.Background(), "fail")
+		assert.NotNil(t, err)
+		assert.Len(t, conv.GetMessages(), 0)
+
+		_, err = conv.Send(context.Background(), "first")
+		assert.Nil(t, err)
+		assert.Len(t, conv.GetMessages(), 2)
+	})
+
+	t.Run("returns an error if the request is invalid", func(t *testing.T) {
+		conv := perplexity.NewConversation(perplexity.NewClient(apiKey), perplexity.NewMessages(), perplexity.WithTemperature(3))
+		_, err := conv.Send(context.Background(), "first")
+		assert.NotNil(t, err)
+		assert.Len(t, conv.GetMessages(), 0)
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		ts := newConversationServer(t)
+		defer ts.Close()
+		conv := perplexity.NewConversation(newConversationClient(ts), perplexity.NewMessages())
+
+		var wg sync.WaitGroup
+		for i := range 5 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := conv.Send(context.Background(), fmt.Sprintf("question %d", i))
+				assert.Nil(t, err)
+			}()
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, 0, len(conv.GetMessages())%2)
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, conv.Turns(), 5)
+	})
+}
+
+func TestConversationSendStream(t *testing.T) {
+	t.Run("streams the answer and appends the turn", func(t *testing.T) {
+		ts := newConversationServer(t)
+		defer ts.Close()
+		conv := perplexity.NewConversation(newConversationClient(ts), perplexity.NewMessages())
+
+		ch := make(chan perplexity.CompletionResponse)
+		nbEvents := 0
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range ch {
+				nbEvents++
+			}
+		}()
+		res, err := conv.SendStream(context.Background(), "first", ch)
+		<-done
+		assert.Nil(t, err)
+		assert.Equal(t, 2, nbEvents)
+		assert.Equal(t, "answer 1 (1 messages)", res.GetLastContent())
+		assert.Equal(t, []perplexity.Turn{
+			{User: "first", Assistant: "answer 1 (1 messages)", Citations: []string{"https://stream.example"}},
+		}, conv.Turns())
+	})
+
+	t.Run("rolls back the user turn if the call fails", func(t *testing.T) {
+		ts := newConversationServer(t)
+		defer ts.Close()
+		conv := perplexity.NewConversation(newConversationClient(ts), perplexity.NewMessages())
+
+		_, err := conv.SendStream(context.Background(), "fail", nil)
+		assert.NotNil(t, err)
+		assert.Len(t, conv.GetMessages(), 0)
+	})
+}
+
+func TestConversationWithFake(t *testing.T) {
+	fake := perplexitytest.NewFake(
+		perplexitytest.Answer("Paris[1].", "https://en.wikipedia.org/wiki/Paris"),
+		perplexitytest.Error(http.StatusInternalServerError, "boom"),
+		perplexitytest.Stream([]string{"About ", "2 million."}),
+	)
+	conv := perplexity.NewConversation(fake, perplexity.NewMessages())
+
+	_, err := conv.Send(context.Background(), "Capital of France?")
+	assert.Nil(t, err)
+	_, err = conv.Send(context.Background(), "Population?")
+	assert.NotNil(t, err)
+	res, err := conv.SendStream(context.Background(), "Population?", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "About 2 million.", res.GetLastContent())
+	assert.Equal(t, []perplexity.Turn{
+		{User: "Capital of France?", Assistant: "Paris[1].", Citations: []string{"https://en.wikipedia.org/wiki/Paris"}},
+		{User: "Population?", Assistant: "About 2 million."},
+	}, conv.Turns())
+
+	calls := fake.Calls()
+	assert.Len(t, calls, 3)
+	assert.Len(t, calls[2].Request.Messages, 3)
+	assert.True(t, calls[2].Request.Stream)
+}
+
+func TestConversationSendMessage(t *testing.T) {
+	fake := perplexitytest.NewFake(perplexitytest.Answer("A cat."), perplexitytest.Stream([]string{"Black."}))
+	conv := perplexity.NewConversation(fake, perplexity.NewMessages())
+	image := perplexity.NewImageURLPart("https://example.com/cat.png")
+	question := perplexity.NewMultimodalMessage("user", perplexity.NewTextPart("What's on this picture?"), image)
+
+	_, err := conv.SendMessage(context.Background(), question)
+	assert.Nil(t, err)
+	assert.Equal(t, question, fake.Calls()[0].Request.Messages[0])
+	_, err = conv.SendMessageStream(context.Background(), perplexity.Message{Role: "user", Content: "Its color?"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, question, conv.GetMessages()[0])
+	assert.Len(t, conv.GetMessages(), 4)
+
+	_, err = conv.SendMessage(context.Background(), perplexity.Message{Role: "assistant", Content: "Hi"})
+	assert.NotNil(t, err)
+	assert.Len(t, fake.Calls(), 2)
+}
@@ -0,0 +1,35 @@
+package perplexity
+
+import (
+	"context"
+	"sync"
+)
+
+// Completer sends blocking completion requests. It is implemented by Client.
+type Completer interface {
+	SendCompletionRequestWithContext(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error)
+}
+
+// Streamer sends streamed completion requests. It is implemented by Client.
+// Like Client.SendSSEHTTPRequestWithContext, implementations write each event on
+// responseChannel, close it and call wg.Done when the request is done.
+type Streamer interface {
+	SendSSEHTTPRequestWithContext(ctx context.Context, wg *sync.WaitGroup, req *CompletionRequest, responseChannel chan<- CompletionResponse) error
+}
+
+// Searcher sends requests to the Search API. It is implemented by Client.
+type Searcher interface {
+	Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error)
+}
+
+// ChatClient sends blocking and streamed completion requests.
+// It is implemented by Client and accepted by Conversation.
+type ChatClient interface {
+	Completer
+	Streamer
+}
+
+var (
+	_ ChatClient = (*Client)(nil)
+	_ Searcher   = (*Client)(nil)
+)
@@ -0,0 +1,512 @@
+package perplexity
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsageEntry is the usage of a completion recorded in a UsageLedger.
+type UsageEntry struct {
+	Time    time.Time     `json:"time"`
+	Model   string        `json:"model"`
+	Usage   Usage         `json:"usage"`
+	Latency time.Duration `json:"-"`
+	// Stream is true for the streamed completions.
+	Stream bool `json:"stream,omitempty"`
+	// Cost is the cost of the completion in US dollars, 0 if its model has no price.
+	Cost float64 `json:"cost"`
+	// Labels are the labels of the context of the request (see ContextWithUsageLabels).
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// usageEntryJSON is the JSON encoding of a UsageEntry, with the latency in milliseconds.
+type usageEntryJSON struct {
+	usageEntry
+	LatencyMS int64 `json:"latency_ms"`
+}
+
+type usageEntry UsageEntry
+
+// MarshalJSON encodes the latency in milliseconds.
+func (e UsageEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(usageEntryJSON{usageEntry(e), e.Latency.Milliseconds()})
+}
+
+// UnmarshalJSON decodes the latency in milliseconds.
+func (e *UsageEntry) UnmarshalJSON(data []byte) error {
+	var v usageEntryJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = UsageEntry(v.usageEntry)
+	e.Latency = time.Duration(v.LatencyMS) * time.Millisecond
+	return nil
+}
+
+type usageLabelsKey struct{}
+
+// ContextWithUsageLabels returns a copy of ctx with labels recorded in the usage ledger with the
+// completions sent with it, e.g. the team or the feature to charge. They are added to the labels of ctx.
+func ContextWithUsageLabels(ctx context.Context, labels map[string]string) context.Context {
+	merged := maps.Clone(UsageLabelsFromContext(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+	maps.Copy(merged, labels)
+	return context.WithValue(ctx, usageLabelsKey{}, merged)
+}
+
+// UsageLabelsFromContext returns the usage labels of ctx, nil if there are none.
+func UsageLabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(usageLabelsKey{}).(map[string]string)
+	return labels
+}
+
+// LedgerStore persists the entries of a UsageLedger.
+type LedgerStore interface {
+	// Append saves an entry.
+	Append(entry UsageEntry) error
+	// Load returns the entries saved, the oldest first.
+	Load() ([]UsageEntry, error)
+}
+
+// FileLedgerStore saves the entries of a ledger in a JSONL file, one entry per line.
+// FileLedgerStore is safe for concurrent use.
+type FileLedgerStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileLedgerStore returns a store of the entries in the file path, created by the first Append.
+func NewFileLedgerStore(path string) *FileLedgerStore {
+	return &FileLedgerStore{path: path}
+}
+
+// Append implements LedgerStore.
+func (s *FileLedgerStore) Append(entry UsageEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode the usage entry: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write the usage entry: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write the usage entry: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write the usage entry: %w", err)
+	}
+	return nil
+}
+
+// Load implements LedgerStore.
+func (s *FileLedgerStore) Load() ([]UsageEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the usage entries: %w", err)
+	}
+	defer f.Close()
+	var entries []UsageEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e UsageEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid usage entry: %w", s.path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the usage entries: %w", err)
+	}
+	return entries, nil
+}
+
+// LedgerOption is a functional option for the UsageLedger.
+type LedgerOption func(*UsageLedger)
+
+// WithLedgerStore sets the store persisting the entries (none by default). The entries already
+// saved are loaded by NewUsageLedger.
+func WithLedgerStore(store LedgerStore) LedgerOption {
+	return func(l *UsageLedger) {
+		l.store = store
+	}
+}
+
+// WithLedgerPricing sets the prices of the models used for the costs of the entries (DefaultPricing by default).
+func WithLedgerPricing(pricing Pricing) LedgerOption {
+	return func(l *UsageLedger) {
+		l.pricing = pricing
+	}
+}
+
+// WithLedgerErrorHandler sets the function called with the errors of the store when the entries
+// are recorded by a Client, which can't return them.
+func WithLedgerErrorHandler(handler func(error)) LedgerOption {
+	return func(l *UsageLedger) {
+		l.onError = handler
+	}
+}
+
+// UsageLedger records the usage of the completions of the clients it is set on with
+// Client.SetUsageLedger, for chargeback: who consumed which tokens of which model, and when.
+// The entries are kept in memory and persisted by the optional store.
+// A UsageLedger can be shared by several clients and is safe for concurrent use.
+type UsageLedger struct {
+	store   LedgerStore
+	pricing Pricing
+	onError func(error)
+
+	mu      sync.RWMutex
+	entries []UsageEntry
+}
+
+// NewUsageLedger creates a usage ledger, with the entries of its store if it has one.
+func NewUsageLedger(opts ...LedgerOption) (*UsageLedger, error) {
+	l := &UsageLedger{
+		pricing: DefaultPricing(),
+		onError: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.store != nil {
+		entries, err := l.store.Load()
+		if err != nil {
+			return nil, err
+		}
+		l.entries = entries
+	}
+	return l, nil
+}
+
+// Record adds an entry to the ledger and saves it in the store. The cost of the entry is computed
+// if it is 0 and its model has a price.
+func (l *UsageLedger) Record(entry UsageEntry) error {
+	if entry.Cost == 0 {
+		if price, ok := l.pricing[entry.Model]; ok {
+			entry.Cost = price.cost(entry.Usage).Total()
+		}
+	}
+	entry.Labels = maps.Clone(entry.Labels)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.store != nil {
+		if err := l.store.Append(entry); err != nil {
+			return err
+		}
+	}
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// record records the completion of a client.
+func (l *UsageLedger) record(ctx context.Context, model string, resp *CompletionResponse, latency time.Duration, stream bool) {
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	err := l.Record(UsageEntry{
+		Time:    time.Now(),
+		Model:   model,
+		Usage:   resp.Usage,
+		Latency: latency,
+		Stream:  stream,
+		Labels:  UsageLabelsFromContext(ctx),
+	})
+	if err != nil {
+		l.onError(err)
+	}
+}
+
+// UsageFilter selects entries of a ledger. The zero fields select all the entries.
+type UsageFilter struct {
+	// From and To select the entries recorded in [From, To).
+	From, To time.Time
+	Model    string
+	// Labels select the entries with these labels.
+	Labels map[string]string
+}
+
+func (f *UsageFilter) match(e *UsageEntry) bool {
+	switch {
+	case !f.From.IsZero() && e.Time.Before(f.From),
+		!f.To.IsZero() && !e.Time.Before(f.To),
+		f.Model != "" && e.Model != f.Model:
+		return false
+	}
+	for k, v := range f.Labels {
+		if value, ok := e.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Entries returns the entries selected by filter, the oldest first.
+func (l *UsageLedger) Entries(filter UsageFilter) []UsageEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var entries []UsageEntry
+	for i := range l.entries {
+		if filter.match(&l.entries[i]) {
+			entries = append(entries, l.entries[i])
+		}
+	}
+	return entries
+}
+
+// Bucket is the period of the time buckets of an aggregation.
+type Bucket string
+
+// Time buckets. The days and the months are the ones of the location of the times of the entries.
+const (
+	BucketNone  Bucket = ""
+	BucketHour  Bucket = "hour"
+	BucketDay   Bucket = "day"
+	BucketMonth Bucket = "month"
+)
+
+// start returns the start of the bucket of t, the zero time for BucketNone.
+func (b Bucket) start(t time.Time) time.Time {
+	switch b {
+	case BucketHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case BucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// GroupByModel groups the entries of an aggregation by model.
+const GroupByModel = "model"
+
+// GroupByLabel groups the entries of an aggregation by the value of a label.
+func GroupByLabel(name string) string {
+	return "label:" + name
+}
+
+// UsageAggregate is the usage of a group of entries.
+type UsageAggregate struct {
+	// Bucket is the start of the time bucket, zero without buckets.
+	Bucket time.Time `json:"bucket,omitempty"`
+	// Group are the values of the dimensions of the group, by dimension (e.g. "model" or "label:team").
+	// The value of a missing label is empty.
+	Group            map[string]string `json:"group,omitempty"`
+	Requests         int               `json:"requests"`
+	PromptTokens     int               `json:"prompt_tokens"`
+	CompletionTokens int               `json:"completion_tokens"`
+	TotalTokens      int               `json:"total_tokens"`
+	Cost             float64           `json:"cost"`
+	// Latency is the total latency of the requests.
+	Latency time.Duration `json:"-"`
+}
+
+type usageAggregate UsageAggregate
+
+// MarshalJSON encodes the latencies in milliseconds.
+func (a UsageAggregate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		usageAggregate
+		LatencyMS     int64 `json:"latency_ms"`
+		MeanLatencyMS int64 `json:"mean_latency_ms"`
+	}{usageAggregate(a), a.Latency.Milliseconds(), a.MeanLatency().Milliseconds()})
+}
+
+// MeanLatency returns the mean latency of the requests.
+func (a *UsageAggregate) MeanLatency() time.Duration {
+	if a.Requests == 0 {
+		return 0
+	}
+	return a.Latency / time.Duration(a.Requests)
+}
+
+// Aggregate returns the usage of the entries selected by filter, by time bucket and by the values
+// of the dimensions groupBy (GroupByModel or GroupByLabel). The aggregates are sorted by bucket
+// and by the values of the dimensions.
+func (l *UsageLedger) Aggregate(filter UsageFilter, bucket Bucket, groupBy ...string) []UsageAggregate {
+	type key struct {
+		bucket time.Time
+		group  string // the values of the dimensions, separated by \x00
+	}
+	index := map[key]int{}
+	var aggregates []UsageAggregate
+	for _, e := range l.Entries(filter) {
+		values := make([]string, len(groupBy))
+		for i, dim := range groupBy {
+			if dim == GroupByModel {
+				values[i] = e.Model
+			} else if name, ok := strings.CutPrefix(dim, "label:"); ok {
+				values[i] = e.Labels[name]
+			}
+		}
+		k := key{bucket: bucket.start(e.Time), group: strings.Join(values, "\x00")}
+		i, ok := index[k]
+		if !ok {
+			i = len(aggregates)
+			index[k] = i
+			a := UsageAggregate{Bucket: k.bucket}
+			if len(groupBy) > 0 {
+				a.Group = make(map[string]string, len(groupBy))
+				for j, dim := range groupBy {
+					a.Group[dim] = values[j]
+				}
+			}
+			aggregates = append(aggregates, a)
+		}
+		a := &aggregates[i]
+		a.Requests++
+		a.PromptTokens += e.Usage.PromptTokens
+		a.CompletionTokens += e.Usage.CompletionTokens
+		a.TotalTokens += e.Usage.TotalTokens
+		a.Cost += e.Cost
+		a.Latency += e.Latency
+	}
+	sort.SliceStable(aggregates, func(i, j int) bool {
+		a, b := &aggregates[i], &aggregates[j]
+		if !a.Bucket.Equal(b.Bucket) {
+			return a.Bucket.Before(b.Bucket)
+		}
+		for _, dim := range groupBy {
+			if a.Group[dim] != b.Group[dim] {
+				return a.Group[dim] < b.Group[dim]
+			}
+		}
+		return false
+	})
+	return aggregates
+}
+
+// WriteJSON writes the entries selected by filter as a JSON array.
+func (l *UsageLedger) WriteJSON(w io.Writer, filter UsageFilter) error {
+	entries := l.Entries(filter)
+	if entries == nil {
+		entries = []UsageEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// WriteCSV writes the entries selected by filter in CSV, with a header. The labels are written
+// in a column per label name, e.g. "label:team", sorted by name.
+func (l *UsageLedger) WriteCSV(w io.Writer, filter UsageFilter) error {
+	entries := l.Entries(filter)
+	names := map[string]bool{}
+	for _, e := range entries {
+		for name := range e.Labels {
+			names[name] = true
+		}
+	}
+	labels := make([]string, 0, len(names))
+	for name := range names {
+		labels = append(labels, name)
+	}
+	sort.Strings(labels)
+
+	cw := csv.NewWriter(w)
+	header := []string{"time", "model", "prompt_tokens", "completion_tokens", "total_tokens",
+		"citation_tokens", "reasoning_tokens", "num_search_queries", "latency_ms", "stream", "cost"}
+	for _, name := range labels {
+		header = append(header, GroupByLabel(name))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{
+			e.Time.Format(time.RFC3339Nano),
+			e.Model,
+			strconv.Itoa(e.Usage.PromptTokens),
+			strconv.Itoa(e.Usage.CompletionTokens),
+			strconv.Itoa(e.Usage.TotalTokens),
+			strconv.Itoa(e.Usage.CitationTokens),
+			strconv.Itoa(e.Usage.ReasoningTokens),
+			strconv.Itoa(e.Usage.NumSearchQueries),
+			strconv.FormatInt(e.Latency.Milliseconds(), 10),
+			strconv.FormatBool(e.Stream),
+			strconv.FormatFloat(e.Cost, 'f', -1, 64),
+		}
+		for _, name := range labels {
+			record = append(record, e.Labels[name])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteAggregatesCSV writes aggregates in CSV, with a header. The dimensions of the groups are
+// written in a column each, sorted by name.
+func WriteAggregatesCSV(w io.Writer, aggregates []UsageAggregate) error {
+	names := map[string]bool{}
+	for _, a := range aggregates {
+		for dim := range a.Group {
+			names[dim] = true
+		}
+	}
+	dims := make([]string, 0, len(names))
+	for dim := range names {
+		dims = append(dims, dim)
+	}
+	sort.Strings(dims)
+
+	cw := csv.NewWriter(w)
+	header := append([]string{"bucket"}, dims...)
+	header = append(header, "requests", "prompt_tokens", "completion_tokens", "total_tokens", "cost", "mean_latency_ms")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, a := range aggregates {
+		bucket := ""
+		if !a.Bucket.IsZero() {
+			bucket = a.Bucket.Format(time.RFC3339)
+		}
+		record := []string{bucket}
+		for _, dim := range dims {
+			record = append(record, a.Group[dim])
+		}
+		record = append(record,
+			strconv.Itoa(a.Requests),
+			strconv.Itoa(a.PromptTokens),
+			strconv.Itoa(a.CompletionTokens),
+			strconv.Itoa(a.TotalTokens),
+			strconv.FormatFloat(a.Cost, 'f', -1, 64),
+			strconv.FormatInt(a.MeanLatency().Milliseconds(), 10),
+		)
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,207 @@
+package perplexity_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageLabels(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, perplexity.UsageLabelsFromContext(ctx))
+	ctx = perplexity.ContextWithUsageLabels(ctx, map[string]string{"team": "search", "feature": "qa"})
+	child := perplexity.ContextWithUsageLabels(ctx, map[string]string{"feature": "summaries"})
+	assert.Equal(t, map[string]string{"team": "search", "feature": "qa"}, perplexity.UsageLabelsFromContext(ctx))
+	assert.Equal(t, map[string]string{"team": "search", "feature": "summaries"}, perplexity.UsageLabelsFromContext(child))
+}
+
+func TestUsageLedgerClient(t *testing.T) {
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	ledger, err := perplexity.NewUsageLedger()
+	assert.Nil(t, err)
+	client := srv.Client()
+	client.SetUsageLedger(ledger)
+
+	ctx := perplexity.ContextWithUsageLabels(context.Background(), map[string]string{"team": "search"})
+	srv.Enqueue(answerWithUsage("sonar"))
+	_, err = client.SendCompletionRequestWithContext(ctx, newBudgetRequest())
+	assert.Nil(t, err)
+
+	srv.Enqueue(answerWithUsage("sonar-pro"))
+	var wg sync.WaitGroup
+	events := make(chan perplexity.CompletionResponse)
+	wg.Add(1)
+	go func() {
+		assert.Nil(t, client.SendSSEHTTPRequestWithContext(context.Background(), &wg,
+			newBudgetRequest(perplexity.WithStream(true)), events))
+	}()
+	for range events {
+	}
+	wg.Wait()
+
+	// The failed requests are not recorded.
+	srv.Enqueue(perplexitytest.Error(500, "boom"))
+	_, err = client.SendCompletionRequestWithContext(ctx, newBudgetRequest())
+	assert.NotNil(t, err)
+
+	entries := ledger.Entries(perplexity.UsageFilter{})
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "sonar", entries[0].Model)
+		assert.Equal(t, 1000, entries[0].Usage.PromptTokens)
+		assert.Equal(t, map[string]string{"team": "search"}, entries[0].Labels)
+		assert.InDelta(t, 0.007, entries[0].Cost, 1e-9)
+		assert.Greater(t, entries[0].Latency, time.Duration(0))
+		assert.False(t, entries[0].Stream)
+		assert.Equal(t, "sonar-pro", entries[1].Model)
+		assert.True(t, entries[1].Stream)
+		assert.Nil(t, entries[1].Labels)
+		assert.InDelta(t, 0.024, entries[1].Cost, 1e-9)
+	}
+}
+
+// newTestLedger returns a ledger with entries of two teams and two models over two days.
+func newTestLedger(t *testing.T, opts ...perplexity.LedgerOption) *perplexity.UsageLedger {
+	t.Helper()
+	ledger, err := perplexity.NewUsageLedger(opts...)
+	assert.Nil(t, err)
+	day := time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC)
+	entries := []perplexity.UsageEntry{
+		{Time: day.Add(9 * time.Hour), Model: "sonar", Labels: map[string]string{"team": "search"}},
+		{Time: day.Add(10 * time.Hour), Model: "sonar-pro", Labels: map[string]string{"team": "search"}},
+		{Time: day.Add(11 * time.Hour), Model: "sonar", Labels: map[string]string{"team": "ads"}},
+		{Time: day.Add(33 * time.Hour), Model: "sonar", Labels: map[string]string{"team": "search"}},
+		{Time: day.Add(34 * time.Hour), Model: "sonar", Cost: 1},
+	}
+	for _, e := range entries {
+		e.Usage = perplexity.Usage{PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000}
+		e.Latency = 100 * time.Millisecond
+		assert.Nil(t, ledger.Record(e))
+	}
+	return ledger
+}
+
+func TestUsageLedgerAggregate(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	all := ledger.Aggregate(perplexity.UsageFilter{}, perplexity.BucketNone)
+	if assert.Len(t, all, 1) {
+		assert.Equal(t, 5, all[0].Requests)
+		assert.Equal(t, 10000, all[0].TotalTokens)
+		assert.InDelta(t, 3*0.007+0.024+1, all[0].Cost, 1e-9)
+		assert.Equal(t, 100*time.Millisecond, all[0].MeanLatency())
+	}
+
+	byTeam := ledger.Aggregate(perplexity.UsageFilter{}, perplexity.BucketDay, perplexity.GroupByLabel("team"))
+	var got []string
+	for _, a := range byTeam {
+		got = append(got, fmt.Sprintf("%s %s %d", a.Bucket.Format(time.DateOnly), a.Group["label:team"], a.Requests))
+	}
+	assert.Equal(t, []string{"2025-01-15 ads 1", "2025-01-15 search 2", "2025-01-16  1", "2025-01-16 search 1"}, got)
+
+	byModel := ledger.Aggregate(perplexity.UsageFilter{Labels: map[string]string{"team": "search"}},
+		perplexity.BucketMonth, perplexity.GroupByModel)
+	if assert.Len(t, byModel, 2) {
+		assert.Equal(t, map[string]string{"model": "sonar"}, byModel[0].Group)
+		assert.Equal(t, 2, byModel[0].Requests)
+		assert.Equal(t, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), byModel[0].Bucket)
+		assert.Equal(t, "sonar-pro", byModel[1].Group["model"])
+		assert.InDelta(t, 0.024, byModel[1].Cost, 1e-9)
+	}
+
+	filter := perplexity.UsageFilter{
+		From:  time.Date(2025, time.January, 15, 10, 0, 0, 0, time.UTC),
+		To:    time.Date(2025, time.January, 16, 9, 0, 0, 0, time.UTC),
+		Model: "sonar",
+	}
+	entries := ledger.Entries(filter)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "ads", entries[0].Labels["team"])
+	}
+}
+
+func TestUsageLedgerExport(t *testing.T) {
+	ledger := newTestLedger(t)
+	filter := perplexity.UsageFilter{Model: "sonar-pro"}
+
+	var buf bytes.Buffer
+	assert.Nil(t, ledger.WriteCSV(&buf, filter))
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"time", "model", "prompt_tokens", "completion_tokens", "total_tokens", "citation_tokens",
+			"reasoning_tokens", "num_search_queries", "latency_ms", "stream", "cost", "label:team"},
+		{"2025-01-15T10:00:00Z", "sonar-pro", "1000", "1000", "2000", "0", "0", "0", "100", "false", "0.024", "search"},
+	}, records)
+
+	buf.Reset()
+	assert.Nil(t, ledger.WriteJSON(&buf, filter))
+	var decoded []map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	if assert.Len(t, decoded, 1) {
+		assert.Equal(t, 100.0, decoded[0]["latency_ms"])
+		assert.Equal(t, "sonar-pro", decoded[0]["model"])
+	}
+	buf.Reset()
+	assert.Nil(t, ledger.WriteJSON(&buf, perplexity.UsageFilter{Model: "none"}))
+	assert.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	aggregates := ledger.Aggregate(perplexity.UsageFilter{}, perplexity.BucketDay, perplexity.GroupByModel)
+	assert.Nil(t, perplexity.WriteAggregatesCSV(&buf, aggregates))
+	records, err = csv.NewReader(&buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bucket", "model", "requests", "prompt_tokens", "completion_tokens", "total_tokens",
+		"cost", "mean_latency_ms"}, records[0])
+	assert.Equal(t, []string{"2025-01-15T00:00:00Z", "sonar", "2", "2000", "2000", "4000", "0.014", "100"}, records[1])
+	assert.Len(t, records, 4)
+
+	data, err := json.Marshal(aggregates[0])
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"mean_latency_ms":100`)
+}
+
+func TestUsageLedgerStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	store := perplexity.NewFileLedgerStore(path)
+	ledger := newTestLedger(t, perplexity.WithLedgerStore(store))
+
+	reloaded, err := perplexity.NewUsageLedger(perplexity.WithLedgerStore(perplexity.NewFileLedgerStore(path)))
+	assert.Nil(t, err)
+	assert.Equal(t, ledger.Entries(perplexity.UsageFilter{}), reloaded.Entries(perplexity.UsageFilter{}))
+
+	errStore := errors.New("disk full")
+	var handled error
+	ledger, err = perplexity.NewUsageLedger(
+		perplexity.WithLedgerStore(failingStore{err: errStore}),
+		perplexity.WithLedgerErrorHandler(func(err error) { handled = err }),
+	)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(ledger.Record(perplexity.UsageEntry{Model: "sonar"}), errStore))
+	assert.Empty(t, ledger.Entries(perplexity.UsageFilter{}))
+
+	srv := perplexitytest.NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	client.SetUsageLedger(ledger)
+	_, err = client.SendCompletionRequest(newBudgetRequest())
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(handled, errStore))
+}
+
+// failingStore is a LedgerStore failing to save the entries.
+type failingStore struct{ err error }
+
+func (s failingStore) Append(perplexity.UsageEntry) error     { return s.err }
+func (s failingStore) Load() ([]perplexity.UsageEntry, error) { return nil, nil }
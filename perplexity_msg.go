@@ -1,17 +1,95 @@
 package perplexity
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptedMessages is returned when a serialized conversation can't be restored.
+var ErrCorruptedMessages = errors.New("corrupted messages")
+
+// messagesFormatVersion is the version of the serialization format of Messages.
+const messagesFormatVersion = 1
 
 // Message is a message object for the Perplexity API.
 type Message struct {
 	Role    string `json:"role" validate:"required,oneof=system user assistant"`
 	Content string `json:"content"`
+	// Parts: the content of a multimodal message (text and images).
+	// When set, the content is sent as an array of parts and Content only holds
+	// the concatenation of the text parts.
+	Parts []ContentPart `json:"-" validate:"dive"`
+}
+
+// MarshalJSON sends the content as a string for text-only messages
+// and as an array of parts for multimodal messages.
+func (m Message) MarshalJSON() ([]byte, error) {
+	content, err := m.marshalContent()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}{
+		Role:    m.Role,
+		Content: content,
+	})
+}
+
+// UnmarshalJSON accepts a content as a string or as an array of parts.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	m.Role = raw.Role
+	return m.unmarshalContent(raw.Content)
+}
+
+func (m *Message) marshalContent() (json.RawMessage, error) {
+	if len(m.Parts) > 0 {
+		return json.Marshal(m.Parts)
+	}
+	return json.Marshal(m.Content)
+}
+
+func (m *Message) unmarshalContent(data json.RawMessage) error {
+	m.Content = ""
+	m.Parts = nil
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	if data[0] == '"' {
+		return json.Unmarshal(data, &m.Content)
+	}
+	if err := json.Unmarshal(data, &m.Parts); err != nil {
+		return err
+	}
+	m.Content = textOfParts(m.Parts)
+	return nil
+}
+
+// MessageMetadata holds information attached to a message of a conversation.
+// It is not sent to the API.
+type MessageMetadata struct {
+	Model         string         `json:"model,omitempty"`
+	Citations     []string       `json:"citations,omitempty"`
+	SearchResults []SearchResult `json:"search_results,omitempty"`
+	Usage         *Usage         `json:"usage,omitempty"`
+	// CreatedAt is the unix timestamp of the message.
+	CreatedAt int64 `json:"created_at,omitempty"`
 }
 
 // Messages is an object that contains a list of messages for the Perplexity API.
 type Messages struct {
 	systemMessage string
-	messages      []Message // A list of messages comprising the conversation so far.
+	messages      []Message         // A list of messages comprising the conversation so far.
+	metadata      []MessageMetadata // The metadata of each message.
 }
 
 // NewMessages returns a new Messages object.
@@ -35,33 +113,51 @@ func WithSystemMessage(content string) MessagesOption {
 
 // AddUserMessage adds a user message to the Messages object.
 func (m *Messages) AddUserMessage(content string) error {
-	if len(m.messages) > 0 {
-		// Previous message should be an assistant message.
-		if m.messages[len(m.messages)-1].Role != "assistant" {
-			return fmt.Errorf("previous message should be an assistant message")
-		}
-	}
-	m.messages = append(m.messages, Message{
+	return m.add(Message{
 		Role:    "user",
 		Content: content,
 	})
-	return nil
+}
+
+// AddUserMessageWithImages adds a user message with images to the Messages object.
+// The images are parts created with NewImageURLPart, NewImagePartFromFile or NewImagePartFromReader.
+func (m *Messages) AddUserMessageWithImages(content string, images ...ContentPart) error {
+	return m.add(NewMultimodalMessage("user", append([]ContentPart{NewTextPart(content)}, images...)...))
 }
 
 // AddAgentMessage adds an assistant message to the Messages object.
 func (m *Messages) AddAgentMessage(content string) error {
-	if len(m.messages) == 0 {
-		// First message should be a user message.
-		return fmt.Errorf("first message should be a user message")
-	}
-	// Previous message should be a user message.
-	if m.messages[len(m.messages)-1].Role != "user" {
-		return fmt.Errorf("previous message should be a user message")
-	}
-	m.messages = append(m.messages, Message{
+	return m.add(Message{
 		Role:    "assistant",
 		Content: content,
 	})
+}
+
+// add adds a user or an assistant message, checking the alternation of the messages.
+func (m *Messages) add(msg Message) error {
+	switch msg.Role {
+	case "user":
+		if len(m.messages) > 0 {
+			// Previous message should be an assistant message.
+			if m.messages[len(m.messages)-1].Role != "assistant" {
+				return fmt.Errorf("previous message should be an assistant message")
+			}
+		}
+	case "assistant":
+		if len(m.messages) == 0 {
+			// First message should be a user message.
+			return fmt.Errorf("first message should be a user message")
+		}
+		// Previous message should be a user message.
+		if m.messages[len(m.messages)-1].Role != "user" {
+			return fmt.Errorf("previous message should be a user message")
+		}
+	default:
+		return fmt.Errorf("unexpected role %q", msg.Role)
+	}
+	m.syncMetadata()
+	m.messages = append(m.messages, msg)
+	m.metadata = append(m.metadata, MessageMetadata{})
 	return nil
 }
 
@@ -83,3 +179,125 @@ func (m *Messages) GetMessages() []Message {
 func (m *Messages) GetSystemMessage() string {
 	return m.systemMessage
 }
+
+// SetMetadata sets the metadata of the message at index i (the system message is not counted).
+func (m *Messages) SetMetadata(i int, metadata MessageMetadata) error {
+	if i < 0 || i >= len(m.messages) {
+		return fmt.Errorf("index %d out of range [0, %d)", i, len(m.messages))
+	}
+	m.syncMetadata()
+	m.metadata[i] = metadata
+	return nil
+}
+
+// GetMetadata returns the metadata of the message at index i (the system message is not counted).
+func (m *Messages) GetMetadata(i int) MessageMetadata {
+	if i < 0 || i >= len(m.metadata) {
+		return MessageMetadata{}
+	}
+	return m.metadata[i]
+}
+
+// Len returns the number of user and assistant messages.
+func (m *Messages) Len() int {
+	return len(m.messages)
+}
+
+// Truncate returns a copy of the first n user and assistant messages, with their images and metadata,
+// and system as system message. It is used to undo turns, or to change the system message.
+func (m *Messages) Truncate(n int, system string) (Messages, error) {
+	if n < 0 || n > len(m.messages) {
+		return Messages{}, fmt.Errorf("length %d out of range [0, %d]", n, len(m.messages))
+	}
+	c := m.clone()
+	c.systemMessage = system
+	c.messages = c.messages[:n]
+	c.metadata = c.metadata[:n]
+	return c, nil
+}
+
+// syncMetadata makes sure there is a metadata for each message.
+func (m *Messages) syncMetadata() {
+	for len(m.metadata) < len(m.messages) {
+		m.metadata = append(m.metadata, MessageMetadata{})
+	}
+}
+
+// clone returns a deep copy of the Messages object.
+func (m *Messages) clone() Messages {
+	c := Messages{
+		systemMessage: m.systemMessage,
+		messages:      append([]Message(nil), m.messages...),
+		metadata:      make([]MessageMetadata, len(m.messages)),
+	}
+	for i := range c.metadata {
+		c.metadata[i] = m.GetMetadata(i)
+		c.metadata[i].Citations = append([]string(nil), c.metadata[i].Citations...)
+		c.metadata[i].SearchResults = append([]SearchResult(nil), c.metadata[i].SearchResults...)
+	}
+	return c
+}
+
+// serializedMessage is a message and its metadata as serialized by Messages.MarshalJSON.
+type serializedMessage struct {
+	Role     string           `json:"role"`
+	Content  json.RawMessage  `json:"content"`
+	Metadata *MessageMetadata `json:"metadata,omitempty"`
+}
+
+// serializedMessages is the serialization format of Messages.
+type serializedMessages struct {
+	Version  int                 `json:"version"`
+	System   string              `json:"system,omitempty"`
+	Messages []serializedMessage `json:"messages"`
+}
+
+// MarshalJSON serializes the system message, the messages and their metadata.
+func (m Messages) MarshalJSON() ([]byte, error) {
+	s := serializedMessages{
+		Version:  messagesFormatVersion,
+		System:   m.systemMessage,
+		Messages: make([]serializedMessage, 0, len(m.messages)),
+	}
+	for i, msg := range m.messages {
+		content, err := msg.marshalContent()
+		if err != nil {
+			return nil, err
+		}
+		sm := serializedMessage{Role: msg.Role, Content: content}
+		if md := m.GetMetadata(i); md.Model != "" || len(md.Citations) > 0 || len(md.SearchResults) > 0 || md.Usage != nil || md.CreatedAt != 0 {
+			sm.Metadata = &md
+		}
+		s.Messages = append(s.Messages, sm)
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON restores a conversation serialized by MarshalJSON.
+// The messages must follow the same rules as AddUserMessage and AddAgentMessage,
+// otherwise an error wrapping ErrCorruptedMessages is returned.
+func (m *Messages) UnmarshalJSON(data []byte) error {
+	var s serializedMessages
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptedMessages, err)
+	}
+	if s.Version != messagesFormatVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrCorruptedMessages, s.Version)
+	}
+	restored := NewMessages(WithSystemMessage(s.System))
+	for i, sm := range s.Messages {
+		msg := Message{Role: sm.Role}
+		err := msg.unmarshalContent(sm.Content)
+		if err == nil {
+			err = restored.add(msg)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: message %d: %w", ErrCorruptedMessages, i, err)
+		}
+		if sm.Metadata != nil {
+			restored.metadata[i] = *sm.Metadata
+		}
+	}
+	*m = restored
+	return nil
+}
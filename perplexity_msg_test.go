@@ -1,6 +1,7 @@
 package perplexity_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/sgaunet/perplexity-go/v2"
@@ -67,3 +68,80 @@ func TestAddTwiceAgentMessage(t *testing.T) {
 		assert.NotNil(t, err)
 	})
 }
+
+func TestMessagesMetadata(t *testing.T) {
+	t.Run("sets and gets the metadata of a message", func(t *testing.T) {
+		m := perplexity.NewMessages()
+		assert.Nil(t, m.AddUserMessage("hello"))
+		assert.Nil(t, m.AddAgentMessage("hi"))
+		err := m.SetMetadata(1, perplexity.MessageMetadata{Model: "sonar", Citations: []string{"https://example.com"}})
+		assert.Nil(t, err)
+		assert.Equal(t, "sonar", m.GetMetadata(1).Model)
+		assert.Equal(t, perplexity.MessageMetadata{}, m.GetMetadata(0))
+		assert.Equal(t, perplexity.MessageMetadata{}, m.GetMetadata(5))
+	})
+	t.Run("returns an error if the index is out of range", func(t *testing.T) {
+		m := perplexity.NewMessages()
+		assert.NotNil(t, m.SetMetadata(0, perplexity.MessageMetadata{}))
+	})
+}
+
+func TestMessagesJSON(t *testing.T) {
+	t.Run("round-trips system message, messages and metadata", func(t *testing.T) {
+		m := perplexity.NewMessages(perplexity.WithSystemMessage("Be precise"))
+		assert.Nil(t, m.AddUserMessage("hello"))
+		assert.Nil(t, m.AddAgentMessage("hi"))
+		assert.Nil(t, m.SetMetadata(1, perplexity.MessageMetadata{
+			Model:     "sonar",
+			Citations: []string{"https://example.com"},
+			Usage:     &perplexity.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3},
+		}))
+		assert.Nil(t, m.AddUserMessage("bye"))
+
+		b, err := json.Marshal(m)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"version":1,"system":"Be precise","messages":[{"role":"user","content":"hello"},{"role":"assistant","content":"hi","metadata":{"model":"sonar","citations":["https://example.com"],"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}},{"role":"user","content":"bye"}]}`, string(b))
+
+		var restored perplexity.Messages
+		assert.Nil(t, json.Unmarshal(b, &restored))
+		assert.Equal(t, m.GetMessages(), restored.GetMessages())
+		assert.Equal(t, m.GetMetadata(1), restored.GetMetadata(1))
+		assert.NotNil(t, restored.AddUserMessage("again"))
+	})
+
+	f := func(testName string, data string) {
+		t.Helper()
+		var m perplexity.Messages
+		err := json.Unmarshal([]byte(data), &m)
+		if !assert.ErrorIs(t, err, perplexity.ErrCorruptedMessages) {
+			t.Logf("Test %s failed", testName)
+		}
+	}
+	f("returns error if not a conversation", `[]`)
+	f("returns error if the version is unknown", `{"version":2,"messages":[]}`)
+	f("returns error if the first message is not a user message", `{"version":1,"messages":[{"role":"assistant","content":"hi"}]}`)
+	f("returns error if two user messages follow each other", `{"version":1,"messages":[{"role":"user","content":"hello"},{"role":"user","content":"hello"}]}`)
+	f("returns error if the role is unknown", `{"version":1,"messages":[{"role":"system","content":"hello"}]}`)
+}
+
+func TestMessagesTruncate(t *testing.T) {
+	m := perplexity.NewMessages(perplexity.WithSystemMessage("Be brief"))
+	assert.Nil(t, m.AddUserMessageWithImages("What's on this picture?", perplexity.NewImageURLPart("https://example.com/cat.png")))
+	assert.Nil(t, m.AddAgentMessage("A cat."))
+	assert.Nil(t, m.SetMetadata(1, perplexity.MessageMetadata{Model: "sonar"}))
+	assert.Nil(t, m.AddUserMessage("And its color?"))
+	assert.Nil(t, m.AddAgentMessage("Black."))
+
+	h, err := m.Truncate(2, "Be precise")
+	assert.Nil(t, err)
+	assert.Equal(t, "Be precise", h.GetSystemMessage())
+	assert.Equal(t, 2, h.Len())
+	assert.Equal(t, m.GetMessages()[1:3], h.GetMessages()[1:])
+	assert.Equal(t, "sonar", h.GetMetadata(1).Model)
+	// the copy can grow without changing m
+	assert.Nil(t, h.AddUserMessage("And its name?"))
+	assert.Equal(t, "And its color?", m.GetMessages()[3].Content)
+
+	_, err = m.Truncate(5, "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,147 @@
+package perplexity
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// DefaultMaxImageSize is the maximum size of an image attached from a file.
+const DefaultMaxImageSize = 50 * 1024 * 1024
+
+// tokensPerImage is a rough estimate of the prompt tokens consumed by an image.
+const tokensPerImage = 1024
+
+var (
+	// ErrImageTooLarge is returned when an image exceeds the maximum size.
+	ErrImageTooLarge = errors.New("image is too large")
+	// ErrUnsupportedImageType is returned when the type of an image is not supported by the API.
+	ErrUnsupportedImageType = errors.New("unsupported image type")
+	// ErrImagesNotSupported is returned by Validate when a request sends images to a model that can't take them.
+	ErrImagesNotSupported = errors.New("model does not support images")
+)
+
+// SupportedImageTypes lists the MIME types of the images accepted by the API.
+var SupportedImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// ModelsSupportingImages lists the models accepting images in messages.
+// It can be completed or overridden by the user.
+var ModelsSupportingImages = map[string]bool{
+	"sonar":               true,
+	"sonar-pro":           true,
+	"sonar-reasoning":     true,
+	"sonar-reasoning-pro": true,
+}
+
+// ContentPart is a part of the content of a multimodal message.
+type ContentPart struct {
+	Type     string    `json:"type" validate:"required,oneof=text image_url"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty" validate:"required_if=Type image_url"`
+}
+
+// ImageURL is the image of a ContentPart: a URL or a base64 data URI.
+type ImageURL struct {
+	URL string `json:"url" validate:"required"`
+}
+
+// NewTextPart returns a text part.
+func NewTextPart(text string) ContentPart {
+	return ContentPart{
+		Type: "text",
+		Text: text,
+	}
+}
+
+// NewImageURLPart returns an image part for an image URL or a data URI (data:image/png;base64,...).
+func NewImageURLPart(url string) ContentPart {
+	return ContentPart{
+		Type:     "image_url",
+		ImageURL: &ImageURL{URL: url},
+	}
+}
+
+// NewImagePartFromFile returns an image part embedding the image file as a data URI.
+// The file must not exceed DefaultMaxImageSize.
+func NewImagePartFromFile(path string) (ContentPart, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer f.Close()
+	part, err := NewImagePartFromReader(f, DefaultMaxImageSize)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return part, nil
+}
+
+// NewImagePartFromReader returns an image part embedding the image read from r as a data URI.
+// The MIME type is detected from the content of the image, which must not exceed maxSize bytes.
+func NewImagePartFromReader(r io.Reader, maxSize int64) (ContentPart, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return ContentPart{}, fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, maxSize)
+	}
+	mimeType := http.DetectContentType(data)
+	if !isSupportedImageType(mimeType) {
+		return ContentPart{}, fmt.Errorf("%w: %s", ErrUnsupportedImageType, mimeType)
+	}
+	return NewImageURLPart("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func isSupportedImageType(mimeType string) bool {
+	for _, t := range SupportedImageTypes {
+		if t == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMultimodalMessage returns a message made of parts.
+func NewMultimodalMessage(role string, parts ...ContentPart) Message {
+	return Message{
+		Role:    role,
+		Content: textOfParts(parts),
+		Parts:   parts,
+	}
+}
+
+// HasImages returns true if the message contains images.
+func (m *Message) HasImages() bool {
+	for _, p := range m.Parts {
+		if p.Type == "image_url" {
+			return true
+		}
+	}
+	return false
+}
+
+// textOfParts returns the concatenation of the text parts.
+func textOfParts(parts []ContentPart) string {
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ValidateImages checks that the model of the request accepts images if messages contain images.
+func (r *CompletionRequest) ValidateImages() error {
+	for _, m := range r.Messages {
+		if m.HasImages() && !ModelsSupportingImages[r.Model] {
+			return fmt.Errorf("%w: %s", ErrImagesNotSupported, r.Model)
+		}
+	}
+	return nil
+}
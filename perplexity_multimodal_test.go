@@ -0,0 +1,103 @@
+package perplexity_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	return buf.Bytes()
+}
+
+func TestMessageJSON(t *testing.T) {
+	t.Run("text-only message is sent as a string", func(t *testing.T) {
+		b, err := json.Marshal(perplexity.Message{Role: "user", Content: "hello"})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"role":"user","content":"hello"}`, string(b))
+	})
+	t.Run("multimodal message is sent as an array of parts", func(t *testing.T) {
+		msg := perplexity.NewMultimodalMessage("user",
+			perplexity.NewTextPart("What's in this image?"),
+			perplexity.NewImageURLPart("https://example.com/cat.png"),
+		)
+		assert.Equal(t, "What's in this image?", msg.Content)
+		b, err := json.Marshal(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"role":"user","content":[{"type":"text","text":"What's in this image?"},{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}]}`, string(b))
+
+		var decoded perplexity.Message
+		assert.Nil(t, json.Unmarshal(b, &decoded))
+		assert.Equal(t, msg, decoded)
+		assert.True(t, decoded.HasImages())
+	})
+}
+
+func TestNewImagePart(t *testing.T) {
+	t.Run("embeds a file as a data URI", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "image.png")
+		assert.Nil(t, os.WriteFile(path, newPNG(t), 0o600))
+		part, err := perplexity.NewImagePartFromFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "image_url", part.Type)
+		assert.True(t, strings.HasPrefix(part.ImageURL.URL, "data:image/png;base64,"))
+	})
+	t.Run("rejects images too large", func(t *testing.T) {
+		_, err := perplexity.NewImagePartFromReader(bytes.NewReader(newPNG(t)), 10)
+		assert.ErrorIs(t, err, perplexity.ErrImageTooLarge)
+	})
+	t.Run("rejects files that are not images", func(t *testing.T) {
+		_, err := perplexity.NewImagePartFromReader(strings.NewReader("hello"), 100)
+		assert.ErrorIs(t, err, perplexity.ErrUnsupportedImageType)
+	})
+	t.Run("returns an error if the file does not exist", func(t *testing.T) {
+		_, err := perplexity.NewImagePartFromFile(filepath.Join(t.TempDir(), "missing.png"))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAddUserMessageWithImages(t *testing.T) {
+	m := perplexity.NewMessages()
+	err := m.AddUserMessageWithImages("What's in this image?", perplexity.NewImageURLPart("https://example.com/cat.png"))
+	assert.Nil(t, err)
+	msgs := m.GetMessages()
+	assert.Len(t, msgs, 1)
+	assert.True(t, msgs[0].HasImages())
+	assert.Greater(t, m.EstimateTokens(), 1000)
+
+	err = m.AddUserMessageWithImages("again")
+	assert.NotNil(t, err)
+
+	b, err := json.Marshal(m)
+	assert.Nil(t, err)
+	var restored perplexity.Messages
+	assert.Nil(t, json.Unmarshal(b, &restored))
+	assert.Equal(t, msgs, restored.GetMessages())
+}
+
+func TestValidateImages(t *testing.T) {
+	msgs := []perplexity.Message{
+		perplexity.NewMultimodalMessage("user", perplexity.NewTextPart("hello"), perplexity.NewImageURLPart("https://example.com/cat.png")),
+	}
+	req := perplexity.NewCompletionRequest(perplexity.WithMessages(msgs))
+	assert.Nil(t, req.Validate())
+
+	req = perplexity.NewCompletionRequest(perplexity.WithMessages(msgs), perplexity.WithModel("sonar-deep-research"))
+	assert.ErrorIs(t, req.Validate(), perplexity.ErrImagesNotSupported)
+
+	req = perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+		perplexity.NewMultimodalMessage("user", perplexity.ContentPart{Type: "image_url"}),
+	}))
+	assert.NotNil(t, req.Validate())
+}
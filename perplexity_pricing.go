@@ -0,0 +1,117 @@
+package perplexity
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoPrice is returned when the pricing has no price for the model of a request or a response.
+var ErrNoPrice = errors.New("no price for the model")
+
+// ModelPrice is the price of a model in US dollars.
+type ModelPrice struct {
+	// Input, Output, Reasoning and Citation are the prices of a million of prompt,
+	// completion, reasoning and citation tokens.
+	Input     float64 `json:"input" yaml:"input"`
+	Output    float64 `json:"output" yaml:"output"`
+	Reasoning float64 `json:"reasoning,omitempty" yaml:"reasoning,omitempty"`
+	Citation  float64 `json:"citation,omitempty" yaml:"citation,omitempty"`
+	// SearchQueries is the price of a thousand search queries.
+	SearchQueries float64 `json:"search_queries,omitempty" yaml:"search_queries,omitempty"`
+	// RequestFees are the fees of a thousand requests, by search context size (low, medium and high).
+	RequestFees map[string]float64 `json:"request_fees,omitempty" yaml:"request_fees,omitempty"`
+}
+
+// Pricing is a price table, by model. Use DefaultPricing and override the prices of your contract:
+//
+//	pricing := perplexity.DefaultPricing()
+//	pricing["sonar-pro"] = perplexity.ModelPrice{Input: 2.5, Output: 12}
+type Pricing map[string]ModelPrice
+
+// DefaultPricing returns the public prices of the models of the API
+// (https://docs.perplexity.ai/getting-started/pricing). They may change: check them for your account.
+func DefaultPricing() Pricing {
+	fees := func(low, medium, high float64) map[string]float64 {
+		return map[string]float64{SearchContextLow: low, SearchContextMedium: medium, SearchContextHigh: high}
+	}
+	return Pricing{
+		"sonar":               {Input: 1, Output: 1, RequestFees: fees(5, 8, 12)},
+		"sonar-pro":           {Input: 3, Output: 15, RequestFees: fees(6, 10, 14)},
+		"sonar-reasoning":     {Input: 1, Output: 5, RequestFees: fees(5, 8, 12)},
+		"sonar-reasoning-pro": {Input: 2, Output: 8, RequestFees: fees(6, 10, 14)},
+		"sonar-deep-research": {Input: 2, Output: 8, Reasoning: 3, Citation: 2, SearchQueries: 5},
+		"r1-1776":             {Input: 2, Output: 8},
+	}
+}
+
+// Cost is the cost of a completion in US dollars, by item.
+type Cost struct {
+	Input     float64 `json:"input"`
+	Output    float64 `json:"output"`
+	Reasoning float64 `json:"reasoning,omitempty"`
+	Citation  float64 `json:"citation,omitempty"`
+	Search    float64 `json:"search,omitempty"`
+	Request   float64 `json:"request,omitempty"`
+}
+
+// Total returns the total cost.
+func (c Cost) Total() float64 {
+	return c.Input + c.Output + c.Reasoning + c.Citation + c.Search + c.Request
+}
+
+// Cost returns the cost of a response from its model and its usage. The request fee is the one of
+// the search context size of the usage (low if the API did not report it).
+func (p Pricing) Cost(resp *CompletionResponse) (Cost, error) {
+	price, ok := p[resp.Model]
+	if !ok {
+		return Cost{}, fmt.Errorf("%w %q", ErrNoPrice, resp.Model)
+	}
+	return price.cost(resp.Usage), nil
+}
+
+// DefaultEstimatedCompletionTokens is the number of completion tokens estimated for a request
+// without MaxTokens. It is above the length of most answers, but a long answer can exceed it.
+const DefaultEstimatedCompletionTokens = 2048
+
+// EstimateCost returns the estimated cost of a request before it is sent: its prompt tokens are
+// estimated with EstimatePromptTokens and its completion tokens are MaxTokens,
+// or DefaultEstimatedCompletionTokens if MaxTokens is not set.
+func (p Pricing) EstimateCost(req *CompletionRequest) (Cost, error) {
+	price, ok := p[req.Model]
+	if !ok {
+		return Cost{}, fmt.Errorf("%w %q", ErrNoPrice, req.Model)
+	}
+	completionTokens := req.MaxTokens
+	if completionTokens == 0 {
+		completionTokens = DefaultEstimatedCompletionTokens
+	}
+	usage := Usage{
+		PromptTokens:      EstimatePromptTokens(req),
+		CompletionTokens:  completionTokens,
+		SearchContextSize: searchContextSize(req),
+	}
+	return price.cost(usage), nil
+}
+
+func (p ModelPrice) cost(u Usage) Cost {
+	size := u.SearchContextSize
+	if size == "" {
+		size = SearchContextLow
+	}
+	return Cost{
+		Input:     p.Input * float64(u.PromptTokens) / 1e6,
+		Output:    p.Output * float64(u.CompletionTokens) / 1e6,
+		Reasoning: p.Reasoning * float64(u.ReasoningTokens) / 1e6,
+		Citation:  p.Citation * float64(u.CitationTokens) / 1e6,
+		Search:    p.SearchQueries * float64(u.NumSearchQueries) / 1e3,
+		Request:   p.RequestFees[size] / 1e3,
+	}
+}
+
+// searchContextSize returns the search context size of a request, low by default.
+func searchContextSize(req *CompletionRequest) string {
+	if req.WebSearchOptions == nil || req.WebSearchOptions.SearchContextSize == "" {
+		return SearchContextLow
+	}
+	return req.WebSearchOptions.SearchContextSize
+}
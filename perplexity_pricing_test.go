@@ -0,0 +1,90 @@
+package perplexity_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPricingCost(t *testing.T) {
+	pricing := perplexity.DefaultPricing()
+	resp := &perplexity.CompletionResponse{
+		Model: "sonar-pro",
+		Usage: perplexity.Usage{PromptTokens: 1000, CompletionTokens: 2000, SearchContextSize: "high"},
+	}
+	cost, err := pricing.Cost(resp)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.003, cost.Input, 1e-9)
+	assert.InDelta(t, 0.03, cost.Output, 1e-9)
+	assert.InDelta(t, 0.014, cost.Request, 1e-9)
+	assert.InDelta(t, 0.047, cost.Total(), 1e-9)
+
+	// The request fee is the one of a low search context by default.
+	resp.Usage.SearchContextSize = ""
+	cost, err = pricing.Cost(resp)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.006, cost.Request, 1e-9)
+
+	resp = &perplexity.CompletionResponse{
+		Model: "sonar-deep-research",
+		Usage: perplexity.Usage{
+			PromptTokens: 1e6, CompletionTokens: 1e6, CitationTokens: 1e6, ReasoningTokens: 1e6, NumSearchQueries: 10,
+		},
+	}
+	cost, err = pricing.Cost(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, perplexity.Cost{Input: 2, Output: 8, Reasoning: 3, Citation: 2, Search: 0.05}, cost)
+
+	pricing["sonar-pro"] = perplexity.ModelPrice{Input: 1, Output: 2}
+	cost, err = pricing.Cost(&perplexity.CompletionResponse{Model: "sonar-pro", Usage: perplexity.Usage{PromptTokens: 1e6, CompletionTokens: 1e6}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, cost.Total())
+	assert.Equal(t, 15.0, perplexity.DefaultPricing()["sonar-pro"].Output)
+
+	_, err = pricing.Cost(&perplexity.CompletionResponse{Model: "unknown"})
+	assert.True(t, errors.Is(err, perplexity.ErrNoPrice))
+}
+
+func TestPricingEstimateCost(t *testing.T) {
+	req := perplexity.NewCompletionRequest(
+		perplexity.WithMessages([]perplexity.Message{{Role: "user", Content: "What's the capital of France?"}}),
+		perplexity.WithModel("sonar-pro"),
+		perplexity.WithMaxTokens(1000),
+		perplexity.WithSearchContextSize(perplexity.SearchContextMedium),
+	)
+	cost, err := perplexity.DefaultPricing().EstimateCost(req)
+	assert.Nil(t, err)
+	assert.InDelta(t, 3*float64(perplexity.EstimatePromptTokens(req))/1e6, cost.Input, 1e-9)
+	assert.InDelta(t, 0.015, cost.Output, 1e-9)
+	assert.InDelta(t, 0.010, cost.Request, 1e-9)
+
+	req.MaxTokens = 0
+	cost, err = perplexity.DefaultPricing().EstimateCost(req)
+	assert.Nil(t, err)
+	assert.InDelta(t, 15*float64(perplexity.DefaultEstimatedCompletionTokens)/1e6, cost.Output, 1e-9)
+
+	req.Model = "unknown"
+	_, err = perplexity.DefaultPricing().EstimateCost(req)
+	assert.True(t, errors.Is(err, perplexity.ErrNoPrice))
+}
+
+func TestWithSearchContextSize(t *testing.T) {
+	req := perplexity.NewCompletionRequest(
+		perplexity.WithMessages([]perplexity.Message{{Role: "user", Content: "Hello"}}),
+		perplexity.WithSearchContextSize(perplexity.SearchContextHigh),
+	)
+	assert.Nil(t, req.Validate())
+	b, err := json.Marshal(req)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"web_search_options":{"search_context_size":"high"}`)
+
+	req.WebSearchOptions.SearchContextSize = "huge"
+	assert.NotNil(t, req.Validate())
+
+	b, err = json.Marshal(perplexity.DefaultCompletionRequest())
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "web_search_options")
+}
@@ -0,0 +1,333 @@
+package perplexity
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Renderer formats the answer of a response and its citations.
+type Renderer interface {
+	// Render returns the formatted answer of the response.
+	Render(resp *CompletionResponse) string
+	// Stream returns a StreamRenderer writing the formatted answer of a stream to w.
+	Stream(w io.Writer) *StreamRenderer
+}
+
+// segmentRenderer is implemented by the renderers to format an answer incrementally.
+type segmentRenderer interface {
+	renderSegment(seg AnswerSegment, refs *references) string
+	renderReferences(refs *references) string
+}
+
+// Reference is a source cited in an answer.
+type Reference struct {
+	Number int
+	URL    string
+	Title  string
+	Date   string
+}
+
+// label returns the title of the reference or its URL if there is no title.
+func (r Reference) label() string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return r.URL
+}
+
+// references numbers the sources cited in an answer.
+// With dedup, a source cited with several numbers gets a single number,
+// given in order of first appearance. Otherwise the numbers of the answer are kept.
+type references struct {
+	dedup bool
+	list  []Reference
+	byKey map[string]int
+}
+
+func newReferences(dedup bool) *references {
+	return &references{
+		dedup: dedup,
+		byKey: make(map[string]int),
+	}
+}
+
+// add returns the reference of the source, adding it if it's not known yet.
+func (r *references) add(src CitationSource) Reference {
+	key := fmt.Sprintf("#%d", src.Index)
+	if r.dedup {
+		key = src.URL
+	}
+	if i, ok := r.byKey[key]; ok {
+		return r.list[i]
+	}
+	ref := Reference{
+		Number: src.Index,
+		URL:    src.URL,
+	}
+	if r.dedup {
+		ref.Number = len(r.list) + 1
+	}
+	if src.SearchResult != nil {
+		ref.Title = src.SearchResult.Title
+		ref.Date = src.SearchResult.Date
+	}
+	r.byKey[key] = len(r.list)
+	r.list = append(r.list, ref)
+	return ref
+}
+
+// sorted returns the references sorted by number.
+func (r *references) sorted() []Reference {
+	list := append([]Reference(nil), r.list...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Number < list[j].Number })
+	return list
+}
+
+// segmentReferences returns the references of the segment, without duplicates.
+func (r *references) segmentReferences(seg AnswerSegment) []Reference {
+	var refs []Reference
+	seen := make(map[int]bool)
+	for _, src := range seg.Sources {
+		ref := r.add(src)
+		if seen[ref.Number] {
+			continue
+		}
+		seen[ref.Number] = true
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+func render(r segmentRenderer, dedup bool, resp *CompletionResponse) string {
+	var sb strings.Builder
+	s := newStreamRenderer(&sb, r, dedup, resp.GetCitations(), resp.GetSearchResults())
+	// writing in a strings.Builder never fails
+	_ = s.write(s.parser.Write(resp.GetLastContent()))
+	_ = s.Close()
+	return sb.String()
+}
+
+// StreamRenderer formats an answer while it is streamed.
+// Segments are written as soon as they are complete, the references are written by Close.
+type StreamRenderer struct {
+	w      io.Writer
+	r      segmentRenderer
+	parser *CitationParser
+	refs   *references
+	err    error
+}
+
+func newStreamRenderer(w io.Writer, r segmentRenderer, dedup bool, citations []string, searchResults []SearchResult) *StreamRenderer {
+	return &StreamRenderer{
+		w:      w,
+		r:      r,
+		parser: NewCitationParser(citations, searchResults),
+		refs:   newReferences(dedup),
+	}
+}
+
+// WriteChunk formats the new content of an event of the stream.
+func (s *StreamRenderer) WriteChunk(resp CompletionResponse) error {
+	return s.write(s.parser.WriteChunk(resp))
+}
+
+// Close writes the end of the answer and the references.
+func (s *StreamRenderer) Close() error {
+	if err := s.write(s.parser.Flush()); err != nil {
+		return err
+	}
+	return s.writeString(s.r.renderReferences(s.refs))
+}
+
+func (s *StreamRenderer) write(segments []AnswerSegment) error {
+	for _, seg := range segments {
+		if err := s.writeString(s.r.renderSegment(seg, s.refs)); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func (s *StreamRenderer) writeString(str string) error {
+	if s.err != nil || str == "" {
+		return s.err
+	}
+	_, s.err = io.WriteString(s.w, str)
+	return s.err
+}
+
+// MarkdownRenderer formats an answer in Markdown.
+// Citations are rendered as footnotes ([^1]) followed by their definitions,
+// or as inline links if InlineLinks is set.
+type MarkdownRenderer struct {
+	InlineLinks bool
+	// Dedup numbers each source once, even if the answer cites it with several numbers.
+	Dedup bool
+}
+
+// Render returns the answer in Markdown.
+func (m MarkdownRenderer) Render(resp *CompletionResponse) string {
+	return render(m, m.Dedup, resp)
+}
+
+// Stream returns a StreamRenderer writing the answer in Markdown.
+func (m MarkdownRenderer) Stream(w io.Writer) *StreamRenderer {
+	return newStreamRenderer(w, m, m.Dedup, nil, nil)
+}
+
+func (m MarkdownRenderer) renderSegment(seg AnswerSegment, refs *references) string {
+	var sb strings.Builder
+	sb.WriteString(seg.Text)
+	for _, ref := range refs.segmentReferences(seg) {
+		if m.InlineLinks {
+			fmt.Fprintf(&sb, "[\\[%d\\]](%s)", ref.Number, markdownURL(ref.URL))
+		} else {
+			fmt.Fprintf(&sb, "[^%d]", ref.Number)
+		}
+	}
+	return sb.String()
+}
+
+func (m MarkdownRenderer) renderReferences(refs *references) string {
+	list := refs.sorted()
+	if len(list) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	if m.InlineLinks {
+		sb.WriteString("\n\n**References**\n\n")
+		for _, ref := range list {
+			fmt.Fprintf(&sb, "%d. [%s](%s)\n", ref.Number, markdownText(ref.label()), markdownURL(ref.URL))
+		}
+		return sb.String()
+	}
+	sb.WriteString("\n\n")
+	for _, ref := range list {
+		fmt.Fprintf(&sb, "[^%d]: [%s](%s)\n", ref.Number, markdownText(ref.label()), markdownURL(ref.URL))
+	}
+	return sb.String()
+}
+
+// markdownText escapes the characters of the text of a link.
+func markdownText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// markdownURL escapes the characters of the destination of a link.
+func markdownURL(s string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(s)
+}
+
+// HTMLRenderer formats an answer in HTML. The answer is escaped: no markup
+// of the answer is kept, line breaks are rendered with <br>.
+// Citations link to a list of references, or directly to the sources if InlineLinks is set.
+type HTMLRenderer struct {
+	InlineLinks bool
+	// Dedup numbers each source once, even if the answer cites it with several numbers.
+	Dedup bool
+}
+
+// Render returns the answer in HTML.
+func (h HTMLRenderer) Render(resp *CompletionResponse) string {
+	return render(h, h.Dedup, resp)
+}
+
+// Stream returns a StreamRenderer writing the answer in HTML.
+func (h HTMLRenderer) Stream(w io.Writer) *StreamRenderer {
+	return newStreamRenderer(w, h, h.Dedup, nil, nil)
+}
+
+func (h HTMLRenderer) renderSegment(seg AnswerSegment, refs *references) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ReplaceAll(html.EscapeString(seg.Text), "\n", "<br>\n"))
+	for _, ref := range refs.segmentReferences(seg) {
+		href := fmt.Sprintf("#ref-%d", ref.Number)
+		if h.InlineLinks {
+			href = safeURL(ref.URL)
+		}
+		if href == "" {
+			fmt.Fprintf(&sb, "<sup>[%d]</sup>", ref.Number)
+			continue
+		}
+		fmt.Fprintf(&sb, `<sup><a href="%s">[%d]</a></sup>`, html.EscapeString(href), ref.Number)
+	}
+	return sb.String()
+}
+
+func (h HTMLRenderer) renderReferences(refs *references) string {
+	list := refs.sorted()
+	if len(list) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n<ol class=\"references\">\n")
+	for _, ref := range list {
+		label := html.EscapeString(ref.label())
+		if href := safeURL(ref.URL); href != "" {
+			label = fmt.Sprintf(`<a href="%s" rel="noopener noreferrer">%s</a>`, html.EscapeString(href), label)
+		}
+		fmt.Fprintf(&sb, "<li id=\"ref-%d\" value=\"%d\">%s</li>\n", ref.Number, ref.Number, label)
+	}
+	sb.WriteString("</ol>\n")
+	return sb.String()
+}
+
+// safeURL returns the URL if it's an absolute http(s) URL, an empty string otherwise.
+func safeURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// TextRenderer formats an answer in plain text.
+// Citation markers are stripped, or kept as numbers referring to endnotes if Endnotes is set.
+type TextRenderer struct {
+	Endnotes bool
+	// Dedup numbers each source once, even if the answer cites it with several numbers.
+	Dedup bool
+}
+
+// Render returns the answer in plain text.
+func (t TextRenderer) Render(resp *CompletionResponse) string {
+	return render(t, t.Dedup, resp)
+}
+
+// Stream returns a StreamRenderer writing the answer in plain text.
+func (t TextRenderer) Stream(w io.Writer) *StreamRenderer {
+	return newStreamRenderer(w, t, t.Dedup, nil, nil)
+}
+
+func (t TextRenderer) renderSegment(seg AnswerSegment, refs *references) string {
+	if !t.Endnotes {
+		return seg.Text
+	}
+	var sb strings.Builder
+	sb.WriteString(seg.Text)
+	for _, ref := range refs.segmentReferences(seg) {
+		fmt.Fprintf(&sb, "[%d]", ref.Number)
+	}
+	return sb.String()
+}
+
+func (t TextRenderer) renderReferences(refs *references) string {
+	list := refs.sorted()
+	if !t.Endnotes || len(list) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nReferences:\n")
+	for _, ref := range list {
+		if ref.Title != "" {
+			fmt.Fprintf(&sb, "[%d] %s - %s\n", ref.Number, ref.Title, ref.URL)
+		} else {
+			fmt.Fprintf(&sb, "[%d] %s\n", ref.Number, ref.URL)
+		}
+	}
+	return sb.String()
+}
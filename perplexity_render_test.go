@@ -0,0 +1,87 @@
+package perplexity_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRenderedResponse() *perplexity.CompletionResponse {
+	return &perplexity.CompletionResponse{
+		Choices: []perplexity.Choice{
+			{Message: perplexity.Message{Role: "assistant", Content: "Paris <b>is</b> the capital[1][3].\nIt is big[2][9]."}},
+		},
+		Citations: &[]string{"https://a.example", "https://b.example", "https://a.example"},
+		SearchResults: []perplexity.SearchResult{
+			{Title: "Site [A]", URL: "https://a.example"},
+		},
+	}
+}
+
+func TestMarkdownRenderer(t *testing.T) {
+	t.Run("footnotes", func(t *testing.T) {
+		out := perplexity.MarkdownRenderer{}.Render(newRenderedResponse())
+		assert.Equal(t, "Paris <b>is</b> the capital[^1][^3].\nIt is big[^2].\n\n"+
+			"[^1]: [Site \\[A\\]](https://a.example)\n"+
+			"[^2]: [https://b.example](https://b.example)\n"+
+			"[^3]: [Site \\[A\\]](https://a.example)\n", out)
+	})
+	t.Run("inline links with dedup", func(t *testing.T) {
+		out := perplexity.MarkdownRenderer{InlineLinks: true, Dedup: true}.Render(newRenderedResponse())
+		assert.Equal(t, "Paris <b>is</b> the capital[\\[1\\]](https://a.example).\nIt is big[\\[2\\]](https://b.example).\n\n"+
+			"**References**\n\n"+
+			"1. [Site \\[A\\]](https://a.example)\n"+
+			"2. [https://b.example](https://b.example)\n", out)
+	})
+}
+
+func TestHTMLRenderer(t *testing.T) {
+	t.Run("escapes the answer and links to the references", func(t *testing.T) {
+		out := perplexity.HTMLRenderer{Dedup: true}.Render(newRenderedResponse())
+		assert.Equal(t, `Paris &lt;b&gt;is&lt;/b&gt; the capital<sup><a href="#ref-1">[1]</a></sup>.<br>
+It is big<sup><a href="#ref-2">[2]</a></sup>.
+<ol class="references">
+<li id="ref-1" value="1"><a href="https://a.example" rel="noopener noreferrer">Site [A]</a></li>
+<li id="ref-2" value="2"><a href="https://b.example" rel="noopener noreferrer">https://b.example</a></li>
+</ol>
+`, out)
+	})
+	t.Run("does not link to unsafe URLs", func(t *testing.T) {
+		resp := &perplexity.CompletionResponse{
+			Choices:   []perplexity.Choice{{Message: perplexity.Message{Content: "x[1]"}}},
+			Citations: &[]string{`javascript:alert("x")`},
+		}
+		out := perplexity.HTMLRenderer{InlineLinks: true}.Render(resp)
+		assert.NotContains(t, out, "href")
+		assert.Contains(t, out, "javascript:alert(&#34;x&#34;)")
+	})
+}
+
+func TestTextRenderer(t *testing.T) {
+	t.Run("strips markers", func(t *testing.T) {
+		out := perplexity.TextRenderer{}.Render(newRenderedResponse())
+		assert.Equal(t, "Paris <b>is</b> the capital.\nIt is big.", out)
+	})
+	t.Run("numbered endnotes", func(t *testing.T) {
+		out := perplexity.TextRenderer{Endnotes: true, Dedup: true}.Render(newRenderedResponse())
+		assert.Equal(t, "Paris <b>is</b> the capital[1].\nIt is big[2].\n\nReferences:\n[1] Site [A] - https://a.example\n[2] https://b.example\n", out)
+	})
+}
+
+func TestStreamRenderer(t *testing.T) {
+	var sb strings.Builder
+	var r perplexity.Renderer = perplexity.MarkdownRenderer{}
+	s := r.Stream(&sb)
+	for _, content := range []string{"Paris", "Paris is[", "Paris is[1] big", "Paris is[1] big."} {
+		err := s.WriteChunk(perplexity.CompletionResponse{
+			Choices:   []perplexity.Choice{{Message: perplexity.Message{Content: content}}},
+			Citations: &[]string{"https://a.example"},
+		})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, "Paris is[^1]", sb.String())
+	assert.Nil(t, s.Close())
+	assert.Equal(t, "Paris is[^1] big.\n\n[^1]: [https://a.example](https://a.example)\n", sb.String())
+}
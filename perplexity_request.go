@@ -75,6 +75,22 @@ type CompletionRequest struct {
 	// decreasing the model's likelihood to repeat the same line verbatim. A value of 1.0 means no penalty.
 	// Incompatible with presence_penalty
 	FrequencyPenalty float64 `json:"frequency_penalty" validate:"gt=0"`
+	// WebSearchOptions: the options of the web search, nil for the defaults of the API.
+	WebSearchOptions *WebSearchOptions `json:"web_search_options,omitempty"`
+}
+
+// Search context sizes: the amount of search context retrieved for a request.
+// Larger contexts give more comprehensive answers at a higher request fee.
+const (
+	SearchContextLow    = "low"
+	SearchContextMedium = "medium"
+	SearchContextHigh   = "high"
+)
+
+// WebSearchOptions are the options of the web search of a request.
+type WebSearchOptions struct {
+	// SearchContextSize: low (the default of the API), medium or high.
+	SearchContextSize string `json:"search_context_size,omitempty" validate:"omitempty,oneof=low medium high"`
 }
 
 // DefaultCompletionRequest returns a default completion request.
@@ -201,6 +217,16 @@ func WithFrequencyPenalty(frequencyPenalty float64) CompletionRequestOption {
 	}
 }
 
+// WithSearchContextSize sets the search context size option: SearchContextLow, SearchContextMedium or SearchContextHigh.
+func WithSearchContextSize(size string) CompletionRequestOption {
+	return func(r *CompletionRequest) {
+		if r.WebSearchOptions == nil {
+			r.WebSearchOptions = &WebSearchOptions{}
+		}
+		r.WebSearchOptions.SearchContextSize = size
+	}
+}
+
 // NewCompletionRequest creates a new completion request.
 func NewCompletionRequest(opts ...CompletionRequestOption) *CompletionRequest {
 	r := DefaultCompletionRequest()
@@ -223,6 +249,9 @@ func (r *CompletionRequest) Validate() error {
 	if err := r.ValidateSearchRecencyFilter(); err != nil {
 		return err
 	}
+	if err := r.ValidateImages(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -3,6 +3,7 @@ package perplexity
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 )
 
 // Usage is a usage object for the Perplexity API.
@@ -10,6 +11,13 @@ type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// CitationTokens, ReasoningTokens and NumSearchQueries are reported by the models that bill them
+	// (e.g. sonar-deep-research).
+	CitationTokens   int `json:"citation_tokens,omitempty"`
+	ReasoningTokens  int `json:"reasoning_tokens,omitempty"`
+	NumSearchQueries int `json:"num_search_queries,omitempty"`
+	// SearchContextSize is the search context size billed for the request.
+	SearchContextSize string `json:"search_context_size,omitempty"`
 }
 
 // Choice is a choice object for the Perplexity API.
@@ -29,6 +37,20 @@ type CompletionResponse struct {
 	Object    string    `json:"object"`
 	Choices   []Choice  `json:"choices"`
 	Citations *[]string `json:"citations,omitempty"`
+	// SearchResults: the search results used to generate the answer (title, URL and date of the sources).
+	SearchResults []SearchResult `json:"search_results,omitempty"`
+	// Images: the images returned if the request has ReturnImages set.
+	Images []Image `json:"images,omitempty"`
+	// RelatedQuestions: the questions returned if the request has ReturnRelatedQuestions set.
+	RelatedQuestions []string `json:"related_questions,omitempty"`
+}
+
+// Image is an image returned by the API.
+type Image struct {
+	ImageURL  string `json:"image_url"`
+	OriginURL string `json:"origin_url,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Width     int    `json:"width,omitempty"`
 }
 
 // String returns a string representation of the CompletionResponse.
@@ -61,3 +83,131 @@ func (r *CompletionResponse) GetCitations() []string {
 	}
 	return *r.Citations
 }
+
+// GetSearchResults returns the search results of the completion response.
+func (r *CompletionResponse) GetSearchResults() []SearchResult {
+	if r.SearchResults == nil {
+		return []SearchResult{}
+	}
+	return r.SearchResults
+}
+
+// metadata returns the metadata of the answer to keep in a conversation.
+func (r *CompletionResponse) metadata() MessageMetadata {
+	md := MessageMetadata{
+		Model:         r.Model,
+		Citations:     append([]string(nil), r.GetCitations()...),
+		SearchResults: append([]SearchResult(nil), r.SearchResults...),
+		CreatedAt:     int64(r.Created),
+	}
+	if r.Usage != (Usage{}) {
+		usage := r.Usage
+		md.Usage = &usage
+	}
+	return md
+}
+
+// StreamContent tracks the content of a stream to return the content added by each event.
+// Depending on the model, the events carry the cumulative content in Message
+// and/or the new tokens in Delta: both are supported.
+type StreamContent struct {
+	content string
+}
+
+// Delta returns the content added by an event of the stream.
+func (s *StreamContent) Delta(event CompletionResponse) string {
+	if len(event.Choices) == 0 {
+		return ""
+	}
+	c := event.Choices[0]
+	if added, ok := strings.CutPrefix(c.Message.Content, s.content); ok && c.Message.Content != "" {
+		s.content = c.Message.Content
+		return added
+	}
+	s.content += c.Delta.Content
+	return c.Delta.Content
+}
+
+// String returns the content received so far.
+func (s *StreamContent) String() string {
+	return s.content
+}
+
+// streamAccumulator rebuilds the complete response from the events of a stream.
+// Depending on the model, events carry the cumulative content in Message
+// and/or the new tokens in Delta: both are supported.
+type streamAccumulator struct {
+	resp    CompletionResponse
+	deltas  strings.Builder
+	message string
+	role    string
+	reason  string
+}
+
+// add merges an event of the stream.
+func (a *streamAccumulator) add(r CompletionResponse) {
+	if r.ID != "" {
+		a.resp.ID = r.ID
+	}
+	if r.Model != "" {
+		a.resp.Model = r.Model
+	}
+	if r.Created != 0 {
+		a.resp.Created = r.Created
+	}
+	if r.Object != "" {
+		a.resp.Object = r.Object
+	}
+	if r.Usage != (Usage{}) {
+		a.resp.Usage = r.Usage
+	}
+	if r.Citations != nil {
+		a.resp.Citations = r.Citations
+	}
+	if r.SearchResults != nil {
+		a.resp.SearchResults = r.SearchResults
+	}
+	if r.Images != nil {
+		a.resp.Images = r.Images
+	}
+	if r.RelatedQuestions != nil {
+		a.resp.RelatedQuestions = r.RelatedQuestions
+	}
+	for _, c := range r.Choices {
+		a.deltas.WriteString(c.Delta.Content)
+		if c.Message.Content != "" {
+			a.message = c.Message.Content
+		}
+		if c.Message.Role != "" {
+			a.role = c.Message.Role
+		} else if c.Delta.Role != "" {
+			a.role = c.Delta.Role
+		}
+		if c.FinishReason != "" {
+			a.reason = c.FinishReason
+		}
+	}
+}
+
+// response returns the complete response.
+func (a *streamAccumulator) response() *CompletionResponse {
+	r := a.resp
+	content := a.message
+	if content == "" {
+		content = a.deltas.String()
+	}
+	role := a.role
+	if role == "" {
+		role = "assistant"
+	}
+	r.Choices = []Choice{
+		{
+			FinishReason: a.reason,
+			Message: Message{
+				Role:    role,
+				Content: content,
+			},
+		},
+	}
+	return &r
+}
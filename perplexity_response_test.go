@@ -1,6 +1,7 @@
 package perplexity_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/sgaunet/perplexity-go/v2"
@@ -99,3 +100,50 @@ func TestGetCitations(t *testing.T) {
 		assert.Equal(t, content.GetCitations(), []string{"citation1", "citation2"})
 	})
 }
+
+func TestDecodeImagesAndRelatedQuestions(t *testing.T) {
+	data := `{"id":"1","model":"sonar","choices":[{"message":{"role":"assistant","content":"Paris"}}],
+		"images":[{"image_url":"https://example.com/paris.jpg","origin_url":"https://example.com/paris","height":600,"width":800}],
+		"related_questions":["What's the population of Paris?"]}`
+	var resp perplexity.CompletionResponse
+	assert.Nil(t, json.Unmarshal([]byte(data), &resp))
+	assert.Equal(t, []perplexity.Image{{
+		ImageURL:  "https://example.com/paris.jpg",
+		OriginURL: "https://example.com/paris",
+		Height:    600,
+		Width:     800,
+	}}, resp.Images)
+	assert.Equal(t, []string{"What's the population of Paris?"}, resp.RelatedQuestions)
+}
+
+func TestStreamContent(t *testing.T) {
+	event := func(message, delta string) perplexity.CompletionResponse {
+		return perplexity.CompletionResponse{
+			Choices: []perplexity.Choice{
+				{
+					Message: perplexity.Message{Role: "assistant", Content: message},
+					Delta:   perplexity.Message{Role: "assistant", Content: delta},
+				},
+			},
+		}
+	}
+	t.Run("events with cumulative messages and deltas", func(t *testing.T) {
+		var content perplexity.StreamContent
+		assert.Equal(t, "Paris ", content.Delta(event("Paris ", "Paris ")))
+		assert.Equal(t, "is", content.Delta(event("Paris is", "is")))
+		assert.Equal(t, "Paris is", content.String())
+	})
+	t.Run("events with cumulative messages only", func(t *testing.T) {
+		var content perplexity.StreamContent
+		assert.Equal(t, "Paris ", content.Delta(event("Paris ", "")))
+		assert.Equal(t, "is", content.Delta(event("Paris is", "")))
+		assert.Equal(t, "Paris is", content.String())
+	})
+	t.Run("events with deltas only", func(t *testing.T) {
+		var content perplexity.StreamContent
+		assert.Equal(t, "Paris ", content.Delta(event("", "Paris ")))
+		assert.Equal(t, "is", content.Delta(event("", "is")))
+		assert.Equal(t, "", content.Delta(perplexity.CompletionResponse{}))
+		assert.Equal(t, "Paris is", content.String())
+	})
+}
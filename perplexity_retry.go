@@ -0,0 +1,85 @@
+package perplexity
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryBackoff is the delay before the first retry of a request when SetRetries is given no backoff.
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+// SetRetries makes the client retry the requests answered with 429 Too Many Requests or a 5xx
+// status code, at most maxRetries times (0 by default: no retry). The first retry waits backoff
+// (DefaultRetryBackoff if 0), doubled at each retry, or the Retry-After of the response if longer.
+// The retries apply to the completions, the streams before their first event, and the searches.
+func (s *Client) SetRetries(maxRetries int, backoff time.Duration) {
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	s.maxRetries = maxRetries
+	s.retryBackoff = backoff
+}
+
+// do sends httpReq, created by newJSONRequest, and retries it as set by SetRetries.
+// The response of the last attempt is returned, whatever its status code.
+func (s *Client) do(httpReq *http.Request) (*http.Response, error) {
+	backoff := s.retryBackoff
+	for retry := 0; ; retry++ {
+		resp, err := s.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		if retry >= s.maxRetries || !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+		wait := max(backoff, retryAfter(resp.Header))
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxSizeErrorBody))
+		resp.Body.Close()
+		if err := sleep(httpReq.Context(), wait); err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		backoff *= 2
+		body, err := httpReq.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		httpReq = httpReq.Clone(httpReq.Context())
+		httpReq.Body = body
+	}
+}
+
+// retryable reports whether a request answered with statusCode may succeed if sent again.
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// retryAfter returns the delay asked by the Retry-After header, in seconds or as a date, 0 if none.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// sleep waits d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
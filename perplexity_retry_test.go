@@ -0,0 +1,90 @@
+package perplexity_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetries(t *testing.T) {
+	t.Run("completions are retried on rate limits and server errors", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1,
+			perplexitytest.OnRequests(perplexitytest.RateLimit(0), 1),
+			perplexitytest.OnRequests(perplexitytest.ServerError(http.StatusServiceUnavailable), 2),
+		)
+		srv := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer srv.Close()
+		client := srv.Client()
+		client.SetRetries(2, time.Millisecond)
+
+		srv.Enqueue(perplexitytest.Answer("Paris."))
+		resp, err := client.SendCompletionRequest(newBudgetRequest())
+		assert.Nil(t, err)
+		assert.Equal(t, "Paris.", resp.GetLastContent())
+	})
+
+	t.Run("the last error is returned", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1, perplexitytest.Always(perplexitytest.RateLimit(0)))
+		srv := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer srv.Close()
+		client := srv.Client()
+		client.SetRetries(1, time.Millisecond)
+
+		_, err := client.SendCompletionRequest(newBudgetRequest())
+		var apiErr *perplexity.APIError
+		if assert.True(t, errors.As(err, &apiErr)) {
+			assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+		}
+	})
+
+	t.Run("searches are retried, not the client errors", func(t *testing.T) {
+		var calls atomic.Int32
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch calls.Add(1) {
+			case 1:
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			case 2:
+				fmt.Fprint(w, `{"results": [{"title": "Go", "url": "https://go.dev"}]}`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer ts.Close()
+		client := perplexity.NewClient(apiKey)
+		client.SetHTTPClient(ts.Client())
+		client.SetSearchEndpoint(ts.URL)
+		client.SetRetries(2, time.Millisecond)
+
+		resp, err := client.Search(context.Background(), perplexity.NewSearchRequest("golang"))
+		assert.Nil(t, err)
+		assert.Len(t, resp.Results, 1)
+		assert.Equal(t, int32(2), calls.Load())
+
+		_, err = client.Search(context.Background(), perplexity.NewSearchRequest("golang"))
+		assert.NotNil(t, err)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("the wait is canceled with the context", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1, perplexitytest.Always(perplexitytest.RateLimit(time.Minute)))
+		srv := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer srv.Close()
+		client := srv.Client()
+		client.SetRetries(1, time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.SendCompletionRequestWithContext(ctx, newBudgetRequest())
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
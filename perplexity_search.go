@@ -0,0 +1,147 @@
+package perplexity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// DefaultSearchMaxResults is the number of results returned by the Search API when MaxResults is not set.
+	DefaultSearchMaxResults = 10
+	// MaxSearchResults is the maximum number of results per query accepted by the Search API.
+	MaxSearchResults = 20
+	// MaxSearchQueries is the maximum number of queries in a single search request.
+	MaxSearchQueries = 5
+)
+
+// SearchRequest is a request object for the Perplexity Search API.
+// https://docs.perplexity.ai/api-reference/search-post
+type SearchRequest struct {
+	// Query: the queries to search for. A single query is sent as a string,
+	// several queries are sent as a multi-query request, at most MaxSearchQueries
+	// (the max of the validate tag must be kept in sync with it).
+	Query []string `json:"query" validate:"required,min=1,max=5,dive,required"`
+	// MaxResults: the maximum number of results returned per query, between 1 and MaxSearchResults
+	// (the lte of the validate tag must be kept in sync with it).
+	// If left unspecified, the API returns DefaultSearchMaxResults results.
+	MaxResults int `json:"max_results,omitempty" validate:"gte=0,lte=20"`
+	// MaxTokensPerPage: the maximum number of tokens extracted from each page.
+	MaxTokensPerPage int `json:"max_tokens_per_page,omitempty" validate:"gte=0"`
+	// SearchDomainFilter: limit the results to the specified domains.
+	// For blacklisting add a - to the beginning of the domain string.
+	SearchDomainFilter []string `json:"search_domain_filter,omitempty" validate:"max=20"`
+	// SearchRecencyFilter: returns results within the specified time interval.
+	// Values include hour, day, week, month, year
+	SearchRecencyFilter string `json:"search_recency_filter,omitempty" validate:"omitempty,oneof=hour day week month year"`
+	// Country: ISO 3166-1 alpha-2 code of the country used to localize the results.
+	Country string `json:"country,omitempty" validate:"omitempty,iso3166_1_alpha2"`
+}
+
+// NewSearchRequest creates a new search request for one or several queries.
+func NewSearchRequest(query ...string) *SearchRequest {
+	return &SearchRequest{
+		Query: query,
+	}
+}
+
+// MarshalJSON sends a single query as a string and several queries as an array.
+func (r SearchRequest) MarshalJSON() ([]byte, error) {
+	type searchRequest SearchRequest
+	if len(r.Query) == 1 {
+		return json.Marshal(struct {
+			Query string `json:"query"`
+			searchRequest
+		}{
+			Query:         r.Query[0],
+			searchRequest: searchRequest(r),
+		})
+	}
+	return json.Marshal(searchRequest(r))
+}
+
+// Validate validates the search request.
+func (r *SearchRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// SearchResult is a result of the Perplexity Search API.
+// It is also used for the search results of a completion.
+type SearchResult struct {
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Snippet     string `json:"snippet,omitempty"`
+	Date        string `json:"date,omitempty"`
+	LastUpdated string `json:"last_updated,omitempty"`
+}
+
+// SearchResponse is a response object for the Perplexity Search API.
+type SearchResponse struct {
+	ID string `json:"id"`
+	// Results contains the results of all the queries.
+	Results []SearchResult `json:"results"`
+	// QueryResults contains the results grouped by query for multi-query requests.
+	QueryResults [][]SearchResult `json:"-"`
+}
+
+// UnmarshalJSON accepts the results of a single query and the results of a multi-query request.
+func (r *SearchResponse) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID      string          `json:"id"`
+		Results json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.ID = raw.ID
+	r.Results = nil
+	r.QueryResults = nil
+	if len(raw.Results) == 0 || string(raw.Results) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Results, &r.Results); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Results, &r.QueryResults); err != nil {
+		return fmt.Errorf("unexpected format of search results: %w", err)
+	}
+	r.Results = nil
+	for _, results := range r.QueryResults {
+		r.Results = append(r.Results, results...)
+	}
+	return nil
+}
+
+// Search sends a search request to the Perplexity Search API.
+func (s *Client) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	if req == nil {
+		return nil, fmt.Errorf("request must not be nil")
+	}
+	httpReq, err := s.newJSONRequest(ctx, s.searchEndpoint, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponseStatus(resp); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	r := &SearchResponse{}
+	err = json.Unmarshal(body, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body: %w - body response=%s", err, string(body))
+	}
+	return r, nil
+}
@@ -0,0 +1,127 @@
+package perplexity_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchRequestMarshalJSON(t *testing.T) {
+	t.Run("single query is sent as a string", func(t *testing.T) {
+		req := perplexity.NewSearchRequest("golang")
+		b, err := json.Marshal(req)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"query":"golang"}`, string(b))
+	})
+	t.Run("multiple queries are sent as an array", func(t *testing.T) {
+		req := perplexity.NewSearchRequest("golang", "rust")
+		req.MaxResults = 5
+		req.SearchRecencyFilter = "week"
+		req.Country = "FR"
+		b, err := json.Marshal(req)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"query":["golang","rust"],"max_results":5,"search_recency_filter":"week","country":"FR"}`, string(b))
+	})
+}
+
+func TestSearchRequestValidate(t *testing.T) {
+	f := func(testName string, expectedValid bool, req *perplexity.SearchRequest) {
+		t.Helper()
+		err := req.Validate()
+		isEqual := assert.Equal(t, expectedValid, err == nil)
+		if !isEqual {
+			t.Logf("Test %s failed", testName)
+		}
+	}
+
+	f("returns error if no query", false, perplexity.NewSearchRequest())
+	f("returns error if a query is empty", false, perplexity.NewSearchRequest("golang", ""))
+	f("returns error if more than 5 queries", false, perplexity.NewSearchRequest("1", "2", "3", "4", "5", "6"))
+	f("returns error if MaxResults gt 20", false, &perplexity.SearchRequest{Query: []string{"golang"}, MaxResults: 21})
+	f("returns error if recency filter is unknown", false, &perplexity.SearchRequest{Query: []string{"golang"}, SearchRecencyFilter: "decade"})
+	f("returns error if country is not an ISO code", false, &perplexity.SearchRequest{Query: []string{"golang"}, Country: "France"})
+	f("returns no error", true, &perplexity.SearchRequest{Query: []string{"golang"}, MaxResults: 20, SearchRecencyFilter: "year", Country: "FR", SearchDomainFilter: []string{"go.dev"}})
+}
+
+func TestSearch(t *testing.T) {
+	t.Run("send search request successfully", func(t *testing.T) {
+		ts := httptest.NewTLSServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.Method, "POST")
+				assert.Equal(t, r.Header.Get("Authorization"), "Bearer "+apiKey)
+				assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
+				b, err := io.ReadAll(r.Body)
+				assert.Nil(t, err)
+				assert.Equal(t, `{"query":"golang","max_results":2}`, string(b))
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprintln(w, `{"id":"1","results":[{"title":"Go","url":"https://go.dev","snippet":"The Go programming language","date":"2025-01-01"}]}`)
+			}))
+		defer ts.Close()
+
+		client := perplexity.NewClient(apiKey)
+		client.SetHTTPClient(ts.Client())
+		client.SetSearchEndpoint(ts.URL)
+
+		req := perplexity.NewSearchRequest("golang")
+		req.MaxResults = 2
+		res, err := client.Search(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Equal(t, "1", res.ID)
+		assert.Equal(t, []perplexity.SearchResult{
+			{Title: "Go", URL: "https://go.dev", Snippet: "The Go programming language", Date: "2025-01-01"},
+		}, res.Results)
+		assert.Nil(t, res.QueryResults)
+	})
+
+	t.Run("multi-query results are grouped by query", func(t *testing.T) {
+		ts := httptest.NewTLSServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"id":"2","results":[[{"title":"Go","url":"https://go.dev"}],[{"title":"Rust","url":"https://rust-lang.org"}]]}`)
+			}))
+		defer ts.Close()
+
+		client := perplexity.NewClient(apiKey)
+		client.SetHTTPClient(ts.Client())
+		client.SetSearchEndpoint(ts.URL)
+
+		res, err := client.Search(context.Background(), perplexity.NewSearchRequest("golang", "rust"))
+		assert.Nil(t, err)
+		assert.Len(t, res.QueryResults, 2)
+		assert.Len(t, res.Results, 2)
+		assert.Equal(t, "Rust", res.QueryResults[1][0].Title)
+	})
+
+	t.Run("returns an APIError on unauthorized", func(t *testing.T) {
+		ts := httptest.NewTLSServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+		defer ts.Close()
+
+		client := perplexity.NewClient(apiKey)
+		client.SetHTTPClient(ts.Client())
+		client.SetSearchEndpoint(ts.URL)
+
+		res, err := client.Search(context.Background(), perplexity.NewSearchRequest("golang"))
+		assert.Nil(t, res)
+		assert.True(t, errors.Is(err, perplexity.ErrUnauthorized))
+		var apiErr *perplexity.APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	})
+
+	t.Run("return error if request is nil", func(t *testing.T) {
+		client := perplexity.NewClient(apiKey)
+		res, err := client.Search(context.Background(), nil)
+		assert.NotNil(t, err)
+		assert.Nil(t, res)
+	})
+}
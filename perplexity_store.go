@@ -0,0 +1,200 @@
+package perplexity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrConversationNotFound is returned by a Store when there is no conversation with the given ID.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ErrInvalidConversationID is returned by a Store when the ID of a conversation is not valid.
+var ErrInvalidConversationID = errors.New("invalid conversation ID")
+
+// Store saves and loads conversations by ID.
+type Store interface {
+	// Save saves the conversation, replacing any conversation with the same ID.
+	Save(ctx context.Context, id string, m Messages) error
+	// Load returns the conversation with the given ID or ErrConversationNotFound.
+	Load(ctx context.Context, id string) (Messages, error)
+	// Delete deletes the conversation with the given ID. Deleting an unknown ID is not an error.
+	Delete(ctx context.Context, id string) error
+	// List returns the IDs of the saved conversations, sorted.
+	List(ctx context.Context) ([]string, error)
+}
+
+// validateConversationID checks that id can be safely used as a file name.
+func validateConversationID(id string) error {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) || strings.ContainsRune(id, 0) {
+		return fmt.Errorf("%w: %q", ErrInvalidConversationID, id)
+	}
+	return nil
+}
+
+// MemoryStore is a Store keeping the conversations in memory.
+// MemoryStore is safe for concurrent use.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	conversations map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		conversations: make(map[string][]byte),
+	}
+}
+
+// Save saves the conversation.
+func (s *MemoryStore) Save(_ context.Context, id string, m Messages) error {
+	if err := validateConversationID(id); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations[id] = data
+	return nil
+}
+
+// Load returns the conversation with the given ID.
+func (s *MemoryStore) Load(_ context.Context, id string) (Messages, error) {
+	s.mu.RLock()
+	data, ok := s.conversations[id]
+	s.mu.RUnlock()
+	if !ok {
+		return Messages{}, fmt.Errorf("%w: %q", ErrConversationNotFound, id)
+	}
+	var m Messages
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Messages{}, err
+	}
+	return m, nil
+}
+
+// Delete deletes the conversation with the given ID.
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversations, id)
+	return nil
+}
+
+// List returns the IDs of the saved conversations.
+func (s *MemoryStore) List(_ context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.conversations))
+	for id := range s.conversations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// FileStore is a Store saving each conversation in a JSON file of a directory.
+type FileStore struct {
+	dir string
+}
+
+// fileStoreExt is the extension of the files of a FileStore.
+const fileStoreExt = ".json"
+
+// NewFileStore returns a FileStore saving the conversations in dir.
+// The directory is created if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+fileStoreExt)
+}
+
+// Save saves the conversation. The file is replaced atomically.
+func (s *FileStore) Save(_ context.Context, id string, m Messages) error {
+	if err := validateConversationID(id); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save conversation %q: %w", id, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save conversation %q: %w", id, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save conversation %q: %w", id, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(id)); err != nil {
+		return fmt.Errorf("failed to save conversation %q: %w", id, err)
+	}
+	return nil
+}
+
+// Load returns the conversation with the given ID.
+func (s *FileStore) Load(_ context.Context, id string) (Messages, error) {
+	if err := validateConversationID(id); err != nil {
+		return Messages{}, err
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Messages{}, fmt.Errorf("%w: %q", ErrConversationNotFound, id)
+	}
+	if err != nil {
+		return Messages{}, fmt.Errorf("failed to load conversation %q: %w", id, err)
+	}
+	var m Messages
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Messages{}, fmt.Errorf("failed to load conversation %q: %w", id, err)
+	}
+	return m, nil
+}
+
+// Delete deletes the conversation with the given ID.
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	if err := validateConversationID(id); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete conversation %q: %w", id, err)
+	}
+	return nil
+}
+
+// List returns the IDs of the saved conversations.
+func (s *FileStore) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	ids := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fileStoreExt) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, fileStoreExt))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
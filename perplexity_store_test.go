@@ -0,0 +1,65 @@
+package perplexity_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store perplexity.Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	m := perplexity.NewMessages(perplexity.WithSystemMessage("Be precise"))
+	assert.Nil(t, m.AddUserMessage("hello"))
+	assert.Nil(t, m.AddAgentMessage("hi"))
+	assert.Nil(t, m.SetMetadata(1, perplexity.MessageMetadata{Citations: []string{"https://example.com"}}))
+
+	_, err := store.Load(ctx, "unknown")
+	assert.ErrorIs(t, err, perplexity.ErrConversationNotFound)
+	assert.ErrorIs(t, store.Save(ctx, "../escape", m), perplexity.ErrInvalidConversationID)
+
+	assert.Nil(t, store.Save(ctx, "b", m))
+	assert.Nil(t, store.Save(ctx, "a", perplexity.NewMessages()))
+	ids, err := store.List(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids)
+
+	restored, err := store.Load(ctx, "b")
+	assert.Nil(t, err)
+	assert.Equal(t, m.GetMessages(), restored.GetMessages())
+	assert.Equal(t, []string{"https://example.com"}, restored.GetMetadata(1).Citations)
+
+	assert.Nil(t, store.Delete(ctx, "b"))
+	assert.Nil(t, store.Delete(ctx, "b"))
+	ids, err = store.List(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, ids)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, perplexity.NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	t.Run("saves and loads conversations", func(t *testing.T) {
+		store, err := perplexity.NewFileStore(filepath.Join(t.TempDir(), "conversations"))
+		assert.Nil(t, err)
+		testStore(t, store)
+	})
+	t.Run("returns a clear error for a corrupted file", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := perplexity.NewFileStore(dir)
+		assert.Nil(t, err)
+		err = os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"version":1,"messages":[{"role":"assistant","content":"hi"}]}`), 0o600)
+		assert.Nil(t, err)
+		_, err = store.Load(context.Background(), "broken")
+		assert.ErrorIs(t, err, perplexity.ErrCorruptedMessages)
+		assert.Contains(t, err.Error(), `"broken"`)
+		assert.Contains(t, err.Error(), "message 0")
+	})
+}
@@ -1,10 +1,12 @@
 package perplexity_test
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -72,6 +74,33 @@ func TestGetCompletion(t *testing.T) {
 		assert.Equal(t, res, &perplexity.CompletionResponse{})
 	})
 
+	t.Run("returns an APIError if status code is not 200", func(t *testing.T) {
+		ts := httptest.NewTLSServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"error":"rate limited"}`)
+			}))
+		defer ts.Close()
+
+		r := perplexity.NewClient(apiKey)
+		r.SetHTTPClient(ts.Client())
+		r.SetEndpoint(ts.URL)
+
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+			{
+				Role:    "user",
+				Content: "What's the capital of France?",
+			},
+		}))
+		res, err := r.SendCompletionRequest(req)
+		assert.Nil(t, res)
+		var apiErr *perplexity.APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+		assert.Equal(t, `{"error":"rate limited"}`, apiErr.Body)
+		assert.False(t, errors.Is(err, perplexity.ErrUnauthorized))
+	})
+
 	t.Run("return error if no message to send to the API", func(t *testing.T) {
 		r := perplexity.NewClient(apiKey)
 		req := perplexity.NewCompletionRequest()
@@ -218,6 +247,83 @@ func TestSendSSEHTTPRequest(t *testing.T) {
 		}, fullResponse.Choices)
 	})
 
+	t.Run("Check that SendSSEHTTPRequest decodes split and large events", func(t *testing.T) {
+		large := strings.Repeat("a", 70000)
+		ts := httptest.NewTLSServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "data: {\"choices\":[{\"message\":")
+				w.(http.Flusher).Flush()
+				fmt.Fprint(w, "{\"content\":\"Paris\"}}]}\r\n\r\n: comment\r\n\r\n")
+				w.(http.Flusher).Flush()
+				fmt.Fprintf(w, "data: {\"choices\":[{\"message\":{\"content\":\"%s\"}}]}\n\n", large)
+			}))
+		defer ts.Close()
+
+		r := perplexity.NewClient(apiKey)
+		r.SetHTTPClient(ts.Client())
+		r.SetEndpoint(ts.URL)
+
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+			{
+				Role:    "user",
+				Content: "What's the capital of France?",
+			},
+		}), perplexity.WithStream(true))
+
+		var wg sync.WaitGroup
+		chResponses := make(chan perplexity.CompletionResponse)
+		errCh := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			errCh <- r.SendSSEHTTPRequest(&wg, req, chResponses)
+		}()
+		var contents []string
+		for msg := range chResponses {
+			contents = append(contents, msg.GetLastContent())
+		}
+		wg.Wait()
+		assert.Nil(t, <-errCh)
+		assert.Equal(t, []string{"Paris", large}, contents)
+	})
+
+	t.Run("Check that SendSSEHTTPRequest ignores malformed events", func(t *testing.T) {
+		ts := httptest.NewTLSServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "data: {\"choices\":[{\"message\":{\"content\":\"Par\"}}]}\r\n\r\n")
+				fmt.Fprint(w, "data: {\"choices\":[{\"mess\r\n\r\n")
+				fmt.Fprint(w, "data: {\"choices\":[{\"message\":{\"content\":\"Paris\"}}]}\r\n\r\n")
+			}))
+		defer ts.Close()
+
+		r := perplexity.NewClient(apiKey)
+		r.SetHTTPClient(ts.Client())
+		r.SetEndpoint(ts.URL)
+
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+			{
+				Role:    "user",
+				Content: "What's the capital of France?",
+			},
+		}), perplexity.WithStream(true))
+
+		var wg sync.WaitGroup
+		chResponses := make(chan perplexity.CompletionResponse)
+		errCh := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			errCh <- r.SendSSEHTTPRequest(&wg, req, chResponses)
+		}()
+		var contents []string
+		for msg := range chResponses {
+			contents = append(contents, msg.GetLastContent())
+		}
+		wg.Wait()
+		assert.Nil(t, <-errCh)
+		assert.Equal(t, []string{"Par", "Paris"}, contents)
+	})
+
 	t.Run("Check that SendSSEHTTPRequest don't accept nil request", func(t *testing.T) {
 		r := perplexity.NewClient(apiKey)
 		ch := make(chan perplexity.CompletionResponse, 5)
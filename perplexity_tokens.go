@@ -0,0 +1,198 @@
+package perplexity
+
+import (
+	"math"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// tokensPerMessage is the overhead of the chat template for each message:
+	// header start, role, header end and end of turn.
+	tokensPerMessage = 4
+	// tokensPerRequest is the overhead of a request: begin of text and
+	// the header priming the assistant reply.
+	tokensPerRequest = 4
+	// maxCalibrationSamples bounds the weight of past samples so that the
+	// calibration keeps adapting when the tokenizer of a model changes.
+	maxCalibrationSamples = 100
+)
+
+// TokenEstimator estimates the number of tokens consumed by a text or a list of messages.
+type TokenEstimator interface {
+	// EstimateTokens returns the estimated number of tokens of a text.
+	EstimateTokens(text string) int
+	// EstimateMessages returns the estimated number of prompt tokens of a list of messages,
+	// including the overhead of the chat template.
+	EstimateMessages(msgs []Message) int
+}
+
+// OfflineEstimator is a TokenEstimator that does not need any network access.
+// It approximates the BPE tokenizer used by the sonar models family
+// and can be calibrated with the usage returned by the API.
+// OfflineEstimator is safe for concurrent use.
+type OfflineEstimator struct {
+	mu      sync.RWMutex
+	ratio   float64
+	samples int
+}
+
+// DefaultTokenEstimator is the estimator used by EstimatePromptTokens and Messages.EstimateTokens.
+var DefaultTokenEstimator TokenEstimator = NewOfflineEstimator()
+
+// NewOfflineEstimator returns a new uncalibrated OfflineEstimator.
+func NewOfflineEstimator() *OfflineEstimator {
+	return &OfflineEstimator{
+		ratio: 1.0,
+	}
+}
+
+// EstimateTokens returns the estimated number of tokens of a text.
+func (e *OfflineEstimator) EstimateTokens(text string) int {
+	return e.apply(countTokens(text))
+}
+
+// EstimateMessages returns the estimated number of prompt tokens of a list of messages.
+func (e *OfflineEstimator) EstimateMessages(msgs []Message) int {
+	return e.apply(countMessagesTokens(msgs))
+}
+
+// Calibrate adjusts the estimator with the number of prompt tokens
+// reported by the API for the request.
+func (e *OfflineEstimator) Calibrate(req *CompletionRequest, usage Usage) {
+	if req == nil || usage.PromptTokens <= 0 {
+		return
+	}
+	estimated := countMessagesTokens(req.Messages)
+	if estimated == 0 {
+		return
+	}
+	observed := float64(usage.PromptTokens) / float64(estimated)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples < maxCalibrationSamples {
+		e.samples++
+	}
+	e.ratio += (observed - e.ratio) / float64(e.samples)
+}
+
+// Ratio returns the correction factor learned by calibration (1 if not calibrated).
+func (e *OfflineEstimator) Ratio() float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.ratio
+}
+
+func (e *OfflineEstimator) apply(tokens int) int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return int(math.Ceil(float64(tokens) * e.ratio))
+}
+
+// EstimatePromptTokens returns the estimated number of prompt tokens of the request
+// using DefaultTokenEstimator.
+func EstimatePromptTokens(req *CompletionRequest) int {
+	if req == nil {
+		return 0
+	}
+	return DefaultTokenEstimator.EstimateMessages(req.Messages)
+}
+
+// EstimateTokens returns the estimated number of prompt tokens of the conversation
+// (system message included) using DefaultTokenEstimator.
+func (m *Messages) EstimateTokens() int {
+	return DefaultTokenEstimator.EstimateMessages(m.GetMessages())
+}
+
+func countMessagesTokens(msgs []Message) int {
+	if len(msgs) == 0 {
+		return 0
+	}
+	n := tokensPerRequest
+	for _, msg := range msgs {
+		n += tokensPerMessage
+		if len(msg.Parts) == 0 {
+			n += countTokens(msg.Content)
+			continue
+		}
+		for _, p := range msg.Parts {
+			if p.Type == "image_url" {
+				n += tokensPerImage
+			} else {
+				n += countTokens(p.Text)
+			}
+		}
+	}
+	return n
+}
+
+// countTokens approximates a BPE tokenizer with a 128k vocabulary:
+//   - a word and its leading space are usually one token, long words are split in chunks of 4 letters,
+//   - numbers are split in groups of 3 digits,
+//   - each punctuation mark or symbol is a token,
+//   - a run of line breaks is a token,
+//   - ideographic characters (CJK) are one token each.
+func countTokens(text string) int {
+	n := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == '\n' || r == '\r':
+			j := i
+			for j < len(text) && (text[j] == '\n' || text[j] == '\r') {
+				j++
+			}
+			n++
+			i = j
+		case unicode.IsSpace(r):
+			i += size
+		case isIdeographic(r):
+			n++
+			i += size
+		case unicode.IsLetter(r):
+			letters := 0
+			j := i
+			for j < len(text) {
+				r2, s2 := utf8.DecodeRuneInString(text[j:])
+				if !unicode.IsLetter(r2) || isIdeographic(r2) {
+					break
+				}
+				letters++
+				j += s2
+			}
+			n += wordTokens(letters)
+			i = j
+		case unicode.IsDigit(r):
+			digits := 0
+			j := i
+			for j < len(text) {
+				r2, s2 := utf8.DecodeRuneInString(text[j:])
+				if !unicode.IsDigit(r2) {
+					break
+				}
+				digits++
+				j += s2
+			}
+			n += (digits + 2) / 3
+			i = j
+		default:
+			n++
+			i += size
+		}
+	}
+	return n
+}
+
+// wordTokens returns the number of tokens of a word of the given number of letters.
+func wordTokens(letters int) int {
+	const wholeWord = 6
+	if letters <= wholeWord {
+		return 1
+	}
+	return 1 + (letters-wholeWord+3)/4
+}
+
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
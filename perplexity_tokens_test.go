@@ -0,0 +1,92 @@
+package perplexity_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfflineEstimatorEstimateTokens(t *testing.T) {
+	f := func(text string, expected int) {
+		t.Helper()
+		e := perplexity.NewOfflineEstimator()
+		assert.Equal(t, expected, e.EstimateTokens(text), "text: %q", text)
+	}
+
+	f("", 0)
+	f("hello", 1)
+	f("hello world", 2)
+	f("What's the capital of France?", 9)
+	f("internationalization", 5)
+	f("1234567", 3)
+	f("line1\n\nline2", 5)
+	f("東京", 2)
+}
+
+func TestOfflineEstimatorEstimateMessages(t *testing.T) {
+	t.Run("empty list of messages", func(t *testing.T) {
+		e := perplexity.NewOfflineEstimator()
+		assert.Equal(t, 0, e.EstimateMessages(nil))
+	})
+	t.Run("adds the overhead of each message", func(t *testing.T) {
+		e := perplexity.NewOfflineEstimator()
+		msgs := []perplexity.Message{
+			{Role: "system", Content: "Be precise"},
+			{Role: "user", Content: "hello"},
+		}
+		// 4 (request) + 4+3 (system) + 4+1 (user)
+		assert.Equal(t, 16, e.EstimateMessages(msgs))
+	})
+}
+
+func TestOfflineEstimatorCalibrate(t *testing.T) {
+	t.Run("ratio converges to the observed usage", func(t *testing.T) {
+		e := perplexity.NewOfflineEstimator()
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+			{Role: "user", Content: "hello"},
+		}))
+		before := e.EstimateMessages(req.Messages)
+		for range 10 {
+			e.Calibrate(req, perplexity.Usage{PromptTokens: before * 2})
+		}
+		assert.InDelta(t, 2.0, e.Ratio(), 0.001)
+		assert.Equal(t, before*2, e.EstimateMessages(req.Messages))
+	})
+	t.Run("ignores empty usage and nil request", func(t *testing.T) {
+		e := perplexity.NewOfflineEstimator()
+		e.Calibrate(nil, perplexity.Usage{PromptTokens: 10})
+		e.Calibrate(perplexity.NewCompletionRequest(), perplexity.Usage{PromptTokens: 10})
+		e.Calibrate(perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{{Role: "user", Content: "hello"}})), perplexity.Usage{})
+		assert.Equal(t, 1.0, e.Ratio())
+	})
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		e := perplexity.NewOfflineEstimator()
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{{Role: "user", Content: "hello"}}))
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e.Calibrate(req, perplexity.Usage{PromptTokens: 18})
+				e.EstimateMessages(req.Messages)
+			}()
+		}
+		wg.Wait()
+		assert.InDelta(t, 2.0, e.Ratio(), 0.001)
+	})
+}
+
+func TestEstimatePromptTokens(t *testing.T) {
+	t.Run("nil request", func(t *testing.T) {
+		assert.Equal(t, 0, perplexity.EstimatePromptTokens(nil))
+	})
+	t.Run("same estimation for the request and the Messages object", func(t *testing.T) {
+		m := perplexity.NewMessages(perplexity.WithSystemMessage("Be precise"))
+		assert.Nil(t, m.AddUserMessage("What's the capital of France?"))
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages(m.GetMessages()))
+		assert.Equal(t, m.EstimateTokens(), perplexity.EstimatePromptTokens(req))
+		assert.Greater(t, m.EstimateTokens(), 0)
+	})
+}
@@ -0,0 +1,254 @@
+package perplexity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// NodeID identifies a message in a ConversationTree.
+type NodeID int
+
+// RootNode is the parent of the first messages of a ConversationTree.
+const RootNode NodeID = -1
+
+// ErrUnknownNode is returned when a NodeID does not exist in the ConversationTree.
+var ErrUnknownNode = errors.New("unknown node")
+
+type treeNode struct {
+	message  Message
+	metadata MessageMetadata
+	parent   NodeID
+	children []NodeID
+}
+
+// ConversationTree is a conversation where earlier messages can be edited or regenerated.
+// Each path from the root to a node is a branch, alternating user and assistant messages.
+// Messages are never deleted: editing a message or regenerating an answer creates a sibling
+// branch, and the active branch moves to it.
+// ConversationTree is not safe for concurrent use.
+type ConversationTree struct {
+	systemMessage string
+	nodes         []treeNode
+	roots         []NodeID
+	current       NodeID // last message of the active branch
+}
+
+// NewConversationTree returns a tree with a single branch made of the messages of m.
+func NewConversationTree(m Messages) *ConversationTree {
+	t := &ConversationTree{
+		systemMessage: m.systemMessage,
+		current:       RootNode,
+	}
+	for i, msg := range m.messages {
+		t.current = t.addNode(t.current, msg, m.GetMetadata(i))
+	}
+	return t
+}
+
+func (t *ConversationTree) addNode(parent NodeID, msg Message, metadata MessageMetadata) NodeID {
+	id := NodeID(len(t.nodes))
+	t.nodes = append(t.nodes, treeNode{
+		message:  msg,
+		metadata: metadata,
+		parent:   parent,
+	})
+	if parent == RootNode {
+		t.roots = append(t.roots, id)
+	} else {
+		t.nodes[parent].children = append(t.nodes[parent].children, id)
+	}
+	return id
+}
+
+func (t *ConversationTree) checkNode(id NodeID) error {
+	if id < 0 || int(id) >= len(t.nodes) {
+		return fmt.Errorf("%w: %d", ErrUnknownNode, id)
+	}
+	return nil
+}
+
+// path returns the nodes from the root to id.
+func (t *ConversationTree) path(id NodeID) []NodeID {
+	var p []NodeID
+	for ; id != RootNode; id = t.nodes[id].parent {
+		p = append(p, id)
+	}
+	for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
+// Current returns the last message of the active branch (RootNode if the tree is empty).
+func (t *ConversationTree) Current() NodeID {
+	return t.current
+}
+
+// Message returns the message and its metadata.
+func (t *ConversationTree) Message(id NodeID) (Message, MessageMetadata, error) {
+	if err := t.checkNode(id); err != nil {
+		return Message{}, MessageMetadata{}, err
+	}
+	return t.nodes[id].message, t.nodes[id].metadata, nil
+}
+
+// Siblings returns the alternatives of the message id (id included), in creation order.
+func (t *ConversationTree) Siblings(id NodeID) ([]NodeID, error) {
+	if err := t.checkNode(id); err != nil {
+		return nil, err
+	}
+	if parent := t.nodes[id].parent; parent != RootNode {
+		return append([]NodeID(nil), t.nodes[parent].children...), nil
+	}
+	return append([]NodeID(nil), t.roots...), nil
+}
+
+// Children returns the messages following id. Use RootNode to get the first messages.
+func (t *ConversationTree) Children(id NodeID) ([]NodeID, error) {
+	if id == RootNode {
+		return append([]NodeID(nil), t.roots...), nil
+	}
+	if err := t.checkNode(id); err != nil {
+		return nil, err
+	}
+	return append([]NodeID(nil), t.nodes[id].children...), nil
+}
+
+// Select makes the branch containing id active. The branch continues to the
+// most recent descendants of id.
+func (t *ConversationTree) Select(id NodeID) error {
+	if err := t.checkNode(id); err != nil {
+		return err
+	}
+	for len(t.nodes[id].children) > 0 {
+		children := t.nodes[id].children
+		id = children[len(children)-1]
+	}
+	t.current = id
+	return nil
+}
+
+// Branch returns the branch ending at id as a Messages object.
+func (t *ConversationTree) Branch(id NodeID) (Messages, error) {
+	m := NewMessages(WithSystemMessage(t.systemMessage))
+	if id == RootNode {
+		return m, nil
+	}
+	if err := t.checkNode(id); err != nil {
+		return Messages{}, err
+	}
+	for _, n := range t.path(id) {
+		if err := m.add(t.nodes[n].message); err != nil {
+			return Messages{}, fmt.Errorf("invalid branch at node %d: %w", n, err)
+		}
+		m.metadata[len(m.metadata)-1] = t.nodes[n].metadata
+	}
+	return m, nil
+}
+
+// Messages returns the active branch as a Messages object.
+func (t *ConversationTree) Messages() Messages {
+	// the active branch is valid by construction
+	m, _ := t.Branch(t.current)
+	return m
+}
+
+// Export returns the branch ending at id as a list of messages for WithMessages.
+func (t *ConversationTree) Export(id NodeID) ([]Message, error) {
+	m, err := t.Branch(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetMessages(), nil
+}
+
+// AddUserMessage adds a user message at the end of the active branch.
+func (t *ConversationTree) AddUserMessage(content string) (NodeID, error) {
+	if t.current != RootNode && t.nodes[t.current].message.Role != "assistant" {
+		return RootNode, fmt.Errorf("previous message should be an assistant message")
+	}
+	t.current = t.addNode(t.current, Message{Role: "user", Content: content}, MessageMetadata{})
+	return t.current, nil
+}
+
+// AddAgentMessage adds an assistant message at the end of the active branch.
+func (t *ConversationTree) AddAgentMessage(content string, metadata MessageMetadata) (NodeID, error) {
+	if t.current == RootNode {
+		return RootNode, fmt.Errorf("first message should be a user message")
+	}
+	if t.nodes[t.current].message.Role != "user" {
+		return RootNode, fmt.Errorf("previous message should be a user message")
+	}
+	t.current = t.addNode(t.current, Message{Role: "assistant", Content: content}, metadata)
+	return t.current, nil
+}
+
+// Fork makes the active branch end after its first n messages (system message excluded).
+// The next message added creates a new branch; the previous one is kept.
+func (t *ConversationTree) Fork(n int) error {
+	p := t.path(t.current)
+	if n < 0 || n > len(p) {
+		return fmt.Errorf("cannot fork at message %d: the branch has %d messages", n, len(p))
+	}
+	if n == 0 {
+		t.current = RootNode
+		return nil
+	}
+	t.current = p[n-1]
+	return nil
+}
+
+// EditUserMessage replaces the user message at index n of the active branch (system message excluded).
+// The new message is added as a sibling of the previous one and the messages following it
+// are discarded from the active branch (they are kept in the previous branch).
+func (t *ConversationTree) EditUserMessage(n int, content string) (NodeID, error) {
+	p := t.path(t.current)
+	if n < 0 || n >= len(p) {
+		return RootNode, fmt.Errorf("cannot edit message %d: the branch has %d messages", n, len(p))
+	}
+	if t.nodes[p[n]].message.Role != "user" {
+		return RootNode, fmt.Errorf("message %d is not a user message", n)
+	}
+	if err := t.Fork(n); err != nil {
+		return RootNode, err
+	}
+	return t.AddUserMessage(content)
+}
+
+// Regenerate asks a new answer to the last user message of the active branch.
+// The new answer is added as a sibling of the previous one, which is kept.
+// If the call fails, the active branch is not modified.
+func (t *ConversationTree) Regenerate(ctx context.Context, client Completer, opts ...CompletionRequestOption) (*CompletionResponse, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client must not be nil")
+	}
+	previous := t.current
+	if previous == RootNode {
+		return nil, fmt.Errorf("nothing to regenerate")
+	}
+	parent := previous
+	if t.nodes[previous].message.Role == "assistant" {
+		parent = t.nodes[previous].parent
+	}
+	msgs, err := t.Export(parent)
+	if err != nil {
+		return nil, err
+	}
+	req := NewCompletionRequest(append(slices.Clone(opts), WithMessages(msgs))...)
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	res, err := client.SendCompletionRequestWithContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	t.current = parent
+	metadata := res.metadata()
+	if _, err := t.AddAgentMessage(res.GetLastContent(), metadata); err != nil {
+		t.current = previous
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,138 @@
+package perplexity_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTree(t *testing.T) *perplexity.ConversationTree {
+	t.Helper()
+	m := perplexity.NewMessages(perplexity.WithSystemMessage("system"))
+	assert.Nil(t, m.AddUserMessage("question 0"))
+	assert.Nil(t, m.AddAgentMessage("answer 0"))
+	assert.Nil(t, m.AddUserMessage("question 1"))
+	assert.Nil(t, m.AddAgentMessage("answer 1"))
+	return perplexity.NewConversationTree(m)
+}
+
+func TestConversationTree(t *testing.T) {
+	t.Run("builds a single branch from Messages", func(t *testing.T) {
+		tree := newTree(t)
+		assert.Equal(t, perplexity.NodeID(3), tree.Current())
+		msgs, err := tree.Export(tree.Current())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"system", "question 0", "answer 0", "question 1", "answer 1"}, contents(msgs))
+	})
+
+	t.Run("enforces the alternation of messages", func(t *testing.T) {
+		tree := newTree(t)
+		_, err := tree.AddAgentMessage("answer", perplexity.MessageMetadata{})
+		assert.NotNil(t, err)
+		_, err = tree.AddUserMessage("question 2")
+		assert.Nil(t, err)
+		_, err = tree.AddUserMessage("question 3")
+		assert.NotNil(t, err)
+
+		empty := perplexity.NewConversationTree(perplexity.NewMessages())
+		_, err = empty.AddAgentMessage("answer", perplexity.MessageMetadata{})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("forks at a message and keeps the previous branch", func(t *testing.T) {
+		tree := newTree(t)
+		previous := tree.Current()
+		assert.Nil(t, tree.Fork(2))
+		id, err := tree.AddUserMessage("other question")
+		assert.Nil(t, err)
+
+		siblings, err := tree.Siblings(id)
+		assert.Nil(t, err)
+		assert.Equal(t, []perplexity.NodeID{2, id}, siblings)
+
+		m := tree.Messages()
+		assert.Equal(t, []string{"system", "question 0", "answer 0", "other question"}, contents(m.GetMessages()))
+		old, err := tree.Export(previous)
+		assert.Nil(t, err)
+		assert.Len(t, old, 5)
+
+		assert.NotNil(t, tree.Fork(10))
+	})
+
+	t.Run("edits a user message and discards the following messages", func(t *testing.T) {
+		tree := newTree(t)
+		id, err := tree.EditUserMessage(0, "edited question")
+		assert.Nil(t, err)
+		assert.Equal(t, id, tree.Current())
+		msgs, err := tree.Export(tree.Current())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"system", "edited question"}, contents(msgs))
+
+		siblings, err := tree.Siblings(id)
+		assert.Nil(t, err)
+		assert.Len(t, siblings, 2)
+
+		// select the original branch again
+		assert.Nil(t, tree.Select(siblings[0]))
+		assert.Equal(t, perplexity.NodeID(3), tree.Current())
+
+		_, err = tree.EditUserMessage(1, "not a user message")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("returns an error for unknown nodes", func(t *testing.T) {
+		tree := newTree(t)
+		_, err := tree.Siblings(42)
+		assert.ErrorIs(t, err, perplexity.ErrUnknownNode)
+		assert.ErrorIs(t, tree.Select(42), perplexity.ErrUnknownNode)
+		_, _, err = tree.Message(-5)
+		assert.ErrorIs(t, err, perplexity.ErrUnknownNode)
+	})
+}
+
+func TestConversationTreeRegenerate(t *testing.T) {
+	fail := false
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var req perplexity.CompletionRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "question 1", req.Messages[len(req.Messages)-1].Content)
+		fmt.Fprint(w, `{"model":"sonar","choices":[{"message":{"role":"assistant","content":"new answer 1"}}],"citations":["https://example.com"]}`)
+	}))
+	defer ts.Close()
+	client := perplexity.NewClient(apiKey)
+	client.SetHTTPClient(ts.Client())
+	client.SetEndpoint(ts.URL)
+
+	tree := newTree(t)
+	opts := make([]perplexity.CompletionRequestOption, 1, 2)
+	opts[0] = perplexity.WithModel("sonar")
+	res, err := tree.Regenerate(context.Background(), client, opts...)
+	assert.Nil(t, err)
+	assert.Equal(t, "new answer 1", res.GetLastContent())
+	// the options of the caller are left unchanged
+	assert.Nil(t, opts[:2][1])
+
+	siblings, err := tree.Siblings(tree.Current())
+	assert.Nil(t, err)
+	assert.Equal(t, []perplexity.NodeID{3, tree.Current()}, siblings)
+	msg, md, err := tree.Message(tree.Current())
+	assert.Nil(t, err)
+	assert.Equal(t, "new answer 1", msg.Content)
+	assert.Equal(t, []string{"https://example.com"}, md.Citations)
+
+	fail = true
+	current := tree.Current()
+	_, err = tree.Regenerate(context.Background(), client)
+	assert.NotNil(t, err)
+	assert.Equal(t, current, tree.Current())
+}
@@ -0,0 +1,227 @@
+package perplexity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrContextWindowExceeded is returned when the conversation can't be truncated enough
+// to fit in the context window of the model.
+var ErrContextWindowExceeded = errors.New("conversation exceeds the context window of the model")
+
+// DefaultContextWindow is the context window used for the models not listed in ModelContextWindows.
+const DefaultContextWindow = 127072
+
+// ModelContextWindows contains the context window (in tokens) of the known models.
+// It can be completed or overridden by the user.
+var ModelContextWindows = map[string]int{
+	"sonar":               127072,
+	"sonar-pro":           200000,
+	"sonar-reasoning":     127072,
+	"sonar-reasoning-pro": 127072,
+	"sonar-deep-research": 127072,
+	"r1-1776":             127072,
+}
+
+// ContextWindow returns the context window (in tokens) of the model.
+func ContextWindow(model string) int {
+	if n, ok := ModelContextWindows[model]; ok {
+		return n
+	}
+	return DefaultContextWindow
+}
+
+// TruncationStrategy removes user/assistant pairs from a conversation until it fits.
+// Implementations must keep the system message (they may extend it) and the latest user turn,
+// and must only remove complete pairs so that the alternation of messages is preserved.
+type TruncationStrategy interface {
+	Truncate(ctx context.Context, m Messages, fits func(Messages) bool) (Messages, error)
+}
+
+// TruncationPolicy applies a TruncationStrategy to the messages of a request
+// so that the estimated prompt tokens plus MaxTokens fit in the context window of the model.
+type TruncationPolicy struct {
+	// Strategy is the strategy used to truncate the conversation (DropOldest if nil).
+	Strategy TruncationStrategy
+	// Estimator estimates the prompt tokens (DefaultTokenEstimator if nil).
+	Estimator TokenEstimator
+	// ContextWindow overrides the context window of the model if greater than 0.
+	ContextWindow int
+}
+
+// Apply returns the messages of m truncated to fit in the context window of req.Model,
+// keeping room for req.MaxTokens completion tokens.
+func (p TruncationPolicy) Apply(ctx context.Context, m Messages, req *CompletionRequest) (Messages, error) {
+	if req == nil {
+		return Messages{}, fmt.Errorf("request must not be nil")
+	}
+	strategy := p.Strategy
+	if strategy == nil {
+		strategy = DropOldest{}
+	}
+	estimator := p.Estimator
+	if estimator == nil {
+		estimator = DefaultTokenEstimator
+	}
+	window := p.ContextWindow
+	if window <= 0 {
+		window = ContextWindow(req.Model)
+	}
+	fits := func(m Messages) bool {
+		return estimator.EstimateMessages(m.GetMessages())+req.MaxTokens <= window
+	}
+	if fits(m) {
+		return m.clone(), nil
+	}
+	truncated, err := strategy.Truncate(ctx, m.clone(), fits)
+	if err != nil {
+		return Messages{}, err
+	}
+	if !fits(truncated) {
+		return Messages{}, ErrContextWindowExceeded
+	}
+	return truncated, nil
+}
+
+// NewTruncatedCompletionRequest creates a completion request with the messages of m
+// truncated by policy. The options are applied before the truncation, so that the
+// model and MaxTokens of the request are taken into account.
+func NewTruncatedCompletionRequest(ctx context.Context, m Messages, policy TruncationPolicy, opts ...CompletionRequestOption) (*CompletionRequest, error) {
+	req := NewCompletionRequest(opts...)
+	truncated, err := policy.Apply(ctx, m, req)
+	if err != nil {
+		return nil, err
+	}
+	req.Messages = truncated.GetMessages()
+	return req, nil
+}
+
+// nbPairs returns the number of user/assistant pairs before the latest user turn.
+func (m *Messages) nbPairs() int {
+	last := len(m.messages) - 1
+	for last >= 0 && m.messages[last].Role != "user" {
+		last--
+	}
+	if last < 0 {
+		return len(m.messages) / 2
+	}
+	return last / 2
+}
+
+// withoutPairs returns a copy of m without the pairs for which drop returns true.
+func (m *Messages) withoutPairs(drop func(pair int) bool) Messages {
+	c := Messages{systemMessage: m.systemMessage}
+	pairs := m.nbPairs()
+	for i := range m.messages {
+		if i/2 < pairs && drop(i/2) {
+			continue
+		}
+		c.messages = append(c.messages, m.messages[i])
+		c.metadata = append(c.metadata, m.GetMetadata(i))
+	}
+	return c
+}
+
+// DropOldest is a TruncationStrategy removing the oldest user/assistant pairs first.
+type DropOldest struct{}
+
+// Truncate removes the oldest pairs until the conversation fits.
+func (DropOldest) Truncate(_ context.Context, m Messages, fits func(Messages) bool) (Messages, error) {
+	for n := 1; n <= m.nbPairs(); n++ {
+		truncated := m.withoutPairs(func(pair int) bool { return pair < n })
+		if fits(truncated) {
+			return truncated, nil
+		}
+	}
+	return Messages{}, ErrContextWindowExceeded
+}
+
+// KeepFirstLast is a TruncationStrategy keeping the First pairs (usually giving the context
+// of the conversation) and the Last pairs, removing the pairs in between, oldest first.
+type KeepFirstLast struct {
+	First int
+	Last  int
+}
+
+// Truncate removes the pairs between the First and the Last pairs until the conversation fits.
+func (s KeepFirstLast) Truncate(_ context.Context, m Messages, fits func(Messages) bool) (Messages, error) {
+	pairs := m.nbPairs()
+	removable := pairs - s.First - s.Last
+	for n := 1; n <= removable; n++ {
+		truncated := m.withoutPairs(func(pair int) bool { return pair >= s.First && pair < s.First+n })
+		if fits(truncated) {
+			return truncated, nil
+		}
+	}
+	return Messages{}, ErrContextWindowExceeded
+}
+
+// DefaultSummaryMaxTokens is the default maximum number of tokens of a summary.
+const DefaultSummaryMaxTokens = 512
+
+// SummarizeOldest is a TruncationStrategy replacing the oldest user/assistant pairs
+// by a summary generated by a model. The summary is appended to the system message
+// so that the alternation of messages is preserved.
+type SummarizeOldest struct {
+	// Client is used to generate the summary.
+	Client Completer
+	// Model is the model generating the summary (DefaultModel if empty).
+	Model string
+	// MaxTokens is the maximum number of tokens of the summary (DefaultSummaryMaxTokens if 0).
+	MaxTokens int
+}
+
+// Truncate summarizes the oldest pairs until the conversation fits.
+func (s SummarizeOldest) Truncate(ctx context.Context, m Messages, fits func(Messages) bool) (Messages, error) {
+	if s.Client == nil {
+		return Messages{}, fmt.Errorf("client must not be nil")
+	}
+	maxTokens := s.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultSummaryMaxTokens
+	}
+	model := s.Model
+	if model == "" {
+		model = DefaultModel
+	}
+	// Find how many pairs must be removed, keeping room for the summary.
+	reserve := strings.Repeat("x ", maxTokens)
+	n := 1
+	for ; n <= m.nbPairs(); n++ {
+		truncated := m.withoutPairs(func(pair int) bool { return pair < n })
+		truncated.systemMessage += reserve
+		if fits(truncated) {
+			break
+		}
+	}
+	if n > m.nbPairs() {
+		return Messages{}, ErrContextWindowExceeded
+	}
+
+	var transcript strings.Builder
+	for _, msg := range m.messages[:2*n] {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+	}
+	req := NewCompletionRequest(
+		WithModel(model),
+		WithMaxTokens(maxTokens),
+		WithMessages([]Message{
+			{Role: "system", Content: "Summarize the following conversation in a few sentences. Keep the facts, names and numbers needed to continue the conversation."},
+			{Role: "user", Content: transcript.String()},
+		}),
+	)
+	res, err := s.Client.SendCompletionRequestWithContext(ctx, req)
+	if err != nil {
+		return Messages{}, fmt.Errorf("failed to summarize the conversation: %w", err)
+	}
+
+	truncated := m.withoutPairs(func(pair int) bool { return pair < n })
+	summary := "Summary of the beginning of the conversation:\n" + res.GetLastContent()
+	if truncated.systemMessage != "" {
+		truncated.systemMessage += "\n\n"
+	}
+	truncated.systemMessage += summary
+	return truncated, nil
+}
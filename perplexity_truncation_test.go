@@ -0,0 +1,131 @@
+package perplexity_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// messageCounter is a TokenEstimator counting 10 tokens per message.
+type messageCounter struct{}
+
+func (messageCounter) EstimateTokens(string) int                      { return 10 }
+func (messageCounter) EstimateMessages(msgs []perplexity.Message) int { return 10 * len(msgs) }
+
+// newLongConversation returns a conversation with a system message, n pairs and a pending user message.
+func newLongConversation(t *testing.T, n int) perplexity.Messages {
+	t.Helper()
+	m := perplexity.NewMessages(perplexity.WithSystemMessage("system"))
+	for i := range n {
+		assert.Nil(t, m.AddUserMessage(fmt.Sprintf("question %d", i)))
+		assert.Nil(t, m.AddAgentMessage(fmt.Sprintf("answer %d", i)))
+	}
+	assert.Nil(t, m.AddUserMessage("last question"))
+	return m
+}
+
+func contents(msgs []perplexity.Message) []string {
+	var res []string
+	for _, m := range msgs {
+		res = append(res, m.Content)
+	}
+	return res
+}
+
+func TestContextWindow(t *testing.T) {
+	assert.Equal(t, 200000, perplexity.ContextWindow("sonar-pro"))
+	assert.Equal(t, perplexity.DefaultContextWindow, perplexity.ContextWindow("unknown"))
+}
+
+func TestTruncationPolicy(t *testing.T) {
+	req := perplexity.NewCompletionRequest(perplexity.WithMaxTokens(10))
+
+	t.Run("does nothing if the conversation fits", func(t *testing.T) {
+		m := newLongConversation(t, 2)
+		policy := perplexity.TruncationPolicy{Estimator: messageCounter{}, ContextWindow: 1000}
+		truncated, err := policy.Apply(context.Background(), m, req)
+		assert.Nil(t, err)
+		assert.Equal(t, m.GetMessages(), truncated.GetMessages())
+	})
+
+	t.Run("drops the oldest pairs by default", func(t *testing.T) {
+		m := newLongConversation(t, 3)
+		// system + 1 pair + last question + max tokens
+		policy := perplexity.TruncationPolicy{Estimator: messageCounter{}, ContextWindow: 50}
+		truncated, err := policy.Apply(context.Background(), m, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"system", "question 2", "answer 2", "last question"}, contents(truncated.GetMessages()))
+		assert.Nil(t, truncated.AddAgentMessage("answer"))
+	})
+
+	t.Run("keeps the first and last pairs", func(t *testing.T) {
+		m := newLongConversation(t, 5)
+		policy := perplexity.TruncationPolicy{
+			Strategy:      perplexity.KeepFirstLast{First: 1, Last: 1},
+			Estimator:     messageCounter{},
+			ContextWindow: 70,
+		}
+		truncated, err := policy.Apply(context.Background(), m, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"system", "question 0", "answer 0", "question 4", "answer 4", "last question"}, contents(truncated.GetMessages()))
+	})
+
+	t.Run("returns an error if the conversation can't fit", func(t *testing.T) {
+		m := newLongConversation(t, 5)
+		policy := perplexity.TruncationPolicy{
+			Strategy:      perplexity.KeepFirstLast{First: 2, Last: 2},
+			Estimator:     messageCounter{},
+			ContextWindow: 70,
+		}
+		_, err := policy.Apply(context.Background(), m, req)
+		assert.ErrorIs(t, err, perplexity.ErrContextWindowExceeded)
+
+		policy = perplexity.TruncationPolicy{Estimator: messageCounter{}, ContextWindow: 20}
+		_, err = policy.Apply(context.Background(), m, req)
+		assert.ErrorIs(t, err, perplexity.ErrContextWindowExceeded)
+	})
+
+	t.Run("summarizes the oldest pairs in the system message", func(t *testing.T) {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req perplexity.CompletionRequest
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, perplexity.DefaultSummaryMaxTokens, req.MaxTokens)
+			assert.Contains(t, req.Messages[1].Content, "user: question 0")
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"they talked"}}]}`)
+		}))
+		defer ts.Close()
+		client := perplexity.NewClient(apiKey)
+		client.SetHTTPClient(ts.Client())
+		client.SetEndpoint(ts.URL)
+
+		m := newLongConversation(t, 3)
+		policy := perplexity.TruncationPolicy{
+			Strategy:      perplexity.SummarizeOldest{Client: client},
+			Estimator:     messageCounter{},
+			ContextWindow: 50,
+		}
+		truncated, err := policy.Apply(context.Background(), m, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"system\n\nSummary of the beginning of the conversation:\nthey talked",
+			"question 2", "answer 2", "last question",
+		}, contents(truncated.GetMessages()))
+	})
+}
+
+func TestNewTruncatedCompletionRequest(t *testing.T) {
+	m := newLongConversation(t, 3)
+	policy := perplexity.TruncationPolicy{Estimator: messageCounter{}, ContextWindow: 50}
+	req, err := perplexity.NewTruncatedCompletionRequest(context.Background(), m, policy, perplexity.WithMaxTokens(10))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, req.MaxTokens)
+	assert.Len(t, req.Messages, 4)
+	// the original conversation is not modified
+	assert.Len(t, m.GetMessages(), 8)
+}
@@ -0,0 +1,175 @@
+package perplexitytest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+var (
+	_ perplexity.ChatClient = (*Fake)(nil)
+	_ perplexity.Searcher   = (*Fake)(nil)
+)
+
+// Call is a call received by a Fake.
+type Call struct {
+	// Method is the name of the method called.
+	Method string
+	// Request is the completion request, nil for Search.
+	Request *perplexity.CompletionRequest
+	// SearchRequest is the request of Search.
+	SearchRequest *perplexity.SearchRequest
+}
+
+// Fake is an in-memory implementation of the interfaces of perplexity.Client, for unit tests
+// that don't need HTTP. It answers with the same responses as the Server, without network.
+// It is safe for concurrent use.
+type Fake struct {
+	// Handler returns the response to a request when no response is enqueued (Answer(DefaultAnswer) if nil).
+	// The request is nil for Search.
+	Handler func(req *perplexity.CompletionRequest) Response
+
+	mu    sync.Mutex
+	queue []Response
+	calls []Call
+}
+
+// NewFake returns a fake answering with responses, in order.
+func NewFake(responses ...Response) *Fake {
+	return &Fake{queue: responses}
+}
+
+// Enqueue adds responses returned in order to the next calls.
+func (f *Fake) Enqueue(responses ...Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queue = append(f.queue, responses...)
+}
+
+// Calls returns the calls received so far.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Reset forgets the calls and the enqueued responses.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queue = nil
+	f.calls = nil
+}
+
+// SendCompletionRequestWithContext returns the next response.
+func (f *Fake) SendCompletionRequestWithContext(ctx context.Context, req *perplexity.CompletionRequest) (*perplexity.CompletionResponse, error) {
+	resp, err := f.respond(ctx, Call{Method: "SendCompletionRequestWithContext", Request: req})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Completion, nil
+}
+
+// SendSSEHTTPRequestWithContext streams the next response on responseChannel.
+// Like perplexity.Client, it closes responseChannel and calls wg.Done when done.
+func (f *Fake) SendSSEHTTPRequestWithContext(ctx context.Context, wg *sync.WaitGroup, req *perplexity.CompletionRequest, responseChannel chan<- perplexity.CompletionResponse) error {
+	if responseChannel == nil {
+		return fmt.Errorf("responseChannel must not be nil")
+	}
+	if wg == nil {
+		return fmt.Errorf("wg must not be nil")
+	}
+	if req == nil {
+		return fmt.Errorf("request must not be nil")
+	}
+	defer close(responseChannel)
+	defer wg.Done()
+
+	resp, err := f.respond(ctx, Call{Method: "SendSSEHTTPRequestWithContext", Request: req})
+	if err != nil {
+		return err
+	}
+	for i, event := range streamEvents(resp) {
+		if i > 0 {
+			if err := sleep(ctx, resp.Delay); err != nil {
+				return err
+			}
+		}
+		select {
+		case responseChannel <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Search returns the search results of the next response.
+func (f *Fake) Search(ctx context.Context, req *perplexity.SearchRequest) (*perplexity.SearchResponse, error) {
+	resp, err := f.respond(ctx, Call{Method: "Search", SearchRequest: req})
+	if err != nil {
+		return nil, err
+	}
+	return &perplexity.SearchResponse{
+		ID:      resp.Completion.ID,
+		Results: resp.Completion.GetSearchResults(),
+	}, nil
+}
+
+// respond records the call and returns the next response, or its error.
+func (f *Fake) respond(ctx context.Context, call Call) (Response, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+
+	if call.Request == nil && call.SearchRequest == nil {
+		return Response{}, fmt.Errorf("request must not be nil")
+	}
+	var err error
+	if call.Request != nil {
+		err = call.Request.Validate()
+	} else {
+		err = call.SearchRequest.Validate()
+	}
+	if err != nil {
+		resp := Error(http.StatusBadRequest, err.Error())
+		return Response{}, &perplexity.APIError{StatusCode: resp.StatusCode, Body: resp.Body}
+	}
+
+	resp := f.next(call.Request)
+	if err := sleep(ctx, resp.Delay); err != nil {
+		return Response{}, err
+	}
+	switch {
+	case resp.Err != nil:
+		return Response{}, resp.Err
+	case resp.StatusCode != 0 && resp.StatusCode != http.StatusOK:
+		return Response{}, &perplexity.APIError{StatusCode: resp.StatusCode, Body: resp.Body}
+	}
+	if call.Request != nil {
+		resp.Completion = complete(resp.Completion, call.Request)
+	} else if resp.Completion == nil {
+		resp.Completion = &perplexity.CompletionResponse{}
+	}
+	return resp, nil
+}
+
+// next returns the next enqueued response, or the response of the handler.
+func (f *Fake) next(req *perplexity.CompletionRequest) Response {
+	f.mu.Lock()
+	if len(f.queue) > 0 {
+		resp := f.queue[0]
+		f.queue = f.queue[1:]
+		f.mu.Unlock()
+		return resp
+	}
+	handler := f.Handler
+	f.mu.Unlock()
+	if handler == nil {
+		return Answer(DefaultAnswer)
+	}
+	return handler(req)
+}
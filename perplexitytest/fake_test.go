@@ -0,0 +1,94 @@
+package perplexitytest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	t.Run("returns the canned responses and records the calls", func(t *testing.T) {
+		fake := perplexitytest.NewFake(perplexitytest.Answer("Paris[1].", "https://en.wikipedia.org/wiki/Paris"))
+		var c perplexity.Completer = fake
+		resp, err := c.SendCompletionRequestWithContext(context.Background(), newRequest(false))
+		assert.Nil(t, err)
+		assert.Equal(t, "Paris[1].", resp.GetLastContent())
+		assert.Equal(t, []string{"https://en.wikipedia.org/wiki/Paris"}, resp.GetCitations())
+
+		fake.Handler = func(req *perplexity.CompletionRequest) perplexitytest.Response {
+			return perplexitytest.Answer("echo: " + req.Messages[0].Content)
+		}
+		resp, err = c.SendCompletionRequestWithContext(context.Background(), newRequest(false))
+		assert.Nil(t, err)
+		assert.Equal(t, "echo: What's the capital of France?", resp.GetLastContent())
+
+		calls := fake.Calls()
+		assert.Len(t, calls, 2)
+		assert.Equal(t, "SendCompletionRequestWithContext", calls[0].Method)
+		assert.Equal(t, "What's the capital of France?", calls[0].Request.Messages[0].Content)
+		fake.Reset()
+		assert.Len(t, fake.Calls(), 0)
+	})
+
+	t.Run("simulates errors", func(t *testing.T) {
+		errNetwork := errors.New("network is down")
+		fake := perplexitytest.NewFake(
+			perplexitytest.Error(http.StatusTooManyRequests, "rate limited"),
+			perplexitytest.Response{Err: errNetwork},
+			perplexitytest.Response{Delay: time.Second},
+		)
+		_, err := fake.SendCompletionRequestWithContext(context.Background(), newRequest(false))
+		var apiErr *perplexity.APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+
+		_, err = fake.SendCompletionRequestWithContext(context.Background(), newRequest(false))
+		assert.ErrorIs(t, err, errNetwork)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = fake.SendCompletionRequestWithContext(ctx, newRequest(false))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		req := newRequest(false)
+		req.Messages = nil
+		_, err = fake.SendCompletionRequestWithContext(context.Background(), req)
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	})
+
+	t.Run("streams the chunks", func(t *testing.T) {
+		fake := perplexitytest.NewFake(perplexitytest.Stream([]string{"Paris ", "is the capital."}))
+		var s perplexity.Streamer = fake
+		var wg sync.WaitGroup
+		ch := make(chan perplexity.CompletionResponse)
+		errCh := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			errCh <- s.SendSSEHTTPRequestWithContext(context.Background(), &wg, newRequest(true), ch)
+		}()
+		var deltas []string
+		for r := range ch {
+			deltas = append(deltas, r.Choices[0].Delta.Content)
+		}
+		wg.Wait()
+		assert.Nil(t, <-errCh)
+		assert.Equal(t, []string{"Paris ", "is the capital."}, deltas)
+	})
+
+	t.Run("returns search results", func(t *testing.T) {
+		fake := perplexitytest.NewFake(perplexitytest.Answer("", "https://go.dev"))
+		var s perplexity.Searcher = fake
+		resp, err := s.Search(context.Background(), perplexity.NewSearchRequest("golang"))
+		assert.Nil(t, err)
+		assert.Equal(t, "https://go.dev", resp.Results[0].URL)
+		assert.Equal(t, "Search", fake.Calls()[0].Method)
+	})
+}
@@ -0,0 +1,431 @@
+package perplexitytest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSplitSize is the maximum size of the pieces of a body split by SplitEvents.
+const maxSplitSize = 256
+
+// Fault is a misbehaviour of the API injected in a response.
+type Fault interface {
+	apply(inj *injection, rng *rand.Rand)
+}
+
+// injection describes how a response is altered.
+type injection struct {
+	delay      time.Duration
+	statusCode int
+	retryAfter time.Duration
+
+	malformed bool
+	padding   int
+	split     bool
+	dripSize  int
+	dripEvery time.Duration
+	dropAfter int
+	rng       *rand.Rand
+}
+
+type latency struct {
+	d, jitter time.Duration
+}
+
+func (f latency) apply(inj *injection, rng *rand.Rand) {
+	inj.delay += f.d
+	if f.jitter > 0 {
+		inj.delay += time.Duration(rng.Int63n(int64(f.jitter)))
+	}
+}
+
+// Latency delays the response by d plus a random duration between 0 and jitter.
+func Latency(d, jitter time.Duration) Fault {
+	return latency{d: d, jitter: jitter}
+}
+
+type statusFault struct {
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (f statusFault) apply(inj *injection, _ *rand.Rand) {
+	inj.statusCode = f.statusCode
+	inj.retryAfter = f.retryAfter
+}
+
+// RateLimit answers 429 Too Many Requests with a Retry-After header.
+func RateLimit(retryAfter time.Duration) Fault {
+	return statusFault{statusCode: http.StatusTooManyRequests, retryAfter: retryAfter}
+}
+
+// ServerError answers with statusCode, 500 if zero. Schedule it with Burst for a burst of errors.
+func ServerError(statusCode int) Fault {
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+	return statusFault{statusCode: statusCode}
+}
+
+type bodyFault func(inj *injection)
+
+func (f bodyFault) apply(inj *injection, _ *rand.Rand) {
+	f(inj)
+}
+
+// MalformedJSON truncates the JSON of the response, or of the first event of a stream.
+func MalformedJSON() Fault {
+	return bodyFault(func(inj *injection) { inj.malformed = true })
+}
+
+// SplitEvents sends the body in pieces of random sizes, so that the events of a stream
+// are split at arbitrary byte boundaries.
+func SplitEvents() Fault {
+	return bodyFault(func(inj *injection) { inj.split = true })
+}
+
+// OversizedEvents pads the JSON of each event of a stream with whitespace to at least size bytes.
+// Events stay valid; use a size larger than 64000 to exceed the usual read buffers.
+func OversizedEvents(size int) Fault {
+	return bodyFault(func(inj *injection) { inj.padding = size })
+}
+
+// DropConnection closes the connection after afterBytes bytes of the body.
+func DropConnection(afterBytes int) Fault {
+	return bodyFault(func(inj *injection) {
+		inj.dropAfter = afterBytes
+	})
+}
+
+// SlowDrip sends the body in pieces of chunkSize bytes, waiting interval before each piece.
+func SlowDrip(chunkSize int, interval time.Duration) Fault {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	return bodyFault(func(inj *injection) {
+		inj.dripSize = chunkSize
+		inj.dripEvery = interval
+	})
+}
+
+// Rule triggers a fault on some requests.
+// The fault is injected in the requests listed in Requests, or in one request out of Every,
+// or else with Probability. Requests are numbered from 1 in the order they are received.
+type Rule struct {
+	Fault       Fault
+	Probability float64
+	Requests    []int
+	Every       int
+}
+
+func (r Rule) triggered(n int, rng *rand.Rand) bool {
+	switch {
+	case len(r.Requests) > 0:
+		for _, i := range r.Requests {
+			if i == n {
+				return true
+			}
+		}
+		return false
+	case r.Every > 0:
+		return n%r.Every == 0
+	default:
+		// draw even if the probability is 0 or 1, so that rules do not change the draws of the others
+		return rng.Float64() < r.Probability
+	}
+}
+
+// Always injects f in every request.
+func Always(f Fault) Rule {
+	return Rule{Fault: f, Probability: 1}
+}
+
+// Sometimes injects f in a request with probability p.
+func Sometimes(p float64, f Fault) Rule {
+	return Rule{Fault: f, Probability: p}
+}
+
+// OnRequests injects f in the requests numbered n (from 1).
+func OnRequests(f Fault, n ...int) Rule {
+	return Rule{Fault: f, Requests: n}
+}
+
+// Burst injects f in count consecutive requests, starting with the request numbered first (from 1).
+func Burst(first, count int, f Fault) Rule {
+	r := Rule{Fault: f}
+	for i := first; i < first+count; i++ {
+		r.Requests = append(r.Requests, i)
+	}
+	return r
+}
+
+// EveryN injects f in one request out of n.
+func EveryN(n int, f Fault) Rule {
+	return Rule{Fault: f, Every: n}
+}
+
+// Scenario decides the faults injected in each request.
+// With the same seed and the same sequence of requests, the same faults are injected.
+// It is safe for concurrent use.
+type Scenario struct {
+	rules []Rule
+
+	mu  sync.Mutex
+	rng *rand.Rand
+	n   int
+}
+
+// NewScenario returns a scenario applying the rules with a random generator seeded with seed.
+func NewScenario(seed int64, rules ...Rule) *Scenario {
+	return &Scenario{
+		rules: rules,
+		rng:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// next returns the faults of the next request.
+func (sc *Scenario) next() *injection {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.n++
+	inj := &injection{dropAfter: -1}
+	for _, r := range sc.rules {
+		if r.triggered(sc.n, sc.rng) {
+			r.Fault.apply(inj, sc.rng)
+		}
+	}
+	inj.rng = rand.New(rand.NewSource(sc.rng.Int63()))
+	return inj
+}
+
+// Transport returns a RoundTripper injecting the faults in the responses of base
+// (http.DefaultTransport if nil). Use it in the HTTP client of a perplexity.Client:
+//
+//	client.SetHTTPClient(&http.Client{Transport: scenario.Transport(nil)})
+func (sc *Scenario) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &faultTransport{base: base, scenario: sc}
+}
+
+type faultTransport struct {
+	base     http.RoundTripper
+	scenario *Scenario
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	inj := t.scenario.next()
+	if err := sleep(req.Context(), inj.delay); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if inj.statusCode != 0 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		body := Error(inj.statusCode, "injected fault").Body
+		resp := &http.Response{
+			Status:        fmt.Sprintf("%d %s", inj.statusCode, http.StatusText(inj.statusCode)),
+			StatusCode:    inj.statusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        inj.header(),
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}
+		resp.Header.Set("Content-Type", "application/json")
+		return resp, nil
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &faultBody{
+		ctx:     req.Context(),
+		closer:  resp.Body,
+		inj:     inj,
+		lines:   bufio.NewReader(resp.Body),
+		dropErr: io.ErrUnexpectedEOF,
+	}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// Handler returns a handler injecting the faults in the responses of h.
+// The response of h is buffered before the faults are applied.
+func (sc *Scenario) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inj := sc.next()
+		if err := sleep(r.Context(), inj.delay); err != nil {
+			return
+		}
+		if inj.statusCode != 0 {
+			for k, v := range inj.header() {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(inj.statusCode)
+			_, _ = io.WriteString(w, Error(inj.statusCode, "injected fault").Body)
+			return
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.Code)
+		body := &faultBody{
+			ctx:     r.Context(),
+			closer:  io.NopCloser(nil),
+			inj:     inj,
+			lines:   bufio.NewReader(rec.Body),
+			dropErr: http.ErrAbortHandler,
+		}
+		flusher, _ := w.(http.Flusher)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if _, errWrite := w.Write(buf[:n]); errWrite != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if errors.Is(err, http.ErrAbortHandler) {
+				// closes the connection without ending the response
+				panic(http.ErrAbortHandler)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// WithScenario injects the faults of the scenario in the responses of the server.
+func WithScenario(sc *Scenario) Option {
+	return func(s *Server) {
+		s.scenario = sc
+	}
+}
+
+// header returns the headers of an injected error response.
+func (inj *injection) header() http.Header {
+	h := make(http.Header)
+	if inj.retryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int((inj.retryAfter+time.Second-1)/time.Second)))
+	}
+	return h
+}
+
+// faultBody applies the faults of an injection to a body, line by line.
+type faultBody struct {
+	ctx     context.Context
+	closer  io.Closer
+	inj     *injection
+	lines   *bufio.Reader
+	dropErr error
+
+	out       []byte
+	sent      int
+	malformed bool
+	err       error
+}
+
+// Read implements io.Reader.
+func (b *faultBody) Read(p []byte) (int, error) {
+	for len(b.out) == 0 && b.err == nil {
+		b.readLine()
+	}
+	if len(b.out) == 0 {
+		return 0, b.err
+	}
+	n := len(p)
+	switch {
+	case b.inj.dripSize > 0:
+		n = min(n, b.inj.dripSize)
+		if err := sleep(b.ctx, b.inj.dripEvery); err != nil {
+			return 0, err
+		}
+	case b.inj.split:
+		n = min(n, 1+b.inj.rng.Intn(maxSplitSize))
+	}
+	if b.inj.dropAfter >= 0 {
+		if b.sent >= b.inj.dropAfter {
+			return 0, b.dropErr
+		}
+		n = min(n, b.inj.dropAfter-b.sent)
+	}
+	n = copy(p, b.out[:min(n, len(b.out))])
+	b.out = b.out[n:]
+	b.sent += n
+	return n, nil
+}
+
+// readLine reads the next line of the body and applies the faults to it.
+func (b *faultBody) readLine() {
+	line, err := b.lines.ReadBytes('\n')
+	if err != nil {
+		b.err = err
+		if errors.Is(err, io.EOF) && b.inj.dropAfter >= 0 {
+			// the connection is dropped even if the body is shorter
+			b.err = b.dropErr
+		}
+	}
+	content := bytes.TrimRight(line, "\r\n")
+	eol := line[len(content):]
+	isEvent := bytes.HasPrefix(content, []byte("data: {"))
+	if b.inj.padding > 0 && isEvent && len(content) < b.inj.padding {
+		padded := make([]byte, 0, b.inj.padding+len(eol))
+		padded = append(padded, "data: {"...)
+		padded = append(padded, bytes.Repeat([]byte(" "), b.inj.padding-len(content))...)
+		padded = append(padded, content[len("data: {"):]...)
+		content = padded
+	}
+	if b.inj.malformed && !b.malformed && len(bytes.TrimSpace(content)) > 0 && (isEvent || !bytes.HasPrefix(content, []byte("data:"))) {
+		b.malformed = true
+		content = content[:len(content)/2]
+	}
+	b.out = append(append(b.out, content...), eol...)
+}
+
+// Close implements io.Closer.
+func (b *faultBody) Close() error {
+	return b.closer.Close()
+}
+
+// sleep waits d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,176 @@
+package perplexitytest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// stream sends a streamed request and returns the events and the error.
+func stream(c *perplexity.Client) ([]perplexity.CompletionResponse, error) {
+	var wg sync.WaitGroup
+	ch := make(chan perplexity.CompletionResponse)
+	errCh := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		errCh <- c.SendSSEHTTPRequest(&wg, newRequest(true), ch)
+	}()
+	var events []perplexity.CompletionResponse
+	for r := range ch {
+		events = append(events, r)
+	}
+	wg.Wait()
+	return events, <-errCh
+}
+
+// newFaultClient returns a client of srv injecting the faults of sc with its transport.
+func newFaultClient(srv *perplexitytest.Server, sc *perplexitytest.Scenario) *perplexity.Client {
+	c := srv.Client()
+	c.SetHTTPClient(&http.Client{
+		Transport: sc.Transport(srv.Server.Client().Transport),
+		Timeout:   perplexity.DefautTimeout,
+	})
+	return c
+}
+
+func statusCodes(t *testing.T, c *perplexity.Client, n int) []int {
+	t.Helper()
+	var codes []int
+	for i := 0; i < n; i++ {
+		_, err := c.SendCompletionRequest(newRequest(false))
+		var apiErr *perplexity.APIError
+		switch {
+		case err == nil:
+			codes = append(codes, http.StatusOK)
+		case errors.As(err, &apiErr):
+			codes = append(codes, apiErr.StatusCode)
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return codes
+}
+
+func TestScenario(t *testing.T) {
+	t.Run("is deterministic for a seed", func(t *testing.T) {
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		newScenario := func() *perplexitytest.Scenario {
+			return perplexitytest.NewScenario(42, perplexitytest.Sometimes(0.5, perplexitytest.ServerError(http.StatusServiceUnavailable)))
+		}
+		first := statusCodes(t, newFaultClient(srv, newScenario()), 20)
+		assert.Equal(t, first, statusCodes(t, newFaultClient(srv, newScenario()), 20))
+		assert.Contains(t, first, http.StatusOK)
+		assert.Contains(t, first, http.StatusServiceUnavailable)
+	})
+
+	t.Run("schedules bursts of errors", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1,
+			perplexitytest.Burst(2, 3, perplexitytest.ServerError(http.StatusBadGateway)),
+			perplexitytest.EveryN(6, perplexitytest.RateLimit(1500*time.Millisecond)),
+		)
+		srv := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer srv.Close()
+		assert.Equal(t, []int{200, 502, 502, 502, 200, 429}, statusCodes(t, srv.Client(), 6))
+	})
+
+	t.Run("rate limits with Retry-After", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1, perplexitytest.Always(perplexitytest.RateLimit(1500*time.Millisecond)))
+		req, err := http.NewRequest(http.MethodPost, "https://api.perplexity.ai/chat/completions", strings.NewReader("{}"))
+		assert.Nil(t, err)
+		resp, err := sc.Transport(nil).RoundTrip(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	})
+
+	t.Run("adds latency", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1, perplexitytest.Always(perplexitytest.Latency(50*time.Millisecond, 20*time.Millisecond)))
+		srv := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer srv.Close()
+		start := time.Now()
+		_, err := srv.Client().SendCompletionRequest(newRequest(false))
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = newFaultClient(srv, sc).SendCompletionRequestWithContext(ctx, newRequest(false))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestBodyFaults(t *testing.T) {
+	chunks := []string{"Paris ", "is ", "the ", "capital", "[1]."}
+	for _, tc := range []struct {
+		name   string
+		fault  perplexitytest.Fault
+		server bool
+	}{
+		{name: "split events through the transport", fault: perplexitytest.SplitEvents()},
+		{name: "split events by the server", fault: perplexitytest.SplitEvents(), server: true},
+		{name: "oversized events through the transport", fault: perplexitytest.OversizedEvents(70000)},
+		{name: "oversized events by the server", fault: perplexitytest.OversizedEvents(70000), server: true},
+		{name: "slow drip", fault: perplexitytest.SlowDrip(100, time.Millisecond), server: true},
+	} {
+		t.Run(tc.name+" are decoded", func(t *testing.T) {
+			sc := perplexitytest.NewScenario(7, perplexitytest.Always(tc.fault), perplexitytest.Always(perplexitytest.SplitEvents()))
+			var c *perplexity.Client
+			var srv *perplexitytest.Server
+			if tc.server {
+				srv = perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+				c = srv.Client()
+			} else {
+				srv = perplexitytest.NewServer()
+				c = newFaultClient(srv, sc)
+			}
+			defer srv.Close()
+			srv.Enqueue(perplexitytest.Stream(chunks, "https://en.wikipedia.org/wiki/Paris"))
+			events, err := stream(c)
+			assert.Nil(t, err)
+			assert.Len(t, events, len(chunks))
+			assert.Equal(t, "Paris is the capital[1].", events[len(events)-1].GetLastContent())
+		})
+	}
+
+	t.Run("dropped connection is an error", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1, perplexitytest.Always(perplexitytest.DropConnection(300)))
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		srv.Enqueue(perplexitytest.Stream(chunks))
+		events, err := stream(newFaultClient(srv, sc))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Less(t, len(events), len(chunks))
+
+		faulty := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer faulty.Close()
+		faulty.Enqueue(perplexitytest.Stream(chunks))
+		events, err = stream(faulty.Client())
+		assert.NotNil(t, err)
+		assert.Less(t, len(events), len(chunks))
+	})
+
+	t.Run("malformed JSON", func(t *testing.T) {
+		sc := perplexitytest.NewScenario(1, perplexitytest.Always(perplexitytest.MalformedJSON()))
+		srv := perplexitytest.NewServer(perplexitytest.WithScenario(sc))
+		defer srv.Close()
+		_, err := srv.Client().SendCompletionRequest(newRequest(false))
+		assert.ErrorContains(t, err, "failed to unmarshal")
+		// the malformed events of a stream are ignored
+		chunks := []string{"Par", "is."}
+		srv.Enqueue(perplexitytest.Stream(chunks))
+		events, err := stream(srv.Client())
+		assert.Nil(t, err)
+		assert.Len(t, events, len(chunks)-1)
+	})
+}
@@ -0,0 +1,391 @@
+// Package perplexitytest provides a fake Perplexity API server for the tests
+// of the programs using the perplexity package.
+//
+//	srv := perplexitytest.NewServer()
+//	defer srv.Close()
+//	srv.Enqueue(perplexitytest.Answer("Paris is the capital of France[1].", "https://en.wikipedia.org/wiki/Paris"))
+//	resp, err := srv.Client().SendCompletionRequest(req)
+//
+// The server checks the authentication and the body of the requests like the API does,
+// answers with the scripted responses, streamed with the framing of the API if the request
+// asks for it, and records the requests it receives.
+package perplexitytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// DefaultAPIKey is the API key accepted by the server if none is set with WithAPIKey.
+const DefaultAPIKey = "perplexitytest-api-key"
+
+// CompletionPath is the path of the completion endpoint of the server.
+const CompletionPath = "/chat/completions"
+
+// DefaultAnswer is the content of the answer of the server when no response is scripted.
+const DefaultAnswer = "This is a test answer."
+
+// Response is a scripted response of the server.
+type Response struct {
+	// StatusCode is the status code of the response, 200 if zero.
+	StatusCode int
+	// Body is the body sent if StatusCode is not 200.
+	Body string
+	// Completion is the response sent if StatusCode is 200.
+	// Its content is streamed if the request asks for a stream.
+	Completion *perplexity.CompletionResponse
+	// Chunks are the pieces of content sent in the events of a stream.
+	// If empty, the content of Completion is split in words.
+	Chunks []string
+	// Delay is the time waited before sending the response, and between the events of a stream.
+	Delay time.Duration
+	// Err is returned by the Fake instead of the response. The Server drops the connection.
+	Err error
+}
+
+// Answer returns a response with content and the citations, each with a search result.
+func Answer(content string, citations ...string) Response {
+	completion := &perplexity.CompletionResponse{
+		Model:  perplexity.DefaultModel,
+		Object: "chat.completion",
+		Choices: []perplexity.Choice{
+			{
+				FinishReason: "stop",
+				Message: perplexity.Message{
+					Role:    "assistant",
+					Content: content,
+				},
+			},
+		},
+	}
+	if len(citations) > 0 {
+		completion.Citations = &citations
+		for i, c := range citations {
+			completion.SearchResults = append(completion.SearchResults, perplexity.SearchResult{
+				Title: fmt.Sprintf("Source %d", i+1),
+				URL:   c,
+			})
+		}
+	}
+	return Response{Completion: completion}
+}
+
+// Stream returns a response streaming the chunks, with the citations.
+// Without stream, the concatenation of the chunks is sent.
+func Stream(chunks []string, citations ...string) Response {
+	r := Answer(strings.Join(chunks, ""), citations...)
+	r.Chunks = chunks
+	return r
+}
+
+// Error returns a response with the status code and an error body like the API's.
+func Error(statusCode int, message string) Response {
+	body, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    strings.ReplaceAll(strings.ToLower(http.StatusText(statusCode)), " ", "_"),
+			"code":    statusCode,
+		},
+	})
+	return Response{
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+}
+
+// RecordedRequest is a request received by the server.
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+	// Request is the decoded body, nil if the body is not a valid completion request.
+	Request *perplexity.CompletionRequest
+	// StatusCode is the status code of the response of the server.
+	StatusCode int
+}
+
+// Option is a functional option of the server.
+type Option func(*Server)
+
+// WithAPIKey sets the API key accepted by the server.
+func WithAPIKey(apiKey string) Option {
+	return func(s *Server) {
+		s.apiKey = apiKey
+	}
+}
+
+// WithHandler sets the function returning the response to a valid request
+// when no response is enqueued.
+func WithHandler(handler func(req *perplexity.CompletionRequest) Response) Option {
+	return func(s *Server) {
+		s.handler = handler
+	}
+}
+
+// WithResponses enqueues responses, sent in order to the next valid requests.
+func WithResponses(responses ...Response) Option {
+	return func(s *Server) {
+		s.queue = append(s.queue, responses...)
+	}
+}
+
+// Server is a fake Perplexity API server. It is safe for concurrent use.
+type Server struct {
+	// Server is the underlying TLS test server.
+	Server *httptest.Server
+
+	apiKey   string
+	handler  func(req *perplexity.CompletionRequest) Response
+	scenario *Scenario
+
+	mu       sync.Mutex
+	queue    []Response
+	requests []RecordedRequest
+}
+
+// NewServer starts a fake server. It must be closed with Close.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		apiKey: DefaultAPIKey,
+		handler: func(*perplexity.CompletionRequest) Response {
+			return Answer(DefaultAnswer)
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(CompletionPath, s.serveCompletion)
+	var h http.Handler = mux
+	if s.scenario != nil {
+		h = s.scenario.Handler(mux)
+	}
+	s.Server = httptest.NewTLSServer(h)
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.Server.Close()
+}
+
+// URL returns the URL of the completion endpoint.
+func (s *Server) URL() string {
+	return s.Server.URL + CompletionPath
+}
+
+// Client returns a client using the server.
+func (s *Server) Client() *perplexity.Client {
+	c := perplexity.NewClient(s.apiKey)
+	c.SetHTTPClient(s.Server.Client())
+	c.SetHTTPTimeout(perplexity.DefautTimeout)
+	c.SetEndpoint(s.URL())
+	return c
+}
+
+// Enqueue adds responses sent in order to the next valid requests.
+func (s *Server) Enqueue(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, responses...)
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest(nil), s.requests...)
+}
+
+// LastRequest returns the last request received and false if no request has been received.
+func (s *Server) LastRequest() (RecordedRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return RecordedRequest{}, false
+	}
+	return s.requests[len(s.requests)-1], true
+}
+
+// Reset forgets the recorded requests and the enqueued responses.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = nil
+	s.requests = nil
+}
+
+func (s *Server) serveCompletion(w http.ResponseWriter, r *http.Request) {
+	rec := RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+	}
+	body, err := io.ReadAll(r.Body)
+	rec.Body = body
+	var resp Response
+	switch {
+	case err != nil:
+		resp = Error(http.StatusBadRequest, fmt.Sprintf("failed to read body: %v", err))
+	case r.Method != http.MethodPost:
+		resp = Error(http.StatusMethodNotAllowed, "method must be POST")
+	case r.Header.Get("Authorization") != "Bearer "+s.apiKey:
+		resp = Error(http.StatusUnauthorized, "invalid API key")
+	case !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json"):
+		resp = Error(http.StatusBadRequest, "content type must be application/json")
+	default:
+		resp = s.validRequestResponse(&rec)
+	}
+	rec.StatusCode = resp.StatusCode
+	if rec.StatusCode == 0 {
+		rec.StatusCode = http.StatusOK
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, rec)
+	s.mu.Unlock()
+
+	time.Sleep(resp.Delay)
+	if resp.Err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if rec.StatusCode != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rec.StatusCode)
+		_, _ = io.WriteString(w, resp.Body)
+		return
+	}
+	if rec.Request.Stream {
+		writeStream(w, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp.Completion)
+}
+
+// validRequestResponse decodes and validates the body of the request and returns the response to send.
+func (s *Server) validRequestResponse(rec *RecordedRequest) Response {
+	var req perplexity.CompletionRequest
+	if err := json.Unmarshal(rec.Body, &req); err != nil {
+		return Error(http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+	}
+	rec.Request = &req
+	if err := req.Validate(); err != nil {
+		return Error(http.StatusBadRequest, err.Error())
+	}
+	s.mu.Lock()
+	var resp Response
+	if len(s.queue) > 0 {
+		resp = s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+	} else {
+		s.mu.Unlock()
+		resp = s.handler(&req)
+	}
+	if resp.Err != nil || (resp.StatusCode != 0 && resp.StatusCode != http.StatusOK) {
+		return resp
+	}
+	resp.Completion = complete(resp.Completion, &req)
+	return resp
+}
+
+// complete returns a copy of the completion with the fields the API always sets.
+func complete(c *perplexity.CompletionResponse, req *perplexity.CompletionRequest) *perplexity.CompletionResponse {
+	var r perplexity.CompletionResponse
+	if c != nil {
+		r = *c
+	}
+	if r.ID == "" {
+		r.ID = fmt.Sprintf("perplexitytest-%d", time.Now().UnixNano())
+	}
+	if r.Model == "" {
+		r.Model = req.Model
+	}
+	if r.Created == 0 {
+		r.Created = int(time.Now().Unix())
+	}
+	if r.Object == "" {
+		r.Object = "chat.completion"
+	}
+	if r.Usage == (perplexity.Usage{}) {
+		r.Usage.PromptTokens = perplexity.EstimatePromptTokens(req)
+		r.Usage.CompletionTokens = perplexity.DefaultTokenEstimator.EstimateTokens(r.GetLastContent())
+		r.Usage.TotalTokens = r.Usage.PromptTokens + r.Usage.CompletionTokens
+	}
+	return &r
+}
+
+// writeStream sends the content of the response in server-sent events.
+func writeStream(w http.ResponseWriter, resp Response) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, _ := w.(http.Flusher)
+
+	for i, event := range streamEvents(resp) {
+		if i > 0 {
+			time.Sleep(resp.Delay)
+		}
+		b, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\r\n\r\n", b); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// streamEvents returns the events streaming the content of the response.
+// Like the API, each event carries the new tokens in delta and the content so far in message.
+func streamEvents(resp Response) []perplexity.CompletionResponse {
+	chunks := resp.Chunks
+	if len(chunks) == 0 {
+		chunks = splitWords(resp.Completion.GetLastContent())
+	}
+	events := make([]perplexity.CompletionResponse, 0, len(chunks))
+	var content strings.Builder
+	for i, chunk := range chunks {
+		content.WriteString(chunk)
+		event := *resp.Completion
+		event.Object = "chat.completion.chunk"
+		event.Choices = []perplexity.Choice{
+			{
+				Message: perplexity.Message{Role: "assistant", Content: content.String()},
+				Delta:   perplexity.Message{Role: "assistant", Content: chunk},
+			},
+		}
+		if i == len(chunks)-1 {
+			event.Choices[0].FinishReason = "stop"
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// splitWords splits s after each space, keeping the spaces.
+func splitWords(s string) []string {
+	var words []string
+	for s != "" {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			words = append(words, s)
+			break
+		}
+		words = append(words, s[:i+1])
+		s = s[i+1:]
+	}
+	return words
+}
@@ -0,0 +1,123 @@
+package perplexitytest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequest(stream bool) *perplexity.CompletionRequest {
+	return perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+		{
+			Role:    "user",
+			Content: "What's the capital of France?",
+		},
+	}), perplexity.WithStream(stream))
+}
+
+func TestServer(t *testing.T) {
+	t.Run("answers with the scripted responses in order", func(t *testing.T) {
+		srv := perplexitytest.NewServer(perplexitytest.WithResponses(
+			perplexitytest.Answer("Paris[1].", "https://en.wikipedia.org/wiki/Paris"),
+		))
+		defer srv.Close()
+		srv.Enqueue(perplexitytest.Error(http.StatusTooManyRequests, "rate limited"))
+
+		resp, err := srv.Client().SendCompletionRequest(newRequest(false))
+		assert.Nil(t, err)
+		assert.Equal(t, "Paris[1].", resp.GetLastContent())
+		assert.Equal(t, []string{"https://en.wikipedia.org/wiki/Paris"}, resp.GetCitations())
+		assert.Equal(t, "Source 1", resp.GetSearchResults()[0].Title)
+		assert.Greater(t, resp.Usage.PromptTokens, 0)
+		assert.Equal(t, resp.Usage.PromptTokens+resp.Usage.CompletionTokens, resp.Usage.TotalTokens)
+
+		_, err = srv.Client().SendCompletionRequest(newRequest(false))
+		var apiErr *perplexity.APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+		assert.Contains(t, apiErr.Body, "rate limited")
+
+		resp, err = srv.Client().SendCompletionRequest(newRequest(false))
+		assert.Nil(t, err)
+		assert.Equal(t, perplexitytest.DefaultAnswer, resp.GetLastContent())
+	})
+
+	t.Run("rejects a wrong API key", func(t *testing.T) {
+		srv := perplexitytest.NewServer(perplexitytest.WithAPIKey("secret"))
+		defer srv.Close()
+		c := srv.Client()
+		_, err := c.SendCompletionRequest(newRequest(false))
+		assert.Nil(t, err)
+
+		c = perplexity.NewClient("wrong")
+		c.SetHTTPClient(srv.Server.Client())
+		c.SetEndpoint(srv.URL())
+		_, err = c.SendCompletionRequest(newRequest(false))
+		assert.ErrorIs(t, err, perplexity.ErrUnauthorized)
+		last, ok := srv.LastRequest()
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusUnauthorized, last.StatusCode)
+	})
+
+	t.Run("rejects an invalid request", func(t *testing.T) {
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		req := newRequest(false)
+		req.Temperature = 3
+		_, err := srv.Client().SendCompletionRequest(req)
+		var apiErr *perplexity.APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	})
+
+	t.Run("records the requests", func(t *testing.T) {
+		srv := perplexitytest.NewServer(perplexitytest.WithHandler(func(req *perplexity.CompletionRequest) perplexitytest.Response {
+			return perplexitytest.Answer("You asked: " + req.Messages[len(req.Messages)-1].Content)
+		}))
+		defer srv.Close()
+		resp, err := srv.Client().SendCompletionRequestWithContext(context.Background(), newRequest(false))
+		assert.Nil(t, err)
+		assert.Equal(t, "You asked: What's the capital of France?", resp.GetLastContent())
+
+		requests := srv.Requests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, http.MethodPost, requests[0].Method)
+		assert.Equal(t, "Bearer "+perplexitytest.DefaultAPIKey, requests[0].Header.Get("Authorization"))
+		assert.Equal(t, "What's the capital of France?", requests[0].Request.Messages[0].Content)
+
+		srv.Reset()
+		assert.Len(t, srv.Requests(), 0)
+	})
+
+	t.Run("streams the chunks", func(t *testing.T) {
+		srv := perplexitytest.NewServer()
+		defer srv.Close()
+		srv.Enqueue(perplexitytest.Stream([]string{"Paris ", "is the capital", "[1]."}, "https://en.wikipedia.org/wiki/Paris"))
+
+		var wg sync.WaitGroup
+		ch := make(chan perplexity.CompletionResponse)
+		errCh := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			errCh <- srv.Client().SendSSEHTTPRequest(&wg, newRequest(true), ch)
+		}()
+		var deltas []string
+		var last perplexity.CompletionResponse
+		for r := range ch {
+			deltas = append(deltas, r.Choices[0].Delta.Content)
+			last = r
+		}
+		wg.Wait()
+		assert.Nil(t, <-errCh)
+		assert.Equal(t, []string{"Paris ", "is the capital", "[1]."}, deltas)
+		assert.Equal(t, "Paris is the capital[1].", last.GetLastContent())
+		assert.Equal(t, "stop", last.Choices[0].FinishReason)
+		assert.Equal(t, []string{"https://en.wikipedia.org/wiki/Paris"}, last.GetCitations())
+	})
+}
@@ -0,0 +1,453 @@
+// Package prompt renders completion requests from prompt templates.
+//
+// A prompt file has a YAML front matter declaring the model, the options of the request,
+// the system message and the variables, followed by the text/template of the user message:
+//
+//	---
+//	model: sonar-pro
+//	system: You are a concise assistant.
+//	options:
+//	  temperature: 0.3
+//	  search_recency_filter: week
+//	variables:
+//	  topic: {type: string, required: true}
+//	  count: {type: int, default: 3}
+//	---
+//	Summarize the news about {{.topic}} in {{.count}} bullet points.
+//
+// The system message is a template too. Rendering a template with the values of its
+// variables returns a validated *perplexity.CompletionRequest; it fails if a required
+// variable is missing, if a variable is not declared or if a value has the wrong type.
+//
+// Prompt files are usually embedded in the program:
+//
+//	//go:embed prompts/*.prompt
+//	var prompts embed.FS
+//
+//	set, err := prompt.ParseFS(prompts, "prompts/*.prompt")
+//	req, err := set.Render("summarize", map[string]any{"topic": "Go"})
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Ext is the extension of the prompt files.
+const Ext = ".prompt"
+
+var (
+	// ErrInvalidPrompt is returned when a prompt file can't be parsed.
+	ErrInvalidPrompt = errors.New("invalid prompt")
+	// ErrMissingVariable is returned when a required variable has no value.
+	ErrMissingVariable = errors.New("missing variable")
+	// ErrExtraVariable is returned when a value is given for a variable that is not declared.
+	ErrExtraVariable = errors.New("undeclared variable")
+	// ErrVariableType is returned when the value of a variable does not have the declared type.
+	ErrVariableType = errors.New("invalid type of variable")
+	// ErrPromptNotFound is returned by a Set when there is no template with the given name.
+	ErrPromptNotFound = errors.New("prompt not found")
+)
+
+// Types of the variables.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeList   = "list" // a list of values of any type
+)
+
+// Variable is a variable declared by a template.
+type Variable struct {
+	// Type is one of the Type constants, string if empty.
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	// Required variables must be given a value when the template is rendered.
+	Required bool `yaml:"required"`
+	// Default is the value of an optional variable without value.
+	Default any `yaml:"default"`
+}
+
+// Options are the options of the request declared by a template.
+// The options not set keep the default value of the requests.
+type Options struct {
+	MaxTokens              *int     `yaml:"max_tokens"`
+	Temperature            *float64 `yaml:"temperature"`
+	TopP                   *float64 `yaml:"top_p"`
+	TopK                   *int     `yaml:"top_k"`
+	PresencePenalty        *float64 `yaml:"presence_penalty"`
+	FrequencyPenalty       *float64 `yaml:"frequency_penalty"`
+	SearchDomainFilter     []string `yaml:"search_domain_filter"`
+	SearchRecencyFilter    string   `yaml:"search_recency_filter"`
+	ReturnImages           bool     `yaml:"return_images"`
+	ReturnRelatedQuestions bool     `yaml:"return_related_questions"`
+}
+
+// frontMatter is the YAML header of a prompt file.
+type frontMatter struct {
+	Name        string              `yaml:"name"`
+	Description string              `yaml:"description"`
+	Model       string              `yaml:"model"`
+	System      string              `yaml:"system"`
+	Options     Options             `yaml:"options"`
+	Variables   map[string]Variable `yaml:"variables"`
+}
+
+// Template is a parsed prompt template.
+type Template struct {
+	// Name is the name of the front matter, or the name of the file without extension.
+	Name        string
+	Description string
+	// Model is the model of the requests, perplexity.DefaultModel if empty.
+	Model     string
+	Options   Options
+	Variables map[string]Variable
+
+	system *template.Template // nil without system message
+	user   *template.Template
+}
+
+// funcs are the functions available in the templates.
+var funcs = template.FuncMap{
+	"join":  join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// join joins the elements of a list with sep. The lists may have elements of any type, like the
+// []any of the YAML and JSON data: each element is formatted with fmt.Sprint.
+func join(list any, sep string) (string, error) {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %T is not a list", list)
+	}
+	elems := make([]string, rv.Len())
+	for i := range elems {
+		elems[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(elems, sep), nil
+}
+
+// Parse parses the content of a prompt file. name is used if the front matter has no name.
+func Parse(name string, data []byte) (*Template, error) {
+	var fm frontMatter
+	body := string(data)
+	if header, rest, ok := splitFrontMatter(body); ok {
+		if err := yaml.Unmarshal([]byte(header), &fm); err != nil {
+			return nil, fmt.Errorf("%w %s: front matter: %w", ErrInvalidPrompt, name, err)
+		}
+		body = rest
+	}
+	if fm.Name != "" {
+		name = fm.Name
+	}
+	t := &Template{
+		Name:        name,
+		Description: fm.Description,
+		Model:       fm.Model,
+		Options:     fm.Options,
+		Variables:   fm.Variables,
+	}
+	if t.Variables == nil {
+		t.Variables = map[string]Variable{}
+	}
+	for v, decl := range t.Variables {
+		if decl.Type == "" {
+			decl.Type = TypeString
+			t.Variables[v] = decl
+		}
+		if decl.Default != nil {
+			if _, err := convert(v, decl.Type, decl.Default); err != nil {
+				return nil, fmt.Errorf("%w %s: default value: %w", ErrInvalidPrompt, name, err)
+			}
+		} else if _, err := convert(v, decl.Type, zero(decl.Type)); err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrInvalidPrompt, name, err)
+		}
+	}
+
+	var err error
+	if fm.System != "" {
+		if t.system, err = newTemplate(name+"/system", fm.System); err != nil {
+			return nil, fmt.Errorf("%w %s: system: %w", ErrInvalidPrompt, name, err)
+		}
+	}
+	if t.user, err = newTemplate(name, strings.TrimSpace(body)); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrInvalidPrompt, name, err)
+	}
+	return t, nil
+}
+
+func newTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// splitFrontMatter returns the front matter delimited by --- lines and the rest of s.
+func splitFrontMatter(s string) (string, string, bool) {
+	s = strings.TrimPrefix(s, "\ufeff")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if !strings.HasPrefix(s, "---\n") {
+		return "", s, false
+	}
+	header, rest, ok := strings.Cut(s[len("---\n"):], "\n---")
+	if !ok {
+		return "", s, false
+	}
+	// the closing delimiter ends the line
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[i+1:]
+	} else {
+		rest = ""
+	}
+	return header, rest, true
+}
+
+// ParseFile parses a prompt file. Its name is the name of the file without extension.
+func ParseFile(filename string) (*Template, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt: %w", err)
+	}
+	return Parse(strings.TrimSuffix(filepath.Base(filename), Ext), data)
+}
+
+// Render returns the request of the template with the values of the variables.
+// The optional variables without value get their default value, or the zero value of their type.
+func (t *Template) Render(vars map[string]any) (*perplexity.CompletionRequest, error) {
+	values, err := t.values(vars)
+	if err != nil {
+		return nil, err
+	}
+	user, err := execute(t.user, values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", t.Name, err)
+	}
+	var msgOpts []perplexity.MessagesOption
+	if t.system != nil {
+		system, err := execute(t.system, values)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render the system message of %s: %w", t.Name, err)
+		}
+		msgOpts = append(msgOpts, perplexity.WithSystemMessage(system))
+	}
+	messages := perplexity.NewMessages(msgOpts...)
+	if err := messages.AddUserMessage(user); err != nil {
+		return nil, err
+	}
+
+	opts := t.options()
+	opts = append(opts, perplexity.WithMessages(messages.GetMessages()))
+	req := perplexity.NewCompletionRequest(opts...)
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid request rendered by %s: %w", t.Name, err)
+	}
+	return req, nil
+}
+
+func execute(t *template.Template, values map[string]any) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// values checks vars against the declared variables and returns the values of all the variables.
+func (t *Template) values(vars map[string]any) (map[string]any, error) {
+	var errs []error
+	for _, name := range sortedKeys(vars) {
+		if _, ok := t.Variables[name]; !ok {
+			errs = append(errs, fmt.Errorf("%w %q", ErrExtraVariable, name))
+		}
+	}
+	values := make(map[string]any, len(t.Variables))
+	for _, name := range sortedKeys(t.Variables) {
+		decl := t.Variables[name]
+		v, ok := vars[name]
+		switch {
+		case ok:
+		case decl.Required:
+			errs = append(errs, fmt.Errorf("%w %q", ErrMissingVariable, name))
+			continue
+		case decl.Default != nil:
+			v = decl.Default
+		default:
+			v = zero(decl.Type)
+		}
+		converted, err := convert(name, decl.Type, v)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values[name] = converted
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to render %s: %w", t.Name, errors.Join(errs...))
+	}
+	return values, nil
+}
+
+// options returns the options of the requests of the template, without the messages.
+func (t *Template) options() []perplexity.CompletionRequestOption {
+	o := t.Options
+	var opts []perplexity.CompletionRequestOption
+	if t.Model != "" {
+		opts = append(opts, perplexity.WithModel(t.Model))
+	}
+	if o.MaxTokens != nil {
+		opts = append(opts, perplexity.WithMaxTokens(*o.MaxTokens))
+	}
+	if o.Temperature != nil {
+		opts = append(opts, perplexity.WithTemperature(*o.Temperature))
+	}
+	if o.TopP != nil {
+		opts = append(opts, perplexity.WithTopP(*o.TopP))
+	}
+	if o.TopK != nil {
+		opts = append(opts, perplexity.WithTopK(*o.TopK))
+	}
+	if o.PresencePenalty != nil {
+		opts = append(opts, perplexity.WithPresencePenalty(*o.PresencePenalty))
+	}
+	if o.FrequencyPenalty != nil {
+		opts = append(opts, perplexity.WithFrequencyPenalty(*o.FrequencyPenalty))
+	}
+	return append(opts,
+		perplexity.WithSearchDomainFilter(o.SearchDomainFilter),
+		perplexity.WithSearchRecencyFilter(o.SearchRecencyFilter),
+		perplexity.WithReturnImages(o.ReturnImages),
+		perplexity.WithReturnRelatedQuestions(o.ReturnRelatedQuestions),
+	)
+}
+
+// zero returns the zero value of a type.
+func zero(typ string) any {
+	switch typ {
+	case TypeInt:
+		return 0
+	case TypeFloat:
+		return 0.0
+	case TypeBool:
+		return false
+	case TypeList:
+		return []any{}
+	}
+	return ""
+}
+
+// convert returns v converted to the type of the variable name.
+// Integers are accepted for floats, and slices of any type for lists.
+func convert(name, typ string, v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	ok := false
+	var converted any
+	switch typ {
+	case TypeString:
+		converted, ok = v.(string)
+	case TypeInt:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			converted, ok = int(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			converted, ok = int(rv.Uint()), true
+		}
+	case TypeFloat:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			converted, ok = rv.Float(), true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			converted, ok = float64(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			converted, ok = float64(rv.Uint()), true
+		}
+	case TypeBool:
+		converted, ok = v.(bool)
+	case TypeList:
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			converted, ok = v, true
+		}
+	default:
+		return nil, fmt.Errorf("%w %q: unknown type %q", ErrVariableType, name, typ)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %q: %T is not a %s", ErrVariableType, name, v, typ)
+	}
+	return converted, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set is a set of templates, by name.
+type Set struct {
+	templates map[string]*Template
+}
+
+// ParseFS parses the prompt files of fsys matching the patterns (see fs.Glob), usually an embed.FS.
+// It fails if two templates have the same name.
+func ParseFS(fsys fs.FS, patterns ...string) (*Set, error) {
+	s := &Set{templates: map[string]*Template{}}
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no prompt file matches %q", pattern)
+		}
+		for _, filename := range matches {
+			data, err := fs.ReadFile(fsys, filename)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read prompt: %w", err)
+			}
+			t, err := Parse(strings.TrimSuffix(path.Base(filename), Ext), data)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := s.templates[t.Name]; ok {
+				return nil, fmt.Errorf("%w %s: duplicate name %q", ErrInvalidPrompt, filename, t.Name)
+			}
+			s.templates[t.Name] = t
+		}
+	}
+	return s, nil
+}
+
+// Lookup returns the template with the given name.
+func (s *Set) Lookup(name string) (*Template, bool) {
+	t, ok := s.templates[name]
+	return t, ok
+}
+
+// Names returns the names of the templates, sorted.
+func (s *Set) Names() []string {
+	return sortedKeys(s.templates)
+}
+
+// Render renders the template with the given name.
+func (s *Set) Render(name string, vars map[string]any) (*perplexity.CompletionRequest, error) {
+	t, ok := s.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrPromptNotFound, name)
+	}
+	return t.Render(vars)
+}
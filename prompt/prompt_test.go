@@ -0,0 +1,128 @@
+package prompt_test
+
+import (
+	"embed"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/prompt"
+	"github.com/stretchr/testify/assert"
+)
+
+//go:embed testdata/*.prompt
+var prompts embed.FS
+
+func TestRender(t *testing.T) {
+	tmpl, err := prompt.ParseFile(filepath.Join("testdata", "summarize.prompt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "summarize", tmpl.Name)
+	assert.Equal(t, "Summarizes the news about a topic.", tmpl.Description)
+
+	t.Run("with the defaults", func(t *testing.T) {
+		req, err := tmpl.Render(map[string]any{"topic": "Go"})
+		assert.Nil(t, err)
+		assert.Equal(t, []perplexity.Message{
+			{Role: "system", Content: "You are a concise assistant writing in English."},
+			{Role: "user", Content: "Summarize the news about Go in 3 bullet points."},
+		}, req.Messages)
+		assert.Equal(t, "sonar-pro", req.Model)
+		assert.Equal(t, 0.3, req.Temperature)
+		assert.Equal(t, 500, req.MaxTokens)
+		assert.Equal(t, perplexity.DefaultTopP, req.TopP)
+		assert.Equal(t, []string{"reuters.com", "apnews.com"}, req.SearchDomainFilter)
+		assert.Equal(t, "week", req.SearchRecencyFilter)
+	})
+
+	t.Run("with all the variables", func(t *testing.T) {
+		req, err := tmpl.Render(map[string]any{
+			"topic":    "Go",
+			"count":    int64(5),
+			"language": "French",
+			"sources":  []string{"go.dev", "github.com"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "You are a concise assistant writing in French.", req.Messages[0].Content)
+		assert.Equal(t, "Summarize the news about Go in 5 bullet points.\nOnly use these sources: go.dev, github.com.", req.Messages[1].Content)
+	})
+
+	t.Run("with a list of the YAML or JSON data", func(t *testing.T) {
+		req, err := tmpl.Render(map[string]any{"topic": "Go", "sources": []any{"go.dev", 42}})
+		assert.Nil(t, err)
+		assert.Equal(t, "Summarize the news about Go in 3 bullet points.\nOnly use these sources: go.dev, 42.", req.Messages[1].Content)
+	})
+
+	t.Run("checks the variables", func(t *testing.T) {
+		_, err := tmpl.Render(map[string]any{"count": 3})
+		assert.True(t, errors.Is(err, prompt.ErrMissingVariable))
+
+		_, err = tmpl.Render(map[string]any{"topic": "Go", "tone": "formal"})
+		assert.True(t, errors.Is(err, prompt.ErrExtraVariable))
+		assert.ErrorContains(t, err, `"tone"`)
+
+		_, err = tmpl.Render(map[string]any{"topic": "Go", "count": "three"})
+		assert.True(t, errors.Is(err, prompt.ErrVariableType))
+		assert.ErrorContains(t, err, `"count": string is not a int`)
+
+		_, err = tmpl.Render(map[string]any{"topic": 42, "sources": "go.dev"})
+		assert.True(t, errors.Is(err, prompt.ErrVariableType))
+		assert.ErrorContains(t, err, `"sources"`)
+		assert.ErrorContains(t, err, `"topic"`)
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Run("without front matter", func(t *testing.T) {
+		tmpl, err := prompt.Parse("hello", []byte("Say hello."))
+		assert.Nil(t, err)
+		req, err := tmpl.Render(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, perplexity.DefaultModel, req.Model)
+		assert.Equal(t, []perplexity.Message{{Role: "user", Content: "Say hello."}}, req.Messages)
+	})
+
+	t.Run("undeclared variables are not rendered", func(t *testing.T) {
+		tmpl, err := prompt.Parse("hello", []byte("---\nvariables:\n  name: {}\n---\nSay hello to {{.name}} and {{.friend}}."))
+		assert.Nil(t, err)
+		_, err = tmpl.Render(map[string]any{"name": "Gopher"})
+		assert.ErrorContains(t, err, `map has no entry for key "friend"`)
+	})
+
+	t.Run("the request is validated", func(t *testing.T) {
+		tmpl, err := prompt.Parse("hot", []byte("---\noptions:\n  temperature: 5\n---\nHi"))
+		assert.Nil(t, err)
+		_, err = tmpl.Render(nil)
+		assert.ErrorContains(t, err, "invalid request rendered by hot")
+	})
+
+	for name, data := range map[string]string{
+		"invalid YAML":     "---\nmodel: [\n---\nHi",
+		"invalid template": "Hi {{.name",
+		"unknown type":     "---\nvariables:\n  name: {type: date}\n---\nHi",
+		"invalid default":  "---\nvariables:\n  count: {type: int, default: many}\n---\nHi",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := prompt.Parse("bad", []byte(data))
+			assert.True(t, errors.Is(err, prompt.ErrInvalidPrompt), err)
+		})
+	}
+}
+
+func TestParseFS(t *testing.T) {
+	set, err := prompt.ParseFS(prompts, "testdata/*.prompt")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"definition", "summarize"}, set.Names())
+
+	req, err := set.Render("definition", map[string]any{"word": "gopher"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Define GOPHER.", req.Messages[0].Content)
+
+	_, err = set.Render("translate", nil)
+	assert.True(t, errors.Is(err, prompt.ErrPromptNotFound))
+
+	_, err = prompt.ParseFS(prompts, "testdata/*.prompt", "testdata/define.prompt")
+	assert.ErrorContains(t, err, `duplicate name "definition"`)
+	_, err = prompt.ParseFS(prompts, "prompts/*.prompt")
+	assert.ErrorContains(t, err, "no prompt file matches")
+}
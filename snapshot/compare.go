@@ -0,0 +1,296 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Thresholds are the minimum similarities of the answers matching their snapshot.
+type Thresholds struct {
+	// Text is the minimum similarity of the normalized texts, between 0 and 1.
+	Text float64
+	// Citations is the minimum overlap of the sets of citations (Jaccard index), between 0 and 1.
+	Citations float64
+}
+
+// DefaultThresholds tolerate rewordings and a few changes of sources.
+var DefaultThresholds = Thresholds{Text: 0.6, Citations: 0.5}
+
+// Comparison is the comparison of an answer with its snapshot.
+type Comparison struct {
+	Golden  *Snapshot
+	Current *Snapshot
+	// Thresholds are the thresholds of the comparison.
+	Thresholds Thresholds
+	// TextSimilarity is the similarity of the normalized texts, between 0 and 1.
+	TextSimilarity float64
+	// CitationOverlap is the Jaccard index of the sets of citations, 1 if both are empty.
+	CitationOverlap  float64
+	AddedCitations   []string
+	RemovedCitations []string
+	// MissingFacts are the key facts missing from the current answer.
+	MissingFacts []string
+	// RequestChanged is true if the request is not the one of the snapshot.
+	RequestChanged bool
+}
+
+// Compare compares the current answer with the golden one. facts are the key facts the current answer must contain.
+func Compare(golden, current *Snapshot, facts []string, thresholds Thresholds) *Comparison {
+	c := &Comparison{
+		Golden:         golden,
+		Current:        current,
+		Thresholds:     thresholds,
+		TextSimilarity: similarity(words(golden.Answer), words(current.Answer)),
+		RequestChanged: !sameRequest(golden, current),
+	}
+	c.CitationOverlap, c.AddedCitations, c.RemovedCitations = overlap(golden.Citations, current.Citations)
+	text := " " + strings.Join(words(current.Answer), " ") + " "
+	for _, fact := range facts {
+		if !strings.Contains(text, " "+strings.Join(words(fact), " ")+" ") {
+			c.MissingFacts = append(c.MissingFacts, fact)
+		}
+	}
+	return c
+}
+
+// Pass reports whether the current answer matches the snapshot.
+func (c *Comparison) Pass() bool {
+	return c.TextSimilarity >= c.Thresholds.Text &&
+		c.CitationOverlap >= c.Thresholds.Citations &&
+		len(c.MissingFacts) == 0 &&
+		!c.RequestChanged
+}
+
+// Diff returns a human-readable report of the differences: the similarities, the changes of
+// citations, the missing facts and the diff of the sentences of the answers.
+func (c *Comparison) Diff() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s (recorded %s)\n+++ %s (current)\n", c.Golden.Name, c.Golden.Recorded.Format("2006-01-02"), c.Current.Name)
+	if c.RequestChanged {
+		sb.WriteString("! the request is not the one of the snapshot\n")
+	}
+	fmt.Fprintf(&sb, "text similarity %.2f (min %.2f)%s\n", c.TextSimilarity, c.Thresholds.Text,
+		failMark(c.TextSimilarity < c.Thresholds.Text))
+	fmt.Fprintf(&sb, "citation overlap %.2f (min %.2f)%s\n", c.CitationOverlap, c.Thresholds.Citations,
+		failMark(c.CitationOverlap < c.Thresholds.Citations))
+	for _, citation := range c.RemovedCitations {
+		fmt.Fprintf(&sb, "- citation %s\n", citation)
+	}
+	for _, citation := range c.AddedCitations {
+		fmt.Fprintf(&sb, "+ citation %s\n", citation)
+	}
+	for _, fact := range c.MissingFacts {
+		fmt.Fprintf(&sb, "! missing fact %q\n", fact)
+	}
+	if diff := DiffText(c.Golden.Answer, c.Current.Answer); diff != "" {
+		sb.WriteString("@@ answer\n")
+		sb.WriteString(diff)
+	}
+	return sb.String()
+}
+
+func failMark(fail bool) string {
+	if fail {
+		return " FAIL"
+	}
+	return ""
+}
+
+// sameRequest reports whether the snapshots have the same request.
+func sameRequest(a, b *Snapshot) bool {
+	x, errX := json.Marshal(a.Request)
+	y, errY := json.Marshal(b.Request)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
+
+// markup matches the citation markers and the Markdown markup removed by the normalization.
+var markup = regexp.MustCompile(`\[\d+\]|[*_#>` + "`" + `]`)
+
+// words returns the normalized words of s: lower case, without markup nor punctuation.
+func words(s string) []string {
+	s = markup.ReplaceAllString(strings.ToLower(s), " ")
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// similarity returns 2*LCS/(len(a)+len(b)), the similarity ratio of two sequences of words.
+func similarity(a, b []string) float64 {
+	if len(a)+len(b) == 0 {
+		return 1
+	}
+	return 2 * float64(lcs(a, b)) / float64(len(a)+len(b))
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				cur[j] = prev[j-1] + 1
+			} else {
+				cur[j] = max(prev[j], cur[j-1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// overlap returns the Jaccard index of the sets of citations, and the citations added and removed.
+// The URLs are compared without scheme, "www." and trailing slash.
+func overlap(golden, current []string) (float64, []string, []string) {
+	g, c := map[string]string{}, map[string]string{}
+	for _, u := range golden {
+		g[normalizeURL(u)] = u
+	}
+	for _, u := range current {
+		c[normalizeURL(u)] = u
+	}
+	var added, removed []string
+	common := 0
+	for key, u := range c {
+		if _, ok := g[key]; ok {
+			common++
+		} else {
+			added = append(added, u)
+		}
+	}
+	for key, u := range g {
+		if _, ok := c[key]; !ok {
+			removed = append(removed, u)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	union := len(g) + len(c) - common
+	if union == 0 {
+		return 1, nil, nil
+	}
+	return float64(common) / float64(union), added, removed
+}
+
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimSpace(rawURL))
+	}
+	key := strings.TrimPrefix(strings.ToLower(u.Host), "www.") + strings.TrimSuffix(u.Path, "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// sentenceEnd matches the ends of the sentences and the line breaks.
+var sentenceEnd = regexp.MustCompile(`[.!?](\s+|$)|\n+`)
+
+// sentences splits a text in sentences, without the empty ones.
+func sentences(s string) []string {
+	var result []string
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(s, -1) {
+		if sentence := strings.TrimSpace(s[start:loc[1]]); sentence != "" {
+			result = append(result, sentence)
+		}
+		start = loc[1]
+	}
+	if sentence := strings.TrimSpace(s[start:]); sentence != "" {
+		result = append(result, sentence)
+	}
+	return result
+}
+
+// DiffText returns the diff of the sentences of two texts: a sentence per line, prefixed with
+// "- " if removed, "+ " if added and "  " if unchanged. The sentences are compared normalized,
+// and the unchanged ones far from the changes are elided. It returns "" if there is no change.
+func DiffText(a, b string) string {
+	return diffSentences(sentences(a), sentences(b))
+}
+
+// diffContext is the number of unchanged sentences around the changes in a diff.
+const diffContext = 1
+
+// diffSentences returns the diff of two lists of sentences, formatted as described by DiffText.
+func diffSentences(a, b []string) string {
+	keyA, keyB := make([]string, len(a)), make([]string, len(b))
+	for i, s := range a {
+		keyA[i] = strings.Join(words(s), " ")
+	}
+	for i, s := range b {
+		keyB[i] = strings.Join(words(s), " ")
+	}
+	// table[i][j] is the LCS of keyA[i:] and keyB[j:]
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if keyA[i] == keyB[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && keyA[i] == keyB[j]:
+			lines = append(lines, line{' ', b[j]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || table[i+1][j] >= table[i][j+1]):
+			lines = append(lines, line{'-', a[i]})
+			changed = true
+			i++
+		default:
+			lines = append(lines, line{'+', b[j]})
+			changed = true
+			j++
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var sb strings.Builder
+	elided := false
+	for k, l := range lines {
+		if l.op == ' ' && !nearChange(k, func(n int) bool { return n >= 0 && n < len(lines) && lines[n].op != ' ' }) {
+			if !elided {
+				sb.WriteString("  ...\n")
+				elided = true
+			}
+			continue
+		}
+		elided = false
+		fmt.Fprintf(&sb, "%c %s\n", l.op, l.text)
+	}
+	return sb.String()
+}
+
+// nearChange reports whether there is a change within diffContext lines of k.
+func nearChange(k int, isChange func(int) bool) bool {
+	for d := -diffContext; d <= diffContext; d++ {
+		if isChange(k + d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package snapshot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+// snap returns a snapshot of an answer with the citations, for the same request.
+func snap(answer string, citations ...string) *snapshot.Snapshot {
+	return &snapshot.Snapshot{
+		Name:      "capital",
+		Request:   perplexity.NewCompletionRequest(perplexity.WithModel("sonar")),
+		Answer:    answer,
+		Citations: citations,
+		Recorded:  time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCompare(t *testing.T) {
+	t.Run("ignores the markup, the case and the citation markers", func(t *testing.T) {
+		c := snapshot.Compare(
+			snap("The capital of France is **Paris** [1].", "https://www.example.com/paris/"),
+			snap("the capital of france is Paris.", "http://example.com/paris"),
+			[]string{"capital of France", "PARIS"}, snapshot.DefaultThresholds)
+		assert.Equal(t, 1.0, c.TextSimilarity)
+		assert.Equal(t, 1.0, c.CitationOverlap)
+		assert.Empty(t, c.MissingFacts)
+		assert.True(t, c.Pass())
+	})
+
+	t.Run("detects the drift", func(t *testing.T) {
+		golden := snap("Paris is the capital of France. It has 2.1 million inhabitants. It is on the Seine. It has many museums.",
+			"https://a.com", "https://b.com", "https://c.com")
+		current := snap("Paris is the capital of France. It has 2.2 million inhabitants. It is on the Seine. It has many museums.",
+			"https://a.com", "https://d.com")
+		c := snapshot.Compare(golden, current, []string{"2.1 million", "Seine", "Paris is"}, snapshot.DefaultThresholds)
+		assert.InDelta(t, 40.0/42, c.TextSimilarity, 1e-9)
+		assert.Equal(t, 0.25, c.CitationOverlap)
+		assert.Equal(t, []string{"https://d.com"}, c.AddedCitations)
+		assert.Equal(t, []string{"https://b.com", "https://c.com"}, c.RemovedCitations)
+		assert.Equal(t, []string{"2.1 million"}, c.MissingFacts)
+		assert.False(t, c.Pass())
+		assert.Equal(t, `--- capital (recorded 2025-03-01)
++++ capital (current)
+text similarity 0.95 (min 0.60)
+citation overlap 0.25 (min 0.50) FAIL
+- citation https://b.com
+- citation https://c.com
++ citation https://d.com
+! missing fact "2.1 million"
+@@ answer
+  Paris is the capital of France.
+- It has 2.1 million inhabitants.
++ It has 2.2 million inhabitants.
+  It is on the Seine.
+  ...
+`, c.Diff())
+	})
+
+	t.Run("detects the change of request", func(t *testing.T) {
+		golden := snap("Paris")
+		current := snap("Paris")
+		current.Request.Model = "sonar-pro"
+		c := snapshot.Compare(golden, current, nil, snapshot.DefaultThresholds)
+		assert.True(t, c.RequestChanged)
+		assert.False(t, c.Pass())
+		assert.Contains(t, c.Diff(), "! the request is not the one of the snapshot\n")
+		assert.NotContains(t, c.Diff(), "@@ answer")
+	})
+
+	t.Run("applies the thresholds", func(t *testing.T) {
+		golden := snap("The capital of France is Paris.")
+		current := snap("Paris is the French capital city.")
+		c := snapshot.Compare(golden, current, nil, snapshot.DefaultThresholds)
+		assert.Less(t, c.TextSimilarity, 0.6)
+		assert.False(t, c.Pass())
+		assert.Contains(t, c.Diff(), "FAIL\n")
+		assert.Contains(t, c.Diff(), "- The capital of France is Paris.\n+ Paris is the French capital city.\n")
+		assert.True(t, snapshot.Compare(golden, current, nil, snapshot.Thresholds{Text: 0.2}).Pass())
+	})
+}
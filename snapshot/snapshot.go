@@ -0,0 +1,307 @@
+// Package snapshot detects the drift of the answers to critical prompts with golden snapshots.
+//
+// A snapshot stores the answer, the citations and the usage of a request. Run sends the
+// requests again and compares the new answers to the snapshots: the similarity of the
+// normalized texts, the overlap of the citations and the presence of key facts.
+//
+//	store := snapshot.NewStore("testdata/snapshots")
+//	results, err := snapshot.Run(ctx, client, store, cases, snapshot.WithUpdate(*update))
+//	for _, r := range results {
+//		if r.Status == snapshot.StatusDrift {
+//			fmt.Print(r.Comparison.Diff())
+//		}
+//	}
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+)
+
+// Ext is the extension of the snapshot files.
+const Ext = ".json"
+
+var (
+	// ErrNotFound is returned by Store.Load when there is no snapshot with the given name.
+	ErrNotFound = errors.New("snapshot not found")
+	// ErrInvalidName is returned for the names that are not valid file names.
+	ErrInvalidName = errors.New("invalid snapshot name")
+)
+
+// validName matches the valid names of snapshots.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Snapshot is the golden answer to a request.
+type Snapshot struct {
+	Name      string                        `json:"name"`
+	Request   *perplexity.CompletionRequest `json:"request"`
+	Answer    string                        `json:"answer"`
+	Citations []string                      `json:"citations,omitempty"`
+	Usage     perplexity.Usage              `json:"usage"`
+	Recorded  time.Time                     `json:"recorded"`
+}
+
+// New returns the snapshot of the response to req.
+func New(name string, req *perplexity.CompletionRequest, resp *perplexity.CompletionResponse) *Snapshot {
+	return &Snapshot{
+		Name:      name,
+		Request:   req,
+		Answer:    resp.GetLastContent(),
+		Citations: resp.GetCitations(),
+		Usage:     resp.Usage,
+		Recorded:  time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Store stores the snapshots in a directory, one JSON file per snapshot.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store of the snapshots of dir. The directory is created by the first Save.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return filepath.Join(s.dir, name+Ext), nil
+}
+
+// Load returns the snapshot name, or ErrNotFound.
+func (s *Store) Load(name string) (*Snapshot, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the snapshot: %w", err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	return &snap, nil
+}
+
+// Save writes the snapshot, replacing the previous one.
+func (s *Store) Save(snap *Snapshot) error {
+	path, err := s.path(snap.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the snapshot: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create the directory of the snapshots: %w", err)
+	}
+	// write then rename, so that an interruption does not corrupt the snapshot
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write the snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write the snapshot: %w", err)
+	}
+	return nil
+}
+
+// Case is a request whose answer is checked against its snapshot.
+type Case struct {
+	// Name is the name of the snapshot: letters, digits, '.', '_' and '-'.
+	Name    string                        `json:"name"`
+	Request *perplexity.CompletionRequest `json:"request,omitempty"`
+	// Prompt is a shortcut for a request with a user message and the default options,
+	// used if Request is nil.
+	Prompt string `json:"prompt,omitempty"`
+	// Facts are the key facts that the answers must contain.
+	Facts []string `json:"facts,omitempty"`
+}
+
+// ReadCases reads cases in JSONL: one Case per line.
+// The fields of the requests not set keep their default value.
+func ReadCases(r io.Reader) ([]Case, error) {
+	var cases []Case
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var c Case
+		if err := unmarshalCase(line, &c); err != nil {
+			return nil, fmt.Errorf("line %d: invalid case: %w", n, err)
+		}
+		if err := c.resolve(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("line %d: duplicate name %q", n, c.Name)
+		}
+		names[c.Name] = true
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the cases: %w", err)
+	}
+	return cases, nil
+}
+
+// unmarshalCase decodes a case. The fields of the request not set keep their default value.
+func unmarshalCase(data []byte, c *Case) error {
+	var raw struct {
+		Case
+		Request json.RawMessage `json:"request"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = raw.Case
+	if raw.Request != nil {
+		c.Request = perplexity.DefaultCompletionRequest()
+		if err := json.Unmarshal(raw.Request, c.Request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve checks the case and builds the request of a prompt.
+// The request of the case is replaced by a copy: the one of the caller is left unchanged.
+func (c *Case) resolve() error {
+	if !validName.MatchString(c.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, c.Name)
+	}
+	if c.Request == nil {
+		if c.Prompt == "" {
+			return errors.New("a case must have a request or a prompt")
+		}
+		messages := perplexity.NewMessages()
+		if err := messages.AddUserMessage(c.Prompt); err != nil {
+			return err
+		}
+		c.Request = perplexity.NewCompletionRequest(perplexity.WithMessages(messages.GetMessages()))
+	} else {
+		req := *c.Request
+		c.Request = &req
+	}
+	c.Request.Stream = false
+	return c.Request.Validate()
+}
+
+// Status is the outcome of the check of a case.
+type Status string
+
+// Statuses of the results.
+const (
+	StatusPass    Status = "pass"    // the answer matches the snapshot
+	StatusDrift   Status = "drift"   // the answer drifted from the snapshot
+	StatusMissing Status = "missing" // there is no snapshot
+	StatusNew     Status = "new"     // there was no snapshot, it has been recorded (update mode)
+	StatusUpdated Status = "updated" // the answer drifted, the snapshot has been replaced (update mode)
+	StatusError   Status = "error"   // the request failed
+)
+
+// Result is the outcome of the check of a case.
+type Result struct {
+	Name   string
+	Status Status
+	// Comparison is the comparison with the snapshot, nil without snapshot.
+	Comparison *Comparison
+	Err        error
+}
+
+// Option configures Run.
+type Option func(*runConfig)
+
+type runConfig struct {
+	update     bool
+	thresholds Thresholds
+}
+
+// WithUpdate records the missing snapshots and replaces the ones that drifted.
+func WithUpdate(update bool) Option {
+	return func(c *runConfig) {
+		c.update = update
+	}
+}
+
+// WithThresholds sets the thresholds of the comparisons (DefaultThresholds by default).
+func WithThresholds(thresholds Thresholds) Option {
+	return func(c *runConfig) {
+		c.thresholds = thresholds
+	}
+}
+
+// Run sends the request of each case and compares the answer with the snapshot of the case.
+// The errors of the requests are reported in the results; Run fails if a snapshot can't be
+// read or written, or if ctx is done.
+func Run(ctx context.Context, client perplexity.Completer, store *Store, cases []Case, opts ...Option) ([]Result, error) {
+	config := runConfig{thresholds: DefaultThresholds}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	results := make([]Result, 0, len(cases))
+	for _, c := range cases {
+		if err := c.resolve(); err != nil {
+			return results, fmt.Errorf("case %s: %w", c.Name, err)
+		}
+		golden, err := store.Load(c.Name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return results, err
+		}
+		resp, err := client.SendCompletionRequestWithContext(ctx, c.Request)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		if err != nil {
+			results = append(results, Result{Name: c.Name, Status: StatusError, Err: err})
+			continue
+		}
+		current := New(c.Name, c.Request, resp)
+		result := Result{Name: c.Name}
+		switch {
+		case golden == nil && config.update:
+			result.Status = StatusNew
+		case golden == nil:
+			result.Status = StatusMissing
+		default:
+			result.Comparison = Compare(golden, current, c.Facts, config.thresholds)
+			result.Status = StatusPass
+			if !result.Comparison.Pass() {
+				result.Status = StatusDrift
+				if config.update {
+					result.Status = StatusUpdated
+				}
+			}
+		}
+		if result.Status == StatusNew || result.Status == StatusUpdated {
+			if err := store.Save(current); err != nil {
+				return results, err
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
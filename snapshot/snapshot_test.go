@@ -0,0 +1,159 @@
+package snapshot_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/sgaunet/perplexity-go/v2/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	store := snapshot.NewStore(filepath.Join(t.TempDir(), "snapshots"))
+	_, err := store.Load("capital")
+	assert.True(t, errors.Is(err, snapshot.ErrNotFound))
+
+	req := perplexity.NewCompletionRequest(perplexity.WithModel("sonar"))
+	s := snapshot.New("capital", req, perplexitytest.Answer("Paris", "https://a.com").Completion)
+	assert.Nil(t, store.Save(s))
+	loaded, err := store.Load("capital")
+	assert.Nil(t, err)
+	assert.Equal(t, s.Answer, loaded.Answer)
+	assert.Equal(t, []string{"https://a.com"}, loaded.Citations)
+	assert.Equal(t, "sonar", loaded.Request.Model)
+	assert.True(t, s.Recorded.Equal(loaded.Recorded))
+
+	_, err = store.Load("../capital")
+	assert.True(t, errors.Is(err, snapshot.ErrInvalidName))
+	s.Name = "a/b"
+	assert.True(t, errors.Is(store.Save(s), snapshot.ErrInvalidName))
+}
+
+func TestReadCases(t *testing.T) {
+	cases, err := snapshot.ReadCases(strings.NewReader(`{"name": "capital", "prompt": "What's the capital of France?", "facts": ["Paris"]}
+
+{"name": "river", "request": {"model": "sonar-pro", "messages": [{"role": "user", "content": "Which river?"}], "stream": true}}
+`))
+	assert.Nil(t, err)
+	assert.Len(t, cases, 2)
+	assert.Equal(t, "What's the capital of France?", cases[0].Request.Messages[0].Content)
+	assert.Equal(t, perplexity.DefaultModel, cases[0].Request.Model)
+	assert.Equal(t, []string{"Paris"}, cases[0].Facts)
+	assert.Equal(t, "sonar-pro", cases[1].Request.Model)
+	assert.False(t, cases[1].Request.Stream)
+
+	tests := map[string]string{
+		`{"name": "a b", "prompt": "x"}`:                                       "line 1: invalid snapshot name",
+		`{"name": "a"}`:                                                        "line 1: a case must have a request or a prompt",
+		`{"name": "a", "request": {"model": "sonar"}}`:                         "line 1: Key: 'CompletionRequest.Messages'",
+		`{"name": "a", "request": "x"}`:                                        "line 1: invalid case",
+		`{"name": "a", "prompt": "x"}` + "\n" + `{"name": "a", "prompt": "y"}`: `line 2: duplicate name "a"`,
+	}
+	for input, msg := range tests {
+		_, err := snapshot.ReadCases(strings.NewReader(input))
+		assert.ErrorContains(t, err, msg, input)
+	}
+}
+
+func TestRun(t *testing.T) {
+	store := snapshot.NewStore(t.TempDir())
+	fake := perplexitytest.NewFake()
+	cases := []snapshot.Case{
+		{Name: "capital", Prompt: "What's the capital of France?", Facts: []string{"Paris"}},
+		{Name: "river", Prompt: "Which river flows through Paris?"},
+	}
+	ctx := context.Background()
+
+	t.Run("reports the missing snapshots", func(t *testing.T) {
+		results, err := snapshot.Run(ctx, fake, store, cases)
+		assert.Nil(t, err)
+		assert.Equal(t, snapshot.StatusMissing, results[0].Status)
+		assert.Equal(t, snapshot.StatusMissing, results[1].Status)
+		_, err = store.Load("capital")
+		assert.True(t, errors.Is(err, snapshot.ErrNotFound))
+	})
+
+	t.Run("records the new snapshots", func(t *testing.T) {
+		fake.Enqueue(
+			perplexitytest.Answer("The capital of France is Paris.", "https://a.com"),
+			perplexitytest.Answer("The Seine flows through Paris.", "https://b.com"),
+		)
+		results, err := snapshot.Run(ctx, fake, store, cases, snapshot.WithUpdate(true))
+		assert.Nil(t, err)
+		assert.Equal(t, snapshot.StatusNew, results[0].Status)
+		assert.Equal(t, snapshot.StatusNew, results[1].Status)
+		golden, err := store.Load("river")
+		assert.Nil(t, err)
+		assert.Equal(t, "The Seine flows through Paris.", golden.Answer)
+	})
+
+	t.Run("compares with the snapshots", func(t *testing.T) {
+		fake.Enqueue(
+			perplexitytest.Answer("Paris, of course.", "https://a.com"),
+			perplexitytest.Answer("The Seine flows through Paris.", "https://b.com"),
+		)
+		results, err := snapshot.Run(ctx, fake, store, cases)
+		assert.Nil(t, err)
+		assert.Equal(t, snapshot.StatusDrift, results[0].Status)
+		assert.Equal(t, snapshot.StatusPass, results[1].Status)
+		assert.Contains(t, results[0].Comparison.Diff(), "+ Paris, of course.")
+		golden, _ := store.Load("capital")
+		assert.Equal(t, "The capital of France is Paris.", golden.Answer)
+
+		fake.Enqueue(
+			perplexitytest.Answer("Paris, of course.", "https://a.com"),
+			perplexitytest.Answer("The Seine flows through Paris.", "https://b.com"),
+		)
+		results, err = snapshot.Run(ctx, fake, store, cases, snapshot.WithThresholds(snapshot.Thresholds{Text: 0.2}))
+		assert.Nil(t, err)
+		assert.Equal(t, snapshot.StatusPass, results[0].Status)
+	})
+
+	t.Run("updates the snapshots that drifted", func(t *testing.T) {
+		fake.Enqueue(
+			perplexitytest.Answer("Lyon.", "https://c.com"),
+			perplexitytest.Error(http.StatusInternalServerError, "boom"),
+		)
+		results, err := snapshot.Run(ctx, fake, store, cases, snapshot.WithUpdate(true))
+		assert.Nil(t, err)
+		assert.Equal(t, snapshot.StatusUpdated, results[0].Status)
+		assert.Equal(t, []string{"Paris"}, results[0].Comparison.MissingFacts)
+		assert.Equal(t, snapshot.StatusError, results[1].Status)
+		assert.NotNil(t, results[1].Err)
+		golden, _ := store.Load("capital")
+		assert.Equal(t, "Lyon.", golden.Answer)
+		golden, _ = store.Load("river")
+		assert.Equal(t, "The Seine flows through Paris.", golden.Answer)
+	})
+
+	t.Run("leaves the cases unchanged", func(t *testing.T) {
+		req := perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+			{Role: "user", Content: "What's the capital of Italy?"},
+		}), perplexity.WithStream(true))
+		cases := []snapshot.Case{
+			{Name: "italy", Request: req},
+			{Name: "capital", Prompt: "What's the capital of France?"},
+		}
+		fake.Enqueue(perplexitytest.Answer("Rome."), perplexitytest.Answer("Paris."))
+		_, err := snapshot.Run(ctx, fake, snapshot.NewStore(t.TempDir()), cases)
+		assert.Nil(t, err)
+		assert.Same(t, req, cases[0].Request)
+		assert.True(t, req.Stream)
+		assert.Nil(t, cases[1].Request)
+		assert.False(t, fake.Calls()[len(fake.Calls())-2].Request.Stream)
+	})
+
+	t.Run("fails on corrupted snapshots", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "capital.json"), []byte("{"), 0o644))
+		_, err := snapshot.Run(ctx, fake, snapshot.NewStore(dir), cases)
+		assert.ErrorContains(t, err, "invalid snapshot")
+	})
+}
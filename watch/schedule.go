@@ -0,0 +1,156 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule is returned by ParseSchedule for the invalid specs.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule returns the times of the runs of a query.
+type Schedule interface {
+	// Next returns the first time of a run after t.
+	Next(t time.Time) time.Time
+}
+
+// every is the schedule of "@every d".
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// shortcuts are the cron expressions of the predefined schedules.
+var shortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// ParseSchedule parses a schedule: a cron expression of five fields (minute, hour,
+// day of month, month and day of week, 0 being Sunday) with the syntax *, 1,2, 1-5 and */15;
+// a shortcut (@hourly, @daily, @weekly, @monthly or @yearly); or "@every duration" (e.g. @every 6h).
+// The cron times are in the location of the times given to Next.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		duration, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || duration < time.Second {
+			return nil, fmt.Errorf("%w %q: the duration must be at least 1s", ErrInvalidSchedule, spec)
+		}
+		return every(duration), nil
+	}
+	if expr, ok := shortcuts[spec]; ok {
+		spec = expr
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: a cron expression has 5 fields", ErrInvalidSchedule, spec)
+	}
+	var c cron
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := [5]*uint64{&c.minutes, &c.hours, &c.days, &c.months, &c.weekdays}
+	for i, field := range fields {
+		set, err := parseField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidSchedule, spec, err)
+		}
+		*sets[i] = set
+	}
+	c.anyDay = fields[2] == "*"
+	c.anyWeekday = fields[4] == "*"
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%w %q: the expression never matches", ErrInvalidSchedule, spec)
+	}
+	return &c, nil
+}
+
+// parseField returns the set of the values of a field of a cron expression, as a bit set.
+func parseField(field string, minimum, maximum int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := minimum, maximum
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				hi = maximum
+			}
+		}
+		if lo < minimum || hi > maximum || lo > hi {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, minimum, maximum)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// cron is the schedule of a cron expression.
+type cron struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday are true if the fields are *: as in cron, when both fields are
+	// restricted, the days matching either field match.
+	anyDay, anyWeekday bool
+}
+
+// maxSearch bounds the search of the next time of the expressions that never match (e.g. February 30).
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case c.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
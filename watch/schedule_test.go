@@ -0,0 +1,45 @@
+package watch_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// Wednesday, January 15th 2025.
+	start := time.Date(2025, time.January, 15, 10, 30, 45, 0, time.UTC)
+	tests := map[string]time.Time{
+		"@every 6h":        start.Add(6 * time.Hour),
+		"* * * * *":        time.Date(2025, time.January, 15, 10, 31, 0, 0, time.UTC),
+		"*/15 * * * *":     time.Date(2025, time.January, 15, 10, 45, 0, 0, time.UTC),
+		"0 8 * * *":        time.Date(2025, time.January, 16, 8, 0, 0, 0, time.UTC),
+		"0 8,12 * * 1-5":   time.Date(2025, time.January, 15, 12, 0, 0, 0, time.UTC),
+		"0 9 * * 6":        time.Date(2025, time.January, 18, 9, 0, 0, 0, time.UTC),
+		"0 0 1 * 0":        time.Date(2025, time.January, 19, 0, 0, 0, 0, time.UTC),
+		"30 6 29 2 *":      time.Date(2028, time.February, 29, 6, 30, 0, 0, time.UTC),
+		"5-10/5 23 31 * *": time.Date(2025, time.January, 31, 23, 5, 0, 0, time.UTC),
+		"@hourly":          time.Date(2025, time.January, 15, 11, 0, 0, 0, time.UTC),
+		"@daily":           time.Date(2025, time.January, 16, 0, 0, 0, 0, time.UTC),
+		"@weekly":          time.Date(2025, time.January, 19, 0, 0, 0, 0, time.UTC),
+		"@monthly":         time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"@yearly":          time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for spec, next := range tests {
+		schedule, err := watch.ParseSchedule(spec)
+		if assert.Nil(t, err, spec) {
+			assert.Equal(t, next, schedule.Next(start), spec)
+		}
+	}
+
+	for _, spec := range []string{
+		"", "@every 1ms", "@every x", "@never", "* * * *", "60 * * * *", "* 24 * * *",
+		"* * 0 * *", "* * * 13 *", "* * * * 7", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *",
+	} {
+		_, err := watch.ParseSchedule(spec)
+		assert.True(t, errors.Is(err, watch.ErrInvalidSchedule), spec)
+	}
+}
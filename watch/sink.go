@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Sink receives the changes of the answers.
+type Sink interface {
+	Emit(ctx context.Context, c *Change) error
+}
+
+// SinkFunc is a function used as a Sink.
+type SinkFunc func(ctx context.Context, c *Change) error
+
+// Emit implements Sink.
+func (f SinkFunc) Emit(ctx context.Context, c *Change) error {
+	return f(ctx, c)
+}
+
+// WriterSink writes the changes on w in a human-readable form, e.g. on os.Stdout.
+func WriterSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(_ context.Context, c *Change) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := fmt.Fprintln(w, c)
+		return err
+	})
+}
+
+// FileSink appends the changes to a JSONL file, one change per line.
+func FileSink(path string) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(_ context.Context, c *Change) error {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("failed to encode the change: %w", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write the change: %w", err)
+		}
+		defer f.Close()
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write the change: %w", err)
+		}
+		return nil
+	})
+}
+
+// WebhookOption configures a WebhookSink.
+type WebhookOption func(*webhook)
+
+// WithWebhookHTTPClient sets the HTTP client posting the changes (http.DefaultClient by default).
+func WithWebhookHTTPClient(client *http.Client) WebhookOption {
+	return func(w *webhook) {
+		w.client = client
+	}
+}
+
+// WithWebhookHeader sets a header of the requests, e.g. Authorization.
+func WithWebhookHeader(key, value string) WebhookOption {
+	return func(w *webhook) {
+		w.header.Set(key, value)
+	}
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+// WebhookSink posts the changes in JSON to url. The responses other than 2xx are errors.
+func WebhookSink(url string, opts ...WebhookOption) Sink {
+	w := &webhook{url: url, client: http.DefaultClient, header: make(http.Header)}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Emit implements Sink.
+func (w *webhook) Emit(ctx context.Context, c *Change) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode the change: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create the request of the webhook: %w", err)
+	}
+	for key, values := range w.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post the change: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post the change: status %d", resp.StatusCode)
+	}
+	return nil
+}
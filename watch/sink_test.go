@@ -0,0 +1,114 @@
+package watch_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	sink := watch.FileSink(path)
+	assert.Nil(t, sink.Emit(context.Background(), &watch.Change{Query: "acme", Fingerprint: "1"}))
+	assert.Nil(t, sink.Emit(context.Background(), &watch.Change{Query: "globex", Fingerprint: "2"}))
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var queries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c watch.Change
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &c))
+		queries = append(queries, c.Query)
+	}
+	assert.Equal(t, []string{"acme", "globex"}, queries)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received watch.Change
+	var header http.Header
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := watch.WebhookSink(srv.URL, watch.WithWebhookHeader("Authorization", "Bearer token"),
+		watch.WithWebhookHTTPClient(srv.Client()))
+	change := &watch.Change{
+		Query:        "acme",
+		Time:         time.Date(2025, time.January, 15, 8, 0, 0, 0, time.UTC),
+		NewCitations: []string{"https://b.com"},
+		Fingerprint:  "abc",
+	}
+	assert.Nil(t, sink.Emit(context.Background(), change))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, change.NewCitations, received.NewCitations)
+	assert.Equal(t, "abc", received.Fingerprint)
+
+	status = http.StatusBadGateway
+	assert.ErrorContains(t, sink.Emit(context.Background(), change), "status 502")
+}
+
+func TestLoadSpec(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watch.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`threshold: 0.8
+dedup: 2h
+queries:
+  - name: acme
+    schedule: "0 8 * * *"
+    prompt: What's new about Acme Corp?
+    system: Be brief.
+    model: sonar-pro
+    search_recency_filter: day
+sinks:
+  - {type: stdout}
+  - {type: file, path: changes.jsonl}
+  - {type: webhook, url: "https://hooks.example.com", headers: {Authorization: Bearer token}}
+`), 0o600))
+
+	spec, err := watch.LoadSpec(path)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "results"), spec.Dir)
+	assert.Equal(t, 2*time.Hour, spec.Dedup)
+	assert.Equal(t, filepath.Join(dir, "changes.jsonl"), spec.Sinks[1].Path)
+	assert.Len(t, spec.Options(), 2)
+
+	queries, err := spec.NewQueries()
+	assert.Nil(t, err)
+	if assert.Len(t, queries, 1) {
+		assert.Equal(t, "acme", queries[0].Name)
+		assert.Equal(t, "sonar-pro", queries[0].Request.Model)
+		assert.Equal(t, "day", queries[0].Request.SearchRecencyFilter)
+		assert.Equal(t, "system", queries[0].Request.Messages[0].Role)
+		assert.Equal(t, "What's new about Acme Corp?", queries[0].Request.Messages[1].Content)
+	}
+	sinks, err := spec.NewSinks(os.Stdout, nil)
+	assert.Nil(t, err)
+	assert.Len(t, sinks, 3)
+
+	spec.Queries[0].Schedule = "never"
+	_, err = spec.NewQueries()
+	assert.ErrorContains(t, err, "query 1: invalid schedule")
+	spec.Sinks[0].Type = "email"
+	_, err = spec.NewSinks(os.Stdout, nil)
+	assert.ErrorContains(t, err, `sink 1: unknown type "email"`)
+
+	assert.Nil(t, os.WriteFile(path, []byte("sinks: []\n"), 0o600))
+	_, err = watch.LoadSpec(path)
+	assert.ErrorContains(t, err, "no query")
+}
@@ -0,0 +1,179 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec describes the queries and the sinks of a watcher in YAML (or JSON):
+//
+//	dir: results          # directory of the results, relative to the spec file
+//	threshold: 0.9        # similarity under which the content has changed
+//	dedup: 24h            # de-duplication window of the changes
+//	queries:
+//	  - name: acme
+//	    schedule: "0 8 * * *"
+//	    prompt: What's new about Acme Corp?
+//	    model: sonar-pro
+//	    search_recency_filter: day
+//	sinks:
+//	  - {type: stdout}
+//	  - {type: file, path: changes.jsonl}
+//	  - {type: webhook, url: "https://hooks.example.com/watch", headers: {Authorization: Bearer token}}
+type Spec struct {
+	Dir       string        `yaml:"dir"`
+	Threshold float64       `yaml:"threshold"`
+	Dedup     time.Duration `yaml:"dedup"`
+	Queries   []QuerySpec   `yaml:"queries"`
+	Sinks     []SinkSpec    `yaml:"sinks"`
+}
+
+// QuerySpec describes a query of a Spec.
+type QuerySpec struct {
+	Name                string   `yaml:"name"`
+	Schedule            string   `yaml:"schedule"`
+	Prompt              string   `yaml:"prompt"`
+	System              string   `yaml:"system"`
+	Model               string   `yaml:"model"`
+	SearchRecencyFilter string   `yaml:"search_recency_filter"`
+	SearchDomainFilter  []string `yaml:"search_domain_filter"`
+}
+
+// SinkSpec describes a sink of a Spec. Type is stdout, file (with a path, relative to the
+// spec file) or webhook (with a url and optional headers).
+type SinkSpec struct {
+	Type    string            `yaml:"type"`
+	Path    string            `yaml:"path"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// LoadSpec reads a spec file. The relative paths of the spec are resolved from the directory
+// of the file; the results are in its subdirectory "results" by default.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the spec: %w", err)
+	}
+	var s Spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	if len(s.Queries) == 0 {
+		return nil, fmt.Errorf("invalid spec %s: no query", path)
+	}
+	if s.Dir == "" {
+		s.Dir = "results"
+	}
+	s.Dir = resolve(path, s.Dir)
+	for i := range s.Sinks {
+		if s.Sinks[i].Path != "" {
+			s.Sinks[i].Path = resolve(path, s.Sinks[i].Path)
+		}
+	}
+	return &s, nil
+}
+
+// resolve returns the path of name relative to the directory of the spec file.
+func resolve(spec, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(spec), name)
+}
+
+// NewQueries returns the queries of the spec.
+func (s *Spec) NewQueries() ([]Query, error) {
+	queries := make([]Query, 0, len(s.Queries))
+	for i, qs := range s.Queries {
+		q, err := qs.query()
+		if err != nil {
+			return nil, fmt.Errorf("query %d: %w", i+1, err)
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+func (qs *QuerySpec) query() (Query, error) {
+	schedule, err := ParseSchedule(qs.Schedule)
+	if err != nil {
+		return Query{}, err
+	}
+	if qs.Prompt == "" {
+		return Query{}, errors.New("the prompt is required")
+	}
+	var msgOpts []perplexity.MessagesOption
+	if qs.System != "" {
+		msgOpts = append(msgOpts, perplexity.WithSystemMessage(qs.System))
+	}
+	messages := perplexity.NewMessages(msgOpts...)
+	if err := messages.AddUserMessage(qs.Prompt); err != nil {
+		return Query{}, err
+	}
+	opts := []perplexity.CompletionRequestOption{
+		perplexity.WithMessages(messages.GetMessages()),
+		perplexity.WithSearchRecencyFilter(qs.SearchRecencyFilter),
+		perplexity.WithSearchDomainFilter(qs.SearchDomainFilter),
+	}
+	if qs.Model != "" {
+		opts = append(opts, perplexity.WithModel(qs.Model))
+	}
+	req := perplexity.NewCompletionRequest(opts...)
+	if err := req.Validate(); err != nil {
+		return Query{}, err
+	}
+	return Query{Name: qs.Name, Schedule: schedule, Request: req}, nil
+}
+
+// NewSinks returns the sinks of the spec. The stdout sinks write on stdout, the webhooks
+// post with httpClient (http.DefaultClient if nil).
+func (s *Spec) NewSinks(stdout io.Writer, httpClient *http.Client) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(s.Sinks))
+	for i, ss := range s.Sinks {
+		switch ss.Type {
+		case "stdout":
+			sinks = append(sinks, WriterSink(stdout))
+		case "file":
+			if ss.Path == "" {
+				return nil, fmt.Errorf("sink %d: the path is required", i+1)
+			}
+			sinks = append(sinks, FileSink(ss.Path))
+		case "webhook":
+			if ss.URL == "" {
+				return nil, fmt.Errorf("sink %d: the url is required", i+1)
+			}
+			opts := make([]WebhookOption, 0, len(ss.Headers)+1)
+			if httpClient != nil {
+				opts = append(opts, WithWebhookHTTPClient(httpClient))
+			}
+			for key, value := range ss.Headers {
+				opts = append(opts, WithWebhookHeader(key, value))
+			}
+			sinks = append(sinks, WebhookSink(ss.URL, opts...))
+		default:
+			return nil, fmt.Errorf("sink %d: unknown type %q", i+1, ss.Type)
+		}
+	}
+	return sinks, nil
+}
+
+// Options returns the options of the watcher set by the spec.
+func (s *Spec) Options() []Option {
+	var opts []Option
+	if s.Threshold > 0 {
+		opts = append(opts, WithThreshold(s.Threshold))
+	}
+	if s.Dedup > 0 {
+		opts = append(opts, WithDedupWindow(s.Dedup))
+	}
+	return opts
+}
@@ -0,0 +1,149 @@
+package watch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore stores the results of each query in a JSONL file of a directory, one result per line,
+// and the times of emission of the changes in the file dedup.json of the directory.
+// FileStore is safe for concurrent use.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore returns a store of the results in dir. The directory is created by the first Append.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) path(query string) (string, error) {
+	if !validName.MatchString(query) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, query)
+	}
+	return filepath.Join(s.dir, query+".jsonl"), nil
+}
+
+// Last implements Store.
+func (s *FileStore) Last(query string) (*Result, error) {
+	results, err := s.Results(query)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return &results[len(results)-1], nil
+}
+
+// Results returns the results of a query, the oldest first.
+func (s *FileStore) Results(query string) ([]Result, error) {
+	path, err := s.path(query)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the results: %w", err)
+	}
+	defer f.Close()
+	var results []Result
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Result
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid result: %w", path, n, err)
+		}
+		results = append(results, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the results: %w", err)
+	}
+	return results, nil
+}
+
+// Append implements Store.
+func (s *FileStore) Append(r *Result) error {
+	path, err := s.path(r.Query)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode the result: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create the directory of the results: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write the result: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write the result: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write the result: %w", err)
+	}
+	return nil
+}
+
+// dedupFile is the name of the file of the emitted changes in the directory of a FileStore.
+const dedupFile = "dedup.json"
+
+// Emitted implements Store.
+func (s *FileStore) Emitted() (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(s.dir, dedupFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]time.Time), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the emitted changes: %w", err)
+	}
+	emitted := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &emitted); err != nil {
+		return nil, fmt.Errorf("invalid emitted changes %s: %w", dedupFile, err)
+	}
+	return emitted, nil
+}
+
+// SaveEmitted implements Store.
+func (s *FileStore) SaveEmitted(emitted map[string]time.Time) error {
+	data, err := json.MarshalIndent(emitted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the emitted changes: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create the directory of the results: %w", err)
+	}
+	path := filepath.Join(s.dir, dedupFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write the emitted changes: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write the emitted changes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+// Package watch monitors topics by asking the same questions on schedules and reporting
+// what changed in the answers: the new citations, the dropped sources and a diff of the content.
+//
+//	schedule, _ := watch.ParseSchedule("0 8 * * *")
+//	w := watch.New(client, watch.NewFileStore("watch"), []watch.Query{{
+//		Name:     "acme",
+//		Schedule: schedule,
+//		Request:  req, // e.g. with perplexity.WithSearchRecencyFilter("day")
+//	}}, watch.WithSinks(watch.WriterSink(os.Stdout)))
+//	err := w.Run(ctx)
+//
+// Each result is persisted in the Store. The first result of a query is its baseline; the next
+// ones are compared to the previous result, and the changes are emitted to the sinks. A change
+// already emitted is not emitted again within the de-duplication window: the emitted changes are
+// persisted in the Store too, so that they are de-duplicated across runs, e.g. of RunOnce from cron.
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/snapshot"
+)
+
+// ErrInvalidName is returned for the names of queries that are not valid file names.
+var ErrInvalidName = errors.New("invalid query name")
+
+// validName matches the valid names of queries.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Query is a request sent on a schedule.
+type Query struct {
+	// Name identifies the query: letters, digits, '.', '_' and '-'.
+	Name     string
+	Schedule Schedule
+	Request  *perplexity.CompletionRequest
+}
+
+// Result is the answer to a query at a time.
+type Result struct {
+	Query     string           `json:"query"`
+	Time      time.Time        `json:"time"`
+	Answer    string           `json:"answer"`
+	Citations []string         `json:"citations,omitempty"`
+	Usage     perplexity.Usage `json:"usage"`
+}
+
+// Change is the change of the answer to a query since its previous run.
+type Change struct {
+	Query    string    `json:"query"`
+	Previous time.Time `json:"previous"`
+	Time     time.Time `json:"time"`
+	// NewCitations are the citations that were not in the previous answer.
+	NewCitations []string `json:"new_citations,omitempty"`
+	// DroppedCitations are the citations of the previous answer that are not in the new one.
+	DroppedCitations []string `json:"dropped_citations,omitempty"`
+	// Similarity is the similarity of the normalized texts of the answers, between 0 and 1.
+	Similarity float64 `json:"similarity"`
+	// Diff is the diff of the sentences of the answers, as returned by snapshot.DiffText.
+	Diff   string `json:"diff,omitempty"`
+	Answer string `json:"answer"`
+	// Fingerprint identifies the change for the de-duplication.
+	Fingerprint string `json:"fingerprint"`
+	// Duplicate is true if the change has already been emitted within the de-duplication window.
+	Duplicate bool `json:"-"`
+}
+
+// String returns a human-readable report of the change.
+func (c *Change) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s changed since %s (similarity %.2f)\n", c.Query,
+		c.Previous.Format(time.RFC3339), c.Similarity)
+	for _, citation := range c.NewCitations {
+		fmt.Fprintf(&sb, "+ citation %s\n", citation)
+	}
+	for _, citation := range c.DroppedCitations {
+		fmt.Fprintf(&sb, "- citation %s\n", citation)
+	}
+	if c.Diff != "" {
+		sb.WriteString("@@ answer\n")
+		sb.WriteString(c.Diff)
+	}
+	return sb.String()
+}
+
+// Store persists the results of the queries and the changes emitted.
+type Store interface {
+	// Last returns the last result of a query, nil if there is none.
+	Last(query string) (*Result, error)
+	// Append saves a result.
+	Append(r *Result) error
+	// Emitted returns the times of emission of the changes, by fingerprint.
+	Emitted() (map[string]time.Time, error)
+	// SaveEmitted replaces the times of emission of the changes.
+	SaveEmitted(emitted map[string]time.Time) error
+}
+
+// Option configures a Watcher.
+type Option func(*Watcher)
+
+// WithSinks sets the sinks of the changes.
+func WithSinks(sinks ...Sink) Option {
+	return func(w *Watcher) {
+		w.sinks = append(w.sinks, sinks...)
+	}
+}
+
+// WithThreshold sets the similarity of the texts under which the content of an answer has changed
+// (0.9 by default). A change of citations is always reported.
+func WithThreshold(threshold float64) Option {
+	return func(w *Watcher) {
+		w.threshold = threshold
+	}
+}
+
+// WithDedupWindow sets the time during which a change already emitted is not emitted again (24 hours by default).
+func WithDedupWindow(window time.Duration) Option {
+	return func(w *Watcher) {
+		w.dedupWindow = window
+	}
+}
+
+// WithErrorHandler sets the function called with the errors of the runs of Run, which go on.
+func WithErrorHandler(handler func(query string, err error)) Option {
+	return func(w *Watcher) {
+		w.onError = handler
+	}
+}
+
+// WithClock sets the function returning the current time (time.Now by default),
+// used for the times of the results, the schedules and the de-duplication.
+func WithClock(now func() time.Time) Option {
+	return func(w *Watcher) {
+		w.now = now
+	}
+}
+
+// Watcher runs queries on their schedule and reports the changes of their answers.
+type Watcher struct {
+	client      perplexity.Completer
+	store       Store
+	queries     []Query
+	sinks       []Sink
+	threshold   float64
+	dedupWindow time.Duration
+	onError     func(query string, err error)
+	now         func() time.Time
+
+	mu sync.Mutex // serializes the updates of the emitted changes of the store
+}
+
+// New returns a watcher of queries sent with client, whose results are persisted in store.
+func New(client perplexity.Completer, store Store, queries []Query, opts ...Option) *Watcher {
+	w := &Watcher{
+		client:      client,
+		store:       store,
+		queries:     queries,
+		threshold:   0.9,
+		dedupWindow: 24 * time.Hour,
+		onError:     func(string, error) {},
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Run runs the queries on their schedule until ctx is done. The errors of the runs are
+// reported to the error handler; Run returns an error only if a query is invalid.
+func (w *Watcher) Run(ctx context.Context) error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+	if len(w.queries) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	next := make([]time.Time, len(w.queries))
+	for i, q := range w.queries {
+		next[i] = q.Schedule.Next(w.now())
+	}
+	for {
+		first := 0
+		for i := range next {
+			if next[i].Before(next[first]) {
+				first = i
+			}
+		}
+		timer := time.NewTimer(time.Until(next[first]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		now := w.now()
+		for i, q := range w.queries {
+			if next[i].After(now) {
+				continue
+			}
+			if _, err := w.Check(ctx, q); err != nil && ctx.Err() == nil {
+				w.onError(q.Name, err)
+			}
+			next[i] = q.Schedule.Next(w.now())
+		}
+	}
+}
+
+// RunOnce runs all the queries now, in order, and returns their changes (nil for the queries
+// without change). It stops at the first error.
+func (w *Watcher) RunOnce(ctx context.Context) ([]*Change, error) {
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	changes := make([]*Change, 0, len(w.queries))
+	for _, q := range w.queries {
+		change, err := w.Check(ctx, q)
+		if err != nil {
+			return changes, fmt.Errorf("query %s: %w", q.Name, err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (w *Watcher) validate() error {
+	names := map[string]bool{}
+	for i, q := range w.queries {
+		switch {
+		case !validName.MatchString(q.Name):
+			return fmt.Errorf("query %d: %w: %q", i+1, ErrInvalidName, q.Name)
+		case names[q.Name]:
+			return fmt.Errorf("query %d: duplicate name %q", i+1, q.Name)
+		case q.Schedule == nil:
+			return fmt.Errorf("query %s: no schedule", q.Name)
+		case q.Request == nil:
+			return fmt.Errorf("query %s: no request", q.Name)
+		}
+		if err := q.Request.Validate(); err != nil {
+			return fmt.Errorf("query %s: %w", q.Name, err)
+		}
+		names[q.Name] = true
+	}
+	return nil
+}
+
+// Check sends a query, persists the result and compares it to the previous one. The change,
+// nil if there is none (or if it is the first result), is emitted to the sinks unless it is a duplicate.
+// The errors of the sinks are joined.
+func (w *Watcher) Check(ctx context.Context, q Query) (*Change, error) {
+	previous, err := w.store.Last(q.Name)
+	if err != nil {
+		return nil, err
+	}
+	req := *q.Request
+	req.Stream = false
+	resp, err := w.client.SendCompletionRequestWithContext(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	current := &Result{
+		Query:     q.Name,
+		Time:      w.now().UTC(),
+		Answer:    resp.GetLastContent(),
+		Citations: resp.GetCitations(),
+		Usage:     resp.Usage,
+	}
+	if err := w.store.Append(current); err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		return nil, nil
+	}
+	change := w.compare(previous, current)
+	if change == nil {
+		return nil, nil
+	}
+	if change.Duplicate, err = w.duplicate(change); err != nil || change.Duplicate {
+		return change, err
+	}
+	var errs []error
+	for _, sink := range w.sinks {
+		if err := sink.Emit(ctx, change); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(w.sinks) {
+		// no sink got the change: it is emitted again the next time it is seen
+		if err := w.forget(change); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return change, errors.Join(errs...)
+}
+
+// compare returns the change from previous to current, nil if there is none.
+func (w *Watcher) compare(previous, current *Result) *Change {
+	cmp := snapshot.Compare(
+		&snapshot.Snapshot{Answer: previous.Answer, Citations: previous.Citations},
+		&snapshot.Snapshot{Answer: current.Answer, Citations: current.Citations},
+		nil, snapshot.Thresholds{},
+	)
+	contentChanged := cmp.TextSimilarity < w.threshold
+	if !contentChanged && len(cmp.AddedCitations) == 0 && len(cmp.RemovedCitations) == 0 {
+		return nil
+	}
+	change := &Change{
+		Query:            current.Query,
+		Previous:         previous.Time,
+		Time:             current.Time,
+		NewCitations:     cmp.AddedCitations,
+		DroppedCitations: cmp.RemovedCitations,
+		Similarity:       cmp.TextSimilarity,
+		Diff:             snapshot.DiffText(previous.Answer, current.Answer),
+		Answer:           current.Answer,
+	}
+	change.Fingerprint = fingerprint(change, contentChanged)
+	return change
+}
+
+// fingerprint identifies a change by its query and its citations, and by its answer if the
+// content changed. The same change of sources is then reported once, even if reworded.
+func fingerprint(c *Change, contentChanged bool) string {
+	parts := []string{c.Query}
+	parts = append(parts, sorted(c.NewCitations)...)
+	parts = append(parts, "")
+	parts = append(parts, sorted(c.DroppedCitations)...)
+	if contentChanged {
+		parts = append(parts, "", strings.Join(strings.Fields(strings.ToLower(c.Answer)), " "))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func sorted(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
+
+// duplicate reports whether the change has been emitted within the window, and records it otherwise.
+func (w *Watcher) duplicate(c *Change) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	emitted, err := w.store.Emitted()
+	if err != nil {
+		return false, err
+	}
+	now := w.now()
+	for fp, t := range emitted {
+		if now.Sub(t) >= w.dedupWindow {
+			delete(emitted, fp)
+		}
+	}
+	if _, ok := emitted[c.Fingerprint]; ok {
+		return true, nil
+	}
+	if emitted == nil {
+		emitted = make(map[string]time.Time)
+	}
+	emitted[c.Fingerprint] = now.UTC()
+	return false, w.store.SaveEmitted(emitted)
+}
+
+// forget removes the record of a change emitted by no sink.
+func (w *Watcher) forget(c *Change) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	emitted, err := w.store.Emitted()
+	if err != nil {
+		return err
+	}
+	delete(emitted, c.Fingerprint)
+	return w.store.SaveEmitted(emitted)
+}
@@ -0,0 +1,290 @@
+package watch_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgaunet/perplexity-go/v2"
+	"github.com/sgaunet/perplexity-go/v2/perplexitytest"
+	"github.com/sgaunet/perplexity-go/v2/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQuery(t *testing.T, name string) watch.Query {
+	t.Helper()
+	schedule, err := watch.ParseSchedule("@every 1h")
+	assert.Nil(t, err)
+	return watch.Query{
+		Name:     name,
+		Schedule: schedule,
+		Request: perplexity.NewCompletionRequest(perplexity.WithMessages([]perplexity.Message{
+			{Role: "user", Content: "What's new about Acme Corp?"},
+		})),
+	}
+}
+
+// clock is a manual clock for WithClock.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func TestWatcherCheck(t *testing.T) {
+	fake := perplexitytest.NewFake(
+		perplexitytest.Answer("Acme Corp released a new rocket. It flew well.", "https://a.com"),
+		perplexitytest.Answer("Acme Corp released a new rocket. It flew well.", "https://a.com"),
+		perplexitytest.Answer("Acme Corp released a new rocket. It flew well.", "https://b.com"),
+		perplexitytest.Answer("Acme Corp filed for bankruptcy after its rocket exploded.", "https://b.com"),
+	)
+	store := watch.NewFileStore(filepath.Join(t.TempDir(), "results"))
+	var out bytes.Buffer
+	var emitted []*watch.Change
+	c := &clock{t: time.Date(2025, time.January, 15, 8, 0, 0, 0, time.UTC)}
+	q := newQuery(t, "acme")
+	q.Request.Stream = true
+	w := watch.New(fake, store, []watch.Query{q}, watch.WithClock(c.now), watch.WithSinks(
+		watch.WriterSink(&out),
+		watch.SinkFunc(func(_ context.Context, change *watch.Change) error {
+			emitted = append(emitted, change)
+			return nil
+		}),
+	))
+	ctx := context.Background()
+
+	// The first result is the baseline, the second one is the same.
+	for range 2 {
+		change, err := w.Check(ctx, q)
+		assert.Nil(t, err)
+		assert.Nil(t, change)
+		c.t = c.t.Add(time.Hour)
+	}
+	assert.False(t, fake.Calls()[0].Request.Stream)
+
+	// The sources changed.
+	change, err := w.Check(ctx, q)
+	assert.Nil(t, err)
+	if assert.NotNil(t, change) {
+		assert.Equal(t, []string{"https://b.com"}, change.NewCitations)
+		assert.Equal(t, []string{"https://a.com"}, change.DroppedCitations)
+		assert.Equal(t, 1.0, change.Similarity)
+		assert.Equal(t, "", change.Diff)
+		assert.Equal(t, time.Date(2025, time.January, 15, 9, 0, 0, 0, time.UTC), change.Previous)
+		assert.Equal(t, time.Date(2025, time.January, 15, 10, 0, 0, 0, time.UTC), change.Time)
+	}
+	c.t = c.t.Add(time.Hour)
+
+	// The content changed.
+	change, err = w.Check(ctx, q)
+	assert.Nil(t, err)
+	if assert.NotNil(t, change) {
+		assert.Empty(t, change.NewCitations)
+		assert.Less(t, change.Similarity, 0.9)
+		assert.Contains(t, change.Diff, "+ Acme Corp filed for bankruptcy")
+		assert.Contains(t, change.Diff, "- Acme Corp released a new rocket.")
+		assert.NotEqual(t, emitted[0].Fingerprint, change.Fingerprint)
+	}
+	assert.Len(t, emitted, 2)
+	assert.Contains(t, out.String(), "acme changed since 2025-01-15T09:00:00Z (similarity 1.00)\n+ citation https://b.com\n- citation https://a.com\n")
+	assert.Contains(t, out.String(), "@@ answer\n")
+
+	results, err := store.Results("acme")
+	assert.Nil(t, err)
+	assert.Len(t, results, 4)
+	assert.Equal(t, "Acme Corp filed for bankruptcy after its rocket exploded.", results[3].Answer)
+	assert.Equal(t, []string{"https://b.com"}, results[3].Citations)
+}
+
+func TestWatcherDedup(t *testing.T) {
+	a := perplexitytest.Answer("Acme Corp released a new rocket.", "https://a.com")
+	b := perplexitytest.Answer("Acme Corp released a new rocket.", "https://b.com")
+	fake := perplexitytest.NewFake(a, b, a, b, a, b)
+	c := &clock{t: time.Date(2025, time.January, 15, 8, 0, 0, 0, time.UTC)}
+	var emitted int
+	q := newQuery(t, "acme")
+	w := watch.New(fake, watch.NewFileStore(t.TempDir()), []watch.Query{q},
+		watch.WithClock(c.now), watch.WithDedupWindow(3*time.Hour),
+		watch.WithSinks(watch.SinkFunc(func(context.Context, *watch.Change) error {
+			emitted++
+			return nil
+		})))
+
+	var duplicates []bool
+	for range 6 {
+		change, err := w.Check(context.Background(), q)
+		assert.Nil(t, err)
+		if change != nil {
+			duplicates = append(duplicates, change.Duplicate)
+		}
+		c.t = c.t.Add(time.Hour)
+	}
+	// a→b at 9h, b→a at 10h, a→b at 11h (duplicate), b→a at 12h (duplicate),
+	// a→b at 13h (the first one is out of the window).
+	assert.Equal(t, []bool{false, false, true, true, false}, duplicates)
+	assert.Equal(t, 3, emitted)
+}
+
+func TestWatcherDedupAcrossRuns(t *testing.T) {
+	a := perplexitytest.Answer("Acme Corp released a new rocket.", "https://a.com")
+	b := perplexitytest.Answer("Acme Corp released a new rocket.", "https://b.com")
+	fake := perplexitytest.NewFake(a, b, a, b)
+	c := &clock{t: time.Date(2025, time.January, 15, 8, 0, 0, 0, time.UTC)}
+	dir := t.TempDir()
+	var emitted int
+	q := newQuery(t, "acme")
+	// each run of the command, e.g. from cron, creates a new watcher
+	run := func() {
+		w := watch.New(fake, watch.NewFileStore(dir), []watch.Query{q},
+			watch.WithClock(c.now), watch.WithDedupWindow(3*time.Hour),
+			watch.WithSinks(watch.SinkFunc(func(context.Context, *watch.Change) error {
+				emitted++
+				return nil
+			})))
+		_, err := w.RunOnce(context.Background())
+		assert.Nil(t, err)
+		c.t = c.t.Add(time.Hour)
+	}
+	for range 4 {
+		run()
+	}
+	// a→b at 9h and b→a at 10h are emitted, a→b at 11h is a duplicate across the runs
+	assert.Equal(t, 2, emitted)
+}
+
+func TestWatcherDedupFailedSinks(t *testing.T) {
+	a := perplexitytest.Answer("Acme Corp released a new rocket.", "https://a.com")
+	b := perplexitytest.Answer("Acme Corp released a new rocket.", "https://b.com")
+	fake := perplexitytest.NewFake(a, b, a, b)
+	errSink := errors.New("sink failed")
+	var emitted int
+	q := newQuery(t, "acme")
+	w := watch.New(fake, watch.NewFileStore(t.TempDir()), []watch.Query{q},
+		watch.WithSinks(watch.SinkFunc(func(context.Context, *watch.Change) error {
+			emitted++
+			if emitted == 1 {
+				return errSink
+			}
+			return nil
+		})))
+
+	var duplicates []bool
+	for range 4 {
+		change, _ := w.Check(context.Background(), q)
+		if change != nil {
+			duplicates = append(duplicates, change.Duplicate)
+		}
+	}
+	// the change a→b failed to be emitted: it is emitted again when seen again
+	assert.Equal(t, []bool{false, false, false}, duplicates)
+	assert.Equal(t, 3, emitted)
+}
+
+func TestWatcherErrors(t *testing.T) {
+	fake := perplexitytest.NewFake(
+		perplexitytest.Error(500, "boom"),
+		perplexitytest.Answer("Acme Corp released a new rocket.", "https://a.com"),
+		perplexitytest.Answer("Acme Corp released a new rocket.", "https://b.com"),
+	)
+	store := watch.NewFileStore(t.TempDir())
+	q := newQuery(t, "acme")
+	errSink := errors.New("sink failed")
+	w := watch.New(fake, store, []watch.Query{q}, watch.WithSinks(
+		watch.SinkFunc(func(context.Context, *watch.Change) error { return errSink }),
+	))
+
+	_, err := w.Check(context.Background(), q)
+	assert.NotNil(t, err)
+	results, err := store.Results("acme")
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+
+	_, err = w.Check(context.Background(), q)
+	assert.Nil(t, err)
+	change, err := w.Check(context.Background(), q)
+	assert.NotNil(t, change)
+	assert.True(t, errors.Is(err, errSink))
+
+	invalid := []watch.Query{q, q}
+	_, err = watch.New(fake, store, invalid).RunOnce(context.Background())
+	assert.ErrorContains(t, err, `duplicate name "acme"`)
+	q.Name = "../acme"
+	_, err = watch.New(fake, store, []watch.Query{q}).RunOnce(context.Background())
+	assert.True(t, errors.Is(err, watch.ErrInvalidName))
+	assert.True(t, errors.Is(store.Append(&watch.Result{Query: "a/b"}), watch.ErrInvalidName))
+}
+
+func TestWatcherRunOnce(t *testing.T) {
+	fake := perplexitytest.NewFake()
+	fake.Handler = func(req *perplexity.CompletionRequest) perplexitytest.Response {
+		return perplexitytest.Answer("Answer to "+req.Messages[0].Content, "https://"+req.Model+".com")
+	}
+	q1, q2 := newQuery(t, "acme"), newQuery(t, "globex")
+	q2.Request.Model = "sonar-pro"
+	store := watch.NewFileStore(t.TempDir())
+	w := watch.New(fake, store, []watch.Query{q1, q2})
+
+	changes, err := w.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []*watch.Change{nil, nil}, changes)
+	fake.Handler = func(req *perplexity.CompletionRequest) perplexitytest.Response {
+		return perplexitytest.Answer("Answer to "+req.Messages[0].Content, "https://new.com")
+	}
+	changes, err = w.RunOnce(context.Background())
+	assert.Nil(t, err)
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, "acme", changes[0].Query)
+		assert.Equal(t, []string{"https://sonar.com"}, changes[0].DroppedCitations)
+		assert.Equal(t, "globex", changes[1].Query)
+		assert.Equal(t, []string{"https://sonar-pro.com"}, changes[1].DroppedCitations)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	fake := perplexitytest.NewFake()
+	calls := make(chan struct{}, 10)
+	fake.Handler = func(*perplexity.CompletionRequest) perplexitytest.Response {
+		calls <- struct{}{}
+		return perplexitytest.Answer("Acme Corp released a new rocket.")
+	}
+	q := newQuery(t, "acme")
+	schedule, err := watch.ParseSchedule("@every 1s")
+	assert.Nil(t, err)
+	q.Schedule = schedule
+	w := watch.New(fake, watch.NewFileStore(t.TempDir()), []watch.Query{q})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	select {
+	case <-calls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query was not run")
+	}
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+
+	q.Request = nil
+	err = watch.New(fake, watch.NewFileStore(t.TempDir()), []watch.Query{q}).Run(context.Background())
+	assert.ErrorContains(t, err, "query acme: no request")
+}
+
+func TestChangeString(t *testing.T) {
+	c := &watch.Change{
+		Query:        "acme",
+		Previous:     time.Date(2025, time.January, 15, 8, 0, 0, 0, time.UTC),
+		NewCitations: []string{"https://b.com"},
+		Similarity:   0.5,
+		Diff:         "- a\n+ b\n",
+	}
+	assert.Equal(t, strings.Join([]string{
+		"acme changed since 2025-01-15T08:00:00Z (similarity 0.50)",
+		"+ citation https://b.com",
+		"@@ answer",
+		"- a",
+		"+ b",
+		"",
+	}, "\n"), c.String())
+}